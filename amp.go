@@ -0,0 +1,113 @@
+// AMP fallback (-prefer-amp).
+// Some sites serve the full article on their AMP page while the canonical
+// page is paywalled or script-rendered; this extracts both and keeps the
+// longer one.
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	// Matches a <link> tag whose rel includes "amphtml"
+	ampLinkRe  = regexp.MustCompile(`(?i)<link\b[^>]*\brel\s*=\s*["']?[^"'>]*\bamphtml\b[^>]*>`)
+	hrefAttrRe = regexp.MustCompile(`(?i)\bhref\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+)
+
+// ampURL returns the absolute URL of the page's <link rel="amphtml">, or ""
+// if it has none.
+func ampURL(page []byte, pageURL *url.URL) string {
+	tag := ampLinkRe.Find(page)
+	if tag == nil {
+		return ""
+	}
+	m := hrefAttrRe.FindSubmatch(tag)
+	if m == nil {
+		return ""
+	}
+	href := strings.TrimSpace(html.UnescapeString(string(m[1]) + string(m[2]) + string(m[3])))
+	u, err := pageURL.Parse(href)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+	u.Fragment = ""
+	return u.String()
+}
+
+// preferAMP fetches and extracts page's AMP version and returns it in place
+// of content when it has more words, or when content failed to extract.
+// The AMP page is only followed one hop, never when it is page itself, and
+// only on page's own host unless cfg.ampCrossOrig. The article keeps the
+// canonical page's metadata and URL; the AMP page only fills gaps. It also
+// returns the page the content came from, for the paywall check.
+func preferAMP(page []byte, pageURL *url.URL, content string, meta articleMeta, extractErr error, cfg cliConfig) (string, articleMeta, []byte, error) {
+	amp := ampURL(page, pageURL)
+	if amp == "" {
+		return content, meta, page, extractErr
+	}
+	u, _ := url.Parse(amp)
+	switch {
+	case sameURL(u, pageURL):
+		return content, meta, page, extractErr
+	case !strings.EqualFold(u.Hostname(), pageURL.Hostname()) && !cfg.ampCrossOrig:
+		vprintf("Skipping cross-origin AMP page %s (see -amp-cross-origin)\n", amp)
+		return content, meta, page, extractErr
+	}
+
+	start := time.Now()
+	ampBytes, ampPageURL, err := fetchHTML(amp, cfg.timeout, cfg.userAgent)
+	cfg.stageTimes.since("fetch", start)
+	if err != nil {
+		vprintf("AMP page %s unavailable: %v\n", amp, err)
+		return content, meta, page, extractErr
+	}
+	if sameURL(ampPageURL, pageURL) {
+		// The AMP link redirected back to the page.
+		return content, meta, page, extractErr
+	}
+	start = time.Now()
+	ampBytes = promoteLazySrc(ampBytes)
+	ampContent, ampMeta, err := extractPage(ampBytes, ampPageURL, cfg)
+	cfg.stageTimes.since("extract", start)
+	if err != nil {
+		vprintf("AMP page %s: %v\n", amp, err)
+		return content, meta, page, extractErr
+	}
+
+	words, ampWords := articleWordCount(content), articleWordCount(ampContent)
+	if extractErr == nil && ampWords <= words {
+		vprintf("Keeping canonical page (%d words; AMP has %d)\n", words, ampWords)
+		return content, meta, page, nil
+	}
+	fmt.Fprintf(logOut, "Using AMP version %s (%d words vs %d)\n", amp, ampWords, words)
+	if extractErr != nil {
+		meta = articleMeta{URL: pageURL.String()}
+	}
+	meta.Title = cmp.Or(meta.Title, ampMeta.Title)
+	meta.Byline = cmp.Or(meta.Byline, ampMeta.Byline)
+	meta.SiteName = cmp.Or(meta.SiteName, ampMeta.SiteName)
+	meta.Excerpt = cmp.Or(meta.Excerpt, ampMeta.Excerpt)
+	meta.PageTitle = cmp.Or(meta.PageTitle, ampMeta.PageTitle)
+	meta.OGTitle = cmp.Or(meta.OGTitle, ampMeta.OGTitle)
+	meta.H1Title = cmp.Or(meta.H1Title, ampMeta.H1Title)
+	meta.Image = cmp.Or(meta.Image, ampMeta.Image)
+	if meta.PublishedTime == nil {
+		meta.PublishedTime = ampMeta.PublishedTime
+	}
+	return ampContent, meta, ampBytes, nil
+}
+
+// sameURL reports whether a and b name the same page, ignoring fragments
+// and a trailing slash.
+func sameURL(a, b *url.URL) bool {
+	key := func(u *url.URL) string {
+		return strings.ToLower(u.Host) + strings.TrimSuffix(u.EscapedPath(), "/") + "?" + u.RawQuery
+	}
+	return key(a) == key(b)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAmpURL(t *testing.T) {
+	page, _ := url.Parse("https://example.com/story")
+	for _, tc := range []struct{ html, want string }{
+		{`<link rel="amphtml" href="/story/amp">`, "https://example.com/story/amp"},
+		{`<LINK href='https://amp.example.com/s?a=1&amp;b=2#top' REL='amphtml'>`, "https://amp.example.com/s?a=1&b=2"},
+		{`<link rel=amphtml href=story.amp>`, "https://example.com/story.amp"},
+		{`<link rel="canonical" href="/story">`, ""},
+		{`<link rel="amphtml" href="javascript:alert(1)">`, ""},
+	} {
+		if got := ampURL([]byte(tc.html), page); got != tc.want {
+			t.Errorf("ampURL(%s) = %q, want %q", tc.html, got, tc.want)
+		}
+	}
+}
+
+func TestFetchAndExtract_PreferAMP(t *testing.T) {
+	long := strings.Repeat("The full story continues with plenty of detail here. ", 20)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/paywalled", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Replace(makeArticleHTML("Paywalled", "Subscribe to read more."),
+			"<head>", `<head><link rel="amphtml" href="/paywalled/amp"><meta name="author" content="Ann Lee">`, 1)))
+	})
+	mux.HandleFunc("/paywalled/amp", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(makeArticleHTML("AMP Title", long)))
+	})
+	mux.HandleFunc("/full", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Replace(makeArticleHTML("Full", long),
+			"<head>", `<head><link rel="amphtml" href="/full/amp">`, 1)))
+	})
+	mux.HandleFunc("/full/amp", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(makeArticleHTML("Full AMP", "Short.")))
+	})
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Replace(makeArticleHTML("Loop", "Short."),
+			"<head>", `<head><link rel="amphtml" href="/loop/">`, 1)))
+	})
+	mux.HandleFunc("/elsewhere", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Replace(makeArticleHTML("Elsewhere", "Short."),
+			"<head>", `<head><link rel="amphtml" href="https://amp.invalid/elsewhere">`, 1)))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	cfg := cliConfig{preferAMP: true, timeout: 5 * time.Second}
+	content, meta, err := fetchAndExtract(srv.URL+"/paywalled", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(content, "full story continues") {
+		t.Errorf("expected the longer AMP content, got:\n%.300s", content)
+	}
+	if meta.URL != srv.URL+"/paywalled" || meta.Title != "Paywalled" || meta.Byline != "Ann Lee" {
+		t.Errorf("expected the canonical page's metadata, got %+v", meta)
+	}
+
+	content, meta, err = fetchAndExtract(srv.URL+"/full", cfg)
+	if err != nil || !strings.Contains(content, "full story continues") || meta.Title != "Full" {
+		t.Errorf("longer canonical page should be kept, got %q (%v)", meta.Title, err)
+	}
+	for _, path := range []string{"/loop", "/elsewhere"} {
+		if content, _, err := fetchAndExtract(srv.URL+path, cfg); err != nil || !strings.Contains(content, "Short.") {
+			t.Errorf("%s: AMP link should not be followed, got %v:\n%.300s", path, err, content)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+// Color profile stripping (-strip-icc).
+// Re-encoded images never carry an ICC profile, but images embedded as they
+// are (small images kept by -skip-small-reencode, images that can't be
+// decoded, GIFs) may carry one of several hundred KB that e-ink screens
+// ignore. These remove it without touching the image data.
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+var (
+	pngSignature  = []byte("\x89PNG\r\n\x1a\n")
+	iccJPEGHeader = []byte("ICC_PROFILE\x00")
+)
+
+// stripColorProfile returns data without its embedded ICC profile (a PNG
+// iCCP chunk, or JPEG APP2 ICC_PROFILE segments), or nil if it is neither
+// format, has no profile, or is malformed.
+func stripColorProfile(data []byte) []byte {
+	switch {
+	case bytes.HasPrefix(data, pngSignature):
+		return stripPNGProfile(data)
+	case bytes.HasPrefix(data, []byte{0xff, 0xd8}):
+		return stripJPEGProfile(data)
+	}
+	return nil
+}
+
+// stripPNGProfile drops the iCCP chunk from a PNG.
+func stripPNGProfile(data []byte) []byte {
+	out := append([]byte(nil), pngSignature...)
+	stripped := false
+	for rest := data[len(pngSignature):]; len(rest) > 0; {
+		if len(rest) < 12 {
+			return nil
+		}
+		n := int(binary.BigEndian.Uint32(rest))
+		if n > len(rest)-12 {
+			return nil
+		}
+		chunk := rest[:12+n] // length, type, data, CRC
+		if string(chunk[4:8]) == "iCCP" {
+			stripped = true
+		} else {
+			out = append(out, chunk...)
+		}
+		rest = rest[len(chunk):]
+	}
+	if !stripped {
+		return nil
+	}
+	return out
+}
+
+// stripJPEGProfile drops APP2 ICC_PROFILE segments from a JPEG's header;
+// everything from the first scan on is copied unchanged.
+func stripJPEGProfile(data []byte) []byte {
+	out := []byte{0xff, 0xd8}
+	stripped := false
+	rest := data[2:]
+	for {
+		if len(rest) < 4 || rest[0] != 0xff {
+			return nil
+		}
+		marker := rest[1]
+		if marker == 0xda || marker == 0xd9 { // SOS, EOI
+			out = append(out, rest...)
+			break
+		}
+		n := int(binary.BigEndian.Uint16(rest[2:]))
+		if n < 2 || n+2 > len(rest) {
+			return nil
+		}
+		seg := rest[:n+2]
+		if marker == 0xe2 && bytes.HasPrefix(seg[4:], iccJPEGHeader) {
+			stripped = true
+		} else {
+			out = append(out, seg...)
+		}
+		rest = rest[len(seg):]
+	}
+	if !stripped {
+		return nil
+	}
+	return out
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+// fakeProfile stands in for an ICC profile's bytes.
+var fakeProfile = bytes.Repeat([]byte("icc!"), 2000)
+
+// withPNGProfile inserts an iCCP chunk after a PNG's IHDR chunk.
+func withPNGProfile(data []byte) []byte {
+	body := append([]byte("sRGB\x00\x00"), fakeProfile...)
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(body)))
+	chunk = append(chunk, "iCCP"...)
+	chunk = append(chunk, body...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+	ihdrEnd := len(pngSignature) + 12 + 13
+	return append(append(append([]byte(nil), data[:ihdrEnd]...), chunk...), data[ihdrEnd:]...)
+}
+
+// withJPEGProfile inserts an APP2 ICC_PROFILE segment after a JPEG's SOI.
+func withJPEGProfile(data []byte) []byte {
+	body := append(append(append([]byte(nil), iccJPEGHeader...), 1, 1), fakeProfile...)
+	seg := []byte{0xff, 0xe2}
+	seg = binary.BigEndian.AppendUint16(seg, uint16(len(body)+2))
+	seg = append(seg, body...)
+	return append(append([]byte{0xff, 0xd8}, seg...), data[2:]...)
+}
+
+func TestStripColorProfile(t *testing.T) {
+	pngData := makePNG(20, 10, color.NRGBA{200, 40, 40, 255})
+	jpegData := makeJPEG(20, 10, color.NRGBA{200, 40, 40, 255})
+
+	for name, tc := range map[string]struct {
+		plain, profiled []byte
+		decode          func([]byte) (image.Image, error)
+	}{
+		"png":  {pngData, withPNGProfile(pngData), func(b []byte) (image.Image, error) { return png.Decode(bytes.NewReader(b)) }},
+		"jpeg": {jpegData, withJPEGProfile(jpegData), func(b []byte) (image.Image, error) { return jpeg.Decode(bytes.NewReader(b)) }},
+	} {
+		if _, err := tc.decode(tc.profiled); err != nil {
+			t.Fatalf("%s: test image with profile should decode: %v", name, err)
+		}
+		got := stripColorProfile(tc.profiled)
+		if !bytes.Equal(got, tc.plain) {
+			t.Errorf("%s: stripping should restore the original %d bytes, got %d", name, len(tc.plain), len(got))
+		}
+		if got := stripColorProfile(tc.plain); got != nil {
+			t.Errorf("%s: image without a profile should be left alone", name)
+		}
+	}
+	if got := stripColorProfile(withPNGProfile(pngData)[:100]); got != nil {
+		t.Error("truncated PNG should be left alone")
+	}
+	if got := stripColorProfile([]byte("GIF89a...")); got != nil {
+		t.Error("other formats should be left alone")
+	}
+}
+
+func TestTryOptimizeDataURI_StripICC(t *testing.T) {
+	profiled := withPNGProfile(makePNG(100, 100, color.NRGBA{255, 0, 0, 255}))
+	b64 := base64.StdEncoding.EncodeToString(profiled)
+
+	var st stats
+	opts := optimizeOpts{maxWidth: 800, quality: 60, skipSmall: true}
+	if uri := tryOptimizeDataURI("image/png", b64, opts, &st); uri != "" {
+		t.Error("without -strip-icc a kept image should stay as it is")
+	}
+	opts.stripICC = true
+	uri := tryOptimizeDataURI("image/png", b64, opts, &st)
+	if !strings.HasPrefix(uri, "data:image/png;base64,") {
+		t.Fatalf("expected the kept PNG without its profile, got %.40q", uri)
+	}
+	if raw, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(uri, "data:image/png;base64,")); bytes.Contains(raw, []byte("iCCP")) {
+		t.Error("iCCP chunk should be removed")
+	}
+	if st.kept != 2 || st.profiles != 1 || st.profileBytes < int64(len(fakeProfile)) {
+		t.Errorf("unexpected stats %+v", st)
+	}
+}
@@ -0,0 +1,145 @@
+// Comments section (-include-comments-section).
+// Readability drops reader comments with the rest of the page furniture.
+// For discussion-heavy posts this recovers them from the markup of a few
+// common comment systems and appends them after the article under a
+// "Comments" heading, where they go through the same image and sanitize
+// steps as the article. Only comments in the served HTML can be found:
+// systems that load them with script, like Disqus, are out of reach.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"strings"
+
+	xhtml "golang.org/x/net/html"
+)
+
+// maxSectionComments caps how many comments are included, in page order.
+const maxSectionComments = 50
+
+// commentSystem describes where one comment system's rendered markup keeps
+// each comment, by class name.
+type commentSystem struct {
+	item    string // each comment, replies nested inside
+	message string // the comment's text
+	author  string // the commenter's name
+}
+
+var commentSystems = []commentSystem{
+	{item: "comment", message: "comment-content", author: "fn"}, // WordPress
+	{item: "comment", message: "comment-text", author: "fn"},    // older WordPress themes
+	{item: "comtr", message: "commtext", author: "hnuser"},      // Hacker News
+}
+
+// extractCommentsSection returns the page's comments as a "Comments"
+// section to append to the article, using the first comment system that
+// finds any, or "" when none does. Each comment is its author's name in
+// bold followed by its text in a blockquote.
+func extractCommentsSection(page []byte, pageURL *url.URL) string {
+	doc, err := xhtml.Parse(bytes.NewReader(page))
+	if err != nil {
+		return ""
+	}
+	for _, sys := range commentSystems {
+		var buf bytes.Buffer
+		n := 0
+		var walk func(p *xhtml.Node)
+		walk = func(p *xhtml.Node) {
+			for c := p.FirstChild; c != nil && n < maxSectionComments; c = c.NextSibling {
+				if c.Type != xhtml.ElementNode {
+					continue
+				}
+				if hasClass(c, sys.item) {
+					if msg := findByClass(c, sys.message); msg != nil {
+						if writeComment(&buf, findByClass(c, sys.author), msg, pageURL) {
+							n++
+						}
+					}
+				}
+				walk(c)
+			}
+		}
+		walk(doc)
+		if n > 0 {
+			fmt.Fprintf(logOut, "Included %d comments\n", n)
+			return `<section class="comments"><h2>Comments</h2>` + buf.String() + `</section>`
+		}
+	}
+	return ""
+}
+
+// writeComment writes one comment to buf, reporting false if its text is
+// empty. Scripts, styles, and reply links are dropped, and links and images
+// made absolute as rawArticle does.
+func writeComment(buf *bytes.Buffer, author, msg *xhtml.Node, pageURL *url.URL) bool {
+	if strings.TrimSpace(textContent(msg)) == "" {
+		return false
+	}
+	var clean func(n *xhtml.Node)
+	clean = func(n *xhtml.Node) {
+		for c := n.FirstChild; c != nil; {
+			next := c.NextSibling
+			switch {
+			case c.Type == xhtml.CommentNode,
+				c.Type == xhtml.ElementNode && (c.Data == "script" || c.Data == "style" || hasClass(c, "reply")):
+				n.RemoveChild(c)
+			case c.Type == xhtml.ElementNode:
+				for i, a := range c.Attr {
+					switch {
+					case urlAttrs[a.Key]:
+						c.Attr[i].Val = resolveAttrURL(a.Val, pageURL)
+					case a.Key == "srcset":
+						c.Attr[i].Val = resolveSrcset(a.Val, pageURL)
+					}
+				}
+				clean(c)
+			}
+			c = next
+		}
+	}
+	clean(msg)
+
+	if author != nil {
+		if name := strings.Join(strings.Fields(textContent(author)), " "); name != "" {
+			buf.WriteString(`<p class="comment-author"><strong>` + xhtml.EscapeString(name) + `</strong></p>`)
+		}
+	}
+	buf.WriteString("<blockquote>")
+	// HN and some themes keep a comment's first paragraph as bare text.
+	if c := msg.FirstChild; c != nil && c.Type == xhtml.TextNode && strings.TrimSpace(c.Data) != "" {
+		buf.WriteString("<p>")
+		for c != nil && (c.Type == xhtml.TextNode || c.Type == xhtml.ElementNode && c.Data != "p" && c.Data != "div") {
+			xhtml.Render(buf, c)
+			c = c.NextSibling
+		}
+		buf.WriteString("</p>")
+		for ; c != nil; c = c.NextSibling {
+			xhtml.Render(buf, c)
+		}
+	} else {
+		for c := msg.FirstChild; c != nil; c = c.NextSibling {
+			xhtml.Render(buf, c)
+		}
+	}
+	buf.WriteString("</blockquote>")
+	return true
+}
+
+// findByClass returns the first element under n (depth first) whose class
+// attribute lists name.
+func findByClass(n *xhtml.Node, name string) *xhtml.Node {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != xhtml.ElementNode {
+			continue
+		}
+		if hasClass(c, name) {
+			return c
+		}
+		if found := findByClass(c, name); found != nil {
+			return found
+		}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExtractCommentsSection(t *testing.T) {
+	base, _ := url.Parse("https://example.com/post/")
+	for _, tc := range []struct {
+		name, page, want string
+	}{
+		{
+			"wordpress",
+			`<ol class="comment-list"><li class="comment depth-1" id="comment-1"><article class="comment-body"><footer class="comment-meta"><b class="fn">Ann</b> says:</footer><div class="comment-content"><p>Great post.</p><script>x()</script></div><div class="reply"><a href="#">Reply</a></div></article>` +
+				`<ol class="children"><li class="comment depth-2"><article class="comment-body"><b class="fn"><a href="/ben">Ben</a></b><div class="comment-content"><p>Agreed, see <a href="notes">notes</a> and <a href="#comment-1">Ann</a>.</p></div></article></li></ol></li></ol>`,
+			`<section class="comments"><h2>Comments</h2><p class="comment-author"><strong>Ann</strong></p><blockquote><p>Great post.</p></blockquote>` +
+				`<p class="comment-author"><strong>Ben</strong></p><blockquote><p>Agreed, see <a href="https://example.com/post/notes">notes</a> and <a href="#comment-1">Ann</a>.</p></blockquote></section>`,
+		},
+		{
+			"hacker news",
+			`<table><tr class="athing comtr"><td><a class="hnuser">dee</a><div class="comment"><div class="commtext c00">Bare first line <i>here</i>.<p>Second.</p></div><div class="reply"><a>reply</a></div></div></td></tr></table>`,
+			`<section class="comments"><h2>Comments</h2><p class="comment-author"><strong>dee</strong></p><blockquote><p>Bare first line <i>here</i>.</p><p>Second.</p></blockquote></section>`,
+		},
+		{"none", `<div class="comment">A comment-styled note, not a comment thread.</div>`, ""},
+		{"empty", `<li class="comment"><div class="comment-content"> </div></li>`, ""},
+	} {
+		if got := extractCommentsSection([]byte(tc.page), base); got != tc.want {
+			t.Errorf("%s:\n got %s\nwant %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestProcessURL_IncludeCommentsSection(t *testing.T) {
+	page := strings.Replace(makeArticleHTML("Discussed", "The article itself."), "</body>",
+		`<div id="comments"><ol class="commentlist"><li class="comment"><cite class="fn">Eve</cite><div class="comment-content"><p>A reader's reply.</p></div></li></ol></div></body>`, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(page))
+	}))
+	defer srv.Close()
+	for _, include := range []bool{false, true} {
+		cfg := cliConfig{format: "html", inclComments: include, timeout: 5 * time.Second}
+		cfg.opts.skipImageFetch = true
+		out, _, _, err := processURL(srv.URL, cfg, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Contains(out, "A reader&#39;s reply.") && strings.Contains(out, ">Comments</h"); got != include {
+			t.Errorf("include %v: comments section present = %v:\n%s", include, got, out)
+		}
+	}
+}
+
+func TestRun_CommentsSectionWithSkipExtraction(t *testing.T) {
+	err := run(cliConfig{inclComments: true, skipExtract: true, args: []string{"http://example.com"}})
+	if err == nil || !strings.Contains(err.Error(), "-skip-extraction") {
+		t.Errorf("expected -include-comments-section with -skip-extraction to fail, got %v", err)
+	}
+}
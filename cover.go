@@ -1,5 +1,6 @@
 // Cover image generation for epub output.
-// Supports multiple cover styles: "typographic" (default), "collage", and "pattern".
+// Supports multiple cover styles: "typographic" (default), "collage", and "pattern",
+// plus "first-image", which uses the first article's lead image.
 package main
 
 import (
@@ -8,6 +9,7 @@ import (
 	"fmt"
 	"image"
 	"image/color"
+	"image/jpeg"
 	"image/png"
 	"math"
 	"strings"
@@ -24,10 +26,29 @@ import (
 const (
 	coverWidth  = 1200
 	coverHeight = 1800
+
+	// maxCoverColumns is the most columns of article titles the collage
+	// cover will lay out; beyond this they get too narrow to read.
+	maxCoverColumns = 3
 )
 
+// coverNow supplies the typographic cover's date when none is given;
+// tests replace it.
+var coverNow = time.Now
+
+// coverOpts holds optional settings for generateCover.
+type coverOpts struct {
+	style    string    // "typographic" (default), "collage", or "pattern"
+	subtitle string    // optional line drawn in the smaller face below the title
+	date     time.Time // date printed on the typographic cover (zero = today)
+
+	// collage layout: columns of article titles (0 = 1), and how many
+	// articles to list before "+ N more" (0 = as many as fit)
+	columns, maxEntries int
+}
+
 // generateCover creates a PNG cover image based on the selected style.
-func generateCover(title string, articles []epubArticle, style string) ([]byte, error) {
+func generateCover(title string, articles []epubArticle, opts coverOpts) ([]byte, error) {
 	img := image.NewGray(image.Rect(0, 0, coverWidth, coverHeight))
 
 	// Fill background white
@@ -47,16 +68,16 @@ func generateCover(title string, articles []epubArticle, style string) ([]byte,
 		return nil, fmt.Errorf("loading small font: %w", err)
 	}
 
-	switch style {
+	switch opts.style {
 	case "pattern":
-		drawPatternCover(img, title, len(articles), boldFace, regularFace)
+		drawPatternCover(img, title, opts.subtitle, len(articles), boldFace, regularFace)
 	case "collage":
-		drawCollageCover(img, title, articles, boldFace, regularFace, smallFace)
+		drawCollageCover(img, title, opts.subtitle, articles, opts.columns, opts.maxEntries, boldFace, regularFace, smallFace)
 	case "typographic":
-		drawTypographicCover(img, title, len(articles), boldFace, regularFace)
+		drawTypographicCover(img, title, opts.subtitle, len(articles), opts.date, boldFace, regularFace)
 	default:
 		// Default to typographic if unknown
-		drawTypographicCover(img, title, len(articles), boldFace, regularFace)
+		drawTypographicCover(img, title, opts.subtitle, len(articles), opts.date, boldFace, regularFace)
 	}
 
 	// Draw "deckle" in bottom-right (common to all styles)
@@ -69,19 +90,67 @@ func generateCover(title string, articles []epubArticle, style string) ([]byte,
 	return buf.Bytes(), nil
 }
 
+// firstImageCover returns the first decodable embedded image of the first
+// article as a JPEG cover: center-cropped to the cover's 2:3 shape and
+// downscaled (never upscaled) to the cover size.
+func firstImageCover(articles []epubArticle) ([]byte, error) {
+	if len(articles) == 0 {
+		return nil, fmt.Errorf("no articles")
+	}
+	html, err := articles[0].loadHTML()
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range dataURIRe.FindAllStringSubmatch(html, -1) {
+		raw, err := decodeBase64(m[3])
+		if err != nil {
+			continue
+		}
+		src, _, err := image.Decode(bytes.NewReader(raw))
+		if err != nil {
+			continue
+		}
+		img := flattenAlpha(src, nil)
+
+		// Crop to the cover aspect ratio, keeping the center
+		b := img.Bounds()
+		w, h := b.Dx(), b.Dy()
+		if w*coverHeight > h*coverWidth {
+			w = h * coverWidth / coverHeight
+		} else {
+			h = w * coverHeight / coverWidth
+		}
+		if w < 1 || h < 1 {
+			continue
+		}
+		x0, y0 := b.Min.X+(b.Dx()-w)/2, b.Min.Y+(b.Dy()-h)/2
+		var cover image.Image = img.SubImage(image.Rect(x0, y0, x0+w, y0+h))
+		if w > coverWidth {
+			cover = resize(cover, coverWidth, coverHeight)
+		}
+
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, cover, &jpeg.Options{Quality: 85}); err != nil {
+			return nil, fmt.Errorf("encoding cover JPEG: %w", err)
+		}
+		return buf.Bytes(), nil
+	}
+	return nil, fmt.Errorf("first article has no usable image")
+}
+
 // drawPatternCover implements the geometric pattern style.
-func drawPatternCover(img *image.Gray, title string, articleCount int, titleFace, metaFace font.Face) {
+func drawPatternCover(img *image.Gray, title, subtitle string, articleCount int, titleFace, metaFace font.Face) {
 	// Generate pattern from title hash
 	hash := sha256.Sum256([]byte(title))
 	drawPattern(img, hash)
 
 	// Draw title block in the centre
-	drawTitleBlock(img, title, articleCount, titleFace, metaFace)
+	drawTitleBlock(img, title, subtitle, articleCount, titleFace, metaFace)
 }
 
 // drawTypographicCover implements a clean, minimal text-only cover.
 // Large title centred vertically, divider rule, article count, and date.
-func drawTypographicCover(img *image.Gray, title string, articleCount int, titleFace, metaFace font.Face) {
+func drawTypographicCover(img *image.Gray, title, subtitle string, articleCount int, date time.Time, titleFace, metaFace font.Face) {
 	const (
 		padX     = 120
 		maxWidth = coverWidth - padX*2
@@ -92,10 +161,17 @@ func drawTypographicCover(img *image.Gray, title string, articleCount int, title
 	titleLineH := titleFace.Metrics().Height.Ceil() + 8
 	metaLineH := metaFace.Metrics().Height.Ceil() + 12
 
+	var subLines []string
+	subtitleH := 0
+	if subtitle != "" {
+		subLines = wrapText(subtitle, metaFace, maxWidth)
+		subtitleH = 20 + len(subLines)*metaLineH
+	}
+
 	// Compute total block height:
-	//   title lines + gap + divider + gap + article count + date
+	//   title lines + subtitle + gap + divider + gap + article count + date
 	dividerGap := 50
-	totalHeight := len(lines)*titleLineH + dividerGap + 6 + dividerGap + metaLineH + metaLineH
+	totalHeight := len(lines)*titleLineH + subtitleH + dividerGap + 6 + dividerGap + metaLineH + metaLineH
 
 	// Start Y so the block is vertically centred (slightly above true centre)
 	startY := (coverHeight-totalHeight)/2 - 60
@@ -109,6 +185,16 @@ func drawTypographicCover(img *image.Gray, title string, articleCount int, title
 		y += titleLineH
 	}
 
+	// Subtitle lines (centred, smaller face)
+	if len(subLines) > 0 {
+		y += 20
+		for _, line := range subLines {
+			lineW := font.MeasureString(metaFace, line).Ceil()
+			drawString(img, line, metaFace, (coverWidth-lineW)/2, y+metaFace.Metrics().Ascent.Ceil())
+			y += metaLineH
+		}
+	}
+
 	// Gap before divider
 	y += dividerGap
 
@@ -142,18 +228,23 @@ func drawTypographicCover(img *image.Gray, title string, articleCount int, title
 	y += metaLineH
 
 	// Date (month and year)
-	now := time.Now()
-	dateText := now.Format("Jan 2, 2006")
+	if date.IsZero() {
+		date = coverNow()
+	}
+	dateText := date.Format("Jan 2, 2006")
 	dateW := font.MeasureString(metaFace, dateText).Ceil()
 	drawString(img, dateText, metaFace, (coverWidth-dateW)/2, y+metaFace.Metrics().Ascent.Ceil())
 }
 
-// drawCollageCover implements the Table-of-Contents Collage style.
-func drawCollageCover(img *image.Gray, title string, articles []epubArticle, titleFace, bodyFace, metaFace font.Face) {
+// drawCollageCover implements the Table-of-Contents Collage style. Article
+// titles fill columns top to bottom, left to right; whatever doesn't fit,
+// or is past maxEntries when that is positive, is summed up as "+ N more".
+func drawCollageCover(img *image.Gray, title, subtitle string, articles []epubArticle, columns, maxEntries int, titleFace, bodyFace, metaFace font.Face) {
 	const (
 		padX      = 80
 		padY      = 120
 		lineGap   = 20
+		colGap    = 50
 		maxWidth  = coverWidth - padX*2
 		maxHeight = coverHeight - padY*2 - 100 // Leave space for footer
 	)
@@ -167,6 +258,14 @@ func drawCollageCover(img *image.Gray, title string, articles []epubArticle, tit
 		drawString(img, line, titleFace, padX, y+titleFace.Metrics().Ascent.Ceil())
 		y += lineHeight
 	}
+	if subtitle != "" {
+		y += 10
+		subHeight := bodyFace.Metrics().Height.Ceil() + 8
+		for _, line := range wrapText(subtitle, bodyFace, maxWidth) {
+			drawString(img, line, bodyFace, padX, y+bodyFace.Metrics().Ascent.Ceil())
+			y += subHeight
+		}
+	}
 	y += 40
 
 	// 2. Draw Divider
@@ -181,44 +280,49 @@ func drawCollageCover(img *image.Gray, title string, articles []epubArticle, tit
 	// 3. Draw Articles
 	bodyHeight := bodyFace.Metrics().Height.Ceil() + 8
 	metaHeight := metaFace.Metrics().Height.Ceil() + 8
-	
+
+	columns = max(columns, 1)
+	colWidth := (maxWidth - colGap*(columns-1)) / columns
+	top, col, x := y, 0, padX
+
 	articlesShown := 0
-	
+
 	for i, art := range articles {
-		// Check if we have space for this article (Title + maybe meta line + margin)
-		// We approximate the height needed.
-		// Truncate title to 2 lines max to save space?
-		// Let's wrap and see.
-		
+		if maxEntries > 0 && articlesShown == maxEntries {
+			break
+		}
+
 		artTitle := art.Title
 		if artTitle == "" {
 			artTitle = fmt.Sprintf("Article %d", i+1)
 		}
-		
-		titleLines := wrapText(artTitle, bodyFace, maxWidth)
+
+		// Titles are cut to 2 lines to leave room for more entries.
+		titleLines := wrapText(artTitle, bodyFace, colWidth)
 		if len(titleLines) > 2 {
 			titleLines = titleLines[:2]
-			titleLines[1] = strings.TrimSuffix(titleLines[1], "...") + "..."
+			titleLines[1] = fitEllipsis(titleLines[1], bodyFace, colWidth)
 		}
-		
+
 		entryHeight := len(titleLines)*bodyHeight + metaHeight + 30 // 30 is margin below
-		
-		// If this entry would push us past the limit, stop here
+
+		// If this entry would push us past the limit, move to the next
+		// column, or stop here after the last one
 		if y+entryHeight > maxHeight {
-			remaining := len(articles) - articlesShown
-			if remaining > 0 {
-				moreText := fmt.Sprintf("+ %d more articles", remaining)
-				drawString(img, moreText, bodyFace, padX, y+bodyFace.Metrics().Ascent.Ceil())
+			if col+1 == columns {
+				break
 			}
-			return
+			col++
+			x = padX + col*(colWidth+colGap)
+			y = top
 		}
-		
+
 		// Draw Article Title
 		for _, line := range titleLines {
-			drawString(img, line, bodyFace, padX, y+bodyFace.Metrics().Ascent.Ceil())
+			drawString(img, line, bodyFace, x, y+bodyFace.Metrics().Ascent.Ceil())
 			y += bodyHeight
 		}
-		
+
 		// Draw Meta (Author, Site)
 		var metaParts []string
 		if art.Byline != "" {
@@ -230,20 +334,25 @@ func drawCollageCover(img *image.Gray, title string, articles []epubArticle, tit
 		if len(metaParts) > 0 {
 			metaStr := "— " + strings.Join(metaParts, ", ")
 			// Truncate meta if too long
-			if font.MeasureString(metaFace, metaStr).Ceil() > maxWidth {
+			if font.MeasureString(metaFace, metaStr).Ceil() > colWidth {
 				// Simple truncation for now
-				for font.MeasureString(metaFace, metaStr+"...").Ceil() > maxWidth && len(metaStr) > 0 {
+				for font.MeasureString(metaFace, metaStr+"...").Ceil() > colWidth && len(metaStr) > 0 {
 					metaStr = metaStr[:len(metaStr)-1]
 				}
 				metaStr += "..."
 			}
-			drawString(img, metaStr, metaFace, padX, y+metaFace.Metrics().Ascent.Ceil())
+			drawString(img, metaStr, metaFace, x, y+metaFace.Metrics().Ascent.Ceil())
 			y += metaHeight
 		}
-		
+
 		y += 30 // Margin between articles
 		articlesShown++
 	}
+
+	if remaining := len(articles) - articlesShown; remaining > 0 {
+		moreText := fmt.Sprintf("+ %d more articles", remaining)
+		drawString(img, moreText, bodyFace, x, y+bodyFace.Metrics().Ascent.Ceil())
+	}
 }
 
 // drawPattern fills the image with a grid of circles whose size and shade
@@ -251,10 +360,10 @@ func drawCollageCover(img *image.Gray, title string, articles []epubArticle, tit
 // bands with a clear central strip left for the title.
 func drawPattern(img *image.Gray, hash [32]byte) {
 	const (
-		cols    = 12
-		rows    = 18
-		cellW   = coverWidth / cols
-		cellH   = coverHeight / rows
+		cols  = 12
+		rows  = 18
+		cellW = coverWidth / cols
+		cellH = coverHeight / rows
 		// Rows reserved for the title block (centre of image)
 		titleRowStart = 7
 		titleRowEnd   = 11
@@ -307,7 +416,7 @@ func fillCircle(img *image.Gray, cx, cy int, radius float64, c color.Gray) {
 
 // drawTitleBlock renders the title text (word-wrapped) and article count
 // centred vertically in the middle of the cover, on a white band.
-func drawTitleBlock(img *image.Gray, title string, articleCount int, titleFace, metaFace font.Face) {
+func drawTitleBlock(img *image.Gray, title, subtitle string, articleCount int, titleFace, metaFace font.Face) {
 	const (
 		bandTop    = 650
 		bandBottom = 1150
@@ -333,9 +442,15 @@ func drawTitleBlock(img *image.Gray, title string, articleCount int, titleFace,
 	lines := wrapText(title, titleFace, maxWidth)
 	lineHeight := titleFace.Metrics().Height.Ceil() + 8
 
-	// Calculate vertical start so title + meta are centred in the band
+	var subLines []string
+	if subtitle != "" {
+		subLines = wrapText(subtitle, metaFace, maxWidth)
+	}
+	subLineHeight := metaFace.Metrics().Height.Ceil() + 8
+
+	// Calculate vertical start so title + subtitle + meta are centred in the band
 	metaHeight := metaFace.Metrics().Height.Ceil() + 16
-	totalHeight := len(lines)*lineHeight + metaHeight
+	totalHeight := len(lines)*lineHeight + len(subLines)*subLineHeight + metaHeight
 	y := bandTop + (bandBottom-bandTop-totalHeight)/2 + titleFace.Metrics().Ascent.Ceil()
 
 	for _, line := range lines {
@@ -345,6 +460,13 @@ func drawTitleBlock(img *image.Gray, title string, articleCount int, titleFace,
 		y += lineHeight
 	}
 
+	// Subtitle below title
+	for _, line := range subLines {
+		lineW := font.MeasureString(metaFace, line).Ceil()
+		drawString(img, line, metaFace, (coverWidth-lineW)/2, y)
+		y += subLineHeight
+	}
+
 	// Article count below title
 	y += 16
 	meta := fmt.Sprintf("%d articles", articleCount)
@@ -404,6 +526,20 @@ func wrapText(text string, face font.Face, maxWidth int) []string {
 	return lines
 }
 
+// fitEllipsis ends line with "…", dropping whole words from its end until
+// it fits in maxWidth.
+func fitEllipsis(line string, face font.Face, maxWidth int) string {
+	line = strings.TrimSuffix(line, "…")
+	for font.MeasureString(face, line+"…").Ceil() > maxWidth {
+		i := strings.LastIndexByte(line, ' ')
+		if i <= 0 {
+			break
+		}
+		line = line[:i]
+	}
+	return line + "…"
+}
+
 // splitWords splits a string on whitespace, returning non-empty tokens.
 func splitWords(s string) []string {
 	var words []string
@@ -3,11 +3,16 @@ package main
 import (
 	"archive/zip"
 	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
 	"image/png"
+	"path/filepath"
 	"strings"
 	"testing"
-	"path/filepath"
 
+	"golang.org/x/image/font"
 	"golang.org/x/image/font/gofont/gobold"
 	"golang.org/x/image/font/gofont/goregular"
 )
@@ -17,7 +22,7 @@ func TestGenerateCover_Collage(t *testing.T) {
 		{Title: "Article 1", Byline: "Author 1", SiteName: "Site 1"},
 		{Title: "Article 2", Byline: "Author 2", SiteName: "Site 2"},
 	}
-	data, err := generateCover("Weekly Reads", articles, "collage")
+	data, err := generateCover("Weekly Reads", articles, coverOpts{style: "collage"})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -40,7 +45,7 @@ func TestGenerateCover_Pattern(t *testing.T) {
 	articles := []epubArticle{
 		{Title: "Article 1"},
 	}
-	data, err := generateCover("Weekly Reads", articles, "pattern")
+	data, err := generateCover("Weekly Reads", articles, coverOpts{style: "pattern"})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -55,7 +60,7 @@ func TestGenerateCover_Typographic(t *testing.T) {
 		{Title: "Article 2", Byline: "Author 2", SiteName: "Site 2"},
 		{Title: "Article 3"},
 	}
-	data, err := generateCover("Weekly Reads", articles, "typographic")
+	data, err := generateCover("Weekly Reads", articles, coverOpts{style: "typographic"})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -77,7 +82,7 @@ func TestGenerateCover_TypographicSingleArticle(t *testing.T) {
 	articles := []epubArticle{
 		{Title: "Only One"},
 	}
-	data, err := generateCover("Solo Read", articles, "typographic")
+	data, err := generateCover("Solo Read", articles, coverOpts{style: "typographic"})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -90,11 +95,11 @@ func TestGenerateCover_TypographicIsDefault(t *testing.T) {
 	articles := []epubArticle{{Title: "A"}}
 
 	// Unknown style should fall back to typographic (the default)
-	a, err := generateCover("Title", articles, "typographic")
+	a, err := generateCover("Title", articles, coverOpts{style: "typographic"})
 	if err != nil {
 		t.Fatal(err)
 	}
-	b, err := generateCover("Title", articles, "unknown-style")
+	b, err := generateCover("Title", articles, coverOpts{style: "unknown-style"})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -105,11 +110,11 @@ func TestGenerateCover_TypographicIsDefault(t *testing.T) {
 
 func TestGenerateCover_Deterministic(t *testing.T) {
 	articles := []epubArticle{{Title: "A"}}
-	a, err := generateCover("Same Title", articles, "collage")
+	a, err := generateCover("Same Title", articles, coverOpts{style: "collage"})
 	if err != nil {
 		t.Fatal(err)
 	}
-	b, err := generateCover("Same Title", articles, "collage")
+	b, err := generateCover("Same Title", articles, coverOpts{style: "collage"})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -123,7 +128,7 @@ func TestGenerateCover_DifferentStyles(t *testing.T) {
 	styles := []string{"typographic", "collage", "pattern"}
 	covers := make(map[string][]byte)
 	for _, s := range styles {
-		data, err := generateCover("Same Title", articles, s)
+		data, err := generateCover("Same Title", articles, coverOpts{style: s})
 		if err != nil {
 			t.Fatalf("style %q: %v", s, err)
 		}
@@ -141,7 +146,7 @@ func TestGenerateCover_DifferentStyles(t *testing.T) {
 func TestGenerateCover_LongTitle(t *testing.T) {
 	title := "This Is a Very Long Title That Should Wrap Across Multiple Lines on the Cover Image"
 	articles := []epubArticle{{Title: "A"}}
-	data, err := generateCover(title, articles, "collage")
+	data, err := generateCover(title, articles, coverOpts{style: "collage"})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -156,6 +161,98 @@ func TestGenerateCover_LongTitle(t *testing.T) {
 	}
 }
 
+func TestGenerateCover_Subtitle(t *testing.T) {
+	articles := []epubArticle{{Title: "A"}}
+	for _, style := range []string{"typographic", "collage", "pattern"} {
+		plain, err := generateCover("Weekly Reads", articles, coverOpts{style: style})
+		if err != nil {
+			t.Fatal(err)
+		}
+		withSub, err := generateCover("Weekly Reads", articles, coverOpts{style: style, subtitle: "A Weekly Digest of Long Reads Worth Your Time"})
+		if err != nil {
+			t.Fatalf("style %q: %v", style, err)
+		}
+		if bytes.Equal(plain, withSub) {
+			t.Errorf("style %q: subtitle should change the cover", style)
+		}
+	}
+}
+
+func TestGenerateCover_CollageLayout(t *testing.T) {
+	var articles []epubArticle
+	for i := range 30 {
+		articles = append(articles, epubArticle{Title: fmt.Sprintf("Short %d", i+1), SiteName: "Site"})
+	}
+	// hasInk reports whether the cover has any dark pixels in r.
+	hasInk := func(opts coverOpts, r image.Rectangle) bool {
+		t.Helper()
+		opts.style = "collage"
+		data, err := generateCover("Weekly Reads", articles, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				if color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y < 0x80 {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	rightHalf := image.Rect(coverWidth/2+40, 400, coverWidth-80, 1400)
+	if hasInk(coverOpts{}, rightHalf) {
+		t.Error("single-column collage should leave the right half blank")
+	}
+	if !hasInk(coverOpts{columns: 2}, rightHalf) {
+		t.Error("two-column collage should list articles in the right half")
+	}
+
+	lower := image.Rect(80, 900, coverWidth-80, 1400)
+	if !hasInk(coverOpts{}, lower) {
+		t.Error("collage should fill the cover with entries by default")
+	}
+	if hasInk(coverOpts{maxEntries: 3}, lower) {
+		t.Error("-cover-max-entries 3 should stop listing after three entries")
+	}
+}
+
+func TestBuildEpub_CoverTitleOverride(t *testing.T) {
+	articles := []epubArticle{{HTML: `<html><body><h1>A</h1><p>Text.</p></body></html>`, Title: "A"}}
+	dir := t.TempDir()
+
+	coverOf := func(name string, opts epubOpts) []byte {
+		path := filepath.Join(dir, name)
+		if err := buildEpub(articles, "Book Title", path, opts); err != nil {
+			t.Fatal(err)
+		}
+		zr, err := zip.OpenReader(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zr.Close()
+		return []byte(findZipFile(zr, "EPUB/images/cover.png"))
+	}
+
+	base := coverOf("base.epub", epubOpts{coverStyle: "typographic"})
+	same := coverOf("same.epub", epubOpts{coverStyle: "typographic", coverTitle: "Book Title"})
+	custom := coverOf("custom.epub", epubOpts{coverStyle: "typographic", coverTitle: "Something Else"})
+	if len(base) == 0 {
+		t.Fatal("cover.png not found")
+	}
+	if !bytes.Equal(base, same) {
+		t.Error("cover title equal to the book title should match the default cover")
+	}
+	if bytes.Equal(base, custom) {
+		t.Error("custom cover title should change the cover")
+	}
+}
+
 func TestWrapText(t *testing.T) {
 	face, err := loadFace(goregular.TTF, 32)
 	if err != nil {
@@ -184,6 +281,20 @@ func TestWrapText_EmptyString(t *testing.T) {
 	}
 }
 
+func TestFitEllipsis(t *testing.T) {
+	face, err := loadFace(goregular.TTF, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	width := font.MeasureString(face, "The quick brown fox").Ceil()
+	if got := fitEllipsis("The quick brown fox", face, width); got != "The quick brown…" {
+		t.Errorf("expected whole words dropped to fit the ellipsis, got %q", got)
+	}
+	if got := fitEllipsis("Short", face, 10000); got != "Short…" {
+		t.Errorf("got %q", got)
+	}
+}
+
 func TestSplitWords(t *testing.T) {
 	tests := []struct {
 		input string
@@ -251,7 +362,7 @@ func TestBuildEpub_HasCover(t *testing.T) {
 	}
 
 	outPath := filepath.Join(t.TempDir(), "cover_test.epub")
-	if err := buildEpub(articles, "Cover Test", outPath, "typographic"); err != nil {
+	if err := buildEpub(articles, "Cover Test", outPath, epubOpts{coverStyle: "typographic"}); err != nil {
 		t.Fatal(err)
 	}
 
@@ -287,7 +398,7 @@ func TestBuildEpub_NoCover(t *testing.T) {
 	}
 
 	outPath := filepath.Join(t.TempDir(), "nocover_test.epub")
-	if err := buildEpub(articles, "Cover Test", outPath, "none"); err != nil {
+	if err := buildEpub(articles, "Cover Test", outPath, epubOpts{coverStyle: "none"}); err != nil {
 		t.Fatal(err)
 	}
 
@@ -309,3 +420,76 @@ func TestBuildEpub_NoCover(t *testing.T) {
 		t.Error("epub should NOT contain cover.png when style is 'none'")
 	}
 }
+
+func TestFirstImageCover(t *testing.T) {
+	article := func(imgs ...[]byte) []epubArticle {
+		body := "<p>Text.</p>"
+		for _, img := range imgs {
+			body += `<img src="` + dataURI("image/png", img) + `" alt="">`
+		}
+		return []epubArticle{{HTML: "<html><body>" + body + "</body></html>"}}
+	}
+
+	tests := []struct {
+		name         string
+		w, h         int
+		wantW, wantH int
+	}{
+		{"landscape cropped to 2:3", 800, 400, 266, 400},
+		{"large portrait downscaled", 2000, 4000, coverWidth, coverHeight},
+	}
+	for _, tt := range tests {
+		jpg, err := firstImageCover(article(makePNG(tt.w, tt.h, color.NRGBA{0, 90, 0, 255})))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(jpg))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if cfg.Width != tt.wantW || cfg.Height != tt.wantH {
+			t.Errorf("%s: got %dx%d, want %dx%d", tt.name, cfg.Width, cfg.Height, tt.wantW, tt.wantH)
+		}
+	}
+
+	// An undecodable image is skipped in favor of the next one.
+	if _, err := firstImageCover(article([]byte("not an image"), makePNG(30, 30, color.White))); err != nil {
+		t.Errorf("expected the second image to be used, got %v", err)
+	}
+	if _, err := firstImageCover(article()); err == nil {
+		t.Error("expected an error when the first article has no image")
+	}
+}
+
+func TestBuildEpub_FirstImageCover(t *testing.T) {
+	dir := t.TempDir()
+	coverFiles := func(name string, articles []epubArticle) []string {
+		path := filepath.Join(dir, name)
+		if err := buildEpub(articles, "Book", path, epubOpts{coverStyle: "first-image"}); err != nil {
+			t.Fatal(err)
+		}
+		zr, err := zip.OpenReader(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zr.Close()
+		var names []string
+		for _, f := range zr.File {
+			if strings.Contains(f.Name, "cover.") && strings.HasPrefix(f.Name, "EPUB/images/") {
+				names = append(names, f.Name)
+			}
+		}
+		return names
+	}
+
+	img := dataURI("image/png", makePNG(600, 900, color.NRGBA{0, 0, 120, 255}))
+	withImage := []epubArticle{{HTML: `<html><body><h1>A</h1><p>Text.</p><img src="` + img + `" alt="lead"></body></html>`, Title: "A"}}
+	if got := coverFiles("image.epub", withImage); len(got) != 1 || got[0] != "EPUB/images/cover.jpg" {
+		t.Errorf("expected cover.jpg from the lead image, got %v", got)
+	}
+
+	noImage := []epubArticle{{HTML: `<html><body><h1>A</h1><p>Text.</p></body></html>`, Title: "A"}}
+	if got := coverFiles("collage.epub", noImage); len(got) != 1 || got[0] != "EPUB/images/cover.png" {
+		t.Errorf("expected collage cover.png fallback, got %v", got)
+	}
+}
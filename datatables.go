@@ -0,0 +1,207 @@
+// Chart images with data tables (-prefer-data-tables).
+// Data journalism often pairs a chart image with an accessible table of the
+// same numbers. Shrunk to an e-ink screen the chart is hard to read while
+// the table isn't, so with a table marked as the chart's data the chart is
+// dropped and the table kept.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var (
+	// Matches class names of tables published as a chart's data. Classes
+	// that only hide a table from sighted readers don't say what it holds.
+	dataTableClassRe = regexp.MustCompile(`(?i)\b(?:data-?table|chart-?data|table-?data)\b`)
+)
+
+// preferDataTables removes chart images whose data is also in a table: an
+// image (or role="img" element) whose aria-describedby, aria-details, or
+// longdesc points at a table, or that sits directly before or after a
+// table marked as data by its class or by an aria-labelledby or
+// aria-describedby pointing back at the chart.
+func preferDataTables(content string) string {
+	if !strings.Contains(content, "<table") {
+		return content
+	}
+	body := &html.Node{Type: html.ElementNode, DataAtom: atom.Body, Data: "body"}
+	nodes, err := html.ParseFragment(strings.NewReader(content), body)
+	if err != nil {
+		return content
+	}
+	for _, n := range nodes {
+		body.AppendChild(n)
+	}
+
+	ids := map[string]*html.Node{}
+	var charts []*html.Node
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode {
+				continue
+			}
+			if id := getAttr(c, "id"); id != "" {
+				ids[id] = c
+			}
+			if c.DataAtom == atom.Img || getAttr(c, "role") == "img" {
+				charts = append(charts, c)
+				continue
+			}
+			walk(c)
+		}
+	}
+	walk(body)
+
+	dropped := 0
+	for _, chart := range charts {
+		unit := chart
+		if p := unit.Parent; p != nil && p.DataAtom == atom.Picture {
+			unit = p
+		}
+		if !describesTable(chart, ids) && !besideDataTable(unit) {
+			continue
+		}
+		parent := unit.Parent
+		parent.RemoveChild(unit)
+		if (parent.DataAtom == atom.P || parent.DataAtom == atom.Figure) && onlyMedia(parent, nil) && findChild(parent, atom.Figcaption) == nil {
+			parent.Parent.RemoveChild(parent)
+		}
+		dropped++
+	}
+	if dropped == 0 {
+		return content
+	}
+	fmt.Fprintf(logOut, "Dropped %d chart images in favor of their data tables\n", dropped)
+
+	var buf bytes.Buffer
+	for c := body.FirstChild; c != nil; c = c.NextSibling {
+		html.Render(&buf, c)
+	}
+	return buf.String()
+}
+
+// describesTable reports whether chart's aria-describedby, aria-details, or
+// longdesc="#id" names an element that is or holds a table.
+func describesTable(chart *html.Node, ids map[string]*html.Node) bool {
+	refs := strings.Fields(getAttr(chart, "aria-describedby") + " " + getAttr(chart, "aria-details"))
+	if ld := getAttr(chart, "longdesc"); strings.HasPrefix(ld, "#") {
+		refs = append(refs, ld[1:])
+	}
+	for _, id := range refs {
+		if t := ids[id]; t != nil && findTable(t) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// besideDataTable reports whether the element next to unit, or next to the
+// <figure> or paragraph holding only unit, is a table marked as its data.
+func besideDataTable(unit *html.Node) bool {
+	nodes := []*html.Node{unit}
+	if p := unit.Parent; (p.DataAtom == atom.Figure || p.DataAtom == atom.P) && onlyMedia(p, unit) {
+		nodes = append(nodes, p)
+	}
+	for _, n := range nodes {
+		for _, s := range []*html.Node{prevElement(n), nextElement(n)} {
+			if s != nil && (isDataTable(s, n) || isDataTable(s, unit)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isDataTable reports whether s is, or wraps, a table marked as the data of
+// chart: by class, or by aria-labelledby/aria-describedby naming chart's id.
+func isDataTable(s, chart *html.Node) bool {
+	t := findTable(s)
+	if t == nil {
+		return false
+	}
+	for _, n := range []*html.Node{s, t} {
+		if dataTableClassRe.MatchString(getAttr(n, "class")) {
+			return true
+		}
+		if id := getAttr(chart, "id"); id != "" {
+			for _, ref := range strings.Fields(getAttr(n, "aria-labelledby") + " " + getAttr(n, "aria-describedby")) {
+				if ref == id {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// findChild returns n's first child element of kind a, or nil.
+func findChild(n *html.Node, a atom.Atom) *html.Node {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && c.DataAtom == a {
+			return c
+		}
+	}
+	return nil
+}
+
+// findTable returns n if it is a table, else the first table inside it.
+func findTable(n *html.Node) *html.Node {
+	if n.DataAtom == atom.Table {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode {
+			if t := findTable(c); t != nil {
+				return t
+			}
+		}
+	}
+	return nil
+}
+
+// onlyMedia reports whether container holds nothing but unit (if any),
+// apart from whitespace, comments, and a <figcaption>.
+func onlyMedia(container, unit *html.Node) bool {
+	for c := container.FirstChild; c != nil; c = c.NextSibling {
+		switch {
+		case c == unit, c.Type == html.CommentNode, c.DataAtom == atom.Figcaption:
+		case c.Type == html.TextNode && strings.TrimSpace(c.Data) == "":
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// prevElement and nextElement return n's nearest element sibling, skipping
+// whitespace and comments, or nil if there is other text or no sibling.
+func prevElement(n *html.Node) *html.Node {
+	for s := n.PrevSibling; s != nil; s = s.PrevSibling {
+		if s.Type == html.ElementNode {
+			return s
+		}
+		if s.Type == html.TextNode && strings.TrimSpace(s.Data) != "" {
+			return nil
+		}
+	}
+	return nil
+}
+
+func nextElement(n *html.Node) *html.Node {
+	for s := n.NextSibling; s != nil; s = s.NextSibling {
+		if s.Type == html.ElementNode {
+			return s
+		}
+		if s.Type == html.TextNode && strings.TrimSpace(s.Data) != "" {
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPreferDataTables(t *testing.T) {
+	const (
+		table = `<table><tr><td>2024</td><td>42</td></tr></table>`
+		tbody = `<table><tbody><tr><td>2024</td><td>42</td></tr></tbody></table>`
+	)
+	for _, tc := range []struct{ in, want string }{
+		// Linked from the image, wherever the table is.
+		{`<p><img src="c.png" aria-describedby="d"/></p><p>Text.</p><div id="d">` + table + `</div>`, `<p>Text.</p><div id="d">` + tbody + `</div>`},
+		{`<img src="c.png" longdesc="#d"/><table id="d"><tbody><tr><td>1</td></tr></tbody></table>`, `<table id="d"><tbody><tr><td>1</td></tr></tbody></table>`},
+		{`<div role="img" aria-details="d"><svg></svg></div><table id="d"><tbody><tr><td>1</td></tr></tbody></table>`, `<table id="d"><tbody><tr><td>1</td></tr></tbody></table>`},
+		// Next to the image and marked as data, including around a figure
+		// or <picture>.
+		{`<figure><img src="c.png"/><figcaption>Sales</figcaption></figure><details class="chart-data"><summary>View the data</summary>` + table + `</details>`, `<figure><figcaption>Sales</figcaption></figure><details class="chart-data"><summary>View the data</summary>` + tbody + `</details>`},
+		{`<picture><source srcset="c.webp"/><img src="c.png"/></picture><table class="data-table"><tbody><tr><td>1</td></tr></tbody></table>`, `<table class="data-table"><tbody><tr><td>1</td></tr></tbody></table>`},
+		{`<table aria-labelledby="chart"><caption>Sales</caption><tbody><tr><td>1</td></tr></tbody></table><img id="chart" src="c.png"/>`, `<table aria-labelledby="chart"><caption>Sales</caption><tbody><tr><td>1</td></tr></tbody></table>`},
+		// Unmarked tables, tables further away, and references to other
+		// elements leave the image alone; a caption or summary that says
+		// "data", or a screen-reader-only class, doesn't tie a table to it.
+		{`<img src="c.png"/>` + table, `<img src="c.png"/>` + table},
+		{`<img src="c.png"/><table><caption>Data for 2023</caption><tbody><tr><td>1</td></tr></tbody></table>`, `<img src="c.png"/><table><caption>Data for 2023</caption><tbody><tr><td>1</td></tr></tbody></table>`},
+		{`<img src="c.png"/><details><summary>Raw data</summary>` + tbody + `</details>`, `<img src="c.png"/><details><summary>Raw data</summary>` + tbody + `</details>`},
+		{`<img src="c.png"/><table class="sr-only"><tbody><tr><td>1</td></tr></tbody></table>`, `<img src="c.png"/><table class="sr-only"><tbody><tr><td>1</td></tr></tbody></table>`},
+		{`<img src="c.png"/><p>Text.</p><table class="data-table"><tr><td>1</td></tr></table>`, `<img src="c.png"/><p>Text.</p><table class="data-table"><tr><td>1</td></tr></table>`},
+		{`<img src="c.png" aria-describedby="n"/><p id="n">A note.</p>` + table, `<img src="c.png" aria-describedby="n"/><p id="n">A note.</p>` + table},
+		{`<p>See <img src="c.png"/> here.</p><table class="data-table"><tr><td>1</td></tr></table>`, `<p>See <img src="c.png"/> here.</p><table class="data-table"><tr><td>1</td></tr></table>`},
+	} {
+		if got := preferDataTables(tc.in); got != tc.want {
+			t.Errorf("preferDataTables(%s)\n got %s\nwant %s", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestProcessURL_PreferDataTables(t *testing.T) {
+	page := makeArticleHTML("Charted", `Sales rose sharply this year, as the chart shows.</p>`+
+		`<figure><img src="https://example.com/chart.png" alt="Sales chart" aria-describedby="sales"/></figure>`+
+		`<table id="sales"><caption>Sales by year</caption><tr><th>Year</th><th>Sales</th></tr><tr><td>2024</td><td>42</td></tr></table><p>More text.`)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(page))
+	}))
+	defer srv.Close()
+	for _, prefer := range []bool{false, true} {
+		cfg := cliConfig{format: "html", preferTables: prefer, timeout: 5 * time.Second}
+		cfg.opts.skipImageFetch = true
+		out, _, _, err := processURL(srv.URL, cfg, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(out, "Sales by year") {
+			t.Errorf("prefer %v: the table should always be kept", prefer)
+		}
+		if got := strings.Contains(out, "chart.png"); got == prefer {
+			t.Errorf("prefer %v: chart kept = %v", prefer, got)
+		}
+	}
+}
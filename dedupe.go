@@ -0,0 +1,68 @@
+// Near-duplicate article detection (-dedupe-content).
+// Syndicated stories reach a list under different URLs, so URL dedup
+// misses them; this compares the articles' text instead.
+package main
+
+import (
+	"hash/fnv"
+	gohtml "html"
+	"strings"
+	"unicode"
+)
+
+// shingleWords is how many consecutive words make up one shingle.
+const shingleWords = 5
+
+// defaultDedupeThreshold is the -dedupe-threshold default: the shingle
+// similarity at which an article counts as a copy of an earlier one.
+const defaultDedupeThreshold = 0.9
+
+// contentShingles returns the set of hashed shingleWords-word runs in an
+// article's text, ignoring markup, case, and punctuation. Articles shorter
+// than one shingle are a single shingle of all their words.
+func contentShingles(articleHTML string) map[uint64]bool {
+	text := gohtml.UnescapeString(stripTagsRe.ReplaceAllString(articleHTML, " "))
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	shingles := map[uint64]bool{}
+	for i := 0; i == 0 || i+shingleWords <= len(words); i++ {
+		h := fnv.New64a()
+		for _, w := range words[i:min(i+shingleWords, len(words))] {
+			h.Write([]byte(w))
+			h.Write([]byte{' '})
+		}
+		shingles[h.Sum64()] = true
+	}
+	return shingles
+}
+
+// shingleSimilarity returns the Jaccard similarity of two shingle sets:
+// shared shingles over all distinct shingles, from 0 (disjoint) to 1.
+func shingleSimilarity(a, b map[uint64]bool) float64 {
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	shared := 0
+	for s := range a {
+		if b[s] {
+			shared++
+		}
+	}
+	union := len(a) + len(b) - shared
+	if union == 0 {
+		return 1
+	}
+	return float64(shared) / float64(union)
+}
+
+// nearDuplicate returns the index of the first of earlier whose shingles
+// are at least threshold similar to s, and that similarity, or -1.
+func nearDuplicate(s map[uint64]bool, earlier []map[uint64]bool, threshold float64) (int, float64) {
+	for i, e := range earlier {
+		if sim := shingleSimilarity(s, e); sim >= threshold {
+			return i, sim
+		}
+	}
+	return -1, 0
+}
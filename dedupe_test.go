@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShingleSimilarity(t *testing.T) {
+	story := strings.Repeat("The council voted on Tuesday to expand the city's bike lanes after a long debate. ", 3) +
+		"Residents spoke for and against the plan, which will cost four million dollars over five years."
+	same := contentShingles("<p>" + story + "</p>")
+	if sim := shingleSimilarity(same, contentShingles("<div><b>"+strings.ToUpper(story)+"</b></div>")); sim != 1 {
+		t.Errorf("case and markup should not matter, got %.2f", sim)
+	}
+	edited := contentShingles("<p>" + story + " Reporting by the Associated Press.</p>")
+	if sim := shingleSimilarity(same, edited); sim < 0.8 {
+		t.Errorf("a syndicated copy with a credit line should be similar, got %.2f", sim)
+	}
+	other := contentShingles("<p>An entirely different article about the weather this weekend and the storm.</p>")
+	if sim := shingleSimilarity(same, other); sim > 0.1 {
+		t.Errorf("unrelated articles should not be similar, got %.2f", sim)
+	}
+	if sim := shingleSimilarity(contentShingles("<p>Hi</p>"), contentShingles("<p>hi!</p>")); sim != 1 {
+		t.Errorf("short articles should compare as one shingle, got %.2f", sim)
+	}
+
+	if i, _ := nearDuplicate(edited, []map[uint64]bool{other, same}, 0.8); i != 1 {
+		t.Errorf("nearDuplicate = %d, want 1", i)
+	}
+	if i, _ := nearDuplicate(edited, []map[uint64]bool{other}, 0.8); i != -1 {
+		t.Errorf("nearDuplicate = %d, want -1", i)
+	}
+}
+
+func TestFetchMultipleArticles_DedupeContent(t *testing.T) {
+	story := strings.Repeat("The council voted on Tuesday to expand the city's bike lanes after a long debate. ", 5)
+	pages := map[string]string{
+		"/original":   makeArticleHTML("Council Expands Bike Lanes", story),
+		"/syndicated": makeArticleHTML("Council expands bike lanes", story),
+		"/other":      makeArticleHTML("Weekend Weather", strings.Repeat("Storms are expected to roll in by Saturday night. ", 5)),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(pages[r.URL.Path]))
+	}))
+	defer srv.Close()
+	urls := []string{srv.URL + "/original", srv.URL + "/syndicated", srv.URL + "/other"}
+
+	var buf bytes.Buffer
+	savedLog := logOut
+	logOut = &buf
+	defer func() { logOut = savedLog }()
+
+	cfg := cliConfig{concurrency: 2, timeout: 5 * time.Second, dedupeThresh: defaultDedupeThreshold}
+	if got := fetchMultipleArticles(urls, cfg); len(got) != 3 {
+		t.Fatalf("without -dedupe-content all articles should be kept, got %d", len(got))
+	}
+	cfg.dedupeContent = true
+	got := fetchMultipleArticles(urls, cfg)
+	if len(got) != 2 || got[0].Title != "Council Expands Bike Lanes" || got[1].Title != "Weekend Weather" {
+		t.Fatalf("expected the first copy and the other article, got %+v", got)
+	}
+	if log := buf.String(); !strings.Contains(log, "Skipping "+urls[1]+": ") || !strings.Contains(log, "similar to "+urls[0]) {
+		t.Errorf("expected the skipped duplicate in the log, got:\n%s", log)
+	}
+}
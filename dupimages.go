@@ -0,0 +1,57 @@
+// Duplicate images within an article (-drop-duplicate-images-within-article).
+// Some pages show the same picture twice, typically the lead photo both as
+// a promoted header and again inline. After images are fetched, each
+// article keeps the first copy of a picture and drops the later ones.
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+)
+
+// Matches an <img> tag, capturing its src.
+var imgSrcTagRe = regexp.MustCompile(`<img\b[^>]*?\bsrc\s*=\s*"([^"]*)"[^>]*>`)
+
+// dropDuplicateImages removes every <img> whose picture appeared earlier in
+// content: the same decoded bytes for embedded images, or the same URL for
+// ones that weren't fetched (markdown). Other images are left alone.
+func dropDuplicateImages(content []byte) []byte {
+	seen := map[string]bool{}
+	dropped := 0
+	out := imgSrcTagRe.ReplaceAllFunc(content, func(tag []byte) []byte {
+		key := imageKey(string(imgSrcTagRe.FindSubmatch(tag)[1]))
+		if key == "" {
+			return tag
+		}
+		if seen[key] {
+			dropped++
+			return nil
+		}
+		seen[key] = true
+		return tag
+	})
+	if dropped > 0 {
+		fmt.Fprintf(logOut, "Dropped %d duplicate images\n", dropped)
+	}
+	return out
+}
+
+// imageKey identifies the picture at src: a hash of an embedded image's
+// bytes, or the URL of an external one; "" if src is empty or unreadable.
+func imageKey(src string) string {
+	if !strings.HasPrefix(src, "data:") {
+		return html.UnescapeString(strings.TrimSpace(src))
+	}
+	_, payload, ok := strings.Cut(src, ";base64,")
+	if !ok {
+		return ""
+	}
+	data, err := decodeBase64(payload)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(data))
+}
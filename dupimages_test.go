@@ -0,0 +1,43 @@
+package main
+
+import (
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func TestDropDuplicateImages(t *testing.T) {
+	red := dataURI("image/png", makePNG(4, 4, color.NRGBA{255, 0, 0, 255}))
+	blue := dataURI("image/png", makePNG(4, 4, color.NRGBA{0, 0, 255, 255}))
+	// The same bytes, encoded without padding.
+	redRaw := strings.TrimRight(red, "=")
+	for _, tc := range []struct{ in, want string }{
+		{
+			`<figure><img src="` + red + `" alt=""></figure><p>Text</p><img src="` + redRaw + `" alt="again"><img src="` + blue + `">`,
+			`<figure><img src="` + red + `" alt=""></figure><p>Text</p><img src="` + blue + `">`,
+		},
+		{
+			`<img src="https://e.com/a.jpg?x=1&amp;y=2"><img src="https://e.com/a.jpg?x=1&y=2"><img src="https://e.com/b.jpg">`,
+			`<img src="https://e.com/a.jpg?x=1&amp;y=2"><img src="https://e.com/b.jpg">`,
+		},
+		{`<img src=""><img src="">`, `<img src=""><img src="">`},
+	} {
+		if got := string(dropDuplicateImages([]byte(tc.in))); got != tc.want {
+			t.Errorf("dropDuplicateImages(%.60s...)\n got %.200s\nwant %.200s", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestProcessArticleImages_DropDuplicates(t *testing.T) {
+	red := dataURI("image/png", makePNG(20, 20, color.NRGBA{255, 0, 0, 255}))
+	html := []byte(`<img src="` + red + `" alt="a"><p>Text</p><img src="` + red + `" alt="b">`)
+	for _, tc := range []struct {
+		drop bool
+		want int
+	}{{false, 2}, {true, 1}} {
+		out := processArticleImages(html, optimizeOpts{maxWidth: 800, quality: 60, dropDupes: tc.drop}, 1)
+		if n := strings.Count(string(out), "<img"); n != tc.want {
+			t.Errorf("drop %v: %d images, want %d", tc.drop, n, tc.want)
+		}
+	}
+}
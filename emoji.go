@@ -0,0 +1,98 @@
+// Emoji images (-inline-emoji).
+// WordPress, Twitter embeds, and chat-style blogs render emoji as tiny
+// <img> tags with the character in alt. Embedded, each costs an image
+// and a fetch, and readers scale it up to a block-sized blot; the alt
+// text is the emoji itself, so that is used instead.
+package main
+
+import (
+	"bytes"
+	gohtml "html"
+	"strings"
+	"unicode/utf8"
+
+	xhtml "golang.org/x/net/html"
+)
+
+// maxEmojiAlt bounds how long an alt can be and still be one emoji: ZWJ
+// family sequences and tagged flags run to about a dozen code points.
+const maxEmojiAlt = 16
+
+// inlineEmojiImages replaces <img> tags that show an emoji with their alt
+// text: images whose class marks them as emoji (emoji, wp-smiley, twemoji)
+// and have alt text, and any image whose alt is a single emoji.
+func inlineEmojiImages(content []byte) []byte {
+	if !bytes.Contains(content, []byte("<img")) {
+		return content
+	}
+	var out bytes.Buffer
+	z := xhtml.NewTokenizer(bytes.NewReader(content))
+	for {
+		tt := z.Next()
+		if tt == xhtml.ErrorToken {
+			break
+		}
+		raw := bytes.Clone(z.Raw()) // TagName and TagAttr lowercase in place
+		name, hasAttr := z.TagName()
+		if (tt != xhtml.StartTagToken && tt != xhtml.SelfClosingTagToken) || string(name) != "img" {
+			out.Write(raw)
+			continue
+		}
+		var alt, class string
+		for hasAttr {
+			var key, val []byte
+			key, val, hasAttr = z.TagAttr()
+			switch string(key) {
+			case "alt":
+				alt = strings.TrimSpace(string(val))
+			case "class":
+				class = string(val)
+			}
+		}
+		if alt != "" && (isEmojiClass(class) || isEmoji(alt)) {
+			out.WriteString(gohtml.EscapeString(alt))
+		} else {
+			out.Write(raw)
+		}
+	}
+	return out.Bytes()
+}
+
+// isEmojiClass reports whether a class attribute marks an emoji image.
+func isEmojiClass(class string) bool {
+	for _, c := range strings.Fields(strings.ToLower(class)) {
+		if c == "wp-smiley" || strings.Contains(c, "emoji") {
+			return true
+		}
+	}
+	return false
+}
+
+// isEmoji reports whether s is a single emoji: pictographs plus the joiners,
+// variation selectors, skin-tone modifiers, and tags that combine them.
+func isEmoji(s string) bool {
+	if s == "" || utf8.RuneCountInString(s) > maxEmojiAlt {
+		return false
+	}
+	pictograph := false
+	for _, r := range s {
+		switch {
+		case r == 0x200d, r == 0xfe0e, r == 0xfe0f: // ZWJ, text/emoji presentation
+		case r >= 0x1f3fb && r <= 0x1f3ff: // skin tones
+		case r >= 0xe0020 && r <= 0xe007f: // tag sequences (subdivision flags)
+		case r == '#', r == '*', r >= '0' && r <= '9': // keycap bases
+		case r == 0x20e3, // combining keycap
+			r >= 0x1f000 && r <= 0x1faff, // pictographs, emoticons, flags
+			r >= 0x2600 && r <= 0x27bf,   // misc symbols, dingbats
+			r >= 0x2300 && r <= 0x23ff,   // technical: ⌚ ⏰
+			r >= 0x2b00 && r <= 0x2bff,   // arrows, ⭐ ⬛
+			r >= 0x2190 && r <= 0x21ff,   // arrows
+			r == 0x00a9, r == 0x00ae, r == 0x203c, r == 0x2049, r == 0x2122,
+			r == 0x2139, r == 0x3030, r == 0x303d, r == 0x3297, r == 0x3299:
+			pictograph = true
+		default:
+			return false
+		}
+	}
+	return pictograph
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestInlineEmojiImages(t *testing.T) {
+	for _, tc := range []struct{ in, want string }{
+		{`<p>Nice <img draggable="false" role="img" class="emoji" alt="👍" src="https://s.w.org/images/core/emoji/72x72/1f44d.png"></p>`, `<p>Nice 👍</p>`},
+		{`<p>Hi <img class="wp-smiley" alt=":)" src="smile.gif" /></p>`, `<p>Hi :)</p>`},
+		{`<p><img src="x.png" alt="👨‍👩‍👧"> family</p>`, `<p>👨‍👩‍👧 family</p>`},
+		{`<p><img src="x.png" alt="1️⃣"> one</p>`, `<p>1️⃣ one</p>`},
+		{`<p><img src="x.png" alt="👍🏽"></p>`, `<p>👍🏽</p>`},
+		{`<p><img class="Twemoji" src="x.png" alt="&lt;3"></p>`, `<p>&lt;3</p>`},
+		// Ordinary images, digits, and emoji-class images with no alt stay.
+		{`<p><img src="chart.png" alt="Sales chart"></p>`, `<p><img src="chart.png" alt="Sales chart"></p>`},
+		{`<p><img src="x.png" alt="1"></p>`, `<p><img src="x.png" alt="1"></p>`},
+		{`<p><img src="x.png" alt="👍 great"></p>`, `<p><img src="x.png" alt="👍 great"></p>`},
+		{`<p><img class="emoji" src="x.png"></p>`, `<p><img class="emoji" src="x.png"></p>`},
+	} {
+		if got := string(inlineEmojiImages([]byte(tc.in))); got != tc.want {
+			t.Errorf("inlineEmojiImages(%s)\n got %s\nwant %s", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestProcessArticleImages_InlineEmoji(t *testing.T) {
+	fetched := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched++
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(makePNG(72, 72, color.NRGBA{255, 200, 0, 255}))
+	}))
+	defer srv.Close()
+
+	in := []byte(`<p>Great <img class="emoji" alt="🎉" src="` + srv.URL + `/1f389.png"></p>`)
+	out := string(processArticleImages(in, optimizeOpts{maxWidth: 800, quality: 60, inlineEmoji: true}, 1))
+	if out != `<p>Great 🎉</p>` || fetched != 0 {
+		t.Errorf("emoji image should be replaced before fetching, got %s (%d fetches)", out, fetched)
+	}
+	out = string(processArticleImages(in, optimizeOpts{maxWidth: 800, quality: 60}, 1))
+	if !strings.Contains(out, "data:image/") {
+		t.Errorf("without -inline-emoji the image should be embedded, got %.80s", out)
+	}
+}
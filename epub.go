@@ -3,12 +3,25 @@
 package main
 
 import (
+	"archive/zip"
+	"bytes"
+	"cmp"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/xml"
 	"fmt"
 	gohtml "html"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
+	"unicode"
 
 	epub "github.com/go-shiori/go-epub"
 )
@@ -20,6 +33,151 @@ var (
 	stripTagsRe = regexp.MustCompile(`<[^>]*>`)
 )
 
+// epubOpts holds optional settings for buildEpub.
+type epubOpts struct {
+	coverStyle     string   // "typographic", "collage", "pattern", "first-image", or "none"
+	coverTitle     string   // cover display title; defaults to the book title
+	coverSubtitle  string   // optional cover subtitle line
+	coverColumns   int      // columns of article titles on the collage cover (0 = 1)
+	coverEntries   int      // articles listed on the collage cover before "+ N more" (0 = as many as fit)
+	maxTitleLen    int      // shorten titles on the cover and contents to this many characters (0 = no limit)
+	author         string   // dc:creator; defaults to bookAuthor's pick from the bylines
+	keepClasses    []string // if non-empty, only these class names survive sanitization
+	dropSections   []string // heading texts whose sections are removed (see dropSections)
+	keepData       []string // data-* attributes kept by the sanitizer (others are stripped)
+	stackTableCols int      // stack tables wider than this many columns (0 disables)
+	popupFootnotes bool     // convert #fnN footnotes to EPUB 3 popup notes
+	expandDetails  bool     // unwrap <details> collapsibles instead of keeping them
+	iframeLinks    bool     // turn <iframe> embeds into "[Embedded: host]" links
+	sourceFooter   bool     // end each article with an "Originally published" line
+	tocGroupBySite bool     // group the contents page under a heading per site
+	titlePage      bool     // open with a text title page, ahead of the contents page
+	tocPosition    string   // "front" (or "") puts the contents page before the articles, "back" after
+	reproducible   bool     // byte-identical output for identical input (see buildTime)
+	identifier     string   // fixed dc:identifier (see bookIdentifier; "" = random, or content-derived if reproducible)
+	svgInline      bool     // inline embedded SVG images as <svg> markup
+	minimal        bool     // strip class, style, title, and unreferenced id attributes
+	theme          string   // key of epubThemes layered on the base stylesheet ("" = default)
+	leadParagraph  string   // key of leadParagraphCSS for each article's opening paragraph ("" = off)
+	validate       bool     // check sections with validateSections; fail instead of writing
+	verifyImages   bool     // check the written book's images against its manifest (verifyEpubImages)
+	spoolDir       string   // -low-memory: stream images to go-epub from files in this directory
+	maxEmbedBytes  int64    // cap on the book's embedded image bytes (0 = unlimited; see applyEmbedBudget)
+
+	// dc:date; zero means the newest article date, else the build time
+	date time.Time
+}
+
+// baseEpubCSS is the minimal stylesheet for readability on e-readers.
+const baseEpubCSS = `body { margin: 1em; line-height: 1.5; }
+img { max-width: 100%; height: auto; }
+pre, code { font-size: 0.85em; }
+blockquote { margin-left: 1em; padding-left: 0.5em; border-left: 2px solid #999; }
+cite { font-style: italic; }
+.quote-source { margin-left: 1em; margin-top: -0.5em; font-size: 0.85em; color: #666; }
+.byline { font-size: 0.85em; color: #666; margin-top: -0.5em; margin-bottom: 1.5em; }
+.byline a { color: #666; }
+.source-footer { margin-top: 2em; margin-bottom: 0; }
+.toc { list-style-type: none; padding-left: 0; }
+.toc-site { font-size: 1.1em; margin-top: 1.5em; }
+.toc li { margin-bottom: 1.2em; }
+.toc a { text-decoration: none; }
+.toc-meta { font-size: 0.85em; color: #666; margin-top: 0.1em; }
+.toc-meta a { color: #666; }
+.stacked-table dl { border-bottom: 1px solid #ccc; padding-bottom: 0.5em; }
+.stacked-table dt { font-weight: bold; font-size: 0.85em; }
+.title-page { text-align: center; margin-top: 25%; }
+.title-page p { color: #666; margin: 0.4em 0; }`
+
+// epubThemes are the -epub-theme presets, each layered over baseEpubCSS.
+// "default" is the base stylesheet alone.
+var epubThemes = map[string]string{
+	"default": "",
+	"serif": `body { font-family: Georgia, "Times New Roman", serif; }
+h1, h2, h3, h4, h5, h6 { font-family: Georgia, "Times New Roman", serif; }`,
+	"sans": `body { font-family: "Helvetica Neue", Helvetica, Arial, sans-serif; }
+h1, h2, h3, h4, h5, h6 { font-family: "Helvetica Neue", Helvetica, Arial, sans-serif; }`,
+	"compact": `body { margin: 0.5em; line-height: 1.3; }
+p { margin: 0.4em 0; }
+h1, h2, h3, h4, h5, h6 { margin: 0.8em 0 0.3em; }
+.byline { margin-bottom: 0.8em; }
+.toc li { margin-bottom: 0.5em; }`,
+	"dark": `body { background-color: #000; color: #ddd; }
+a { color: #8ab4f8; }
+blockquote { border-left-color: #666; }
+.byline, .byline a, .toc-meta, .toc-meta a { color: #999; }
+.stacked-table dl { border-bottom-color: #444; }`,
+}
+
+// keptClassCSS holds default rules for semantic class names that are
+// commonly worth keeping with -keep-classes.
+var keptClassCSS = map[string]string{
+	"pullquote": ".pullquote { font-size: 1.2em; font-style: italic; margin: 1em 2em; }",
+	"caption":   ".caption { font-size: 0.85em; color: #666; text-align: center; }",
+	"credit":    ".credit { font-size: 0.75em; color: #666; }",
+	"note":      ".note { font-size: 0.9em; border-left: 2px solid #999; padding-left: 0.5em; }",
+	"lede":      ".lede { font-size: 1.1em; }",
+	"epigraph":  ".epigraph { font-style: italic; margin-left: 2em; }",
+}
+
+// epubCSS returns the stylesheet for an epub: the base rules, then the
+// theme's, then default rules for any kept classes that have one, then the
+// -lead-paragraph rule.
+func epubCSS(opts epubOpts) string {
+	css := baseEpubCSS
+	if theme := epubThemes[opts.theme]; theme != "" {
+		css += "\n" + theme
+	}
+	for _, c := range opts.keepClasses {
+		if rule, ok := keptClassCSS[c]; ok {
+			css += "\n" + rule
+		}
+	}
+	if rule := leadParagraphCSS[opts.leadParagraph]; rule != "" {
+		css += "\n" + rule
+	}
+	return css
+}
+
+// sanitizeOpts derives the sanitizer settings from the epub options.
+func (o epubOpts) sanitizeOpts() sanitizeOpts {
+	so := sanitizeOpts{
+		stackTableCols: o.stackTableCols,
+		popupFootnotes: o.popupFootnotes,
+		expandDetails:  o.expandDetails,
+		keepData:       dataAttrSet(o.keepData),
+		iframeLinks:    o.iframeLinks,
+	}
+	for _, h := range o.dropSections {
+		if key := titleKey(h); key != "" {
+			if so.dropSections == nil {
+				so.dropSections = map[string]bool{}
+			}
+			so.dropSections[key] = true
+		}
+	}
+	if len(o.keepClasses) > 0 || o.minimal {
+		so.keepClasses = map[string]bool{}
+		for _, c := range o.keepClasses {
+			so.keepClasses[c] = true
+		}
+	}
+	if o.minimal {
+		so.minimal = true
+		for c := range ownClasses {
+			so.keepClasses[c] = true
+		}
+	}
+	if so.keepClasses != nil && o.leadParagraph != "" {
+		so.keepClasses["lead"] = true
+	}
+	return so
+}
+
+// ownClasses are the class names deckle adds to article markup. -minimal
+// keeps them so the stylesheet still applies.
+var ownClasses = map[string]bool{"byline": true, "source-footer": true, "stacked-table": true}
+
 // epubArticle holds a processed article and its metadata for epub inclusion.
 type epubArticle struct {
 	HTML          string     // Full HTML (with <body> tags)
@@ -28,6 +186,21 @@ type epubArticle struct {
 	Byline        string     // Author name from metadata
 	SiteName      string     // Publication name from metadata
 	PublishedTime *time.Time // Publication date, if available
+	Spool         string     // file holding HTML moved to disk by -low-memory (HTML is then empty)
+	Source        int        // 1-based position of the article's URL in the input (0 if unknown)
+}
+
+// loadHTML returns the article's HTML, reading it back from its spool file
+// if -low-memory moved it to disk.
+func (a epubArticle) loadHTML() (string, error) {
+	if a.Spool == "" {
+		return a.HTML, nil
+	}
+	data, err := os.ReadFile(a.Spool)
+	if err != nil {
+		return "", fmt.Errorf("reading spooled article: %w", err)
+	}
+	return string(data), nil
 }
 
 // extractBodyContent extracts the content between <body> and </body> tags.
@@ -63,7 +236,12 @@ func extractH1Title(html string) string {
 
 // extractImages finds all base64 data URI images in the HTML body,
 // registers them with the epub, and rewrites src attributes to internal paths.
-func extractImages(e *epub.Epub, body string, chapterIdx int) (string, error) {
+// Images are named chNNN_imgMMM for chapterIdx NNN; buildEpub passes the
+// article's input position, so a failed or reordered article doesn't
+// rename the images of the others.
+// With a spoolDir, each image is written there and registered by path, so
+// go-epub streams it from disk instead of holding the data URI until Write.
+func extractImages(e *epub.Epub, body string, chapterIdx int, spoolDir string) (string, error) {
 	imgIdx := 0
 	var lastErr error
 
@@ -94,19 +272,27 @@ func extractImages(e *epub.Epub, body string, chapterIdx int) (string, error) {
 		imgIdx++
 
 		// Decode base64 to verify it's valid
-		_, err := base64.StdEncoding.DecodeString(b64data)
+		raw, err := base64.StdEncoding.DecodeString(b64data)
 		if err != nil {
 			// Try raw encoding (no padding)
-			_, err = base64.RawStdEncoding.DecodeString(b64data)
+			raw, err = base64.RawStdEncoding.DecodeString(b64data)
 			if err != nil {
 				fmt.Fprintf(logOut, "Warning: invalid base64 for %s: %v\n", filename, err)
 				return match
 			}
 		}
 
-		// go-epub accepts data URIs directly via AddImage
-		dataURI := "data:" + mime + ";base64," + b64data
-		internalPath, err := e.AddImage(dataURI, filename)
+		// go-epub accepts data URIs directly via AddImage, or file paths
+		source := "data:" + mime + ";base64," + b64data
+		if spoolDir != "" {
+			source = filepath.Join(spoolDir, filename)
+			if err := os.WriteFile(source, raw, 0600); err != nil {
+				fmt.Fprintf(logOut, "Warning: failed to spool image %s: %v\n", filename, err)
+				lastErr = err
+				return match
+			}
+		}
+		internalPath, err := e.AddImage(source, filename)
 		if err != nil {
 			fmt.Fprintf(logOut, "Warning: failed to add image %s: %v\n", filename, err)
 			lastErr = err
@@ -119,81 +305,283 @@ func extractImages(e *epub.Epub, body string, chapterIdx int) (string, error) {
 	return result, lastErr
 }
 
+// Matches an <img> whose src is an embedded SVG
+var svgImgRe = regexp.MustCompile(`<img\b[^>]*?\bsrc\s*=\s*"data:image/svg\+xml;base64,([^"]*)"[^>]*>`)
+
+// inlineSVGImages replaces embedded SVG <img> elements with the SVG markup
+// itself (-svg-inline), for readers that render inline SVG but not SVG
+// images. The markup is cleaned by sanitizeSVG, with the ids of the nth
+// SVG prefixed "svgN-". The image's alt text becomes the SVG's accessible
+// label. SVGs that are not well-formed XML stay as images.
+func inlineSVGImages(body string) string {
+	n := 0
+	return svgImgRe.ReplaceAllStringFunc(body, func(match string) string {
+		raw, err := decodeBase64(svgImgRe.FindStringSubmatch(match)[1])
+		if err != nil {
+			return match
+		}
+		n++
+		svg, ok := sanitizeSVG(raw, fmt.Sprintf("svg%d-", n))
+		if !ok {
+			vprintf("SVG is not well-formed XML, keeping it as an image\n")
+			return match
+		}
+		rootEnd := strings.IndexByte(svg, '>')
+		if strings.HasSuffix(svg[:rootEnd], "/") {
+			rootEnd--
+		}
+		root := svg[:rootEnd]
+		if m := altRe.FindStringSubmatch(match); m != nil && m[1] != "" && !strings.Contains(root, "aria-label") {
+			root += ` role="img" aria-label="` + m[1] + `"`
+		}
+		return root + svg[rootEnd:]
+	})
+}
+
 // buildTOCBody generates the HTML body for the front matter table of contents.
 // It creates a linked list of articles with their authors and source URLs.
-func buildTOCBody(articles []epubArticle) string {
+// With groupBySite, articles are listed under an <h2> per site name, in order
+// of each site's first article; those without a site name go under "Other".
+func buildTOCBody(articles []epubArticle, groupBySite bool) string {
+	return "<h1>Contents</h1>\n" + buildTOCList(articles, groupBySite, func(i int) string {
+		return fmt.Sprintf("article%03d.xhtml", i+1)
+	})
+}
+
+// buildTOCList generates buildTOCBody's article list, linking the i-th
+// (0-based) article to href(i).
+func buildTOCList(articles []epubArticle, groupBySite bool, href func(i int) string) string {
 	var b strings.Builder
-	b.WriteString("<h1>Contents</h1>\n<ol class=\"toc\">\n")
-	for i, a := range articles {
-		filename := fmt.Sprintf("article%03d.xhtml", i+1)
-		title := a.Title
-		if title == "" {
-			title = fmt.Sprintf("Article %d", i+1)
+	if !groupBySite {
+		b.WriteString("<ol class=\"toc\">\n")
+		for i, a := range articles {
+			writeTOCEntry(&b, i, a, href(i))
 		}
-		b.WriteString("<li>\n")
-		b.WriteString(fmt.Sprintf(`<a href="%s">%s</a>`, filename, gohtml.EscapeString(title)))
-		b.WriteByte('\n')
+		b.WriteString("</ol>\n")
+		return b.String()
+	}
 
-		// Build metadata line: date · author · site · url
-		var meta []string
-		if a.PublishedTime != nil {
-			meta = append(meta, gohtml.EscapeString(a.PublishedTime.Format("January 2, 2006")))
+	var sites []string
+	bySite := map[string][]int{}
+	for i, a := range articles {
+		site := a.SiteName
+		if site == "" {
+			site = "Other"
 		}
-		if a.Byline != "" {
-			meta = append(meta, gohtml.EscapeString(a.Byline))
+		if _, ok := bySite[site]; !ok && site != "Other" {
+			sites = append(sites, site)
 		}
-		if a.SiteName != "" {
-			meta = append(meta, gohtml.EscapeString(a.SiteName))
+		bySite[site] = append(bySite[site], i)
+	}
+	if _, ok := bySite["Other"]; ok {
+		sites = append(sites, "Other")
+	}
+	for _, site := range sites {
+		b.WriteString(fmt.Sprintf("<h2 class=\"toc-site\">%s</h2>\n<ol class=\"toc\">\n", gohtml.EscapeString(site)))
+		for _, i := range bySite[site] {
+			writeTOCEntry(&b, i, articles[i], href(i))
+		}
+		b.WriteString("</ol>\n")
+	}
+	return b.String()
+}
+
+// shortenTitles returns a copy of articles with each title shortened to
+// limit characters (see shortenTitle), or articles itself if limit is 0.
+func shortenTitles(articles []epubArticle, limit int) []epubArticle {
+	if limit <= 0 {
+		return articles
+	}
+	shown := slices.Clone(articles)
+	for i := range shown {
+		shown[i].Title = shortenTitle(shown[i].Title, limit)
+	}
+	return shown
+}
+
+// shortenTitle cuts title to at most limit characters, ellipsis included,
+// at the last word boundary that fits (mid-word only for a single long
+// word), and ends it with "…" (all that's left at limit 1). Titles that
+// fit, and any title when limit is 0, are returned unchanged.
+func shortenTitle(title string, limit int) string {
+	runes := []rune(title)
+	if limit <= 0 || len(runes) <= limit {
+		return title
+	}
+	if limit == 1 {
+		return "…"
+	}
+	cut := runes[:limit-1]
+	if !unicode.IsSpace(runes[len(cut)]) {
+		if i := strings.LastIndexFunc(string(cut), unicode.IsSpace); i > 0 {
+			cut = []rune(string(cut)[:i])
 		}
-		metaLine := strings.Join(meta, " · ")
+	}
+	return strings.TrimRightFunc(string(cut), func(r rune) bool {
+		return unicode.IsSpace(r) || strings.ContainsRune(",;:-–—", r)
+	}) + "…"
+}
+
+// buildTitlePageBody generates the -title-page section: the book title,
+// the span of the articles' publication dates, how many articles come from
+// how many sites, and when the book was made.
+func buildTitlePageBody(title string, articles []epubArticle, generated time.Time) string {
+	var b strings.Builder
+	b.WriteString("<section class=\"title-page\" epub:type=\"titlepage\">\n")
+	fmt.Fprintf(&b, "<h1>%s</h1>\n", gohtml.EscapeString(title))
 
-		if a.URL != "" {
-			displayURL := a.URL
-			for _, prefix := range []string{"https://", "http://"} {
-				displayURL = strings.TrimPrefix(displayURL, prefix)
+	var oldest, newest time.Time
+	var sites []string
+	for _, a := range articles {
+		if t := a.PublishedTime; t != nil {
+			if oldest.IsZero() || t.Before(oldest) {
+				oldest = *t
+			}
+			if t.After(newest) {
+				newest = *t
 			}
-			displayURL = strings.TrimSuffix(displayURL, "/")
-			link := fmt.Sprintf(`<a href="%s">%s</a>`,
-				gohtml.EscapeString(a.URL), gohtml.EscapeString(displayURL))
-			if metaLine != "" {
-				metaLine += "<br/>" + link
-			} else {
-				metaLine = link
+		}
+		site := a.SiteName
+		if site == "" {
+			if u, err := url.Parse(a.URL); err == nil {
+				site = strings.TrimPrefix(u.Hostname(), "www.")
 			}
 		}
+		if site != "" && !slices.Contains(sites, site) {
+			sites = append(sites, site)
+		}
+	}
+	const dateFmt = "January 2, 2006"
+	switch {
+	case oldest.IsZero():
+	case oldest.Format(dateFmt) == newest.Format(dateFmt):
+		fmt.Fprintf(&b, "<p class=\"title-page-dates\">%s</p>\n", oldest.Format(dateFmt))
+	default:
+		fmt.Fprintf(&b, "<p class=\"title-page-dates\">%s – %s</p>\n", oldest.Format(dateFmt), newest.Format(dateFmt))
+	}
 
+	summary := fmt.Sprintf("%d %s", len(articles), plural(int64(len(articles)), "article"))
+	switch len(sites) {
+	case 0:
+	case 1:
+		summary += " from " + sites[0]
+	default:
+		summary += fmt.Sprintf(" from %d sites", len(sites))
+	}
+	fmt.Fprintf(&b, "<p class=\"title-page-summary\">%s</p>\n", gohtml.EscapeString(summary))
+	fmt.Fprintf(&b, "<p class=\"title-page-generated\">Generated %s</p>\n", generated.Format(dateFmt))
+	b.WriteString("</section>\n")
+	return b.String()
+}
+
+// writeTOCEntry writes the list item for the i-th (0-based) article,
+// linking its title to href.
+func writeTOCEntry(b *strings.Builder, i int, a epubArticle, href string) {
+	title := a.Title
+	if title == "" {
+		title = fmt.Sprintf("Article %d", i+1)
+	}
+	b.WriteString("<li>\n")
+	b.WriteString(fmt.Sprintf(`<a href="%s">%s</a>`, gohtml.EscapeString(href), gohtml.EscapeString(title)))
+	b.WriteByte('\n')
+
+	// Build metadata line: date · author · site · url
+	var meta []string
+	if a.PublishedTime != nil {
+		meta = append(meta, gohtml.EscapeString(a.PublishedTime.Format("January 2, 2006")))
+	}
+	if a.Byline != "" {
+		meta = append(meta, gohtml.EscapeString(a.Byline))
+	}
+	if a.SiteName != "" {
+		meta = append(meta, gohtml.EscapeString(a.SiteName))
+	}
+	metaLine := strings.Join(meta, " · ")
+
+	if a.URL != "" {
+		displayURL := a.URL
+		for _, prefix := range []string{"https://", "http://"} {
+			displayURL = strings.TrimPrefix(displayURL, prefix)
+		}
+		displayURL = strings.TrimSuffix(displayURL, "/")
+		link := fmt.Sprintf(`<a href="%s">%s</a>`,
+			gohtml.EscapeString(a.URL), gohtml.EscapeString(displayURL))
 		if metaLine != "" {
-			b.WriteString(fmt.Sprintf(`<p class="toc-meta">%s</p>`, metaLine))
-			b.WriteByte('\n')
+			metaLine += "<br/>" + link
+		} else {
+			metaLine = link
 		}
-		b.WriteString("</li>\n")
 	}
-	b.WriteString("</ol>\n")
-	return b.String()
+
+	if metaLine != "" {
+		b.WriteString(fmt.Sprintf(`<p class="toc-meta">%s</p>`, metaLine))
+		b.WriteByte('\n')
+	}
+	b.WriteString("</li>\n")
+}
+
+// addCover generates the cover for opts.coverStyle and sets it on e.
+// "first-image" falls back to the collage when the first article has no
+// usable image.
+func addCover(e *epub.Epub, title string, articles []epubArticle, opts epubOpts) error {
+	coverTitle := opts.coverTitle
+	if coverTitle == "" {
+		coverTitle = title
+	}
+	coverTitle = shortenTitle(coverTitle, opts.maxTitleLen)
+	style := opts.coverStyle
+	coverURI, filename := "", "cover.png"
+	if style == "first-image" {
+		jpg, err := firstImageCover(articles)
+		if err == nil {
+			coverURI = "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(jpg)
+			filename = "cover.jpg"
+		} else {
+			vprintf("No cover image (%v), using collage cover\n", err)
+			style = "collage"
+		}
+	}
+	if coverURI == "" {
+		coverPNG, err := generateCover(coverTitle, articles, coverOpts{
+			style:      style,
+			subtitle:   opts.coverSubtitle,
+			date:       cmp.Or(opts.date, opts.buildTime()),
+			columns:    opts.coverColumns,
+			maxEntries: opts.coverEntries,
+		})
+		if err != nil {
+			return fmt.Errorf("could not generate cover: %w", err)
+		}
+		coverURI = "data:image/png;base64," + base64.StdEncoding.EncodeToString(coverPNG)
+	}
+
+	imgPath, err := e.AddImage(coverURI, filename)
+	if err != nil {
+		return fmt.Errorf("could not add cover image: %w", err)
+	}
+	if err := e.SetCover(imgPath, ""); err != nil {
+		return fmt.Errorf("could not set cover: %w", err)
+	}
+	return nil
 }
 
 // buildEpub creates an epub3 file from a list of articles with metadata.
 // It generates a front matter table of contents followed by the article sections.
-func buildEpub(articles []epubArticle, title string, outputPath string, coverStyle string) error {
+func buildEpub(articles []epubArticle, title string, outputPath string, opts epubOpts) error {
 	e, err := epub.NewEpub(title)
 	if err != nil {
 		return fmt.Errorf("creating epub: %w", err)
 	}
 	e.SetLang("en")
-	e.SetAuthor("deckle")
+	e.SetAuthor(cmp.Or(opts.author, bookAuthor(articles)))
+	if opts.identifier != "" {
+		e.SetIdentifier(bookIdentifier(opts.identifier))
+	} else if opts.reproducible {
+		e.SetIdentifier(contentUUID(title, articles))
+	}
 
 	// Add minimal CSS for readability on e-readers
-	css := `body { margin: 1em; line-height: 1.5; }
-img { max-width: 100%; height: auto; }
-pre, code { font-size: 0.85em; }
-blockquote { margin-left: 1em; padding-left: 0.5em; border-left: 2px solid #999; }
-.byline { font-size: 0.85em; color: #666; margin-top: -0.5em; margin-bottom: 1.5em; }
-.byline a { color: #666; }
-.toc { list-style-type: none; padding-left: 0; }
-.toc li { margin-bottom: 1.2em; }
-.toc a { text-decoration: none; }
-.toc-meta { font-size: 0.85em; color: #666; margin-top: 0.1em; }
-.toc-meta a { color: #666; }`
+	css := epubCSS(opts)
 	cssDataURI := "data:text/css;base64," + base64.StdEncoding.EncodeToString([]byte(css))
 	cssPath, err := e.AddCSS(cssDataURI, "styles.css")
 	if err != nil {
@@ -202,53 +590,532 @@ blockquote { margin-left: 1em; padding-left: 0.5em; border-left: 2px solid #999;
 		cssPath = ""
 	}
 
+	// The cover and contents show titles cut to -max-title-length; the
+	// book metadata and article headings keep them whole.
+	shown := shortenTitles(articles, opts.maxTitleLen)
+
 	// Generate and set cover image
-	if coverStyle != "none" {
-		coverPNG, err := generateCover(title, articles, coverStyle)
+	var landmarks []landmark
+	if opts.coverStyle != "none" {
+		if err := addCover(e, title, shown, opts); err != nil {
+			fmt.Fprintf(logOut, "Warning: %v\n", err)
+		} else {
+			// go-epub's cover page when SetCover is given no filename
+			landmarks = append(landmarks, landmark{epubType: "cover", guide: "cover", file: "cover.xhtml", title: "Cover"})
+		}
+	}
+
+	// The table of contents goes before the articles, or after them with
+	// -toc-position back; reading order follows the order sections are added.
+	var sections []epubSection
+	addTOC := func() {
+		tocBody := "<section epub:type=\"toc\">\n" + buildTOCBody(shown, opts.tocGroupBySite) + "</section>\n"
+		file, err := e.AddSection(tocBody, "Contents", "contents.xhtml", cssPath)
 		if err != nil {
-			fmt.Fprintf(logOut, "Warning: could not generate cover: %v\n", err)
+			fmt.Fprintf(logOut, "Warning: could not add table of contents: %v\n", err)
+			return
+		}
+		landmarks = append(landmarks, landmark{epubType: "toc", guide: "toc", file: file, title: "Contents"})
+		sections = append(sections, epubSection{"contents.xhtml", tocBody})
+	}
+	if opts.titlePage {
+		body := buildTitlePageBody(title, articles, cmp.Or(opts.buildTime(), time.Now()))
+		if file, err := e.AddSection(body, title, "titlepage.xhtml", cssPath); err != nil {
+			fmt.Fprintf(logOut, "Warning: could not add title page: %v\n", err)
 		} else {
-			coverURI := "data:image/png;base64," + base64.StdEncoding.EncodeToString(coverPNG)
-			imgPath, err := e.AddImage(coverURI, "cover.png")
-			if err != nil {
-				fmt.Fprintf(logOut, "Warning: could not add cover image: %v\n", err)
-			} else if err := e.SetCover(imgPath, ""); err != nil {
-				fmt.Fprintf(logOut, "Warning: could not set cover: %v\n", err)
-			}
+			landmarks = append(landmarks, landmark{epubType: "titlepage", guide: "title-page", file: file, title: "Title Page"})
+			sections = append(sections, epubSection{"titlepage.xhtml", body})
 		}
 	}
+	if opts.tocPosition != "back" {
+		addTOC()
+	}
 
-	// Add front matter table of contents
-	tocBody := buildTOCBody(articles)
-	_, err = e.AddSection(tocBody, "Contents", "contents.xhtml", cssPath)
-	if err != nil {
-		fmt.Fprintf(logOut, "Warning: could not add table of contents: %v\n", err)
+	// Each book spools its images to its own directory: separate epubs
+	// reuse the same image file names.
+	var imageDir string
+	if opts.spoolDir != "" {
+		imageDir, err = os.MkdirTemp(opts.spoolDir, "images-*")
+		if err != nil {
+			return fmt.Errorf("creating image spool: %w", err)
+		}
+		defer os.RemoveAll(imageDir)
 	}
 
+	// Each book gets the whole -max-embedded-bytes budget, charged in
+	// article order.
+	var budget *embedBudget
+	if opts.maxEmbedBytes > 0 {
+		budget = &embedBudget{limit: opts.maxEmbedBytes}
+		defer reportEmbedBudget(budget)
+	}
+
+	sOpts := opts.sanitizeOpts()
+	itemProps := map[string][]string{}
 	for i, a := range articles {
-		body := extractBodyContent(a.HTML)
+		html, err := a.loadHTML()
+		if err != nil {
+			fmt.Fprintf(logOut, "Warning: could not add article %d: %v\n", i+1, err)
+			continue
+		}
+		if budget != nil {
+			html = string(applyEmbedBudget([]byte(html), budget))
+		}
+		body := extractBodyContent(html)
 		chTitle := extractH1Title(body)
+		if chTitle == "" {
+			chTitle = a.Title
+		}
 		if chTitle == "" {
 			chTitle = fmt.Sprintf("Article %d", i+1)
 		}
 
+		if opts.sourceFooter {
+			body += formatSourceFooter(sourceInfo{
+				URL:           a.URL,
+				SiteName:      a.SiteName,
+				PublishedTime: a.PublishedTime,
+			})
+		}
+
+		if opts.leadParagraph != "" {
+			body = markLeadParagraph(body)
+		}
+
 		// Sanitize HTML to XHTML for epub compatibility
-		body = sanitizeForXHTML(body)
+		body = sanitizeForXHTMLOpts(body, sOpts)
 
 		// Extract and embed base64 images
-		body, _ = extractImages(e, body, i+1)
+		if opts.svgInline {
+			body = inlineSVGImages(body)
+		}
+		body, _ = extractImages(e, body, cmp.Or(a.Source, i+1), imageDir)
 
-		filename := fmt.Sprintf("article%03d.xhtml", i+1)
-		_, err := e.AddSection(body, chTitle, filename, cssPath)
+		filename, err := e.AddSection(body, shortenTitle(chTitle, opts.maxTitleLen), fmt.Sprintf("article%03d.xhtml", i+1), cssPath)
 		if err != nil {
 			fmt.Fprintf(logOut, "Warning: could not add section %q: %v\n", chTitle, err)
 			continue
 		}
+		sections = append(sections, epubSection{filename, body})
+		if strings.Contains(body, "<math") {
+			itemProps[filename] = append(itemProps[filename], "mathml")
+		}
+		if strings.Contains(body, "<svg") {
+			itemProps[filename] = append(itemProps[filename], "svg")
+		}
+		if !slices.ContainsFunc(landmarks, func(l landmark) bool { return l.epubType == "bodymatter" }) {
+			landmarks = append(landmarks, landmark{epubType: "bodymatter", guide: "text", file: filename, title: "Start"})
+		}
+	}
+
+	if opts.tocPosition == "back" {
+		addTOC()
+	}
+
+	if opts.validate {
+		if problems := validateSections(sections); len(problems) > 0 {
+			for _, p := range problems {
+				fmt.Fprintf(logOut, "Invalid: %s\n", p)
+			}
+			return fmt.Errorf("epub failed validation with %d problem(s); not writing %s", len(problems), outputPath)
+		}
 	}
 
 	if err := e.Write(outputPath); err != nil {
 		return fmt.Errorf("writing epub: %w", err)
 	}
 
+	if landmarks, err = resolveLandmarks(outputPath, landmarks); err != nil {
+		return fmt.Errorf("reading written epub: %w", err)
+	}
+	date := cmp.Or(opts.date, newestPublished(articles), opts.buildTime(), time.Now())
+	err = rewriteEpub(outputPath, opts.buildTime(), func(name string, data []byte) []byte {
+		data = addLandmarks(name, data, landmarks)
+		if path.Base(name) != "package.opf" {
+			return data
+		}
+		if opts.reproducible {
+			data = opfModifiedRe.ReplaceAll(data, []byte("${1}"+opts.buildTime().Format(time.RFC3339)+"$2"))
+			data = sortManifest(data)
+		}
+		data = setOPFDate(data, date)
+		return markItemProperties(data, itemProps)
+	})
+	if err != nil {
+		return fmt.Errorf("rewriting epub: %w", err)
+	}
+
+	if opts.verifyImages {
+		problems, err := verifyEpubImages(outputPath)
+		if err != nil {
+			return fmt.Errorf("verifying images: %w", err)
+		}
+		for _, p := range problems {
+			fmt.Fprintf(logOut, "Invalid: %s\n", p)
+		}
+		if len(problems) > 0 {
+			return fmt.Errorf("%s failed the image check with %d problem(s)", outputPath, len(problems))
+		}
+	}
+
 	return nil
 }
+
+var (
+	// Matches the dcterms:modified value go-epub stamps with the current time.
+	opfModifiedRe = regexp.MustCompile(`(<meta property="dcterms:modified">)[^<]*(</meta>)`)
+	// Matches the OPF manifest and the items within it.
+	opfManifestRe = regexp.MustCompile(`(?s)<manifest>.*?</manifest>`)
+	opfItemRe     = regexp.MustCompile(`<item\b[^>]*>(?:</item>)?`)
+)
+
+// sortManifest orders the OPF manifest items by their markup. go-epub lists
+// images and stylesheets in map order, which varies between runs; manifest
+// order carries no meaning, so sorting it is safe.
+func sortManifest(opf []byte) []byte {
+	return opfManifestRe.ReplaceAllFunc(opf, func(manifest []byte) []byte {
+		locs := opfItemRe.FindAllIndex(manifest, -1)
+		items := make([]string, len(locs))
+		for i, loc := range locs {
+			items[i] = string(manifest[loc[0]:loc[1]])
+		}
+		slices.Sort(items)
+		var out bytes.Buffer
+		prev := 0
+		for i, loc := range locs {
+			out.Write(manifest[prev:loc[0]])
+			out.WriteString(items[i])
+			prev = loc[1]
+		}
+		out.Write(manifest[prev:])
+		return out.Bytes()
+	})
+}
+
+// newestPublished returns the latest article publication date, or the zero
+// time if no article has one.
+func newestPublished(articles []epubArticle) time.Time {
+	var newest time.Time
+	for _, a := range articles {
+		if a.PublishedTime != nil && a.PublishedTime.After(newest) {
+			newest = *a.PublishedTime
+		}
+	}
+	return newest
+}
+
+// setOPFDate adds a dc:date publication date to the package document's
+// metadata, which go-epub has no setter for.
+func setOPFDate(opf []byte, date time.Time) []byte {
+	el := "<dc:date>" + date.UTC().Format(time.RFC3339) + "</dc:date>\n  </metadata>"
+	return bytes.Replace(opf, []byte("</metadata>"), []byte(el), 1)
+}
+
+// parseBookDate parses a -date value, a date (2006-01-02) or an RFC 3339
+// timestamp. The empty string gives the zero time.
+func parseBookDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid -date %q (must be YYYY-MM-DD or an RFC 3339 time)", s)
+	}
+	return t, nil
+}
+
+// buildTime is the time recorded in a reproducible epub: $SOURCE_DATE_EPOCH
+// if set, else 1980-01-01 (the earliest zip timestamp). It is zero, meaning
+// "now", for ordinary builds.
+func (o epubOpts) buildTime() time.Time {
+	if !o.reproducible {
+		return time.Time{}
+	}
+	if secs, err := strconv.ParseInt(os.Getenv("SOURCE_DATE_EPOCH"), 10, 64); err == nil {
+		return time.Unix(secs, 0).UTC()
+	}
+	return time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// msDosTime converts t to zip's DOS date and time fields (2-second
+// resolution, 1980 at the earliest).
+func msDosTime(t time.Time) (date, clock uint16) {
+	if t.Year() < 1980 {
+		t = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	date = uint16(t.Day() + int(t.Month())<<5 + (t.Year()-1980)<<9)
+	clock = uint16(t.Second()/2 + t.Minute()<<5 + t.Hour()<<11)
+	return date, clock
+}
+
+// maxBookAuthors is how many bylines bookAuthor lists before "and others".
+const maxBookAuthors = 3
+
+// bookAuthor picks the epub author from the articles' bylines: the most
+// common byline when one appears more often than any other, else the
+// distinct bylines joined in article order. "deckle" stands in when no
+// article has a byline.
+func bookAuthor(articles []epubArticle) string {
+	counts := map[string]int{}
+	var names []string
+	for _, a := range articles {
+		name := strings.Join(strings.Fields(a.Byline), " ")
+		if name == "" {
+			continue
+		}
+		if counts[name] == 0 {
+			names = append(names, name)
+		}
+		counts[name]++
+	}
+	switch len(names) {
+	case 0:
+		return "deckle"
+	case 1:
+		return names[0]
+	}
+
+	// SortStableFunc keeps ties in article order.
+	byCount := slices.Clone(names)
+	slices.SortStableFunc(byCount, func(a, b string) int { return counts[b] - counts[a] })
+	if counts[byCount[0]] > counts[byCount[1]] {
+		return byCount[0]
+	}
+	if len(names) > maxBookAuthors {
+		return strings.Join(names[:maxBookAuthors], ", ") + ", and others"
+	}
+	return strings.Join(names, ", ")
+}
+
+// contentUUID derives a stable urn:uuid identifier from the book title and
+// its articles, in place of go-epub's random one.
+func contentUUID(title string, articles []epubArticle) string {
+	h := sha256.New()
+	io.WriteString(h, title)
+	for _, a := range articles {
+		io.WriteString(h, "\x00"+a.URL+"\x00"+a.Title)
+	}
+	b := h.Sum(nil)[:16]
+	b[6] = b[6]&0x0f | 0x50 // version 5 (name-based, SHA)
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// bareUUIDRe matches a UUID given without its urn:uuid: prefix.
+var bareUUIDRe = regexp.MustCompile(`^(?i)[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// bookIdentifier returns the -epub-id value as the book's identifier,
+// writing a bare UUID as a urn:uuid: URN like go-epub's own.
+func bookIdentifier(id string) string {
+	if bareUUIDRe.MatchString(id) {
+		return "urn:uuid:" + strings.ToLower(id)
+	}
+	return id
+}
+
+// landmark is a navigation point offered by readers' "Go to" menus.
+type landmark struct {
+	epubType string // EPUB 3 landmarks type: "cover", "toc", or "bodymatter"
+	guide    string // EPUB 2 guide reference type: "cover", "toc", or "text"
+	file     string // section file name, as AddSection returned it
+	href     string // set by resolveLandmarks: relative to the package document
+	title    string
+}
+
+// resolveLandmarks sets each landmark's href from the manifest of the
+// written epub, so the links follow wherever go-epub put the sections.
+// Landmarks whose file isn't in the manifest are dropped with a warning
+// rather than left dangling.
+func resolveLandmarks(epubPath string, marks []landmark) ([]landmark, error) {
+	if len(marks) == 0 {
+		return marks, nil
+	}
+	zr, err := zip.OpenReader(epubPath)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	var opf *zip.File
+	for _, f := range zr.File {
+		if path.Ext(f.Name) == ".opf" {
+			opf = f
+		}
+	}
+	var pkg struct {
+		Items []struct {
+			Href string `xml:"href,attr"`
+		} `xml:"manifest>item"`
+	}
+	if err := xml.Unmarshal(readZipEntry(opf), &pkg); err != nil {
+		return nil, fmt.Errorf("reading package document: %w", err)
+	}
+	hrefs := map[string]string{} // file name -> href
+	for _, item := range pkg.Items {
+		hrefs[path.Base(item.Href)] = item.Href
+	}
+	var resolved []landmark
+	for _, m := range marks {
+		if m.href = hrefs[m.file]; m.href == "" {
+			fmt.Fprintf(logOut, "Warning: %s landmark: %s is not in the book\n", m.epubType, m.file)
+			continue
+		}
+		resolved = append(resolved, m)
+	}
+	return resolved, nil
+}
+
+// addLandmarks adds the landmarks to the EPUB 3 navigation document (a
+// hidden landmarks nav) and to the package document (an EPUB 2 <guide>
+// for older readers). go-epub writes neither, so both are patched after
+// writing; other files are returned unchanged.
+func addLandmarks(name string, data []byte, marks []landmark) []byte {
+	if len(marks) == 0 {
+		return data
+	}
+	var b strings.Builder
+	var at int
+	switch path.Base(name) {
+	case "nav.xhtml":
+		at = bytes.LastIndex(data, []byte("</body>"))
+		b.WriteString("    <nav epub:type=\"landmarks\" hidden=\"\">\n      <h1>Guide</h1>\n      <ol>\n")
+		for _, m := range marks {
+			fmt.Fprintf(&b, "        <li><a epub:type=\"%s\" href=\"%s\">%s</a></li>\n", m.epubType, m.href, gohtml.EscapeString(m.title))
+		}
+		b.WriteString("      </ol>\n    </nav>\n")
+	case "package.opf":
+		at = bytes.Index(data, []byte("</spine>"))
+		if at >= 0 {
+			at += len("</spine>")
+		}
+		b.WriteString("\n  <guide>\n")
+		for _, m := range marks {
+			fmt.Fprintf(&b, "    <reference type=\"%s\" title=\"%s\" href=\"%s\"></reference>\n", m.guide, gohtml.EscapeString(m.title), m.href)
+		}
+		b.WriteString("  </guide>")
+	default:
+		return data
+	}
+	if at < 0 {
+		return data
+	}
+	return slices.Concat(data[:at], []byte(b.String()), data[at:])
+}
+
+// markItemProperties adds properties (e.g. "mathml", "svg") to the manifest
+// items for the given section files, as EPUB 3 requires for documents
+// containing MathML or inline SVG. go-epub has no API for item properties,
+// so the OPF is patched after writing.
+func markItemProperties(opf []byte, props map[string][]string) []byte {
+	s := string(opf)
+	for name, p := range props {
+		re := regexp.MustCompile(`(<item\b[^>]*\bhref="[^"]*` + regexp.QuoteMeta(name) + `")`)
+		s = re.ReplaceAllString(s, `$1 properties="`+strings.Join(p, " ")+`"`)
+	}
+	return []byte(s)
+}
+
+// rewriteEpub rewrites the text entries of an already-written epub: fn
+// receives each markup, package, or stylesheet entry's name and contents
+// and returns the contents to store. Images and other entries are copied
+// still compressed, so a large book is never held in memory. Entry order
+// and compression are preserved so mimetype stays first and uncompressed;
+// modification times are kept unless modified is non-zero. Times are
+// stored only in the DOS header fields: an extended-timestamp extra field
+// would break the OCF rule that mimetype has no extra field. The new book
+// is written beside the old one and renamed over it, so a failed rewrite
+// leaves the original intact.
+func rewriteEpub(epubPath string, modified time.Time, fn func(name string, data []byte) []byte) error {
+	zr, err := zip.OpenReader(epubPath)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	info, err := os.Stat(epubPath)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(epubPath), ".deckle-*.epub")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+	if err := writeRewrittenEpub(tmp, &zr.Reader, modified, fn); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), epubPath)
+}
+
+// writeRewrittenEpub writes zr's entries to w for rewriteEpub.
+func writeRewrittenEpub(w io.Writer, zr *zip.Reader, modified time.Time, fn func(name string, data []byte) []byte) error {
+	zw := zip.NewWriter(w)
+	for _, f := range zr.File {
+		hdr := &zip.FileHeader{
+			Name:         f.Name,
+			Method:       f.Method,
+			ModifiedTime: f.ModifiedTime,
+			ModifiedDate: f.ModifiedDate,
+		}
+		if !modified.IsZero() {
+			hdr.ModifiedDate, hdr.ModifiedTime = msDosTime(modified)
+		}
+
+		if !rewritableEntry(f.Name) {
+			raw, err := f.OpenRaw()
+			if err != nil {
+				return err
+			}
+			hdr.Flags = f.Flags
+			hdr.CRC32 = f.CRC32
+			hdr.CompressedSize64 = f.CompressedSize64
+			hdr.UncompressedSize64 = f.UncompressedSize64
+			dst, err := zw.CreateRaw(hdr)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(dst, raw); err != nil {
+				return err
+			}
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+		dst, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		if _, err := dst.Write(fn(f.Name, data)); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// rewritableEntry reports whether rewriteEpub passes the named entry to its
+// callback: the book's markup, package document, navigation, and styles.
+func rewritableEntry(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".xhtml", ".html", ".opf", ".ncx", ".xml", ".css":
+		return true
+	}
+	return false
+}
@@ -2,12 +2,19 @@ package main
 
 import (
 	"archive/zip"
+	"bytes"
 	"encoding/base64"
 	"image/color"
 	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"regexp"
+	"runtime"
 	"strings"
 	"testing"
 	"time"
@@ -83,7 +90,7 @@ func TestBuildEpub_Basic(t *testing.T) {
 	}
 
 	outPath := filepath.Join(t.TempDir(), "test.epub")
-	err := buildEpub(articles, "Test Book", outPath, "collage")
+	err := buildEpub(articles, "Test Book", outPath, epubOpts{coverStyle: "collage"})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -201,7 +208,7 @@ func TestExtractImages_MultipleMIMETypes(t *testing.T) {
 		`<img src="data:image/gif;base64,R0lGODlhAQABAIAAAAAAAP///yH5BAEAAAAALAAAAAABAAEAAAIBRAA7" alt="gif">`
 
 	e, _ := epub.NewEpub("test")
-	result, err := extractImages(e, body, 1)
+	result, err := extractImages(e, body, 1, "")
 	if err != nil {
 		t.Logf("extractImages returned error (may be expected): %v", err)
 	}
@@ -215,18 +222,75 @@ func TestExtractImages_InvalidBase64(t *testing.T) {
 	body := `<img src="data:image/jpeg;base64,!!!invalid!!!" alt="broken">`
 
 	e, _ := epub.NewEpub("test")
-	result, _ := extractImages(e, body, 1)
+	result, _ := extractImages(e, body, 1, "")
 	// Invalid base64 should keep the original
 	if !strings.Contains(result, "!!!invalid!!!") {
 		t.Error("invalid base64 image should be kept as-is")
 	}
 }
 
+func TestShortenTitle(t *testing.T) {
+	for _, tc := range []struct {
+		in    string
+		limit int
+		want  string
+	}{
+		{"A Short Title", 0, "A Short Title"},
+		{"A Short Title", 13, "A Short Title"},
+		{"The Remarkable History of the Pencil, Told in Twelve Objects", 40, "The Remarkable History of the Pencil…"},
+		{"Why Go's scheduler works: a deep dive", 26, "Why Go's scheduler works…"},
+		{"Supercalifragilisticexpialidocious", 10, "Supercali…"},
+		{"Café au lait à la française", 15, "Café au lait à…"},
+		{"Supercalifragilisticexpialidocious", 2, "S…"},
+		{"Supercalifragilisticexpialidocious", 1, "…"},
+		{"A", 1, "A"},
+	} {
+		got := shortenTitle(tc.in, tc.limit)
+		if got != tc.want {
+			t.Errorf("shortenTitle(%q, %d) = %q, want %q", tc.in, tc.limit, got, tc.want)
+		}
+		if tc.limit > 0 && len([]rune(got)) > tc.limit {
+			t.Errorf("shortenTitle(%q, %d) is %d characters", tc.in, tc.limit, len([]rune(got)))
+		}
+	}
+}
+
+func TestBuildEpub_MaxTitleLength(t *testing.T) {
+	long := "The Remarkable History of the Pencil, Told in Twelve Objects"
+	articles := []epubArticle{{
+		HTML:  `<html><body><h1>` + long + `</h1><p>Graphite.</p></body></html>`,
+		Title: long,
+	}}
+	outPath := filepath.Join(t.TempDir(), "titles.epub")
+	if err := buildEpub(articles, long, outPath, epubOpts{coverStyle: "none", maxTitleLen: 40}); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.OpenReader(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+
+	short := "The Remarkable History of the Pencil…"
+	if toc := findZipFile(zr, "EPUB/xhtml/contents.xhtml"); !strings.Contains(toc, short) || strings.Contains(toc, long) {
+		t.Error("contents page should show the shortened title")
+	}
+	if nav := findZipFile(zr, "EPUB/nav.xhtml"); !strings.Contains(nav, short) {
+		t.Error("navigation should show the shortened title")
+	}
+	if article := findZipFile(zr, "EPUB/xhtml/article001.xhtml"); !strings.Contains(article, long) {
+		t.Error("the article heading should keep the full title")
+	}
+	if opf := findZipFile(zr, "EPUB/package.opf"); !strings.Contains(opf, long) {
+		t.Error("book metadata should keep the full title")
+	}
+}
+
 func TestBuildTOCBody_EmptyTitle(t *testing.T) {
 	articles := []epubArticle{
 		{HTML: "<body><p>content</p></body>", Title: "", URL: "https://example.com"},
 	}
-	result := buildTOCBody(articles)
+	result := buildTOCBody(articles, false)
 	if !strings.Contains(result, "Article 1") {
 		t.Error("empty title should fall back to 'Article N'")
 	}
@@ -244,7 +308,7 @@ func TestBuildTOCBody_FullMetadata(t *testing.T) {
 			PublishedTime: &pubDate,
 		},
 	}
-	result := buildTOCBody(articles)
+	result := buildTOCBody(articles, false)
 	if !strings.Contains(result, "My Article") {
 		t.Error("expected article title in TOC")
 	}
@@ -271,7 +335,7 @@ func TestBuildTOCBody_DateOnly(t *testing.T) {
 			PublishedTime: &pubDate,
 		},
 	}
-	result := buildTOCBody(articles)
+	result := buildTOCBody(articles, false)
 	if !strings.Contains(result, "December 1, 2023") {
 		t.Error("expected published date in TOC")
 	}
@@ -284,13 +348,51 @@ func TestBuildTOCBody_URLOnly(t *testing.T) {
 	articles := []epubArticle{
 		{HTML: "<body><p>c</p></body>", Title: "T", URL: "https://example.com/"},
 	}
-	result := buildTOCBody(articles)
+	result := buildTOCBody(articles, false)
 	// URL should have scheme and trailing slash stripped
 	if !strings.Contains(result, "example.com") {
 		t.Error("expected clean URL in TOC")
 	}
 }
 
+func TestBuildTOCBody_GroupBySite(t *testing.T) {
+	articles := []epubArticle{
+		{Title: "A1", SiteName: "Alpha"},
+		{Title: "N1"},
+		{Title: "B1", SiteName: "Beta & Co"},
+		{Title: "A2", SiteName: "Alpha"},
+	}
+	result := buildTOCBody(articles, true)
+
+	order := []string{
+		`<h2 class="toc-site">Alpha</h2>`,
+		`<a href="article001.xhtml">A1</a>`,
+		`<a href="article004.xhtml">A2</a>`,
+		`<h2 class="toc-site">Beta &amp; Co</h2>`,
+		`<a href="article003.xhtml">B1</a>`,
+		`<h2 class="toc-site">Other</h2>`,
+		`<a href="article002.xhtml">N1</a>`,
+	}
+	last := -1
+	for _, want := range order {
+		idx := strings.Index(result, want)
+		if idx <= last {
+			t.Fatalf("expected %q after previous entries in:\n%s", want, result)
+		}
+		last = idx
+	}
+	if n := strings.Count(result, `<ol class="toc">`); n != 3 {
+		t.Errorf("expected one list per site, got %d", n)
+	}
+	if !strings.Contains(result, `<p class="toc-meta">Alpha</p>`) {
+		t.Error("metadata line should be unchanged when grouped")
+	}
+
+	if flat := buildTOCBody(articles, false); strings.Contains(flat, "<h2") {
+		t.Error("ungrouped TOC should have no site headings")
+	}
+}
+
 func TestExtractBodyContent_NoEndBody(t *testing.T) {
 	input := `<html><body><p>hello</p>`
 	got := extractBodyContent(input)
@@ -304,7 +406,7 @@ func TestBuildEpub_NoTitleFallback(t *testing.T) {
 		{HTML: `<html><body><p>No heading here.</p></body></html>`, Title: ""},
 	}
 	outPath := filepath.Join(t.TempDir(), "notitle.epub")
-	err := buildEpub(articles, "Fallback Title", outPath, "collage")
+	err := buildEpub(articles, "Fallback Title", outPath, epubOpts{coverStyle: "collage"})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -340,9 +442,18 @@ func TestBuildEpub_EpubCheck(t *testing.T) {
 			URL:   "https://example.com/chapter-two",
 		},
 	}
+	var progressive bytes.Buffer
+	if err := encodeProgressiveJPEG(&progressive, gradientImage(240, 160), 60); err != nil {
+		t.Fatal(err)
+	}
+	articles = append(articles, epubArticle{
+		HTML:  `<html><body><h1>Chapter Three</h1><p>A progressive JPEG.</p><img src="` + dataURI("image/jpeg", progressive.Bytes()) + `" alt="gradient"/></body></html>`,
+		Title: "Chapter Three",
+		URL:   "https://example.com/chapter-three",
+	})
 
 	outPath := filepath.Join(t.TempDir(), "check.epub")
-	err := buildEpub(articles, "EpubCheck Test", outPath, "collage")
+	err := buildEpub(articles, "EpubCheck Test", outPath, epubOpts{coverStyle: "collage"})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -353,4 +464,724 @@ func TestBuildEpub_EpubCheck(t *testing.T) {
 	if err != nil {
 		t.Errorf("epubcheck failed:\n%s", out)
 	}
+
+	// Inline SVG, twice on one page so their ids must not collide.
+	svg := `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" viewBox="0 0 10 10"><defs><linearGradient id="g"><stop offset="0" stop-color="red"/></linearGradient></defs><rect id="r" width="10" height="10" fill="url(#g)"/><use xlink:href="#r"/><image href="https://example.com/x.png"/></svg>`
+	img := `<img src="` + dataURI("image/svg+xml", []byte(svg)) + `" alt="Box"/>`
+	articles = append(articles, epubArticle{
+		HTML:  `<html><body><h1>Chapter Four</h1><p>` + img + `</p><p>` + img + `</p></body></html>`,
+		Title: "Chapter Four",
+	})
+	if err := buildEpub(articles, "EpubCheck Test", outPath, epubOpts{coverStyle: "collage", svgInline: true}); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := runCommand("epubcheck", outPath); err != nil {
+		t.Errorf("epubcheck failed with inline SVG:\n%s", out)
+	}
+}
+
+func TestEpubCSS_KeptClassRules(t *testing.T) {
+	css := epubCSS(epubOpts{keepClasses: []string{"pullquote", "unknown"}})
+	if !strings.Contains(css, ".pullquote {") {
+		t.Error("expected default rule for kept class pullquote")
+	}
+	if strings.Contains(css, ".unknown") {
+		t.Error("classes without a default rule should not get CSS")
+	}
+	if epubCSS(epubOpts{}) != baseEpubCSS {
+		t.Error("without kept classes the stylesheet should be unchanged")
+	}
+}
+
+func TestEpubCSS_Themes(t *testing.T) {
+	if epubCSS(epubOpts{theme: "default"}) != baseEpubCSS {
+		t.Error("default theme should be the base stylesheet")
+	}
+	for name, want := range map[string]string{
+		"serif":   "serif;",
+		"sans":    "sans-serif;",
+		"compact": "line-height: 1.3;",
+		"dark":    "background-color: #000;",
+	} {
+		css := epubCSS(epubOpts{theme: name, keepClasses: []string{"lede"}})
+		if !strings.HasPrefix(css, baseEpubCSS+"\n") || !strings.Contains(css, want) {
+			t.Errorf("%s: expected base rules then %q, got:\n%s", name, want, css)
+		}
+		// Later rules win in CSS, so kept-class rules come after the theme.
+		if strings.Index(css, ".lede {") < strings.Index(css, want) {
+			t.Errorf("%s: kept-class rules should follow the theme", name)
+		}
+	}
+
+	err := run(cliConfig{format: "epub", output: "x.epub", epubTheme: "neon", args: []string{"http://example.com"}})
+	if err == nil || !strings.Contains(err.Error(), `unknown epub theme "neon"`) {
+		t.Errorf("expected unknown theme error, got %v", err)
+	}
+}
+
+func TestBuildEpub_KeepClasses(t *testing.T) {
+	articles := []epubArticle{{
+		HTML:  `<html><body><h1>Classy</h1><p class="pullquote junk">Quoted.</p></body></html>`,
+		Title: "Classy",
+	}}
+	outPath := filepath.Join(t.TempDir(), "classes.epub")
+	opts := epubOpts{coverStyle: "none", keepClasses: []string{"pullquote"}}
+	if err := buildEpub(articles, "Classes", outPath, opts); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.OpenReader(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+
+	article := findZipFile(zr, "EPUB/xhtml/article001.xhtml")
+	if !strings.Contains(article, `class="pullquote"`) || strings.Contains(article, "junk") {
+		t.Errorf("article should keep only allowlisted classes, got %q", article)
+	}
+	if css := findZipFile(zr, "EPUB/css/styles.css"); !strings.Contains(css, ".pullquote") {
+		t.Error("stylesheet should include the pullquote rule")
+	}
+}
+
+func TestBuildEpub_MathMLProperty(t *testing.T) {
+	articles := []epubArticle{
+		{HTML: `<html><body><h1>Plain</h1><p>No math.</p></body></html>`, Title: "Plain"},
+		{HTML: `<html><body><h1>Math</h1><p><math><mi>x</mi></math></p></body></html>`, Title: "Math"},
+	}
+	outPath := filepath.Join(t.TempDir(), "math.epub")
+	if err := buildEpub(articles, "Math", outPath, epubOpts{coverStyle: "none"}); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.OpenReader(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+
+	if zr.File[0].Name != "mimetype" || zr.File[0].Method != zip.Store || len(zr.File[0].Extra) != 0 {
+		t.Error("mimetype must remain the first, uncompressed entry with no extra field")
+	}
+	opf := findZipFile(zr, "EPUB/package.opf")
+	if !regexp.MustCompile(`href="xhtml/article002.xhtml"[^>]*properties="mathml"`).MatchString(opf) {
+		t.Errorf("math section should be marked with properties=\"mathml\", got %q", opf)
+	}
+	if regexp.MustCompile(`href="xhtml/article001.xhtml"[^>]*properties="mathml"`).MatchString(opf) {
+		t.Error("plain section should not be marked as MathML")
+	}
+}
+
+func TestBuildEpub_Landmarks(t *testing.T) {
+	articles := []epubArticle{
+		{HTML: `<html><body><h1>First</h1><p>One.</p></body></html>`, Title: "First"},
+		{HTML: `<html><body><h1>Second</h1><p>Two.</p></body></html>`, Title: "Second"},
+	}
+	for _, cover := range []string{"collage", "none"} {
+		outPath := filepath.Join(t.TempDir(), "marks.epub")
+		if err := buildEpub(articles, "Marks", outPath, epubOpts{coverStyle: cover}); err != nil {
+			t.Fatal(err)
+		}
+		zr, err := zip.OpenReader(outPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zr.Close()
+
+		nav := findZipFile(zr, "EPUB/nav.xhtml")
+		opf := findZipFile(zr, "EPUB/package.opf")
+		for _, want := range []string{
+			`<nav epub:type="landmarks" hidden="">`,
+			`<a epub:type="toc" href="xhtml/contents.xhtml">`,
+			`<a epub:type="bodymatter" href="xhtml/article001.xhtml">`,
+		} {
+			if !strings.Contains(nav, want) {
+				t.Errorf("%s: nav.xhtml missing %q:\n%s", cover, want, nav)
+			}
+		}
+		if !strings.Contains(opf, `</spine>
+  <guide>`) || !strings.Contains(opf, `<reference type="toc" title="Contents" href="xhtml/contents.xhtml">`) {
+			t.Errorf("%s: package.opf missing guide:\n%s", cover, opf)
+		}
+		if hasCover := strings.Contains(nav, `epub:type="cover"`); hasCover != (cover != "none") {
+			t.Errorf("%s: cover landmark present = %v", cover, hasCover)
+		}
+		if !strings.Contains(findZipFile(zr, "EPUB/xhtml/contents.xhtml"), `<section epub:type="toc">`) {
+			t.Errorf("%s: contents page should be marked epub:type=\"toc\"", cover)
+		}
+
+		// Every landmark, in either document, links to a file in the book.
+		inBook := map[string]bool{}
+		for _, f := range zr.File {
+			inBook[f.Name] = true
+		}
+		hrefs := regexp.MustCompile(`<a epub:type="[^"]+" href="([^"]+)"`).FindAllStringSubmatch(nav, -1)
+		hrefs = append(hrefs, regexp.MustCompile(`<reference [^>]*href="([^"]+)"`).FindAllStringSubmatch(opf, -1)...)
+		if len(hrefs) < 4 {
+			t.Errorf("%s: expected landmarks in both nav.xhtml and package.opf, got %d", cover, len(hrefs))
+		}
+		for _, m := range hrefs {
+			if !inBook["EPUB/"+m[1]] {
+				t.Errorf("%s: landmark href %q is not in the book", cover, m[1])
+			}
+		}
+	}
+}
+
+func TestBuildEpub_TOCPosition(t *testing.T) {
+	articles := []epubArticle{
+		{HTML: `<html><body><h1>First</h1><p>One.</p></body></html>`, Title: "First"},
+		{HTML: `<html><body><h1>Second</h1><p>Two.</p></body></html>`, Title: "Second"},
+	}
+	itemrefRe := regexp.MustCompile(`<itemref idref="([^"]+)"`)
+	navRe := regexp.MustCompile(`<a href="xhtml/([^"]+)"`)
+	for pos, want := range map[string][]string{
+		"front": {"contents.xhtml", "article001.xhtml", "article002.xhtml"},
+		"back":  {"article001.xhtml", "article002.xhtml", "contents.xhtml"},
+	} {
+		outPath := filepath.Join(t.TempDir(), pos+".epub")
+		if err := buildEpub(articles, "Order", outPath, epubOpts{coverStyle: "none", tocPosition: pos}); err != nil {
+			t.Fatal(err)
+		}
+		zr, err := zip.OpenReader(outPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zr.Close()
+
+		var spine, nav []string
+		for _, m := range itemrefRe.FindAllStringSubmatch(findZipFile(zr, "EPUB/package.opf"), -1) {
+			spine = append(spine, m[1])
+		}
+		for _, m := range navRe.FindAllStringSubmatch(findZipFile(zr, "EPUB/nav.xhtml"), -1) {
+			nav = append(nav, m[1])
+		}
+		if strings.Join(spine, " ") != strings.Join(want, " ") {
+			t.Errorf("%s: spine order %v, want %v", pos, spine, want)
+		}
+		if strings.Join(nav, " ") != strings.Join(want, " ") {
+			t.Errorf("%s: nav order %v, want %v", pos, nav, want)
+		}
+	}
+}
+
+func TestBuildEpub_TitlePage(t *testing.T) {
+	t.Setenv("SOURCE_DATE_EPOCH", "1700000000")
+	march := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	may := time.Date(2024, 5, 9, 12, 0, 0, 0, time.UTC)
+	articles := []epubArticle{
+		{HTML: `<html><body><p>One.</p></body></html>`, Title: "One", SiteName: "Example", PublishedTime: &may},
+		{HTML: `<html><body><p>Two.</p></body></html>`, Title: "Two", URL: "https://www.other.org/two", PublishedTime: &march},
+		{HTML: `<html><body><p>Three.</p></body></html>`, Title: "Three", SiteName: "Example"},
+	}
+	outPath := filepath.Join(t.TempDir(), "title.epub")
+	opts := epubOpts{coverStyle: "typographic", titlePage: true, reproducible: true, validate: true}
+	if err := buildEpub(articles, "Spring & Summer", outPath, opts); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.OpenReader(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+
+	page := findZipFile(zr, "EPUB/xhtml/titlepage.xhtml")
+	for _, want := range []string{
+		"<h1>Spring &amp; Summer</h1>",
+		"March 1, 2024 – May 9, 2024",
+		"3 articles from 2 sites",
+		"Generated November 14, 2023",
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("title page missing %q:\n%s", want, page)
+		}
+	}
+	var spine []string
+	for _, m := range regexp.MustCompile(`<itemref idref="([^"]+)"`).FindAllStringSubmatch(findZipFile(zr, "EPUB/package.opf"), -1) {
+		spine = append(spine, m[1])
+	}
+	if len(spine) < 3 || spine[0] != "cover.xhtml" || spine[1] != "titlepage.xhtml" || spine[2] != "contents.xhtml" {
+		t.Errorf("expected cover, title page, then contents, got spine %v", spine)
+	}
+
+	single := buildTitlePageBody("One", articles[:1], may)
+	if !strings.Contains(single, ">May 9, 2024<") || !strings.Contains(single, "1 article from Example") {
+		t.Errorf("unexpected single-article title page:\n%s", single)
+	}
+}
+
+func TestBuildEpub_SVG(t *testing.T) {
+	svg := `<?xml version="1.0"?><svg width="20" height="10" onload="evil()"><script>evil()</script><rect width="20" height="10"/></svg>`
+	broken := `<svg xmlns="http://www.w3.org/2000/svg"><rect></svg>`
+	articles := []epubArticle{{
+		HTML: `<html><body><h1>Diagrams</h1><p>Text.</p>` +
+			`<img src="` + dataURI("image/svg+xml", []byte(svg)) + `" alt="A box">` +
+			`<img src="` + dataURI("image/svg+xml", []byte(broken)) + `" alt="Broken"></body></html>`,
+		Title: "Diagrams",
+	}}
+
+	for _, inline := range []bool{false, true} {
+		outPath := filepath.Join(t.TempDir(), "svg.epub")
+		if err := buildEpub(articles, "SVG", outPath, epubOpts{coverStyle: "none", svgInline: inline}); err != nil {
+			t.Fatal(err)
+		}
+		zr, err := zip.OpenReader(outPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zr.Close()
+		chapter := findZipFile(zr, "EPUB/xhtml/article001.xhtml")
+		opf := findZipFile(zr, "EPUB/package.opf")
+
+		if !inline {
+			if strings.Count(chapter, `.svg" alt=`) != 2 || strings.Contains(chapter, "<svg") {
+				t.Errorf("SVGs should be image files by default, got:\n%s", chapter)
+			}
+			if strings.Contains(opf, `properties="svg"`) {
+				t.Error("chapter without inline SVG should not be marked")
+			}
+			continue
+		}
+		if !strings.Contains(chapter, `<svg width="20" height="10" xmlns="http://www.w3.org/2000/svg" role="img" aria-label="A box"><rect width="20" height="10"/></svg>`) {
+			t.Errorf("expected inlined, script-free SVG, got:\n%s", chapter)
+		}
+		if !strings.Contains(chapter, `.svg" alt="Broken"`) {
+			t.Errorf("malformed SVG should stay an image file, got:\n%s", chapter)
+		}
+		if !regexp.MustCompile(`href="xhtml/article001.xhtml"[^>]*properties="svg"`).MatchString(opf) {
+			t.Errorf("chapter with inline SVG should have properties=\"svg\", got:\n%s", opf)
+		}
+	}
+}
+
+func TestBuildEpub_Reproducible(t *testing.T) {
+	t.Setenv("SOURCE_DATE_EPOCH", "1700000000")
+	img := dataURI("image/png", makePNG(40, 30, color.NRGBA{90, 0, 0, 255}))
+	articles := []epubArticle{
+		{HTML: `<html><body><h1>One</h1><p>Text.</p><img src="` + img + `" alt=""></body></html>`, Title: "One", URL: "https://example.com/1"},
+		{HTML: `<html><body><h1>Two</h1><p>Math <math><mi>x</mi></math></p></body></html>`, Title: "Two", URL: "https://example.com/2"},
+	}
+	dir := t.TempDir()
+	build := func(name string, opts epubOpts) []byte {
+		path := filepath.Join(dir, name)
+		if err := buildEpub(articles, "Repro", path, opts); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	opts := epubOpts{coverStyle: "typographic", reproducible: true}
+	a := build("a.epub", opts)
+	b := build("b.epub", opts)
+	if !bytes.Equal(a, b) {
+		t.Fatal("reproducible builds of the same input should be byte-identical")
+	}
+	if bytes.Equal(build("c.epub", epubOpts{coverStyle: "typographic"}), build("d.epub", epubOpts{coverStyle: "typographic"})) {
+		t.Error("ordinary builds should keep a random identifier")
+	}
+
+	zr, err := zip.OpenReader(filepath.Join(dir, "a.epub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	opf := findZipFile(zr, "EPUB/package.opf")
+	if !strings.Contains(opf, `<meta property="dcterms:modified">2023-11-14T22:13:20Z</meta>`) {
+		t.Errorf("expected modified time from SOURCE_DATE_EPOCH in:\n%s", opf)
+	}
+	for _, f := range zr.File {
+		if !f.Modified.Equal(time.Date(2023, 11, 14, 22, 13, 20, 0, time.UTC)) {
+			t.Errorf("%s: unexpected modification time %v", f.Name, f.Modified)
+		}
+	}
+	if len(zr.File[0].Extra) != 0 {
+		t.Error("mimetype entry must have no extra field")
+	}
+}
+
+func TestBuildEpub_ReproducibleCoverDate(t *testing.T) {
+	// The typographic cover prints a date; a reproducible book must not take
+	// it from the clock.
+	t.Setenv("SOURCE_DATE_EPOCH", "1700000000")
+	defer func(orig func() time.Time) { coverNow = orig }(coverNow)
+	articles := []epubArticle{{HTML: `<html><body><h1>One</h1><p>Text.</p></body></html>`, Title: "One", URL: "https://example.com/1"}}
+	dir := t.TempDir()
+	build := func(name string, today time.Time) []byte {
+		coverNow = func() time.Time { return today }
+		path := filepath.Join(dir, name)
+		if err := buildEpub(articles, "Repro", path, epubOpts{coverStyle: "typographic", reproducible: true}); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	monday := build("monday.epub", time.Date(2025, 6, 2, 12, 0, 0, 0, time.UTC))
+	tuesday := build("tuesday.epub", time.Date(2025, 6, 3, 12, 0, 0, 0, time.UTC))
+	if !bytes.Equal(monday, tuesday) {
+		t.Error("reproducible builds on different days should be byte-identical")
+	}
+}
+
+func TestBuildEpub_Date(t *testing.T) {
+	older := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	newer := time.Date(2024, 5, 9, 8, 30, 0, 0, time.FixedZone("EDT", -4*3600))
+	articles := []epubArticle{
+		{HTML: `<html><body><p>One.</p></body></html>`, Title: "One", PublishedTime: &newer},
+		{HTML: `<html><body><p>Two.</p></body></html>`, Title: "Two", PublishedTime: &older},
+		{HTML: `<html><body><p>Three.</p></body></html>`, Title: "Three"},
+	}
+	override, err := parseBookDate("2020-02-29")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseBookDate("29/02/2020"); err == nil {
+		t.Error("expected an error for an unsupported date format")
+	}
+
+	for _, tc := range []struct {
+		opts epubOpts
+		want string
+	}{
+		{epubOpts{coverStyle: "none"}, "<dc:date>2024-05-09T12:30:00Z</dc:date>"},
+		{epubOpts{coverStyle: "none", date: override}, "<dc:date>2020-02-29T00:00:00Z</dc:date>"},
+	} {
+		outPath := filepath.Join(t.TempDir(), "date.epub")
+		if err := buildEpub(articles, "Dated", outPath, tc.opts); err != nil {
+			t.Fatal(err)
+		}
+		zr, err := zip.OpenReader(outPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opf := findZipFile(zr, "EPUB/package.opf")
+		zr.Close()
+		if !strings.Contains(opf, tc.want) {
+			t.Errorf("expected %s in:\n%s", tc.want, opf)
+		}
+	}
+}
+
+func TestContentUUID(t *testing.T) {
+	a := []epubArticle{{URL: "https://example.com/1", Title: "One"}}
+	id := contentUUID("Book", a)
+	if !regexp.MustCompile(`^urn:uuid:[0-9a-f]{8}-[0-9a-f]{4}-5[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(id) {
+		t.Errorf("not a version 5 UUID URN: %s", id)
+	}
+	if contentUUID("Book", a) != id {
+		t.Error("identifier should be stable")
+	}
+	if contentUUID("Other Book", a) == id {
+		t.Error("identifier should depend on the title")
+	}
+}
+
+func TestBuildEpub_Identifier(t *testing.T) {
+	articles := []epubArticle{{HTML: `<html><body><p>One.</p></body></html>`, Title: "One", URL: "https://example.com/1"}}
+	for _, tc := range []struct {
+		opts epubOpts
+		want string
+	}{
+		{epubOpts{coverStyle: "none", identifier: "6BA7B810-9DAD-11D1-80B4-00C04FD430C8"}, ">urn:uuid:6ba7b810-9dad-11d1-80b4-00c04fd430c8</dc:identifier>"},
+		{epubOpts{coverStyle: "none", identifier: "urn:isbn:9780000000002", reproducible: true}, ">urn:isbn:9780000000002</dc:identifier>"},
+		{epubOpts{coverStyle: "none", reproducible: true}, ">" + contentUUID("Identified", articles) + "</dc:identifier>"},
+	} {
+		outPath := filepath.Join(t.TempDir(), "id.epub")
+		if err := buildEpub(articles, "Identified", outPath, tc.opts); err != nil {
+			t.Fatal(err)
+		}
+		zr, err := zip.OpenReader(outPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opf := findZipFile(zr, "EPUB/package.opf")
+		zr.Close()
+		if !strings.Contains(opf, tc.want) {
+			t.Errorf("expected %s in:\n%s", tc.want, opf)
+		}
+	}
+}
+
+func TestBookAuthor(t *testing.T) {
+	bylines := func(names ...string) []epubArticle {
+		var a []epubArticle
+		for _, n := range names {
+			a = append(a, epubArticle{Byline: n})
+		}
+		return a
+	}
+	for _, tc := range []struct {
+		bylines []string
+		want    string
+	}{
+		{nil, "deckle"},
+		{[]string{"", " "}, "deckle"},
+		{[]string{"Ann Lee", "", "Ann  Lee"}, "Ann Lee"},
+		{[]string{"Bo", "Ann", "Ann"}, "Ann"},
+		{[]string{"Bo", "Ann"}, "Bo, Ann"},
+		{[]string{"A", "B", "C", "D"}, "A, B, C, and others"},
+	} {
+		if got := bookAuthor(bylines(tc.bylines...)); got != tc.want {
+			t.Errorf("bookAuthor(%q) = %q, want %q", tc.bylines, got, tc.want)
+		}
+	}
+
+	outPath := filepath.Join(t.TempDir(), "author.epub")
+	articles := []epubArticle{{HTML: `<html><body><p>Body.</p></body></html>`, Title: "One", Byline: "Ann Lee"}}
+	if err := buildEpub(articles, "Author", outPath, epubOpts{coverStyle: "none", author: "Editor"}); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.OpenReader(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	if opf := findZipFile(zr, "EPUB/package.opf"); !strings.Contains(opf, ">Editor</dc:creator>") {
+		t.Errorf("expected -author as dc:creator in:\n%s", opf)
+	}
+}
+
+func TestBuildEpub_SourceFooter(t *testing.T) {
+	articles := []epubArticle{{
+		HTML:     `<html><body><h1>Shared</h1><p>Body.</p></body></html>`,
+		Title:    "Shared",
+		URL:      "https://example.com/shared?a=1&b=2",
+		SiteName: "Example",
+	}}
+	outPath := filepath.Join(t.TempDir(), "footer.epub")
+	if err := buildEpub(articles, "Footer", outPath, epubOpts{coverStyle: "none", sourceFooter: true}); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.OpenReader(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+
+	article := findZipFile(zr, "EPUB/xhtml/article001.xhtml")
+	want := `<p class="byline source-footer">Originally published at Example — <a href="https://example.com/shared?a=1&amp;b=2">`
+	if !strings.Contains(article, want) {
+		t.Errorf("expected source footer, got %q", article)
+	}
+	if strings.Index(article, "source-footer") < strings.Index(article, "Body.") {
+		t.Error("footer should come after the article body")
+	}
+}
+
+func TestRun_LowMemory(t *testing.T) {
+	png := makePNG(300, 200, color.RGBA{30, 90, 160, 255})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, ".png") {
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(png)
+			return
+		}
+		w.Write([]byte(makeArticleHTML("Story "+r.URL.Path[1:], `Text <img src="/pic.png" alt="pic">`)))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	spool := t.TempDir()
+	t.Setenv("TMPDIR", spool)
+	build := func(name string, lowMemory bool) map[string]string {
+		cfg := cliConfig{
+			format:        "epub",
+			output:        filepath.Join(dir, name),
+			coverStyle:    "first-image",
+			titleOverride: "Big Book",
+			reproducible:  true,
+			lowMemory:     lowMemory,
+			opts:          optimizeOpts{maxWidth: 800, quality: 60},
+			timeout:       5 * time.Second,
+			args:          []string{srv.URL + "/a", srv.URL + "/b"},
+		}
+		if err := run(cfg); err != nil {
+			t.Fatal(err)
+		}
+		zr, err := zip.OpenReader(cfg.output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zr.Close()
+		files := map[string]string{}
+		for _, f := range zr.File {
+			files[f.Name] = findZipFile(zr, f.Name)
+		}
+		return files
+	}
+
+	inMemory, spooled := build("memory.epub", false), build("spooled.epub", true)
+	if len(spooled) != len(inMemory) {
+		t.Fatalf("expected the same %d files, got %d", len(inMemory), len(spooled))
+	}
+	for name, data := range inMemory {
+		if spooled[name] != data {
+			t.Errorf("%s differs with -low-memory", name)
+		}
+	}
+	if spooled["EPUB/images/ch002_img000.jpg"] == "" || spooled["EPUB/images/cover.jpg"] == "" {
+		t.Error("expected article images and the first-image cover")
+	}
+	if left, _ := os.ReadDir(spool); len(left) != 0 {
+		t.Errorf("spool files should be removed, found %d entries", len(left))
+	}
+}
+
+func TestRewriteEpub_StreamsImages(t *testing.T) {
+	// A spooled book's images can be far larger than memory should hold;
+	// rewriting the book must copy them without reading them in.
+	const imageSize = 16 << 20
+	dir := t.TempDir()
+	out := filepath.Join(dir, "big.epub")
+	f, err := os.Create(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	w, _ := zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	io.WriteString(w, "application/epub+zip")
+	w, _ = zw.Create("EPUB/package.opf")
+	io.WriteString(w, "<package></package>")
+	w, _ = zw.Create("EPUB/images/big.jpg")
+	rng := rand.New(rand.NewSource(1))
+	chunk := make([]byte, 64<<10)
+	for n := 0; n < imageSize; n += len(chunk) {
+		rng.Read(chunk)
+		w.Write(chunk)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	err = rewriteEpub(out, time.Date(2024, 1, 2, 3, 4, 6, 0, time.UTC), func(name string, data []byte) []byte {
+		if strings.HasSuffix(name, ".jpg") {
+			t.Errorf("images should not be passed to the callback, got %s", name)
+		}
+		return bytes.ReplaceAll(data, []byte("<package>"), []byte("<package><metadata/>"))
+	})
+	runtime.ReadMemStats(&after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > imageSize/4 {
+		t.Errorf("rewrite allocated %d bytes for a %d-byte image", alloc, imageSize)
+	}
+
+	zr, err := zip.OpenReader(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	if opf := findZipFile(zr, "EPUB/package.opf"); opf != "<package><metadata/></package>" {
+		t.Errorf("package document not rewritten: %q", opf)
+	}
+	for _, f := range zr.File {
+		if !f.Modified.Equal(time.Date(2024, 1, 2, 3, 4, 6, 0, time.UTC)) {
+			t.Errorf("%s: unexpected modification time %v", f.Name, f.Modified)
+		}
+		if f.Name == "EPUB/images/big.jpg" {
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			rng := rand.New(rand.NewSource(1))
+			want := make([]byte, len(chunk))
+			for n := 0; n < imageSize; n += len(chunk) {
+				rng.Read(want)
+				if _, err := io.ReadFull(rc, chunk); err != nil || !bytes.Equal(chunk, want) {
+					t.Fatalf("image changed at byte %d (%v)", n, err)
+				}
+			}
+			rc.Close()
+		}
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("expected only the book in its directory, found %d entries", len(entries))
+	}
+}
+
+func TestRun_ImageNamesSurviveFailedArticle(t *testing.T) {
+	png := makePNG(300, 200, color.RGBA{30, 90, 160, 255})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, ".png"):
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(png)
+		case r.URL.Path == "/broken":
+			http.Error(w, "gone", http.StatusNotFound)
+		default:
+			w.Write([]byte(makeArticleHTML("Story "+r.URL.Path[1:], `Text <img src="/pic.png" alt="pic">`)))
+		}
+	}))
+	defer srv.Close()
+
+	build := func(paths ...string) map[string]bool {
+		cfg := cliConfig{
+			format:     "epub",
+			output:     filepath.Join(t.TempDir(), "book.epub"),
+			coverStyle: "none",
+			opts:       optimizeOpts{maxWidth: 800, quality: 60},
+			timeout:    5 * time.Second,
+		}
+		for _, p := range paths {
+			cfg.args = append(cfg.args, srv.URL+p)
+		}
+		if err := run(cfg); err != nil {
+			t.Fatal(err)
+		}
+		zr, err := zip.OpenReader(cfg.output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zr.Close()
+		images := map[string]bool{}
+		for _, f := range zr.File {
+			if strings.HasPrefix(f.Name, "EPUB/images/") {
+				images[path.Base(f.Name)] = true
+			}
+		}
+		return images
+	}
+
+	got := build("/a", "/broken", "/c")
+	if !got["ch001_img000.jpg"] || !got["ch003_img000.jpg"] || got["ch002_img000.jpg"] || len(got) != 2 {
+		t.Errorf("images should be named by input position despite the failed article, got %v", got)
+	}
+	if all := build("/a", "/b", "/c"); !all["ch003_img000.jpg"] {
+		t.Errorf("expected the third article's image as ch003 on a clean run, got %v", all)
+	}
+}
+
+func TestRun_EpubDate(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(makeArticleHTML("Dated", "An article with a date set on the command line.")))
+	}))
+	defer srv.Close()
+
+	out := filepath.Join(t.TempDir(), "book.epub")
+	cfg := cliConfig{format: "epub", output: out, date: "2020-02-29", timeout: 5 * time.Second, args: []string{srv.URL}}
+	if err := run(cfg); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.OpenReader(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	if opf := findZipFile(zr, "EPUB/package.opf"); !strings.Contains(opf, "<dc:date>2020-02-29T00:00:00Z</dc:date>") {
+		t.Errorf("expected the -date in dc:date, got:\n%s", opf)
+	}
+
+	cfg.date = "29/02/2020"
+	if err := run(cfg); err == nil || !strings.Contains(err.Error(), "invalid -date") {
+		t.Errorf("expected an invalid -date error, got %v", err)
+	}
 }
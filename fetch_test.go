@@ -2,10 +2,15 @@ package main
 
 import (
 	"bytes"
+	"encoding/base64"
+	"image/color"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -29,6 +34,38 @@ func TestFetchHTML_Success(t *testing.T) {
 	}
 }
 
+func TestFetchHTML_Charsets(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        []byte
+		want        string
+	}{
+		{"header windows-1252", "text/html; charset=windows-1252",
+			[]byte("<p>caf\xe9 \x93quoted\x94</p>"), "<p>café “quoted”</p>"},
+		{"meta shift_jis", "text/html",
+			[]byte("<meta charset=\"Shift_JIS\"><p>\x93\xfa\x96\x7b</p>"), "<p>日本</p>"},
+		{"utf-8 BOM", "text/html; charset=utf-8",
+			[]byte("\xef\xbb\xbf<p>naïve</p>"), "<p>naïve</p>"},
+		{"undeclared utf-8", "text/html",
+			[]byte("<p>naïve</p>"), "<p>naïve</p>"},
+	}
+	for _, tt := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", tt.contentType)
+			w.Write(tt.body)
+		}))
+		body, _, err := fetchHTML(srv.URL, 5*time.Second, defaultUA)
+		srv.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := string(body); !strings.HasSuffix(got, tt.want) || strings.HasPrefix(got, "\ufeff") {
+			t.Errorf("%s: got %q, want suffix %q", tt.name, got, tt.want)
+		}
+	}
+}
+
 func TestFetchHTML_NotFound(t *testing.T) {
 	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(404)
@@ -223,6 +260,48 @@ func TestFetchHTML_ExceedsSizeLimit(t *testing.T) {
 	}
 }
 
+func TestReadHTMLLimited_TruncatesAtTagBoundary(t *testing.T) {
+	page := "<html><body><p>first</p><p>second paragraph that is cut</p></body></html>"
+	got, err := readHTMLLimited(strings.NewReader(page), 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "<html><body><p>first</p><p>" {
+		t.Errorf("got %q, want truncation after the last complete tag", got)
+	}
+}
+
+func TestReadHTMLLimited_UnderLimit(t *testing.T) {
+	page := "<p>short</p>"
+	got, err := readHTMLLimited(strings.NewReader(page), 100)
+	if err != nil || string(got) != page {
+		t.Errorf("got %q, %v; want unchanged page", got, err)
+	}
+}
+
+func TestFetchHTML_OversizedPageTruncated(t *testing.T) {
+	saved := maxResponseBytes
+	defer func() { maxResponseBytes = saved }()
+	maxResponseBytes = 1000
+
+	page := "<html><body>" + strings.Repeat("<p>paragraph</p>", 200) + "</body></html>"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(page))
+	}))
+	defer srv.Close()
+
+	body, _, err := fetchHTML(srv.URL, 5*time.Second, defaultUA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(body) > 1000 {
+		t.Errorf("body length %d exceeds limit", len(body))
+	}
+	if !strings.HasSuffix(string(body), ">") {
+		t.Errorf("body should end at a complete tag, ends with %q", body[len(body)-10:])
+	}
+}
+
 func TestFetchHTML_WithinSizeLimit(t *testing.T) {
 	saved := maxResponseBytes
 	defer func() { maxResponseBytes = saved }()
@@ -351,3 +430,224 @@ func TestFetchHTML_WithProxy(t *testing.T) {
 		t.Error("request did not go through proxy")
 	}
 }
+
+// authProxy returns a forwarding proxy that requires user:secret credentials.
+func authProxy(t *testing.T) *httptest.Server {
+	t.Helper()
+	want := "Basic " + base64.StdEncoding.EncodeToString([]byte("user:s3cr#t"))
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Proxy-Authorization") != want {
+			w.Header().Set("Proxy-Authenticate", `Basic realm="proxy"`)
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		resp, err := http.Get(r.URL.String())
+		if err != nil {
+			http.Error(w, err.Error(), 502)
+			return
+		}
+		defer resp.Body.Close()
+		w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+		io.Copy(w, resp.Body)
+	}))
+}
+
+func TestFetchHTML_AuthenticatedProxy(t *testing.T) {
+	saved := fetchProxyURL
+	defer func() { fetchProxyURL = saved }()
+
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, ".png") {
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(makePNG(4, 4, color.White))
+			return
+		}
+		w.Write([]byte("<html><body>behind auth</body></html>"))
+	}))
+	defer target.Close()
+	proxy := authProxy(t)
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+
+	// Without credentials the proxy refuses, and nothing secret leaks.
+	fetchProxyURL = proxy.URL
+	if _, _, err := fetchHTML(target.URL, 5*time.Second, defaultUA); err == nil || !strings.Contains(err.Error(), "407") {
+		t.Fatalf("expected HTTP 407 without credentials, got %v", err)
+	}
+
+	proxyURL.User = url.UserPassword("user", "s3cr#t")
+	fetchProxyURL = proxyURL.String()
+	body, _, err := fetchHTML(target.URL, 5*time.Second, defaultUA)
+	if err != nil {
+		t.Fatalf("fetchHTML via authenticated proxy: %v", err)
+	}
+	if !strings.Contains(string(body), "behind auth") {
+		t.Errorf("unexpected body %q", body)
+	}
+
+	// Image fetches use the same proxy credentials.
+	data, _, err := fetchImageData(target.URL + "/pic.png")
+	if err != nil {
+		t.Fatalf("image fetch via authenticated proxy: %v", err)
+	}
+	if len(data) == 0 {
+		t.Error("expected image data")
+	}
+
+	// Wrong password: the error must not echo the credentials.
+	proxyURL.User = url.UserPassword("user", "wrong-pass")
+	fetchProxyURL = proxyURL.String()
+	_, _, err = fetchHTML(target.URL, 5*time.Second, defaultUA)
+	if err == nil {
+		t.Fatal("expected failure with wrong credentials")
+	}
+	if strings.Contains(err.Error(), "wrong-pass") {
+		t.Errorf("error leaks proxy password: %v", err)
+	}
+}
+
+func TestFetchHTML_SelfSignedRequiresInsecure(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html><body>internal</body></html>"))
+	}))
+	defer srv.Close()
+
+	if _, _, err := fetchHTML(srv.URL, 5*time.Second, defaultUA); err == nil {
+		t.Fatal("expected certificate error without -insecure")
+	}
+
+	fetchInsecure = true
+	defer func() { fetchInsecure = false }()
+	body, _, err := fetchHTML(srv.URL, 5*time.Second, defaultUA)
+	if err != nil {
+		t.Fatalf("expected success with -insecure: %v", err)
+	}
+	if !strings.Contains(string(body), "internal") {
+		t.Errorf("unexpected body %q", body)
+	}
+}
+
+func TestNewProxyClient_Insecure(t *testing.T) {
+	fetchInsecure = true
+	defer func() { fetchInsecure = false }()
+	tr := newProxyClient("", time.Second).Transport.(*http.Transport)
+	if !tr.TLSClientConfig.InsecureSkipVerify {
+		t.Error("proxy client should skip verification with -insecure")
+	}
+}
+
+func TestFetchHTML_AcceptLanguage(t *testing.T) {
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Get("Accept-Language"))
+		w.Write([]byte("<html></html>"))
+	}))
+	defer srv.Close()
+
+	defer func() { fetchAcceptLanguage = defaultAcceptLanguage }()
+	for _, lang := range []string{defaultAcceptLanguage, "de-DE,de;q=0.9", ""} {
+		fetchAcceptLanguage = lang
+		if _, _, err := fetchHTML(srv.URL, 5*time.Second, defaultUA); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{defaultAcceptLanguage, "de-DE,de;q=0.9", ""}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("request %d: Accept-Language = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestFetchHTML_Redirects(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/s/abc":
+			http.Redirect(w, r, "/go?id=abc", http.StatusMovedPermanently)
+		case "/go":
+			http.Redirect(w, r, "/2024/real-article", http.StatusFound)
+		default:
+			w.Write([]byte(makeArticleHTML("Real Article", "")))
+		}
+	}))
+	defer srv.Close()
+
+	_, u, err := fetchHTML(srv.URL+"/s/abc", 5*time.Second, defaultUA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.String() != srv.URL+"/2024/real-article" {
+		t.Errorf("expected the final URL, got %s", u)
+	}
+
+	html, _, src, err := processURL(srv.URL+"/s/abc", cliConfig{timeout: 5 * time.Second, userAgent: defaultUA, format: "html"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src.URL != srv.URL+"/2024/real-article" || !strings.Contains(html, `href="`+srv.URL+`/2024/real-article"`) {
+		t.Errorf("source should link the final URL, got %q", src.URL)
+	}
+
+	defer func() { fetchMaxRedirects = defaultMaxRedirects }()
+	fetchMaxRedirects = 1
+	_, _, err = fetchHTML(srv.URL+"/s/abc", 5*time.Second, defaultUA)
+	if err == nil || !strings.Contains(err.Error(), "too many redirects (more than 1; see -max-redirects)") {
+		t.Errorf("expected a redirect cap error, got %v", err)
+	}
+	fetchMaxRedirects = 0
+	if _, _, err := fetchHTML(srv.URL+"/go", 5*time.Second, defaultUA); err == nil {
+		t.Error("-max-redirects 0 should refuse any redirect")
+	}
+	if _, _, err := fetchHTML(srv.URL+"/2024/real-article", 5*time.Second, defaultUA); err != nil {
+		t.Errorf("pages without redirects should still load: %v", err)
+	}
+}
+
+func TestPresetUserAgent(t *testing.T) {
+	for name, want := range map[string]string{
+		"chrome":    "Chrome/",
+		"Firefox":   "Firefox/",
+		"safari":    "Safari/",
+		"googlebot": "Googlebot/",
+	} {
+		ua, err := presetUserAgent(name)
+		if err != nil || !strings.Contains(ua, want) {
+			t.Errorf("presetUserAgent(%q) = %q, %v; want it to contain %q", name, ua, err, want)
+		}
+	}
+	if _, err := presetUserAgent("netscape"); err == nil {
+		t.Error("expected an error for an unknown preset")
+	}
+}
+
+func TestRun_UserAgentPreset(t *testing.T) {
+	var got atomic.Value
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got.Store(r.UserAgent())
+		w.Write([]byte(makeArticleHTML("Agent", "Which browser asked for this page?")))
+	}))
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		cfg  cliConfig
+		want string
+	}{
+		{cliConfig{userAgent: "deckle-test", uaPreset: "googlebot"}, "Googlebot/"},
+		{cliConfig{userAgent: "deckle-test", uaPreset: "googlebot", uaExplicit: true}, "deckle-test"},
+	} {
+		tc.cfg.format, tc.cfg.timeout = "html", 5*time.Second
+		tc.cfg.output = filepath.Join(t.TempDir(), "out.html")
+		tc.cfg.args = []string{srv.URL}
+		if err := run(tc.cfg); err != nil {
+			t.Fatal(err)
+		}
+		if ua, _ := got.Load().(string); !strings.Contains(ua, tc.want) {
+			t.Errorf("preset %q (explicit %v): User-Agent %q, want %q", tc.cfg.uaPreset, tc.cfg.uaExplicit, ua, tc.want)
+		}
+	}
+
+	err := run(cliConfig{uaPreset: "netscape", args: []string{srv.URL}})
+	if err == nil || !strings.Contains(err.Error(), "unknown user agent preset") {
+		t.Errorf("expected an unknown preset error, got %v", err)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
 )
 
 var (
@@ -16,6 +17,19 @@ var (
 	headingRe    = regexp.MustCompile(`(?i)<(/?)h([1-6])([^>]*)>`)
 	titleSplitRe = regexp.MustCompile(`\s*[-|\x{2013}\x{2014}]\s+`)
 	bodyTagRe    = regexp.MustCompile(`(?i)(<body[^>]*>)`)
+	firstHeadRe  = regexp.MustCompile(`(?is)<h[1-6]\b[^>]*>(.*?)</h[1-6]>`)
+	// A <p> or <div> holding only text, either all bold (group 2) or plain
+	// (group 3); group 1 is the element's attributes
+	pseudoHeadingRe = regexp.MustCompile(`(?i)<(?:p|div)\b([^>]*)>\s*(?:<(?:strong|b)\b[^>]*>([^<]+)</(?:strong|b)>|([^<]+))\s*</(?:p|div)>`)
+	// Attributes that style an element as a heading
+	headingHintRe   = regexp.MustCompile(`(?i)\bclass\s*=\s*"[^"]*\b(?:heading|subhead|subheading|section-title)\b|font-weight\s*:\s*(?:bold|[6-9]00)`)
+	nextParagraphRe = regexp.MustCompile(`(?i)^\s*<p\b`)
+)
+
+// Longest text, in runes and words, promotePseudoHeadings treats as a heading.
+const (
+	maxPseudoHeadingRunes = 80
+	maxPseudoHeadingWords = 12
 )
 
 // extractTitle extracts the article title from <title> tag or first <h1>.
@@ -66,6 +80,61 @@ func shiftHeadings(text string) string {
 	})
 }
 
+// promotePseudoHeadings turns paragraphs styled as headings into <h2>s
+// (-promote-pseudo-headings), for sites that mark sections up as bold or
+// heading-classed <div>/<p> elements. To leave ordinary short paragraphs
+// alone, a candidate must be short text with no sentence punctuation at its
+// end, entirely bold or carrying a heading class or bold style, and directly
+// followed by a paragraph that is not itself a candidate.
+func promotePseudoHeadings(text string) string {
+	var b strings.Builder
+	prev := 0
+	for _, m := range pseudoHeadingRe.FindAllStringSubmatchIndex(text, -1) {
+		heading, ok := pseudoHeading(text, m)
+		if !ok {
+			continue
+		}
+		rest := text[m[1]:]
+		if !nextParagraphRe.MatchString(rest) {
+			continue
+		}
+		if next := pseudoHeadingRe.FindStringSubmatchIndex(rest); next != nil && strings.TrimSpace(rest[:next[0]]) == "" {
+			if _, ok := pseudoHeading(rest, next); ok {
+				continue
+			}
+		}
+		b.WriteString(text[prev:m[0]])
+		b.WriteString("<h2>" + heading + "</h2>")
+		prev = m[1]
+	}
+	if prev == 0 {
+		return text
+	}
+	b.WriteString(text[prev:])
+	return b.String()
+}
+
+// pseudoHeading returns the text of the pseudoHeadingRe match m in text,
+// and whether it qualifies as a heading: all bold or styled as one, short,
+// and not ending in sentence punctuation.
+func pseudoHeading(text string, m []int) (string, bool) {
+	var heading string
+	switch {
+	case m[4] >= 0:
+		heading = text[m[4]:m[5]]
+	case headingHintRe.MatchString(text[m[2]:m[3]]):
+		heading = text[m[6]:m[7]]
+	default:
+		return "", false
+	}
+	heading = strings.Join(strings.Fields(heading), " ")
+	plain := html.UnescapeString(heading)
+	if plain == "" || len([]rune(plain)) > maxPseudoHeadingRunes || len(strings.Fields(plain)) > maxPseudoHeadingWords {
+		return "", false
+	}
+	return heading, !strings.ContainsAny(plain[len(plain)-1:], ".,;:")
+}
+
 // sourceInfo holds attribution info for an article.
 type sourceInfo struct {
 	URL           string     // Original article URL
@@ -92,14 +161,7 @@ func formatByline(src sourceInfo) string {
 	byline := strings.Join(parts, " · ")
 
 	if src.URL != "" {
-		// Show a clean version of the URL (strip scheme)
-		displayURL := src.URL
-		for _, prefix := range []string{"https://", "http://"} {
-			displayURL = strings.TrimPrefix(displayURL, prefix)
-		}
-		displayURL = strings.TrimSuffix(displayURL, "/")
-		link := fmt.Sprintf(`<a href="%s">%s</a>`,
-			html.EscapeString(src.URL), html.EscapeString(displayURL))
+		link := sourceLink(src.URL)
 		if byline != "" {
 			byline += "<br/>" + link
 		} else {
@@ -113,16 +175,47 @@ func formatByline(src sourceInfo) string {
 	return fmt.Sprintf(`<p class="byline">%s</p>`, byline)
 }
 
+// sourceLink renders a link to the source URL, shown without its scheme.
+func sourceLink(rawURL string) string {
+	displayURL := rawURL
+	for _, prefix := range []string{"https://", "http://"} {
+		displayURL = strings.TrimPrefix(displayURL, prefix)
+	}
+	displayURL = strings.TrimSuffix(displayURL, "/")
+	return fmt.Sprintf(`<a href="%s">%s</a>`,
+		html.EscapeString(rawURL), html.EscapeString(displayURL))
+}
+
+// formatSourceFooter builds an "Originally published at <site> on <date> —
+// <url>" paragraph for the end of an article, omitting empty fields.
+// Returns empty string if there's nothing to show.
+func formatSourceFooter(src sourceInfo) string {
+	text := "Originally published"
+	if src.SiteName != "" {
+		text += " at " + html.EscapeString(src.SiteName)
+	}
+	if src.PublishedTime != nil {
+		text += " on " + html.EscapeString(src.PublishedTime.Format("January 2, 2006"))
+	}
+	switch {
+	case src.URL != "" && (src.SiteName != "" || src.PublishedTime != nil):
+		text += " — " + sourceLink(src.URL)
+	case src.URL != "":
+		text += " at " + sourceLink(src.URL)
+	case src.SiteName == "" && src.PublishedTime == nil:
+		return ""
+	}
+	return fmt.Sprintf(`<p class="byline source-footer">%s</p>`, text)
+}
+
 // normalizeHeadings shifts all headings down one level and inserts an H1
 // with the article title and optional byline. If titleOverride is non-empty,
 // it is used instead of extracting the title from the HTML.
 func normalizeHeadings(text string, titleOverride string, src sourceInfo) string {
-	title := titleOverride
-	if title != "" {
-		title = cleanTitle(title)
-	} else {
-		title = extractTitle(text)
-	}
+	title := articleTitle(text, titleOverride)
+
+	// The inserted H1 replaces a leading heading that repeats the title
+	text = dropDuplicateTitle(text, title)
 
 	// Shift all existing headings down one level
 	text = shiftHeadings(text)
@@ -144,6 +237,52 @@ func normalizeHeadings(text string, titleOverride string, src sourceInfo) string
 	return renderFullHTML(text, title, src)
 }
 
+// dropDuplicateTitle removes the first heading of the body when it matches
+// title, ignoring case, punctuation, and markup. Only a heading with no text
+// before it is removed, so a later section heading that happens to repeat
+// the title is kept.
+func dropDuplicateTitle(text, title string) string {
+	start := 0
+	if loc := bodyTagRe.FindStringIndex(text); loc != nil {
+		start = loc[1]
+	}
+	loc := firstHeadRe.FindStringSubmatchIndex(text[start:])
+	if loc == nil {
+		return text
+	}
+	if strings.TrimSpace(htmlTagRe.ReplaceAllString(text[start:start+loc[0]], "")) != "" {
+		return text
+	}
+	heading := html.UnescapeString(htmlTagRe.ReplaceAllString(text[start+loc[2]:start+loc[3]], ""))
+	if key := titleKey(heading); key == "" || key != titleKey(title) {
+		return text
+	}
+	return text[:start+loc[0]] + text[start+loc[1]:]
+}
+
+// titleKey reduces a title to its lowercased words for comparison.
+func titleKey(s string) string {
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(words, " ")
+}
+
+// rawHeadings wraps the article as-is, without shifting headings or
+// inserting a title and byline (-raw-headings). The cleaned title is still
+// used for the document metadata.
+func rawHeadings(text string, titleOverride string, src sourceInfo) string {
+	return renderFullHTML(text, articleTitle(text, titleOverride), src)
+}
+
+// articleTitle returns the cleaned titleOverride, or the title found in text.
+func articleTitle(text string, titleOverride string) string {
+	if titleOverride != "" {
+		return cleanTitle(titleOverride)
+	}
+	return extractTitle(text)
+}
+
 // renderFullHTML wraps the article fragment in a complete HTML document.
 func renderFullHTML(fragment string, title string, src sourceInfo) string {
 	lower := strings.ToLower(fragment)
@@ -187,6 +326,7 @@ func renderFullHTML(fragment string, title string, src sourceInfo) string {
 		pre { white-space: pre-wrap; word-wrap: break-word; }
 		.byline { color: #666; font-style: italic; margin-bottom: 2rem; }
 		blockquote { border-left: 4px solid #eee; padding-left: 1rem; margin-left: 0; color: #666; }
+		.quote-source { color: #666; font-size: 0.9em; margin-top: -0.5rem; }
 	</style>
 </head>
 <body>
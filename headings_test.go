@@ -144,6 +144,84 @@ func TestNormalizeHeadings_InsertsH1(t *testing.T) {
 	}
 }
 
+func TestNormalizeHeadings_DropsDuplicateTitle(t *testing.T) {
+	doc := `<html><head><title>Why Go? - Example</title></head><body><div><img src="a.png"><h1 class="title">Why <em>Go</em></h1><h2>Part one</h2><p>text</p></div></body></html>`
+	result := normalizeHeadings(doc, "", sourceInfo{})
+	if strings.Count(result, "Why") != 2 || strings.Contains(result, `class="title"`) {
+		t.Errorf("expected duplicate heading removed, got:\n%s", result)
+	}
+	if !strings.Contains(result, "<h3>Part one</h3>") {
+		t.Error("other headings should still be shifted")
+	}
+
+	for name, tc := range map[string]struct{ doc, kept string }{
+		"near match":    {`<html><head><title>Why Go</title></head><body><h1>Why Go Matters</h1><p>text</p></body></html>`, "<h2>Why Go Matters</h2>"},
+		"text before":   {`<html><head><title>Why Go</title></head><body><p>Intro.</p><h2>Why Go</h2></body></html>`, "<h3>Why Go</h3>"},
+		"not the first": {`<html><head><title>Why Go</title></head><body><h2>Setup</h2><h2>Why Go</h2></body></html>`, "<h3>Why Go</h3>"},
+		"no words":      {`<html><head><title>***</title></head><body><h1>!!!</h1></body></html>`, "<h2>!!!</h2>"},
+	} {
+		if result := normalizeHeadings(tc.doc, "", sourceInfo{}); !strings.Contains(result, tc.kept) {
+			t.Errorf("%s: heading should be kept, got:\n%s", name, result)
+		}
+	}
+}
+
+func TestPromotePseudoHeadings(t *testing.T) {
+	for name, tc := range map[string]struct{ in, want string }{
+		"bold div": {
+			`<div><b>Early years</b></div>
+<p>She grew up by the sea.</p>`,
+			`<h2>Early years</h2>
+<p>She grew up by the sea.</p>`,
+		},
+		"heading class": {
+			`<div class="article-heading">Later &amp; last</div><p>Then she moved.</p>`,
+			`<h2>Later &amp; last</h2><p>Then she moved.</p>`,
+		},
+		"bold style": {
+			`<p style="font-weight: 700"> What   changed? </p><p>Everything.</p>`,
+			`<h2>What changed?</h2><p>Everything.</p>`,
+		},
+		"kicker before heading": {
+			`<p><strong>Part two</strong></p><p><strong>The journey</strong></p><p>It was long.</p>`,
+			`<p><strong>Part two</strong></p><h2>The journey</h2><p>It was long.</p>`,
+		},
+	} {
+		if got := promotePseudoHeadings(tc.in); got != tc.want {
+			t.Errorf("%s:\n got %q\nwant %q", name, got, tc.want)
+		}
+	}
+
+	for name, in := range map[string]string{
+		"plain short paragraph": `<p>Yes.</p><p>And so on.</p>`,
+		"plain without hint":    `<p>A short line</p><p>Body text.</p>`,
+		"bold sentence":         `<p><b>This is important.</b></p><p>Body text.</p>`,
+		"too long":              `<p><b>` + strings.Repeat("word ", 13) + `</b></p><p>Body text.</p>`,
+		"not before paragraph":  `<p><b>Caption</b></p><figure><img src="a.jpg"></figure>`,
+		"mixed content":         `<p><b>Note</b> the date</p><p>Body text.</p>`,
+		"last in body":          `<p>Body text.</p><p><b>The end</b></p>`,
+	} {
+		if got := promotePseudoHeadings(in); got != in {
+			t.Errorf("%s: should be left alone, got %q", name, got)
+		}
+	}
+}
+
+func TestRawHeadings(t *testing.T) {
+	fragment := `<div><h1>Clean Title</h1><h2>Part one</h2><p>text</p></div>`
+	result := rawHeadings(fragment, "Clean Title - Example Site", sourceInfo{Byline: "Jane"})
+
+	if strings.Count(result, "<h1>") != 1 || !strings.Contains(result, "<h1>Clean Title</h1><h2>Part one</h2>") {
+		t.Errorf("headings should be untouched, got:\n%s", result)
+	}
+	if !strings.Contains(result, "<title>Clean Title</title>") {
+		t.Error("expected cleaned title in document metadata")
+	}
+	if strings.Contains(result, `class="byline"`) {
+		t.Error("raw headings should not insert a byline")
+	}
+}
+
 func TestNormalizeHeadings_TitleOverride(t *testing.T) {
 	html := `<html><head><title>Original Title</title></head><body><p>text</p></body></html>`
 	result := normalizeHeadings(html, "Custom Title", sourceInfo{})
@@ -262,3 +340,34 @@ func TestFormatByline_DateOnly(t *testing.T) {
 		t.Error("expected byline paragraph when date is present")
 	}
 }
+
+func TestFormatSourceFooter(t *testing.T) {
+	date := time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		src  sourceInfo
+		want string
+	}{
+		{"empty", sourceInfo{}, ""},
+		{
+			"all fields",
+			sourceInfo{URL: "https://example.com/post/", SiteName: "Ex & Co", PublishedTime: &date},
+			`<p class="byline source-footer">Originally published at Ex &amp; Co on March 5, 2024 — <a href="https://example.com/post/">example.com/post</a></p>`,
+		},
+		{
+			"url only",
+			sourceInfo{URL: "https://example.com/a"},
+			`<p class="byline source-footer">Originally published at <a href="https://example.com/a">example.com/a</a></p>`,
+		},
+		{
+			"date only",
+			sourceInfo{PublishedTime: &date},
+			`<p class="byline source-footer">Originally published on March 5, 2024</p>`,
+		},
+	}
+	for _, tt := range tests {
+		if got := formatSourceFooter(tt.src); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
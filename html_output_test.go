@@ -83,3 +83,37 @@ func TestHTMLOutput_CompleteDocument(t *testing.T) {
 		t.Error("output missing article H1")
 	}
 }
+
+func TestHTMLOutput_Fragment(t *testing.T) {
+	srv := serveArticles(map[string]string{
+		"/a": makeArticleHTML("Fragment Article", "Body text for the fragment test."),
+	}, nil)
+	defer srv.Close()
+
+	outFile := filepath.Join(t.TempDir(), "fragment.html")
+	cfg := cliConfig{
+		opts:         optimizeOpts{maxWidth: 800, quality: 60},
+		output:       outFile,
+		format:       "html",
+		timeout:      5 * time.Second,
+		userAgent:    "test-agent",
+		htmlFragment: true,
+		args:         []string{srv.URL + "/a"},
+	}
+	if err := run(cfg); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := string(data)
+	for _, wrapper := range []string{"<!DOCTYPE", "<html", "<head", "<style", "<body"} {
+		if strings.Contains(content, wrapper) {
+			t.Errorf("fragment should not contain %s:\n%s", wrapper, content)
+		}
+	}
+	if !strings.HasPrefix(content, "<h1>Fragment Article</h1>") || !strings.Contains(content, "Body text for the fragment test.") {
+		t.Errorf("expected the article markup, got:\n%s", content)
+	}
+}
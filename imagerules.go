@@ -0,0 +1,151 @@
+// Per-host image optimization rules (-image-quality-by-url).
+// A rules file overrides max width, quality, or grayscale for images whose
+// original URL host matches a pattern.
+package main
+
+import (
+	"bufio"
+	"fmt"
+	gohtml "html"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// imageRule overrides optimizeOpts for image hosts matching pattern.
+// Zero maxWidth/quality and nil grayscale leave the global setting.
+type imageRule struct {
+	pattern   string // path.Match glob against the host, e.g. "*.cdn.example.com"
+	maxWidth  int
+	quality   int
+	grayscale *bool
+}
+
+// Attribute that carries an external image's host through embedding, so
+// rules can be applied once the src is a data URI.
+const imageHostAttr = "data-deckle-host"
+
+var imageHostAttrRe = regexp.MustCompile(`\s` + imageHostAttr + `="([^"]*)"`)
+
+// loadImageRules reads a rules file. Each non-blank, non-# line is a host
+// pattern followed by key=value overrides:
+//
+//	*.photos.example.com  quality=35 max-width=600
+//	diagrams.example.org  grayscale=false
+//
+// The first matching rule wins.
+func loadImageRules(filename string) ([]imageRule, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("opening image rules: %w", err)
+	}
+	defer f.Close()
+
+	var rules []imageRule
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule, err := parseImageRule(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", filename, n, err)
+		}
+		rules = append(rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading image rules: %w", err)
+	}
+	return rules, nil
+}
+
+// parseImageRule parses one "pattern key=value..." rules line.
+func parseImageRule(line string) (imageRule, error) {
+	fields := strings.Fields(line)
+	rule := imageRule{pattern: strings.ToLower(fields[0])}
+	if _, err := path.Match(rule.pattern, ""); err != nil {
+		return rule, fmt.Errorf("bad host pattern %q: %w", fields[0], err)
+	}
+	if len(fields) == 1 {
+		return rule, fmt.Errorf("rule for %q has no overrides", fields[0])
+	}
+	for _, kv := range fields[1:] {
+		key, val, ok := strings.Cut(kv, "=")
+		if !ok {
+			return rule, fmt.Errorf("expected key=value, got %q", kv)
+		}
+		switch key {
+		case "max-width", "quality":
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 1 || (key == "quality" && n > 95) {
+				if key == "quality" {
+					return rule, fmt.Errorf("invalid quality %q (must be 1-95)", val)
+				}
+				return rule, fmt.Errorf("invalid %s %q", key, val)
+			}
+			if key == "quality" {
+				rule.quality = n
+			} else {
+				rule.maxWidth = n
+			}
+		case "grayscale":
+			b, err := strconv.ParseBool(val)
+			if err != nil {
+				return rule, fmt.Errorf("invalid grayscale %q", val)
+			}
+			rule.grayscale = &b
+		default:
+			return rule, fmt.Errorf("unknown override %q (must be max-width, quality, or grayscale)", key)
+		}
+	}
+	return rule, nil
+}
+
+// forHost returns opts with the first rule matching host applied.
+func (o optimizeOpts) forHost(host string) optimizeOpts {
+	host = strings.ToLower(host)
+	for _, r := range o.rules {
+		if ok, _ := path.Match(r.pattern, host); !ok {
+			continue
+		}
+		if r.maxWidth > 0 {
+			o.maxWidth = r.maxWidth
+		}
+		if r.quality > 0 {
+			o.quality = r.quality
+			o.grayQuality = 0
+		}
+		if r.grayscale != nil {
+			o.grayscale = *r.grayscale
+		}
+		return o
+	}
+	return o
+}
+
+// imageURLHost returns the lowercased host of an (HTML-escaped) image URL.
+func imageURLHost(imgURL string) string {
+	u, err := url.Parse(gohtml.UnescapeString(imgURL))
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// markImageHosts tags each external <img> with its URL's host, ahead of
+// fetchAndEmbed replacing the src with a data URI.
+func markImageHosts(html []byte) []byte {
+	return extImgRe.ReplaceAllFunc(html, func(match []byte) []byte {
+		m := extImgRe.FindSubmatch(match)
+		host := imageURLHost(string(m[2]))
+		if host == "" {
+			return match
+		}
+		// extImgRe matches start with "<img"; the attribute goes right after.
+		return append([]byte(`<img `+imageHostAttr+`="`+host+`"`), match[len("<img"):]...)
+	})
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image/color"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestLoadImageRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.txt")
+	os.WriteFile(path, []byte(`# CDN with huge photos
+*.Photos.example.com  quality=35 max-width=600
+
+diagrams.example.org grayscale=false
+`), 0644)
+	rules, err := loadImageRules(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 {
+		t.Fatalf("expected 2 rules, got %d", len(rules))
+	}
+	if r := rules[0]; r.pattern != "*.photos.example.com" || r.quality != 35 || r.maxWidth != 600 || r.grayscale != nil {
+		t.Errorf("unexpected first rule %+v", r)
+	}
+	if r := rules[1]; r.grayscale == nil || *r.grayscale {
+		t.Errorf("expected grayscale=false override, got %+v", r)
+	}
+
+	for _, bad := range []string{
+		"example.com",
+		"example.com quality",
+		"example.com quality=0",
+		"example.com quality=96",
+		"example.com max-width=wide",
+		"example.com grayscale=maybe",
+		"example.com sharpen=1",
+		"[ quality=40",
+	} {
+		os.WriteFile(path, []byte("# header\n"+bad+"\n"), 0644)
+		_, err := loadImageRules(path)
+		if err == nil || !strings.Contains(err.Error(), ":2:") {
+			t.Errorf("%q: expected error with line number, got %v", bad, err)
+		}
+	}
+}
+
+func TestOptimizeOptsForHost(t *testing.T) {
+	gray := true
+	opts := optimizeOpts{
+		maxWidth:    800,
+		quality:     60,
+		grayQuality: 50,
+		rules: []imageRule{
+			{pattern: "*.cdn.example.com", quality: 30},
+			{pattern: "img.example.com", maxWidth: 400, grayscale: &gray},
+			{pattern: "*.example.com", quality: 90},
+		},
+	}
+
+	got := opts.forHost("Photos.CDN.example.com")
+	if got.quality != 30 || got.grayQuality != 0 || got.maxWidth != 800 {
+		t.Errorf("cdn rule: got quality %d/%d width %d", got.quality, got.grayQuality, got.maxWidth)
+	}
+	got = opts.forHost("img.example.com")
+	if got.maxWidth != 400 || !got.grayscale || got.quality != 60 {
+		t.Errorf("img rule: got %+v", got)
+	}
+	if got := opts.forHost("other.org"); got.quality != 60 || got.maxWidth != 800 || got.grayscale {
+		t.Errorf("unmatched host should keep global opts, got %+v", got)
+	}
+}
+
+func TestProcessArticleImages_ImageRules(t *testing.T) {
+	jpg := makeJPEG(1000, 500, color.RGBA{200, 100, 50, 255})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(jpg)
+	}))
+	defer srv.Close()
+
+	// Same server reached by two host names.
+	viaIP := srv.URL + "/a.jpg"
+	viaName := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1) + "/b.jpg"
+	html := `<p><img src="` + viaIP + `" alt="ip"><img src="` + viaName + `" alt="name"><img src="http://127.0.0.1:1/missing.jpg"></p>`
+
+	opts := optimizeOpts{
+		maxWidth: 800,
+		quality:  60,
+		rules:    []imageRule{{pattern: "localhost", maxWidth: 200}},
+	}
+	out := string(processArticleImages([]byte(html), opts, 2))
+
+	if strings.Contains(out, imageHostAttr) {
+		t.Errorf("host marks should be stripped, got:\n%.300s", out)
+	}
+	if !strings.Contains(out, `<img src="http://127.0.0.1:1/missing.jpg">`) {
+		t.Error("failed image should be left as it was")
+	}
+
+	widths := map[string]int{}
+	for _, m := range regexp.MustCompile(`src="data:image/jpeg;base64,([^"]+)" alt="(\w+)"`).FindAllStringSubmatch(out, -1) {
+		raw, _ := base64.StdEncoding.DecodeString(m[1])
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(raw))
+		if err != nil {
+			t.Fatal(err)
+		}
+		widths[m[2]] = cfg.Width
+	}
+	if widths["ip"] != 800 || widths["name"] != 200 {
+		t.Errorf("expected ip=800 (global) and name=200 (rule), got %v", widths)
+	}
+}
@@ -0,0 +1,95 @@
+// Image URL normalization (-normalize-image-urls).
+// Sites append cache-busting parameters (?v=123, ?_=1712345678) to image
+// URLs, so the same picture shows up under several URLs and is fetched once
+// for each. This drops parameters known to be volatile, keeping the ones
+// that select a variant of the image (?w=800, ?format=jpg).
+package main
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// volatileImageParams are query parameters that never change which image
+// is served: cache busters and click trackers.
+var volatileImageParams = map[string]bool{
+	"v": true, "ver": true, "version": true, "rev": true, "cb": true,
+	"cachebuster": true, "cache_buster": true, "bust": true, "nocache": true,
+	"fbclid": true, "gclid": true, "mc_cid": true, "mc_eid": true,
+}
+
+// timestampParams are dropped only when their value is a number, as a
+// timestamp is; other values may pick the image.
+var timestampParams = map[string]bool{"_": true, "t": true, "ts": true, "time": true, "timestamp": true}
+
+// signatureParams mark a signed URL: imgix (s), AWS (X-Amz-Signature, and
+// Expires with Signature for CloudFront and S3's older scheme), Google
+// Cloud Storage, and Azure. The signature covers the query, so dropping
+// any parameter would break it.
+var signatureParams = map[string]bool{
+	"s": true, "signature": true, "expires": true, "x-amz-signature": true,
+	"x-goog-signature": true, "sig": true,
+}
+
+// Matches the path signature of a signed Cloudinary URL (/s--abcd1234--/).
+var cloudinarySigRe = regexp.MustCompile(`/s--[\w-]+--/`)
+
+// normalizeImageURLs rewrites the http(s) URLs in content's <img> and
+// <source> tags with normalizeImageURL.
+func normalizeImageURLs(content []byte) []byte {
+	return imgOrSourceTagRe.ReplaceAllFunc(content, func(tag []byte) []byte {
+		return tagURLRe.ReplaceAllFunc(tag, func(u []byte) []byte {
+			raw := html.UnescapeString(string(u))
+			norm := normalizeImageURL(raw)
+			if norm == raw {
+				return u
+			}
+			return []byte(strings.ReplaceAll(norm, "&", "&amp;"))
+		})
+	})
+}
+
+// normalizeImageURL removes volatile query parameters (volatileImageParams,
+// numeric timestampParams, and utm_* tracking tags) from imgURL, keeping
+// the others in their original order and form. Signed URLs are left as
+// they are.
+func normalizeImageURL(imgURL string) string {
+	u, err := url.Parse(imgURL)
+	if err != nil || u.RawQuery == "" || cloudinarySigRe.MatchString(u.Path) {
+		return imgURL
+	}
+	var kept []string
+	params := strings.Split(u.RawQuery, "&")
+	for _, param := range params {
+		key, val, _ := strings.Cut(param, "=")
+		if k, err := url.QueryUnescape(key); err == nil {
+			key = strings.ToLower(k)
+		}
+		switch {
+		case signatureParams[key]:
+			return imgURL
+		case volatileImageParams[key] || strings.HasPrefix(key, "utm_") || timestampParams[key] && isDigits(val):
+			continue
+		}
+		kept = append(kept, param)
+	}
+	if len(kept) == len(params) {
+		return imgURL
+	}
+	u.RawQuery = strings.Join(kept, "&")
+	u.ForceQuery = false
+	return u.String()
+}
+
+// isDigits reports whether s is a non-empty run of ASCII digits.
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestNormalizeImageURL(t *testing.T) {
+	for _, tc := range []struct{ in, want string }{
+		{"https://cdn.example.com/a.jpg?v=123", "https://cdn.example.com/a.jpg"},
+		{"https://cdn.example.com/a.jpg?w=800&v=abc&utm_source=x", "https://cdn.example.com/a.jpg?w=800"},
+		{"https://cdn.example.com/a.jpg?_=1712345678&format=jpg&CB=9", "https://cdn.example.com/a.jpg?format=jpg"},
+		// Non-numeric t= may choose the image; unknown parameters stay.
+		{"https://cdn.example.com/thumb?t=large&id=7", "https://cdn.example.com/thumb?t=large&id=7"},
+		{"https://cdn.example.com/a.jpg?w=800&h=600", "https://cdn.example.com/a.jpg?w=800&h=600"},
+		{"https://cdn.example.com/a%20b.jpg?ts=99#x", "https://cdn.example.com/a%20b.jpg#x"},
+		{"https://cdn.example.com/a.jpg", "https://cdn.example.com/a.jpg"},
+		// Signed URLs stop working if any parameter goes.
+		{"https://acme.imgix.net/a.jpg?w=800&v=2&s=0123456789abcdef", "https://acme.imgix.net/a.jpg?w=800&v=2&s=0123456789abcdef"},
+		{"https://res.cloudinary.com/demo/image/upload/s--Ie0QCKGX--/w_400/a.jpg?_=1712345678", "https://res.cloudinary.com/demo/image/upload/s--Ie0QCKGX--/w_400/a.jpg?_=1712345678"},
+		{"https://b.s3.amazonaws.com/a.jpg?X-Amz-Date=20240101T000000Z&v=1&X-Amz-Signature=abc", "https://b.s3.amazonaws.com/a.jpg?X-Amz-Date=20240101T000000Z&v=1&X-Amz-Signature=abc"},
+		{"https://d1.cloudfront.net/a.jpg?ts=99&Expires=1712345678&Signature=abc&Key-Pair-Id=K1", "https://d1.cloudfront.net/a.jpg?ts=99&Expires=1712345678&Signature=abc&Key-Pair-Id=K1"},
+	} {
+		if got := normalizeImageURL(tc.in); got != tc.want {
+			t.Errorf("normalizeImageURL(%s) = %s, want %s", tc.in, got, tc.want)
+		}
+	}
+
+	in := `<img src="https://e.com/a.jpg?w=800&amp;v=2" alt="see https://e.com/b.jpg"><source srcset="https://e.com/a.jpg?v=1 1x, https://e.com/a.jpg?v=1&amp;w=1600 2x"><a href="https://e.com/?v=1">`
+	want := `<img src="https://e.com/a.jpg?w=800" alt="see https://e.com/b.jpg"><source srcset="https://e.com/a.jpg 1x, https://e.com/a.jpg?w=1600 2x"><a href="https://e.com/?v=1">`
+	if got := string(normalizeImageURLs([]byte(in))); got != want {
+		t.Errorf("normalizeImageURLs\n got %s\nwant %s", got, want)
+	}
+}
+
+func TestProcessArticleImages_NormalizeURLs(t *testing.T) {
+	imgData := makePNG(10, 10, color.NRGBA{255, 0, 0, 255})
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(imgData)
+	}))
+	defer srv.Close()
+	saved := fetchImageClient
+	fetchImageClient = srv.Client()
+	defer func() { fetchImageClient = saved }()
+
+	html := []byte(`<img src="` + srv.URL + `/a.png?v=1" alt="one"><p>Text</p><img src="` + srv.URL + `/a.png?v=2" alt="two">`)
+	for _, tc := range []struct {
+		clean bool
+		want  int32
+	}{{false, 2}, {true, 1}} {
+		hits.Store(0)
+		out := processArticleImages(html, optimizeOpts{maxWidth: 800, quality: 60, cleanURLs: tc.clean}, 2)
+		if n := strings.Count(string(out), "data:image/"); n != 2 {
+			t.Errorf("clean %v: expected both images embedded, got %d", tc.clean, n)
+		}
+		if got := hits.Load(); got != tc.want {
+			t.Errorf("clean %v: %d fetches, want %d", tc.clean, got, tc.want)
+		}
+	}
+}
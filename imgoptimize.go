@@ -5,23 +5,31 @@ package main
 import (
 	"bytes"
 	"encoding/base64"
+	"encoding/xml"
+	"errors"
 	"fmt"
 	"html"
 	"image"
 	"image/color"
 	"image/draw"
 	"image/gif"
-	"image/jpeg"
-	_ "image/png"
+	"image/png"
+	"io"
 	"math"
 	"net/http"
+	"net/url"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/srwiley/oksvg"
+	"github.com/srwiley/rasterx"
 	xdraw "golang.org/x/image/draw"
 	_ "golang.org/x/image/webp"
+	xhtml "golang.org/x/net/html"
 )
 
 func humanSize(n int64) string {
@@ -43,6 +51,22 @@ func resize(src image.Image, dstW, dstH int) *image.NRGBA {
 	return dst
 }
 
+// cropBanner center-crops img to maxRatio (width:height) when it is wider
+// than that. Narrower images, and maxRatio <= 0, return img unchanged.
+func cropBanner(img *image.NRGBA, maxRatio float64) *image.NRGBA {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if maxRatio <= 0 || h == 0 || float64(w)/float64(h) <= maxRatio {
+		return img
+	}
+	newW := int(math.Round(float64(h) * maxRatio))
+	if newW < 1 {
+		newW = 1
+	}
+	x0 := b.Min.X + (w-newW)/2
+	return img.SubImage(image.Rect(x0, b.Min.Y, x0+newW, b.Max.Y)).(*image.NRGBA)
+}
+
 func toGrayscale(src image.Image) *image.Gray {
 	b := src.Bounds()
 	gray := image.NewGray(b)
@@ -54,16 +78,162 @@ func toGrayscale(src image.Image) *image.Gray {
 	return gray
 }
 
-// flattenAlpha composites src onto a white background.
-func flattenAlpha(src image.Image) *image.NRGBA {
+// unsharpMask sharpens src by adding back strength/50 times its difference
+// from a 3x3 Gaussian blur, so strength 0-100 gives an amount of 0-2.
+// Edge pixels blur against their clamped neighbors; alpha is unchanged.
+func unsharpMask(src *image.NRGBA, strength int) *image.NRGBA {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	amount := float64(strength) / 50
+	kernel := [3]int{1, 2, 1}
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var blur [3]int
+			for ky := -1; ky <= 1; ky++ {
+				row := src.Pix[(min(max(y+ky, 0), h-1))*src.Stride:]
+				for kx := -1; kx <= 1; kx++ {
+					p := row[min(max(x+kx, 0), w-1)*4:]
+					k := kernel[ky+1] * kernel[kx+1]
+					blur[0] += k * int(p[0])
+					blur[1] += k * int(p[1])
+					blur[2] += k * int(p[2])
+				}
+			}
+			i := y*src.Stride + x*4
+			o := y*dst.Stride + x*4
+			for c := range 3 {
+				v := float64(src.Pix[i+c])
+				v += amount * (v - float64(blur[c])/16)
+				dst.Pix[o+c] = uint8(min(max(math.Round(v), 0), 255))
+			}
+			dst.Pix[o+3] = src.Pix[i+3]
+		}
+	}
+	return dst
+}
+
+// flattenAlpha composites src onto a solid background (white if bg is nil).
+func flattenAlpha(src image.Image, bg color.Color) *image.NRGBA {
+	if bg == nil {
+		bg = color.White
+	}
 	b := src.Bounds()
 	dst := image.NewNRGBA(b)
-	white := image.NewUniform(color.White)
-	draw.Draw(dst, b, white, image.Point{}, draw.Src)
+	draw.Draw(dst, b, image.NewUniform(bg), image.Point{}, draw.Src)
 	draw.Draw(dst, b, src, b.Min, draw.Over)
 	return dst
 }
 
+// maxPNGColors is the most distinct colors an image may have for -keep-png
+// to treat it as flat-color artwork rather than a photo.
+const maxPNGColors = 256
+
+// flatPalette returns the distinct colors of img, or nil if it has more
+// than max colors.
+func flatPalette(img image.Image, max int) color.Palette {
+	seen := map[color.NRGBA]bool{}
+	var palette color.Palette
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if seen[c] {
+				continue
+			}
+			if len(palette) == max {
+				return nil
+			}
+			seen[c] = true
+			palette = append(palette, c)
+		}
+	}
+	return palette
+}
+
+// Screenshots and diagrams often have too many colors for a palette
+// (anti-aliased text, shaded UI), but are still mostly flat fills broken by
+// sharp edges. Photos, with their noise and gradients, are neither.
+const (
+	minFlatShare = 0.8 // pixels that repeat their left neighbor exactly
+	minEdgeShare = 0.5 // changed pixels that are a sharp edge
+	edgeStep     = 64  // smallest channel difference that counts as an edge
+)
+
+// isHighEdge reports whether img is flat-color artwork with too many
+// colors for flatPalette: at least minFlatShare of its pixels equal their
+// left neighbor, and at least minEdgeShare of the rest differ from it by
+// edgeStep or more in some channel.
+func isHighEdge(img image.Image) bool {
+	var flat, changed, edges int
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		prev := color.NRGBAModel.Convert(img.At(b.Min.X, y)).(color.NRGBA)
+		for x := b.Min.X + 1; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			switch d := max(absDiff(c.R, prev.R), absDiff(c.G, prev.G), absDiff(c.B, prev.B)); {
+			case d == 0:
+				flat++
+			case d >= edgeStep:
+				edges++
+				changed++
+			default:
+				changed++
+			}
+			prev = c
+		}
+	}
+	total := flat + changed
+	return total > 0 && float64(flat) >= minFlatShare*float64(total) &&
+		float64(edges) >= minEdgeShare*float64(changed)
+}
+
+// absDiff returns |a-b|.
+func absDiff(a, b uint8) uint8 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+// encodePNG encodes img as a palette PNG, or truecolor if palette is nil
+// (8-bit gray with grayscale). Resampled edges are mapped back onto the
+// original palette so the output stays sharp and small.
+func encodePNG(img image.Image, palette color.Palette, opts optimizeOpts) (string, int) {
+	var encImg image.Image = img
+	if opts.grayscale {
+		encImg = toGrayscale(img)
+	} else if palette != nil {
+		p := image.NewPaletted(img.Bounds(), palette)
+		draw.Draw(p, p.Rect, img, img.Bounds().Min, draw.Src)
+		encImg = p
+	}
+
+	var buf bytes.Buffer
+	enc := png.Encoder{CompressionLevel: png.BestCompression}
+	if err := enc.Encode(&buf, encImg); err != nil {
+		fmt.Fprintf(logOut, "Warning: PNG encode failed: %v\n", err)
+		return "", 0
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), buf.Len()
+}
+
+// parseHexColor parses "#rgb" or "#rrggbb" (the "#" is optional).
+func parseHexColor(s string) (color.Color, error) {
+	h := strings.TrimPrefix(strings.TrimSpace(s), "#")
+	if len(h) == 3 {
+		h = string([]byte{h[0], h[0], h[1], h[1], h[2], h[2]})
+	}
+	if len(h) != 6 {
+		return nil, fmt.Errorf("invalid hex color %q", s)
+	}
+	v, err := strconv.ParseUint(h, 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hex color %q", s)
+	}
+	return color.NRGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 255}, nil
+}
+
 func isAnimatedGIF(data []byte) bool {
 	g, err := gif.DecodeAll(bytes.NewReader(data))
 	if err != nil {
@@ -76,35 +246,278 @@ type optimizeOpts struct {
 	maxWidth       int
 	quality        int
 	grayscale      bool
-	skipImageFetch bool // skip downloading external images (e.g. markdown mode)
+	grayQuality    int           // JPEG quality used with grayscale (0 = quality)
+	targetSSIM     float64       // pick each JPEG's quality to reach this SSIM (0 = use quality)
+	sharpen        int           // unsharp-mask strength 0-100 for downscaled images (0 = off)
+	progressive    bool          // write progressive instead of baseline JPEGs
+	stripICC       bool          // drop ICC profiles from images embedded without re-encoding
+	skipSmall      bool          // keep small images that already fit as-is (see keepSmallOriginal)
+	bgColor        color.Color   // background for flattening transparency (nil = white)
+	keepPNG        bool          // encode flat-color images as PNG instead of JPEG
+	keepWebP       bool          // embed WebP images that already fit as-is instead of as JPEG
+	cropRatio      float64       // center-crop images wider than this width:height (0 = off)
+	maxPixels      int64         // skip decoding images declaring more pixels than this (0 = no cap)
+	skipImageFetch bool          // skip downloading external images (e.g. markdown mode)
+	requireAlt     bool          // drop images without alt text, unless a figcaption describes them
+	inlineEmoji    bool          // replace emoji images with their alt text
+	cleanURLs      bool          // drop cache-busting query parameters from image URLs
+	dropDupes      bool          // drop later copies of an image already in the article
+	stageTimes     *stageTimer   // this article's -timings (nil = untimed)
+	optimizeSVG    bool          // minify SVG images instead of passing them through
+	rasterizeSVG   bool          // render SVG images to JPEG for readers without SVG support
+	budget         *embedBudget  // shared cap on embedded image bytes (nil = unlimited)
+	rules          []imageRule   // per-host overrides, applied by forHost
+	workers        chan struct{} // limits concurrent decodes/encodes (nil = unlimited)
+}
+
+// embedBudget caps the total bytes of images embedded across every article
+// of one output. It is shared between concurrently processed articles.
+type embedBudget struct {
+	limit   int64
+	used    atomic.Int64
+	skipped atomic.Int64
+}
+
+// take reserves n bytes, reporting false (and counting a skip) if that
+// would exceed the limit.
+func (b *embedBudget) take(n int64) bool {
+	for {
+		used := b.used.Load()
+		if used+n > b.limit {
+			b.skipped.Add(1)
+			return false
+		}
+		if b.used.CompareAndSwap(used, used+n) {
+			return true
+		}
+	}
+}
+
+var (
+	svgCommentRe  = regexp.MustCompile(`(?s)<!--.*?-->`)
+	svgMetadataRe = regexp.MustCompile(`(?s)<metadata\b.*?</metadata>`)
+	// Editor-private elements, e.g. <sodipodi:namedview .../>
+	svgEditorElemRe = regexp.MustCompile(`(?s)<(?:inkscape|sodipodi|sketch):\w+\b[^>]*?(?:/>|>.*?</(?:inkscape|sodipodi|sketch):\w+>)`)
+	// Editor-private attributes
+	svgEditorAttrRe = regexp.MustCompile(`\s+(?:inkscape|sodipodi|sketch|rdf|cc|dc):[\w-]+\s*=\s*"[^"]*"`)
+	// Editor-private namespace declarations; group 1 is the prefix
+	svgEditorNSRe = regexp.MustCompile(`\s+xmlns:(inkscape|sodipodi|sketch|rdf|cc|dc)\s*=\s*"[^"]*"`)
+	// Geometry attributes, whose numbers are trimmed to 3 decimals
+	svgGeomAttrRe  = regexp.MustCompile(`\s(?:d|points|x[12]?|y[12]?|c[xy]|r[xy]?|width|height|transform|viewBox)\s*=\s*"[^"]*"`)
+	svgPrecisionRe = regexp.MustCompile(`(\d\.\d{3})\d+`)
+	// Whitespace between tags, or a <text> element (group 2, with any
+	// whitespace around it), whose whitespace between runs is rendered and
+	// so kept
+	svgInterTagWSRe = regexp.MustCompile(`(?s)(>\s*)?(<text\b.*?</text>)\s*|>\s+<`)
+	// Matches the XML declaration, doctype, and comments that may precede <svg>
+	svgPrologRe = regexp.MustCompile(`(?s)<\?xml.*?\?>|<!DOCTYPE[^>]*>|<!--.*?-->`)
+)
+
+// rasterizeSVG renders an SVG at its intrinsic size, scaled down to
+// maxWidth. Malformed or dimensionless SVGs return an error.
+func rasterizeSVG(data []byte, maxWidth int) (img image.Image, err error) {
+	// oksvg panics on some malformed path data.
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("rendering SVG: %v", r)
+		}
+	}()
+
+	icon, err := oksvg.ReadIconStream(bytes.NewReader(data), oksvg.WarnErrorMode)
+	if err != nil {
+		return nil, err
+	}
+	vb := icon.ViewBox
+	if vb.W <= 0 || vb.H <= 0 {
+		return nil, fmt.Errorf("SVG has no usable viewBox or size")
+	}
+	w, h := vb.W, vb.H
+	if maxWidth > 0 && w > float64(maxWidth) {
+		h = h * float64(maxWidth) / w
+		w = float64(maxWidth)
+	}
+	iw, ih := int(math.Ceil(w)), int(math.Ceil(h))
+	if iw > maxSVGRasterPixels/ih {
+		return nil, fmt.Errorf("SVG too large to rasterize (%dx%d)", iw, ih)
+	}
+
+	icon.SetTarget(0, 0, w, h)
+	rgba := image.NewRGBA(image.Rect(0, 0, iw, ih))
+	icon.Draw(rasterx.NewDasher(iw, ih, rasterx.NewScannerGV(iw, ih, rgba, rgba.Bounds())), 1)
+	return rgba, nil
+}
+
+// maxSVGRasterPixels bounds the canvas for rasterized SVGs, which can
+// declare huge sizes with a tiny file.
+const maxSVGRasterPixels = 4096 * 4096
+
+// minifySVG strips comments, metadata, editor cruft, excess numeric
+// precision in geometry attributes, and whitespace between tags outside
+// <text>. Editor namespace declarations go only once nothing uses their
+// prefix. Returns nil if the input (or the result) is not well-formed XML,
+// so callers pass it through.
+func minifySVG(data []byte) []byte {
+	if !isWellFormedXML(data) {
+		return nil
+	}
+	out := svgCommentRe.ReplaceAll(data, nil)
+	out = svgMetadataRe.ReplaceAll(out, nil)
+	out = svgEditorElemRe.ReplaceAll(out, nil)
+	out = svgEditorAttrRe.ReplaceAll(out, nil)
+	out = svgEditorNSRe.ReplaceAllFunc(out, func(decl []byte) []byte {
+		prefix := svgEditorNSRe.FindSubmatch(decl)[1]
+		if regexp.MustCompile(`(?:<|</|\s)` + string(prefix) + `:`).Match(out) {
+			return decl
+		}
+		return nil
+	})
+	out = svgGeomAttrRe.ReplaceAllFunc(out, func(attr []byte) []byte {
+		return svgPrecisionRe.ReplaceAll(attr, []byte("$1"))
+	})
+	out = svgInterTagWSRe.ReplaceAllFunc(out, func(m []byte) []byte {
+		sub := svgInterTagWSRe.FindSubmatch(m)
+		switch {
+		case sub[2] == nil:
+			return []byte("><")
+		case sub[1] != nil:
+			return append([]byte(">"), sub[2]...)
+		}
+		return m
+	})
+	out = bytes.TrimSpace(out)
+	if !isWellFormedXML(out) {
+		return nil
+	}
+	return out
+}
+
+// isWellFormedXML reports whether data parses as XML without errors.
+func isWellFormedXML(data []byte) bool {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.Strict = true
+	for {
+		_, err := dec.Token()
+		if err == io.EOF {
+			return true
+		}
+		if err != nil {
+			return false
+		}
+	}
+}
+
+// smallImageMaxBytes is the largest image -skip-small-reencode keeps as-is.
+const smallImageMaxBytes = 40 * 1024
+
+// keepSmallOriginal reports whether an image is better left alone than
+// re-encoded (-skip-small-reencode): a JPEG, PNG, or GIF of at most
+// smallImageMaxBytes that already fits maxWidth and needs no grayscale
+// conversion or banner crop, where a JPEG re-encode could only grow it or
+// cost quality.
+func keepSmallOriginal(data []byte, mime string, opts optimizeOpts) bool {
+	if !opts.skipSmall || opts.grayscale || len(data) > smallImageMaxBytes {
+		return false
+	}
+	switch mime {
+	case "image/jpeg", "image/png", "image/gif":
+	default:
+		return false
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || "image/"+format != mime || cfg.Width > opts.maxWidth {
+		return false
+	}
+	return opts.cropRatio == 0 || cfg.Height == 0 || float64(cfg.Width)/float64(cfg.Height) <= opts.cropRatio
+}
+
+// keepWebPOriginal reports whether a WebP image should be embedded as it
+// is (-keep-webp): it already fits maxWidth and needs no grayscale
+// conversion or banner crop. There is no WebP encoder to re-encode it, and
+// transcoding to JPEG would lose quality a second time.
+func keepWebPOriginal(data []byte, mime string, opts optimizeOpts) bool {
+	if !opts.keepWebP || opts.grayscale || !strings.Contains(mime, "webp") {
+		return false
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || format != "webp" || cfg.Width > opts.maxWidth {
+		return false
+	}
+	return opts.cropRatio == 0 || cfg.Height == 0 || float64(cfg.Width)/float64(cfg.Height) <= opts.cropRatio
 }
 
 // optimizeImage returns the new data URI string and raw JPEG byte count,
 // or empty string to signal "skip / pass through".
 func optimizeImage(data []byte, mime string, opts optimizeOpts) (string, int) {
-	// Pass through SVG
+	if opts.workers != nil {
+		opts.workers <- struct{}{}
+		defer func() { <-opts.workers }()
+	}
+
+	// Pass through SVG, optionally rasterized or minified
 	if strings.Contains(mime, "svg") {
-		return "", 0
+		if opts.rasterizeSVG {
+			img, err := rasterizeSVG(data, opts.maxWidth)
+			if err == nil {
+				return encodeImage(img, opts)
+			}
+			fmt.Fprintf(logOut, "Warning: could not rasterize SVG, passing through: %v\n", err)
+		}
+		if !opts.optimizeSVG {
+			return "", 0
+		}
+		min := minifySVG(data)
+		if min == nil || len(min) >= len(data) {
+			return "", 0
+		}
+		return "data:image/svg+xml;base64," + base64.StdEncoding.EncodeToString(min), len(min)
 	}
 	// Pass through AVIF (no Go decoder; already well-compressed)
 	if strings.Contains(mime, "avif") {
 		return "", 0
 	}
+	// Pass through WebP that fits, with -keep-webp
+	if keepWebPOriginal(data, mime, opts) {
+		return "", 0
+	}
 	// Pass through animated GIF
 	if strings.Contains(mime, "gif") && isAnimatedGIF(data) {
 		return "", 0
 	}
 
+	// Check declared dimensions before decoding allocates the full bitmap
+	if opts.maxPixels > 0 {
+		if cfg, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil &&
+			int64(cfg.Width)*int64(cfg.Height) > opts.maxPixels {
+			fmt.Fprintf(logOut, "Warning: image is %dx%d, over the %d pixel limit; keeping original\n", cfg.Width, cfg.Height, opts.maxPixels)
+			return "", 0
+		}
+	}
+
 	img, _, err := image.Decode(bytes.NewReader(data))
 	if err != nil {
 		fmt.Fprintf(logOut, "Warning: could not decode image (%s): %v\n", mime, err)
 		return "", 0
 	}
+	return encodeImage(img, opts)
+}
 
-	// Flatten alpha onto white for JPEG
-	img = flattenAlpha(img)
+// encodeImage flattens, downscales, and JPEG-encodes a decoded image,
+// returning its data URI and encoded size. With keepPNG, flat-color images
+// (logos, diagrams, screenshots) are encoded as PNG instead: palette PNG if
+// they have few colors, truecolor if they have many but are high-edge.
+func encodeImage(img image.Image, opts optimizeOpts) (string, int) {
+	// Flatten alpha onto white for JPEG, then trim extreme banners
+	img = cropBanner(flattenAlpha(img, opts.bgColor), opts.cropRatio)
+
+	var palette color.Palette
+	keepPNG := false
+	if opts.keepPNG {
+		palette = flatPalette(img, maxPNGColors)
+		keepPNG = palette != nil || isHighEdge(img)
+	}
 
 	// Downscale by width only (never upscale)
+	var scaled *image.NRGBA
 	b := img.Bounds()
 	w, h := b.Dx(), b.Dy()
 	if w > opts.maxWidth {
@@ -114,16 +527,44 @@ func optimizeImage(data []byte, mime string, opts optimizeOpts) (string, int) {
 		if newH < 1 {
 			newH = 1
 		}
-		img = resize(img, newW, newH)
+		scaled = resize(img, newW, newH)
+		img = scaled
+	}
+
+	if keepPNG {
+		return encodePNG(img, palette, opts)
+	}
+
+	// Restore detail the downscale softened, before any grayscale conversion
+	if scaled != nil && opts.sharpen > 0 {
+		img = unsharpMask(scaled, opts.sharpen)
 	}
 
 	var encImg image.Image = img
+	quality := opts.quality
 	if opts.grayscale {
 		encImg = toGrayscale(img)
+		if opts.grayQuality > 0 {
+			quality = opts.grayQuality
+		}
+	}
+
+	if opts.targetSSIM > 0 {
+		buf, q, err := encodeJPEGForSSIM(encImg, opts.targetSSIM)
+		if err == nil && opts.progressive {
+			// The search compares baseline encodes; re-encode at the quality it chose.
+			buf.Reset()
+			err = encodeProgressiveJPEG(buf, encImg, q)
+		}
+		if err != nil {
+			fmt.Fprintf(logOut, "Warning: JPEG encode failed: %v\n", err)
+			return "", 0
+		}
+		return "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), buf.Len()
 	}
 
 	var buf bytes.Buffer
-	if err := jpeg.Encode(&buf, encImg, &jpeg.Options{Quality: opts.quality}); err != nil {
+	if err := encodeJPEG(&buf, encImg, quality, opts.progressive); err != nil {
 		fmt.Fprintf(logOut, "Warning: JPEG encode failed: %v\n", err)
 		return "", 0
 	}
@@ -138,6 +579,8 @@ var (
 	dataURIRe = regexp.MustCompile(`(<img\b[^>]*?\bsrc\s*=\s*")data:([^;]+);base64,([^"]*)(")`)
 	// Matches <picture>...</picture> (non-greedy across newlines)
 	pictureRe = regexp.MustCompile(`(?s)<picture\b[^>]*>.*?</picture>`)
+	// Matches a whole <img> tag whose src is an embedded data URI; group 1 is the payload
+	embeddedImgRe = regexp.MustCompile(`<img\b[^>]*?\bsrc\s*=\s*"data:[^;"]+;base64,([^"]*)"[^>]*>`)
 	// Extracts data URIs from srcset or src attributes inside <source>/<img> tags
 	dataURIExtractRe = regexp.MustCompile(`data:([^;]+);base64,([^\s",]+)`)
 	// Extracts external URLs from srcset attributes (e.g. "https://...jpg 640w, https://...jpg 1400w")
@@ -151,6 +594,18 @@ var (
 	lazyImgRe = regexp.MustCompile(`<img\b[^>]*\bdata-src\s*=[^>]*>`)
 	// Matches src="data:image/svg+xml;base64,..." (placeholder) within an img tag
 	svgSrcAttrRe = regexp.MustCompile(`\bsrc\s*=\s*"data:image/svg\+xml;base64,[^"]*"`)
+	// Matches a <noscript> holding exactly one <img>, with the placeholder
+	// <img> directly before it (if any) captured in group 1
+	noscriptImgRe = regexp.MustCompile(`(?is)(<img\b[^>]*>\s*)?<noscript\b[^>]*>\s*(<img\b[^>]*>)\s*</noscript>`)
+	// Attributes that mark the <img> before a <noscript> as a lazy-load
+	// placeholder (see isLazyPlaceholder); group 1 is the value
+	imgSrcValRe     = regexp.MustCompile(`(?i)\ssrc\s*=\s*"([^"]*)"`)
+	imgDataSrcValRe = regexp.MustCompile(`(?i)\sdata-src\s*=\s*"([^"]*)"`)
+	lazyLoadingRe   = regexp.MustCompile(`(?i)\sloading\s*=\s*"?lazy`)
+	blankGIFRe      = regexp.MustCompile(`(?i)(?:blank|spacer|transparent|empty|pixel|placeholder)[\w-]*\.gif\b`)
+	// Matches a 1x1 tracking pixel's width/height attributes
+	pixelWidthRe  = regexp.MustCompile(`(?i)\bwidth\s*=\s*["']?1["'\s/>]`)
+	pixelHeightRe = regexp.MustCompile(`(?i)\bheight\s*=\s*["']?1["'\s/>]`)
 )
 
 // Matches <img ... src="https://..."> (external URL images)
@@ -172,8 +627,11 @@ func getImageClient() *http.Client {
 
 // promoteLazySrc rewrites data-src="..." to src="..." on img tags
 // that use lazy loading, so downstream tools see the real image URLs.
-// Also removes SVG placeholder src attrs to avoid duplicates.
+// Also removes SVG placeholder src attrs to avoid duplicates, and unwraps
+// <noscript> image fallbacks.
 func promoteLazySrc(html []byte) []byte {
+	html = unwrapNoscriptImages(html)
+
 	// Remove SVG placeholder src attrs on img tags that also have data-src.
 	// WordPress et al. use src="data:image/svg+xml;base64,..." as a 1x1 pixel
 	// placeholder alongside data-src="real-url". Promoting data-src would create
@@ -186,12 +644,58 @@ func promoteLazySrc(html []byte) []byte {
 	return html
 }
 
+// unwrapNoscriptImages replaces <noscript> blocks containing a single <img>
+// with that image, dropping the <img> right before it when it is the
+// lazy-load placeholder for it. Blocks with any other content and 1x1
+// tracking pixels are left alone.
+func unwrapNoscriptImages(html []byte) []byte {
+	return noscriptImgRe.ReplaceAllFunc(html, func(match []byte) []byte {
+		m := noscriptImgRe.FindSubmatch(match)
+		prev, img := m[1], m[2]
+		if pixelWidthRe.Match(img) && pixelHeightRe.Match(img) {
+			return match
+		}
+		if prev != nil && !isLazyPlaceholder(prev, img) {
+			return append(prev, img...)
+		}
+		return img
+	})
+}
+
+// isLazyPlaceholder reports whether the <img> tag prev stands in for img
+// until scripts load it: its src is a data: URI or a blank GIF, it has only
+// data-src or loading="lazy" and no real src, or it shows the same image.
+func isLazyPlaceholder(prev, img []byte) bool {
+	var src, real string
+	if m := imgSrcValRe.FindSubmatch(prev); m != nil {
+		src = strings.TrimSpace(string(m[1]))
+	}
+	if m := imgSrcValRe.FindSubmatch(img); m != nil {
+		real = strings.TrimSpace(string(m[1]))
+	}
+	switch {
+	case strings.HasPrefix(strings.ToLower(src), "data:"), blankGIFRe.MatchString(src):
+		return true
+	case src == "":
+		return imgDataSrcValRe.Match(prev) || lazyLoadingRe.Match(prev)
+	}
+	if real == "" {
+		return false
+	}
+	if src == real {
+		return true
+	}
+	m := imgDataSrcValRe.FindSubmatch(prev)
+	return m != nil && strings.TrimSpace(string(m[1])) == real
+}
+
 // fetchImageData downloads an image URL and returns its raw bytes and MIME type.
 // It unescapes HTML entities in the URL, reads up to maxResponseBytes, and
 // detects the MIME type from the Content-Type header (falling back to sniffing).
 func fetchImageData(imgURL string) ([]byte, string, error) {
 	imgURL = html.UnescapeString(imgURL)
 
+	fetchLimiter.wait()
 	resp, err := getImageClient().Get(imgURL)
 	if err != nil {
 		return nil, "", err
@@ -205,6 +709,7 @@ func fetchImageData(imgURL string) ([]byte, string, error) {
 	if err != nil {
 		return nil, "", err
 	}
+	warcOut.recordExchange(resp.Request, resp, data)
 
 	mime := resp.Header.Get("Content-Type")
 	if i := strings.Index(mime, ";"); i >= 0 {
@@ -217,14 +722,34 @@ func fetchImageData(imgURL string) ([]byte, string, error) {
 			mime = mime[:i]
 		}
 	}
+	// SVGs served as text or generic XML sniff as such; left alone they
+	// would be embedded as data:text/plain images no reader displays.
+	if !strings.HasPrefix(mime, "image/") && looksLikeSVG(data) {
+		mime = "image/svg+xml"
+	}
 
 	return data, mime, nil
 }
 
+// looksLikeSVG reports whether data starts (after any XML declaration,
+// doctype, or comments) with an <svg> root element.
+func looksLikeSVG(data []byte) bool {
+	head := data[:min(len(data), 1024)]
+	i := bytes.Index(head, []byte("<svg"))
+	if i < 0 {
+		return false
+	}
+	rest := svgPrologRe.ReplaceAll(head[:i], nil)
+	return len(bytes.TrimSpace(rest)) == 0
+}
+
 // fetchOneImage downloads a single external image URL and returns its data URI
 // components, or empty strings on failure.
 func fetchOneImage(imgURL string) (mime, encoded string) {
-	data, m, err := fetchImageData(imgURL)
+	data, m, err := fetchImage(imgURL)
+	if errors.Is(err, errHostBroken) {
+		return "", ""
+	}
 	if err != nil {
 		fmt.Fprintf(logOut, "Warning: could not fetch %s: %v\n", imgURL, err)
 		return "", ""
@@ -232,6 +757,203 @@ func fetchOneImage(imgURL string) (mime, encoded string) {
 	return m, base64.StdEncoding.EncodeToString(data)
 }
 
+var (
+	// Matches a whole <img> or <source> tag
+	imgOrSourceTagRe = regexp.MustCompile(`(?i)<(?:img|source)\b[^>]*>`)
+	// Matches an http(s) URL in a tag's src or srcset
+	tagURLRe = regexp.MustCompile(`https?://[^\s"',]+`)
+)
+
+// heroKey reduces an image URL to host and path, so the same image served
+// at another size (via query parameters) or scheme still compares equal.
+func heroKey(imgURL string) string {
+	u, err := url.Parse(html.UnescapeString(imgURL))
+	if err != nil {
+		return imgURL
+	}
+	return strings.ToLower(u.Host) + u.EscapedPath()
+}
+
+// prependHeroImage puts the page's lead image (og:image) at the top of
+// content for -include-hero, unless content already shows it or it can't
+// be fetched. The image is embedded as a data URI, so it is optimized like
+// any other; with keepURL (markdown) the external URL is kept as-is, without
+// fetching it. alt (the article title) describes the image, so -require-alt
+// keeps it.
+func prependHeroImage(content, hero, alt string, keepURL bool) string {
+	key := heroKey(hero)
+	for _, tag := range imgOrSourceTagRe.FindAllString(content, -1) {
+		for _, u := range tagURLRe.FindAllString(tag, -1) {
+			if heroKey(u) == key {
+				return content
+			}
+		}
+	}
+	src := html.EscapeString(hero)
+	if !keepURL {
+		data, mime, err := fetchImage(hero)
+		if err != nil {
+			vprintf("Hero image %s unavailable: %v\n", hero, err)
+			return content
+		}
+		src = "data:" + mime + ";base64," + base64.StdEncoding.EncodeToString(data)
+	}
+	return `<figure><img src="` + src + `" alt="` + html.EscapeString(alt) + `"></figure>` + content
+}
+
+// minLeadWords is how many words make a paragraph "substantial" for
+// stripLeadingImages: more than a caption, credit, or ad label.
+const minLeadWords = 20
+
+// stripLeadingImages removes the <img> and <picture> elements (with any
+// <figure> around them) that come before content's first paragraph of more
+// than minLeadWords words, for -strip-leading-images: the site logos and ad
+// images some extractions start with. Content without such a paragraph is
+// returned unchanged, so image-only pages keep their images.
+func stripLeadingImages(content string) string {
+	var out, figure, para bytes.Buffer
+	inPara, figureHasImage := false, false
+	picture, figureDepth := 0, 0
+	z := xhtml.NewTokenizer(strings.NewReader(content))
+	for {
+		tt := z.Next()
+		if tt == xhtml.ErrorToken {
+			return content // no substantial paragraph
+		}
+		raw := z.Raw()
+		name, _ := z.TagName()
+		start := tt == xhtml.StartTagToken || tt == xhtml.SelfClosingTagToken
+		end := tt == xhtml.EndTagToken
+		w := &out
+		if figureDepth > 0 {
+			w = &figure
+		}
+		switch tag := string(name); {
+		case start && tag == "picture":
+			picture++
+			figureHasImage = true
+			continue
+		case end && tag == "picture" && picture > 0:
+			picture--
+			continue
+		case picture > 0:
+			continue
+		case start && tag == "img":
+			figureHasImage = true
+			continue
+		case tt == xhtml.StartTagToken && tag == "figure":
+			if figureDepth == 0 {
+				figure.Reset()
+				figureHasImage = false
+				w = &figure
+			}
+			figureDepth++
+		case end && tag == "figure" && figureDepth > 0:
+			figure.Write(raw)
+			if figureDepth--; figureDepth == 0 && !figureHasImage {
+				out.Write(figure.Bytes())
+			}
+			continue
+		case start && tag == "p":
+			inPara = true
+			para.Reset()
+		case end && tag == "p" && inPara:
+			inPara = false
+			if len(strings.Fields(html.UnescapeString(para.String()))) > minLeadWords {
+				w.Write(raw)
+				if figureDepth > 0 {
+					out.Write(figure.Bytes())
+				}
+				// Everything after this paragraph stays as it was.
+				for z.Next() != xhtml.ErrorToken {
+					out.Write(z.Raw())
+				}
+				return out.String()
+			}
+		case tt == xhtml.TextToken && inPara:
+			para.Write(raw)
+		}
+		w.Write(raw)
+	}
+}
+
+// dropAltlessImages removes <img> and <picture> elements with no alt text
+// (missing, empty, or blank), which are usually spacers and decoration, for
+// -require-alt. Images in a <figure> with a <figcaption> are kept: the
+// caption says what they show.
+func dropAltlessImages(content []byte) []byte {
+	type figure struct{ captioned bool }
+	type candidate struct {
+		start, end int     // token range to drop
+		fig        *figure // innermost enclosing figure, if any
+		alt        bool
+	}
+	var tokens [][]byte
+	var figs []*figure
+	var images []candidate
+	picture := -1 // index of the open <picture> image, if any
+	z := xhtml.NewTokenizer(bytes.NewReader(content))
+	for {
+		tt := z.Next()
+		if tt == xhtml.ErrorToken {
+			break
+		}
+		tokens = append(tokens, bytes.Clone(z.Raw()))
+		i := len(tokens) - 1
+		name, hasAttr := z.TagName()
+		var fig *figure
+		if len(figs) > 0 {
+			fig = figs[len(figs)-1]
+		}
+		switch tag := string(name); {
+		case tt == xhtml.EndTagToken && tag == "figure" && len(figs) > 0:
+			figs = figs[:len(figs)-1]
+		case tt == xhtml.EndTagToken && tag == "picture" && picture >= 0:
+			images[picture].end = i
+			picture = -1
+		case tt == xhtml.EndTagToken:
+		case tag == "figure":
+			figs = append(figs, &figure{})
+		case tag == "figcaption" && fig != nil:
+			fig.captioned = true
+		case tag == "picture" && picture < 0:
+			images = append(images, candidate{start: i, end: i, fig: fig})
+			picture = len(images) - 1
+		case tag == "img":
+			alt := false
+			for hasAttr {
+				var key, val []byte
+				key, val, hasAttr = z.TagAttr()
+				if string(key) == "alt" && len(bytes.TrimSpace(val)) > 0 {
+					alt = true
+				}
+			}
+			if picture >= 0 {
+				images[picture].alt = images[picture].alt || alt
+			} else {
+				images = append(images, candidate{start: i, end: i, fig: fig, alt: alt})
+			}
+		}
+	}
+
+	drop := make([]bool, len(tokens))
+	for _, img := range images {
+		if img.alt || (img.fig != nil && img.fig.captioned) {
+			continue
+		}
+		for i := img.start; i <= img.end; i++ {
+			drop[i] = true
+		}
+	}
+	var out bytes.Buffer
+	for i, tok := range tokens {
+		if !drop[i] {
+			out.Write(tok)
+		}
+	}
+	return out.Bytes()
+}
+
 // fetchAndEmbed downloads external image URLs and embeds them as data URIs.
 // concurrency controls how many images are fetched in parallel (min 1).
 func fetchAndEmbed(html []byte, concurrency int) []byte {
@@ -250,22 +972,41 @@ func fetchAndEmbed(html []byte, concurrency int) []byte {
 		mime    string
 		encoded string
 	}
-	results := make([]fetchResult, len(matches))
+	// An image used more than once is fetched once.
+	var urls []string
+	index := map[string]int{} // URL → its position in urls
+	which := make([]int, len(matches))
+	for i, m := range matches {
+		imgURL := string(html[m[4]:m[5]]) // group 2: the URL
+		j, ok := index[imgURL]
+		if !ok {
+			j = len(urls)
+			index[imgURL] = j
+			urls = append(urls, imgURL)
+		}
+		which[i] = j
+	}
+	fetchedURLs := make([]fetchResult, len(urls))
 	var wg sync.WaitGroup
 	sem := make(chan struct{}, concurrency)
+	progress.addImages(len(urls))
 
-	for i, m := range matches {
-		imgURL := string(html[m[4]:m[5]]) // group 2: the URL
+	for j, imgURL := range urls {
 		wg.Add(1)
-		go func(i int, imgURL string) {
+		go func(j int, imgURL string) {
 			defer wg.Done()
 			sem <- struct{}{}
 			defer func() { <-sem }()
 			mime, encoded := fetchOneImage(imgURL)
-			results[i] = fetchResult{mime: mime, encoded: encoded}
-		}(i, imgURL)
+			fetchedURLs[j] = fetchResult{mime: mime, encoded: encoded}
+			progress.imageDone()
+		}(j, imgURL)
 	}
 	wg.Wait()
+	results := make([]fetchResult, len(matches))
+	for i, j := range which {
+		results[i] = fetchedURLs[j]
+	}
 
 	// Rebuild HTML with fetched results
 	var out bytes.Buffer
@@ -298,6 +1039,9 @@ func fetchAndEmbed(html []byte, concurrency int) []byte {
 
 type stats struct {
 	count          int
+	kept           int   // small images left as-is by -skip-small-reencode
+	profiles       int   // color profiles removed from kept images by -strip-icc
+	profileBytes   int64 // bytes those profiles took
 	originalTotal  int64
 	optimizedTotal int64
 }
@@ -320,9 +1064,13 @@ func tryOptimizeDataURI(mime, b64data string, opts optimizeOpts, st *stats) stri
 		return ""
 	}
 
+	if keepSmallOriginal(raw, mime, opts) {
+		st.kept++
+		return keptImageURI(raw, mime, opts, st)
+	}
 	uri, jpegLen := optimizeImage(raw, mime, opts)
 	if uri == "" {
-		return ""
+		return keptImageURI(raw, mime, opts, st)
 	}
 
 	st.originalTotal += int64(len(raw))
@@ -331,9 +1079,82 @@ func tryOptimizeDataURI(mime, b64data string, opts optimizeOpts, st *stats) stri
 	return uri
 }
 
+// keptImageURI returns the data URI for an image embedded as it is: "" to
+// leave the original in place or, with opts.stripICC, the image without
+// its color profile when it had one.
+func keptImageURI(raw []byte, mime string, opts optimizeOpts, st *stats) string {
+	if !opts.stripICC {
+		return ""
+	}
+	slim := stripColorProfile(raw)
+	if slim == nil {
+		return ""
+	}
+	st.profiles++
+	st.profileBytes += int64(len(raw) - len(slim))
+	return "data:" + mime + ";base64," + base64.StdEncoding.EncodeToString(slim)
+}
+
 // fetchImage downloads an image URL and returns its bytes and MIME type.
+// Hosts cut off by imageHosts are not contacted.
 func fetchImage(imgURL string) ([]byte, string, error) {
-	return fetchImageData(imgURL)
+	host := imageURLHost(imgURL)
+	if !imageHosts.allow(host) {
+		return nil, "", errHostBroken
+	}
+	data, mime, err := fetchImageData(imgURL)
+	imageHosts.record(host, err)
+	return data, mime, err
+}
+
+// imageHosts stops image fetches from a host after repeated consecutive
+// failures, for the rest of the run (nil = never). Set by run() from
+// -image-concurrency-backoff.
+var imageHosts *hostBreaker
+
+// errHostBroken is returned for images on a host imageHosts has cut off.
+var errHostBroken = errors.New("host skipped after repeated failures")
+
+// hostBreaker counts consecutive fetch failures per host and trips once a
+// host reaches threshold. It is shared by all articles' image fetches.
+type hostBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	failures  map[string]int
+	broken    map[string]bool
+}
+
+func newHostBreaker(threshold int) *hostBreaker {
+	return &hostBreaker{threshold: threshold, failures: map[string]int{}, broken: map[string]bool{}}
+}
+
+// allow reports whether host may still be fetched from.
+func (b *hostBreaker) allow(host string) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !b.broken[host]
+}
+
+// record notes the outcome of a fetch from host, tripping the breaker on
+// the threshold-th failure in a row.
+func (b *hostBreaker) record(host string, err error) {
+	if b == nil || host == "" {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil {
+		b.failures[host] = 0
+		return
+	}
+	b.failures[host]++
+	if b.failures[host] >= b.threshold && !b.broken[host] {
+		b.broken[host] = true
+		fmt.Fprintf(logOut, "Warning: %d image fetches from %s failed in a row; skipping its remaining images\n", b.failures[host], host)
+	}
 }
 
 // pickBestSrcsetURL extracts URLs from a srcset attribute value and picks
@@ -395,11 +1216,28 @@ func processArticleImages(html []byte, opts optimizeOpts, concurrency int) []byt
 	// Promote lazy-loaded images (data-src → src)
 	html = promoteLazySrc(html)
 
+	// Drop decorative images before anything fetches them.
+	if opts.requireAlt {
+		html = dropAltlessImages(html)
+	}
+	if opts.inlineEmoji {
+		html = inlineEmojiImages(html)
+	}
+	if opts.cleanURLs {
+		html = normalizeImageURLs(html)
+	}
+
 	// Fetch external image URLs and embed as data URIs.
 	// Skipped in markdown mode: images stay as external URLs there.
 	if !opts.skipImageFetch {
+		if len(opts.rules) > 0 {
+			html = markImageHosts(html)
+		}
+		start := time.Now()
 		html = fetchAndEmbed(html, concurrency)
+		opts.stageTimes.since("image-fetch", start)
 	}
+	defer opts.stageTimes.since("optimize", time.Now())
 
 	// Collapse <picture> elements into single <img> tags.
 	// Image counting happens after this step, in the data URI pass.
@@ -445,23 +1283,32 @@ func processArticleImages(html []byte, opts optimizeOpts, concurrency int) []byt
 					return match
 				}
 
-				uri, jpegLen := optimizeImage(data, mime, opts)
-				if uri != "" {
+				imgOpts := opts.forHost(imageURLHost(imgURL))
+				if keepSmallOriginal(data, mime, imgOpts) {
+					st.kept++
+				} else if uri, jpegLen := optimizeImage(data, mime, imgOpts); uri != "" {
 					st.originalTotal += int64(len(data))
 					st.optimizedTotal += int64(jpegLen)
 					st.count++
 					return []byte(fmt.Sprintf(`<img src="%s" alt="%s">`, uri, alt))
 				}
 
-				// Can't optimize (SVG/AVIF) — embed as-is
-				encoded := base64.StdEncoding.EncodeToString(data)
-				return []byte(fmt.Sprintf(`<img src="data:%s;base64,%s" alt="%s">`, mime, encoded, alt))
+				// Can't optimize (SVG/AVIF) or small enough already — embed as-is
+				uri := keptImageURI(data, mime, imgOpts, &st)
+				if uri == "" {
+					uri = "data:" + mime + ";base64," + base64.StdEncoding.EncodeToString(data)
+				}
+				return []byte(fmt.Sprintf(`<img src="%s" alt="%s">`, uri, alt))
 			}
 		}
 
 		return match
 	})
 
+	if opts.dropDupes {
+		html = dropDuplicateImages(html)
+	}
+
 	// Optimize standalone <img src="data:..."> (not inside <picture>)
 	nImages := len(dataURIRe.FindAllIndex(html, -1))
 	totalImages.Add(int64(nImages))
@@ -475,7 +1322,13 @@ func processArticleImages(html []byte, opts optimizeOpts, concurrency int) []byt
 		b64data := string(parts[3])
 		suffix := parts[4]
 
-		uri := tryOptimizeDataURI(mime, b64data, opts, &st)
+		imgOpts := opts
+		if m := imageHostAttrRe.FindSubmatch(prefix); m != nil {
+			imgOpts = opts.forHost(string(m[1]))
+			prefix = imageHostAttrRe.ReplaceAll(prefix, nil)
+		}
+
+		uri := tryOptimizeDataURI(mime, b64data, imgOpts, &st)
 		if uri == "" {
 			return match
 		}
@@ -487,12 +1340,43 @@ func processArticleImages(html []byte, opts optimizeOpts, concurrency int) []byt
 		return out.Bytes()
 	})
 
-	if st.count > 0 {
+	imageTotals.add(st)
+	switch {
+	case st.count > 0:
 		fmt.Fprintf(logOut, "Optimized %d images: %s → %s\n",
 			st.count, humanSize(st.originalTotal), humanSize(st.optimizedTotal))
-	} else {
+	case st.kept == 0:
 		fmt.Fprintln(logOut, "No optimizable images found.")
 	}
+	if st.kept > 0 {
+		fmt.Fprintf(logOut, "Kept %d small images as-is\n", st.kept)
+	}
+	if st.profiles > 0 {
+		fmt.Fprintf(logOut, "Stripped color profiles from %d images, saving %s\n", st.profiles, humanSize(st.profileBytes))
+	}
+
+	// Drop host marks left on images that failed to fetch.
+	if len(opts.rules) > 0 {
+		html = imageHostAttrRe.ReplaceAll(html, nil)
+	}
+
+	if opts.budget != nil {
+		html = applyEmbedBudget(html, opts.budget)
+	}
 
 	return html
 }
+
+// applyEmbedBudget drops embedded images once the shared budget is spent.
+// Images are charged in document order; buildEpub charges a book's
+// articles in order, and fetchMultipleArticles combined HTML's in input
+// order.
+func applyEmbedBudget(html []byte, budget *embedBudget) []byte {
+	return embeddedImgRe.ReplaceAllFunc(html, func(match []byte) []byte {
+		payload := embeddedImgRe.FindSubmatch(match)[1]
+		if budget.take(int64(base64.StdEncoding.DecodedLen(len(payload)))) {
+			return match
+		}
+		return nil
+	})
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"encoding/base64"
+	"fmt"
 	"image"
 	"image/color"
 	"image/gif"
@@ -11,6 +12,8 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 )
 
@@ -108,6 +111,252 @@ func TestOptimizeImage_Grayscale(t *testing.T) {
 	}
 }
 
+func TestOptimizeImage_GrayscaleQuality(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 200, 200))
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 200; x++ {
+			img.Set(x, y, color.NRGBA{uint8(x * y), uint8(x ^ y), uint8(x + 3*y), 255})
+		}
+	}
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	data := buf.Bytes()
+
+	_, low := optimizeImage(data, "image/png", optimizeOpts{maxWidth: 800, quality: 90, grayscale: true, grayQuality: 20})
+	_, high := optimizeImage(data, "image/png", optimizeOpts{maxWidth: 800, quality: 90, grayscale: true})
+	if low >= high {
+		t.Errorf("grayscale-quality 20 should produce a smaller file than quality 90: %d >= %d", low, high)
+	}
+
+	// Color output ignores grayQuality.
+	_, color1 := optimizeImage(data, "image/png", optimizeOpts{maxWidth: 800, quality: 90, grayQuality: 20})
+	_, color2 := optimizeImage(data, "image/png", optimizeOpts{maxWidth: 800, quality: 90})
+	if color1 != color2 {
+		t.Errorf("grayQuality should not affect color output: %d != %d", color1, color2)
+	}
+}
+
+func TestParseHexColor(t *testing.T) {
+	tests := []struct {
+		in   string
+		want color.NRGBA
+	}{
+		{"#ffffff", color.NRGBA{255, 255, 255, 255}},
+		{"#1a2B3c", color.NRGBA{0x1a, 0x2b, 0x3c, 255}},
+		{"000", color.NRGBA{0, 0, 0, 255}},
+		{"#f80", color.NRGBA{0xff, 0x88, 0x00, 255}},
+	}
+	for _, tt := range tests {
+		got, err := parseHexColor(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("parseHexColor(%q) = %v, %v; want %v", tt.in, got, err, tt.want)
+		}
+	}
+	for _, bad := range []string{"", "#ff", "#gggggg", "#12345", "red"} {
+		if _, err := parseHexColor(bad); err == nil {
+			t.Errorf("parseHexColor(%q) should fail", bad)
+		}
+	}
+}
+
+func TestOptimizeImage_BackgroundColor(t *testing.T) {
+	// White content on a transparent background vanishes on white.
+	data := makePNG(20, 20, color.NRGBA{0, 0, 0, 0})
+	uri, _ := optimizeImage(data, "image/png", optimizeOpts{maxWidth: 800, quality: 90, bgColor: color.NRGBA{0, 0, 0, 255}})
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(uri, "data:image/jpeg;base64,"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, g, b, _ := img.At(10, 10).RGBA(); r>>8 > 10 || g>>8 > 10 || b>>8 > 10 {
+		t.Errorf("transparent pixels should be flattened onto black, got %d,%d,%d", r>>8, g>>8, b>>8)
+	}
+}
+
+func TestCropBanner(t *testing.T) {
+	// 900x300 (3:1) with a red center third between blue edges.
+	banner := image.NewNRGBA(image.Rect(0, 0, 900, 300))
+	for y := 0; y < 300; y++ {
+		for x := 0; x < 900; x++ {
+			c := color.NRGBA{0, 0, 255, 255}
+			if x >= 300 && x < 600 {
+				c = color.NRGBA{255, 0, 0, 255}
+			}
+			banner.Set(x, y, c)
+		}
+	}
+
+	got := cropBanner(banner, 2)
+	if got.Bounds().Dx() != 600 || got.Bounds().Dy() != 300 {
+		t.Fatalf("expected 600x300 crop, got %v", got.Bounds())
+	}
+	// Center preserved: 150px of blue trimmed from each side.
+	if got.Bounds().Min.X != 150 {
+		t.Errorf("crop should be centered, starts at x=%d", got.Bounds().Min.X)
+	}
+	if c := got.NRGBAAt(450, 150); c.R != 255 {
+		t.Errorf("center pixel should stay red, got %v", c)
+	}
+
+	if same := cropBanner(banner, 3.5); same != banner {
+		t.Error("image within the ratio should be untouched")
+	}
+	if same := cropBanner(banner, 0); same != banner {
+		t.Error("ratio 0 should disable cropping")
+	}
+	tall := image.NewNRGBA(image.Rect(0, 0, 100, 900))
+	if same := cropBanner(tall, 1.5); same != tall {
+		t.Error("tall images should not be cropped")
+	}
+}
+
+func TestOptimizeImage_CropBanners(t *testing.T) {
+	data := makeJPEG(1500, 300, color.RGBA{90, 90, 90, 255})
+	uri, _ := optimizeImage(data, "image/jpeg", optimizeOpts{maxWidth: 800, quality: 60, cropRatio: 2.5})
+	raw, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(uri, "data:image/jpeg;base64,"))
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Cropped to 750x300 first, so it needs no downscale.
+	if cfg.Width != 750 || cfg.Height != 300 {
+		t.Errorf("expected 750x300 after crop, got %dx%d", cfg.Width, cfg.Height)
+	}
+}
+
+func TestOptimizeImage_MaxPixels(t *testing.T) {
+	data := makePNG(300, 200, color.White)
+	var buf bytes.Buffer
+	savedLog := logOut
+	logOut = &buf
+	defer func() { logOut = savedLog }()
+
+	if uri, _ := optimizeImage(data, "image/png", optimizeOpts{maxWidth: 800, quality: 60, maxPixels: 50000}); uri != "" {
+		t.Error("image over the pixel limit should be left unoptimized")
+	}
+	if !strings.Contains(buf.String(), "300x200") {
+		t.Errorf("expected a warning naming the dimensions, got %q", buf.String())
+	}
+	if uri, _ := optimizeImage(data, "image/png", optimizeOpts{maxWidth: 800, quality: 60, maxPixels: 60000}); uri == "" {
+		t.Error("image at the pixel limit should be optimized")
+	}
+}
+
+func TestOptimizeImage_Workers(t *testing.T) {
+	data := makeJPEG(400, 300, color.RGBA{10, 20, 30, 255})
+	opts := optimizeOpts{maxWidth: 200, quality: 60, workers: make(chan struct{}, 1)}
+	var wg sync.WaitGroup
+	var ok atomic.Int32
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if uri, _ := optimizeImage(data, "image/jpeg", opts); uri != "" {
+				ok.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if ok.Load() != 4 || len(opts.workers) != 0 {
+		t.Errorf("expected 4 optimized images and released slots, got %d (slots held %d)", ok.Load(), len(opts.workers))
+	}
+}
+
+func TestOptimizeImage_KeepPNG(t *testing.T) {
+	// Two-color "diagram", wider than maxWidth.
+	diagram := image.NewNRGBA(image.Rect(0, 0, 1200, 300))
+	for y := 0; y < 300; y++ {
+		for x := 0; x < 1200; x++ {
+			c := color.NRGBA{255, 255, 255, 255}
+			if (x/40+y/40)%2 == 0 {
+				c = color.NRGBA{0, 0, 200, 255}
+			}
+			diagram.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	png.Encode(&buf, diagram)
+
+	uri, n := optimizeImage(buf.Bytes(), "image/png", optimizeOpts{maxWidth: 800, quality: 60, keepPNG: true})
+	if !strings.HasPrefix(uri, "data:image/png;base64,") || n == 0 {
+		t.Fatalf("flat image should stay PNG, got %.30q", uri)
+	}
+	raw, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(uri, "data:image/png;base64,"))
+	img, err := png.Decode(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Dx() != 800 {
+		t.Errorf("expected resize to 800 wide, got %d", img.Bounds().Dx())
+	}
+	if _, ok := img.(*image.Paletted); !ok {
+		t.Errorf("expected a palette PNG, got %T", img)
+	}
+
+	uri, _ = optimizeImage(buf.Bytes(), "image/png", optimizeOpts{maxWidth: 800, quality: 60, keepPNG: true, grayscale: true})
+	raw, _ = base64.StdEncoding.DecodeString(strings.TrimPrefix(uri, "data:image/png;base64,"))
+	if img, err := png.Decode(bytes.NewReader(raw)); err != nil {
+		t.Fatal(err)
+	} else if _, ok := img.(*image.Gray); !ok {
+		t.Errorf("expected a gray PNG with grayscale, got %T", img)
+	}
+
+	// Default and photo-like images still go to JPEG.
+	if uri, _ := optimizeImage(buf.Bytes(), "image/png", optimizeOpts{maxWidth: 800, quality: 60}); !strings.HasPrefix(uri, "data:image/jpeg") {
+		t.Error("without -keep-png, PNGs should become JPEG")
+	}
+	photo := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			photo.Set(x, y, color.NRGBA{uint8(x * 4), uint8(y * 4), uint8(x * y), 255})
+		}
+	}
+	buf.Reset()
+	png.Encode(&buf, photo)
+	if uri, _ := optimizeImage(buf.Bytes(), "image/png", optimizeOpts{maxWidth: 800, quality: 60, keepPNG: true}); !strings.HasPrefix(uri, "data:image/jpeg") {
+		t.Error("many-color images should still become JPEG")
+	}
+
+	// A "screenshot" of 400 flat-colored tiles: too many colors for a
+	// palette, but high-edge, so truecolor PNG.
+	shot := image.NewNRGBA(image.Rect(0, 0, 400, 400))
+	for y := 0; y < 400; y++ {
+		for x := 0; x < 400; x++ {
+			i := x/20 + y/20*20
+			shot.Set(x, y, color.NRGBA{uint8(i * 37), uint8(i / 2), uint8(255 - i%2*128), 255})
+		}
+	}
+	buf.Reset()
+	png.Encode(&buf, shot)
+	uri, _ = optimizeImage(buf.Bytes(), "image/png", optimizeOpts{maxWidth: 800, quality: 60, keepPNG: true})
+	raw, _ = base64.StdEncoding.DecodeString(strings.TrimPrefix(uri, "data:image/png;base64,"))
+	if img, err := png.Decode(bytes.NewReader(raw)); err != nil {
+		t.Fatalf("high-edge image should stay PNG, got %.30q", uri)
+	} else if _, ok := img.(*image.Paletted); ok {
+		t.Error("expected a truecolor PNG for a many-color image")
+	}
+}
+
+func TestOptimizeImage_KeepWebP(t *testing.T) {
+	// A 1x1 lossless WebP.
+	webp, _ := base64.StdEncoding.DecodeString("UklGRhoAAABXRUJQVlA4TA0AAAAvAAAAEAcQERGIiP4HAA==")
+	opts := optimizeOpts{maxWidth: 800, quality: 60, keepWebP: true}
+	if uri, _ := optimizeImage(webp, "image/webp", opts); uri != "" {
+		t.Errorf("WebP that fits should be passed through with -keep-webp, got %.30q", uri)
+	}
+	// Grayscale needs a re-encode, and by default WebP becomes JPEG.
+	opts.grayscale = true
+	if uri, _ := optimizeImage(webp, "image/webp", opts); !strings.HasPrefix(uri, "data:image/jpeg") {
+		t.Errorf("grayscale WebP should still become JPEG, got %.30q", uri)
+	}
+	if uri, _ := optimizeImage(webp, "image/webp", optimizeOpts{maxWidth: 800, quality: 60}); !strings.HasPrefix(uri, "data:image/jpeg") {
+		t.Errorf("without -keep-webp, WebP should become JPEG, got %.30q", uri)
+	}
+}
+
 func TestOptimizeImage_PassthroughSVG(t *testing.T) {
 	uri, _ := optimizeImage([]byte("<svg></svg>"), "image/svg+xml", optimizeOpts{maxWidth: 800, quality: 60})
 	if uri != "" {
@@ -232,7 +481,7 @@ func TestHumanSize(t *testing.T) {
 		{1048576, "1.0MB"},
 		{1073741824, "1.0GB"},
 		{1099511627776, "1.0TB"},
-		{1125899906842624, "1.0TB"},  // exactly 1 PB - overflows to final return
+		{1125899906842624, "1.0TB"}, // exactly 1 PB - overflows to final return
 	}
 	for _, tt := range tests {
 		got := humanSize(tt.input)
@@ -370,6 +619,31 @@ func TestTryOptimizeDataURI(t *testing.T) {
 	}
 }
 
+func TestTryOptimizeDataURI_SkipSmallReencode(t *testing.T) {
+	small := base64.StdEncoding.EncodeToString(makePNG(100, 100, color.NRGBA{255, 0, 0, 255}))
+	wide := base64.StdEncoding.EncodeToString(makePNG(1000, 100, color.NRGBA{255, 0, 0, 255}))
+	opts := optimizeOpts{maxWidth: 800, quality: 60, skipSmall: true}
+
+	var st stats
+	if uri := tryOptimizeDataURI("image/png", small, opts, &st); uri != "" {
+		t.Error("small image that fits should be kept as-is")
+	}
+	if st.kept != 1 || st.count != 0 {
+		t.Errorf("expected kept=1 count=0, got %+v", st)
+	}
+	if uri := tryOptimizeDataURI("image/png", wide, opts, &st); uri == "" {
+		t.Error("image wider than max-width should still be re-encoded")
+	}
+	gray := opts
+	gray.grayscale = true
+	if uri := tryOptimizeDataURI("image/png", small, gray, &st); uri == "" {
+		t.Error("grayscale conversion should still re-encode small images")
+	}
+	if st.kept != 1 || st.count != 2 {
+		t.Errorf("expected kept=1 count=2, got %+v", st)
+	}
+}
+
 func TestTryOptimizeDataURI_SVGPassthrough(t *testing.T) {
 	b64 := base64.StdEncoding.EncodeToString([]byte("<svg></svg>"))
 	opts := optimizeOpts{maxWidth: 800, quality: 60}
@@ -640,6 +914,42 @@ func TestFetchOneImage_UsesFetchImageData(t *testing.T) {
 	}
 }
 
+func TestFetchAndEmbed_HostBackoff(t *testing.T) {
+	png := makePNG(10, 10, color.White)
+	var failing, healthy atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.Host, "127.0.0.1") {
+			failing.Add(1)
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		healthy.Add(1)
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(png)
+	}))
+	defer srv.Close()
+
+	imageHosts = newHostBreaker(2)
+	defer func() { imageHosts = nil }()
+
+	viaName := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+	var page strings.Builder
+	for i := range 5 {
+		fmt.Fprintf(&page, `<img src="%s/f%d.png"><img src="%s/h%d.png">`, srv.URL, i, viaName, i)
+	}
+	out := string(fetchAndEmbed([]byte(page.String()), 1))
+
+	if n := failing.Load(); n != 2 {
+		t.Errorf("expected the failing host to be tried twice, got %d requests", n)
+	}
+	if n := healthy.Load(); n != 5 {
+		t.Errorf("expected all 5 images from the healthy host, got %d", n)
+	}
+	if strings.Count(out, `src="`+srv.URL+`/f`) != 5 || strings.Count(out, "data:image/png") != 5 {
+		t.Errorf("failing host's images should keep their URLs, got:\n%s", out)
+	}
+}
+
 func TestFetchImage_Success(t *testing.T) {
 	imgData := makePNG(10, 10, color.NRGBA{255, 0, 0, 255})
 	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -704,6 +1014,30 @@ func TestFetchImage_MIMESniff(t *testing.T) {
 	}
 }
 
+func TestFetchImage_SVGSniff(t *testing.T) {
+	svg := `<?xml version="1.0"?>
+<!-- drawn by hand -->
+<svg xmlns="http://www.w3.org/2000/svg" width="10" height="10"><rect width="10" height="10"/></svg>`
+	for _, ct := range []string{"", "application/octet-stream", "text/plain", "text/xml; charset=utf-8"} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", ct)
+			w.Write([]byte(svg))
+		}))
+		_, mime, err := fetchImage(srv.URL + "/diagram.svg")
+		srv.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mime != "image/svg+xml" {
+			t.Errorf("Content-Type %q: mime = %q, want image/svg+xml", ct, mime)
+		}
+	}
+
+	if looksLikeSVG([]byte(`<html><body><svg></svg></body></html>`)) {
+		t.Error("an HTML page containing an SVG is not an SVG")
+	}
+}
+
 func TestFetchImage_ContentTypeWithCharset(t *testing.T) {
 	imgData := makePNG(10, 10, color.NRGBA{0, 255, 0, 255})
 	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -759,6 +1093,59 @@ func TestPromoteLazySrc_DataSrcset(t *testing.T) {
 	}
 }
 
+func TestPromoteLazySrc_NoscriptFallback(t *testing.T) {
+	html := []byte(`<p>Intro</p><img class="lazy" src="data:image/gif;base64,R0lGOD=="> <noscript><img src="https://example.com/real.jpg" alt="real"></noscript><p>After</p>`)
+	got := string(promoteLazySrc(html))
+	want := `<p>Intro</p><img src="https://example.com/real.jpg" alt="real"><p>After</p>`
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestPromoteLazySrc_NoscriptWithoutPlaceholder(t *testing.T) {
+	html := []byte(`<p>Text</p><noscript>
+  <img src="https://example.com/real.jpg">
+</noscript>`)
+	got := string(promoteLazySrc(html))
+	if got != `<p>Text</p><img src="https://example.com/real.jpg">` {
+		t.Errorf("noscript image should be unwrapped, got %q", got)
+	}
+}
+
+func TestPromoteLazySrc_NoscriptPlaceholders(t *testing.T) {
+	noscript := `<noscript><img src="https://example.com/real.jpg" alt="real"></noscript>`
+	for _, prev := range []string{
+		`<img src="/wp-content/themes/x/blank.gif">`,
+		`<img data-src="https://example.com/real.jpg">`,
+		`<img loading="lazy" class="lazy">`,
+		`<img src="https://example.com/real.jpg" class="js-only">`,
+	} {
+		if got := string(promoteLazySrc([]byte(prev + noscript))); got != `<img src="https://example.com/real.jpg" alt="real">` {
+			t.Errorf("placeholder %s should be dropped, got %q", prev, got)
+		}
+	}
+
+	// A real image that merely sits before an unrelated <noscript> stays.
+	html := `<img src="https://example.com/chart.png" alt="Chart"><noscript><img src="https://example.com/other.jpg" alt="Other"></noscript>`
+	want := `<img src="https://example.com/chart.png" alt="Chart"><img src="https://example.com/other.jpg" alt="Other">`
+	if got := string(promoteLazySrc([]byte(html))); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestPromoteLazySrc_NoscriptLeftAlone(t *testing.T) {
+	for _, html := range []string{
+		`<noscript><link rel="stylesheet" href="/no-js.css"></noscript>`,
+		`<noscript><img src="a.jpg"><img src="b.jpg"></noscript>`,
+		`<noscript><img src="a.jpg"><p>Enable JavaScript</p></noscript>`,
+		`<noscript><img height="1" width="1" src="https://tracker.example.com/px"/></noscript>`,
+	} {
+		if got := string(promoteLazySrc([]byte(html))); got != html {
+			t.Errorf("expected %q unchanged, got %q", html, got)
+		}
+	}
+}
+
 func TestPickBestSrcsetURL_SingleURL(t *testing.T) {
 	html := []byte(`<source srcset="https://example.com/only.jpg">`)
 	u := pickBestSrcsetURL(html)
@@ -1001,3 +1388,265 @@ func TestProcessArticleImages_SkipImageFetch_Picture(t *testing.T) {
 	}
 	_ = result
 }
+
+func TestMinifySVG(t *testing.T) {
+	svg := []byte(`<?xml version="1.0"?>
+<!-- Created with Inkscape -->
+<svg xmlns="http://www.w3.org/2000/svg" xmlns:inkscape="http://www.inkscape.org/namespaces/inkscape"
+     xmlns:sodipodi="http://sodipodi.sourceforge.net/DTD/sodipodi-0.dtd" inkscape:version="1.2">
+  <metadata><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#"></rdf:RDF></metadata>
+  <sodipodi:namedview id="base" inkscape:zoom="1.4"/>
+  <circle cx="10.123456" cy="20.5" r="5.000001" inkscape:label="dot"/>
+</svg>`)
+	got := string(minifySVG(svg))
+	for _, cruft := range []string{"<!--", "metadata", "inkscape", "sodipodi", "10.123456", "\n"} {
+		if strings.Contains(got, cruft) {
+			t.Errorf("minified SVG should not contain %q: %s", cruft, got)
+		}
+	}
+	if !strings.Contains(got, `cx="10.123"`) || !strings.Contains(got, `cy="20.5"`) {
+		t.Errorf("expected coordinates rounded to 3 decimals, got %s", got)
+	}
+}
+
+func TestMinifySVG_KeepsMeaning(t *testing.T) {
+	svg := []byte(`<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink"
+     xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#" viewBox="0 0 10.123456 10">
+  <rdf:Description rdf:about="x"/>
+  <linearGradient id="g1.23456"/>
+  <rect x="1.123456" width="2.5" height="2.5" fill="url(#g1.23456)"/>
+  <use xlink:href="https://example.com/v1.234567/sprite.svg#a"/>
+  <text x="1" y="8"><tspan>Hello</tspan> <tspan>world</tspan></text>
+</svg>`)
+	got := string(minifySVG(svg))
+	for _, want := range []string{
+		`viewBox="0 0 10.123 10"`, `x="1.123"`, // geometry is trimmed
+		`id="g1.23456"`, `url(#g1.23456)`, `v1.234567/sprite.svg`, // other values are not
+		`<tspan>Hello</tspan> <tspan>world</tspan>`, // nor is rendered whitespace
+		`xmlns:rdf=`, // still used by rdf:Description
+		`/><rect`, `</text></svg>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %s in minified SVG: %s", want, got)
+		}
+	}
+}
+
+func TestMinifySVG_Malformed(t *testing.T) {
+	if got := minifySVG([]byte(`<svg><circle r="1"></svg>`)); got != nil {
+		t.Errorf("malformed SVG should return nil, got %q", got)
+	}
+}
+
+func TestOptimizeImage_OptimizeSVG(t *testing.T) {
+	svg := []byte("<svg xmlns=\"http://www.w3.org/2000/svg\">\n  <!-- editor comment -->\n  <rect width=\"10\" height=\"10\"/>\n</svg>")
+	opts := optimizeOpts{maxWidth: 800, quality: 60, optimizeSVG: true}
+	uri, n := optimizeImage(svg, "image/svg+xml", opts)
+	if !strings.HasPrefix(uri, "data:image/svg+xml;base64,") {
+		t.Fatalf("expected SVG data URI, got %q", uri)
+	}
+	if n >= len(svg) {
+		t.Errorf("minified size %d should be smaller than %d", n, len(svg))
+	}
+
+	// Malformed SVG passes through unmodified
+	if uri, _ := optimizeImage([]byte("<svg><g></svg>"), "image/svg+xml", opts); uri != "" {
+		t.Error("malformed SVG should pass through")
+	}
+}
+
+func TestApplyEmbedBudget(t *testing.T) {
+	img := func(n int) string {
+		return `<img src="data:image/jpeg;base64,` + base64.StdEncoding.EncodeToString(make([]byte, n)) + `" alt="x">`
+	}
+	html := []byte("<p>" + img(300) + img(300) + img(300) + "</p>")
+	budget := &embedBudget{limit: 700}
+	got := string(applyEmbedBudget(html, budget))
+	if n := strings.Count(got, "<img"); n != 2 {
+		t.Errorf("expected 2 images within budget, got %d", n)
+	}
+	if budget.skipped.Load() != 1 || budget.used.Load() != 600 {
+		t.Errorf("skipped=%d used=%d, want 1 and 600", budget.skipped.Load(), budget.used.Load())
+	}
+
+	// The budget is shared: a second article gets nothing once it's spent.
+	got = string(applyEmbedBudget([]byte(img(200)), budget))
+	if strings.Contains(got, "<img") {
+		t.Error("image over the shared budget should be dropped")
+	}
+}
+
+func TestProcessArticleImages_EmbedBudget(t *testing.T) {
+	img := dataURI("image/png", makePNG(50, 50, color.RGBA{200, 0, 0, 255}))
+	html := []byte(`<p><img src="` + img + `"><img src="` + img + `"></p>`)
+	budget := &embedBudget{limit: 1}
+	result := processArticleImages(html, optimizeOpts{maxWidth: 800, quality: 60, budget: budget}, 1)
+	if strings.Contains(string(result), "<img") {
+		t.Error("all images should be dropped with a 1-byte budget")
+	}
+	if budget.skipped.Load() != 2 {
+		t.Errorf("expected 2 skipped images, got %d", budget.skipped.Load())
+	}
+}
+
+func TestRasterizeSVG(t *testing.T) {
+	svg := []byte(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 200 100"><rect x="0" y="0" width="100" height="100" fill="#000000"/></svg>`)
+	img, err := rasterizeSVG(svg, 80)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 80 || b.Dy() != 40 {
+		t.Errorf("expected 80x40 after scaling to maxWidth, got %dx%d", b.Dx(), b.Dy())
+	}
+	// Left half is the black rect, right half is transparent.
+	if _, _, _, a := img.At(10, 20).RGBA(); a == 0 {
+		t.Error("expected the rect to be drawn")
+	}
+	if _, _, _, a := img.At(70, 20).RGBA(); a != 0 {
+		t.Error("expected the right half to stay empty")
+	}
+}
+
+func TestRasterizeSVG_Failures(t *testing.T) {
+	for name, svg := range map[string]string{
+		"malformed":   `<svg><rect`,
+		"no size":     `<svg xmlns="http://www.w3.org/2000/svg"><rect width="10" height="10"/></svg>`,
+		"huge canvas": `<svg xmlns="http://www.w3.org/2000/svg" width="100" height="10000000"></svg>`,
+	} {
+		if _, err := rasterizeSVG([]byte(svg), 800); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestOptimizeImage_RasterizeSVG(t *testing.T) {
+	svg := []byte(`<svg xmlns="http://www.w3.org/2000/svg" width="50" height="50"><circle cx="25" cy="25" r="20" fill="red"/></svg>`)
+	uri, n := optimizeImage(svg, "image/svg+xml", optimizeOpts{maxWidth: 800, quality: 60, rasterizeSVG: true})
+	if !strings.HasPrefix(uri, "data:image/jpeg;base64,") || n == 0 {
+		t.Fatalf("expected a JPEG data URI, got %.40q", uri)
+	}
+
+	// Unrenderable SVGs fall back to passthrough.
+	uri, _ = optimizeImage([]byte(`<svg><rect`), "image/svg+xml", optimizeOpts{maxWidth: 800, quality: 60, rasterizeSVG: true})
+	if uri != "" {
+		t.Errorf("expected passthrough for a broken SVG, got %.40q", uri)
+	}
+
+	// Default stays passthrough.
+	if uri, _ := optimizeImage(svg, "image/svg+xml", optimizeOpts{maxWidth: 800, quality: 60}); uri != "" {
+		t.Error("SVG should pass through without -rasterize-svg")
+	}
+}
+
+func TestPrependHeroImage(t *testing.T) {
+	png := makePNG(40, 20, color.RGBA{0, 0, 200, 255})
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if r.URL.Path != "/lead.png" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(png)
+	}))
+	defer srv.Close()
+	hero := srv.URL + "/lead.png"
+	body := `<p>Story text.</p>`
+
+	got := prependHeroImage(body, hero, "Q&A", false)
+	want := `<figure><img src="` + dataURI("image/png", png) + `" alt="Q&amp;A"></figure>` + body
+	if got != want {
+		t.Errorf("expected embedded hero before the body, got:\n%.200s", got)
+	}
+	if got := string(dropAltlessImages([]byte(got))); got != want {
+		t.Errorf("-require-alt should keep the hero, got:\n%.200s", got)
+	}
+
+	// Markdown keeps the URL and never downloads it.
+	hits.Store(0)
+	if got := prependHeroImage(body, hero, "Q&A", true); got != `<figure><img src="`+hero+`" alt="Q&amp;A"></figure>`+body {
+		t.Errorf("markdown should keep the hero URL, got %q", got)
+	}
+	if n := hits.Load(); n != 0 {
+		t.Errorf("markdown hero should not be fetched, got %d requests", n)
+	}
+
+	// Already present, at another size: left alone without fetching.
+	hits.Store(0)
+	present := `<p><img src="` + strings.Replace(hero, "http:", "https:", 1) + `?w=600&amp;q=80" alt="x"></p>` + body
+	if got := prependHeroImage(present, hero, "", false); got != present {
+		t.Errorf("hero already in the body should not be added again, got:\n%s", got)
+	}
+	srcset := `<picture><source srcset="` + hero + `?w=320 320w"><img src="data:image/png;base64,AA=="></picture>`
+	if got := prependHeroImage(srcset, hero, "", false); got != srcset {
+		t.Errorf("hero in a srcset should count as present, got:\n%s", got)
+	}
+	if n := hits.Load(); n != 0 {
+		t.Errorf("present hero should not be fetched, got %d requests", n)
+	}
+
+	if got := prependHeroImage(body, srv.URL+"/gone.png", "", false); got != body {
+		t.Errorf("unfetchable hero should be skipped, got %q", got)
+	}
+}
+
+func TestStripLeadingImages(t *testing.T) {
+	long := "<p>" + strings.Repeat("word ", 25) + "</p>"
+	in := `<div><a href="/"><img src="logo.png"></a><figure><picture><source srcset="ad.webp"><img src="ad.jpg"></picture><figcaption>Ad</figcaption></figure>` +
+		`<figure><figcaption>Quote</figcaption></figure><p>Short caption.</p>` + long + `<img src="body.jpg"></div>`
+	want := `<div><a href="/"></a><figure><figcaption>Quote</figcaption></figure><p>Short caption.</p>` + long + `<img src="body.jpg"></div>`
+	if got := stripLeadingImages(in); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	// Without a substantial paragraph, nothing is removed.
+	photos := `<img src="a.jpg"><p>Caption one.</p><img src="b.jpg">`
+	if got := stripLeadingImages(photos); got != photos {
+		t.Errorf("image-only content should be unchanged, got %s", got)
+	}
+}
+
+func TestDropAltlessImages(t *testing.T) {
+	in := `<p><img src="spacer.gif"><img src="blank.png" alt=" "><img src="chart.png" alt="Sales chart"/></p>` +
+		`<picture><source srcset="a.webp"><img src="a.jpg" alt=""></picture>` +
+		`<picture><source srcset="b.webp"><img src="b.jpg" alt="Map"></picture>` +
+		`<figure><img src="c.jpg" alt=""><figcaption>The harbor at dawn</figcaption></figure>` +
+		`<figure><img src="d.jpg"></figure>`
+	want := `<p><img src="chart.png" alt="Sales chart"/></p>` +
+		`<picture><source srcset="b.webp"><img src="b.jpg" alt="Map"></picture>` +
+		`<figure><img src="c.jpg" alt=""><figcaption>The harbor at dawn</figcaption></figure>` +
+		`<figure></figure>`
+	if got := string(dropAltlessImages([]byte(in))); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestUnsharpMask(t *testing.T) {
+	flat := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	for i := 0; i < len(flat.Pix); i += 4 {
+		copy(flat.Pix[i:], []uint8{120, 60, 30, 255})
+	}
+	if got := unsharpMask(flat, 100); !bytes.Equal(got.Pix, flat.Pix) {
+		t.Error("a flat image should be unchanged")
+	}
+
+	// A vertical edge: dark left half, light right half.
+	edge := image.NewNRGBA(image.Rect(0, 0, 6, 3))
+	for y := 0; y < 3; y++ {
+		for x := 0; x < 6; x++ {
+			v := uint8(100)
+			if x >= 3 {
+				v = 150
+			}
+			edge.SetNRGBA(x, y, color.NRGBA{v, v, v, 255})
+		}
+	}
+	got := unsharpMask(edge, 50)
+	if dark, light := got.NRGBAAt(2, 1).R, got.NRGBAAt(3, 1).R; dark >= 100 || light <= 150 {
+		t.Errorf("edge contrast should increase, got %d|%d", dark, light)
+	}
+	if got.NRGBAAt(0, 1) != edge.NRGBAAt(0, 1) {
+		t.Error("pixels away from the edge should be unchanged")
+	}
+}
@@ -181,7 +181,7 @@ func TestIntegration_RichArticle(t *testing.T) {
 
 	// Run full pipeline
 	opts := optimizeOpts{maxWidth: 800, quality: 60, grayscale: true}
-	html, title, src, err := processURL(srv.URL, opts, 5*time.Second, "test-agent", "", 5)
+	html, title, src, err := processURL(srv.URL, cliConfig{opts: opts, timeout: 5*time.Second, userAgent: "test-agent", concurrency: 5}, "")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -282,7 +282,7 @@ func TestIntegration_ExternalImages(t *testing.T) {
 	defer func() { fetchImageClient = saved }()
 
 	opts := optimizeOpts{maxWidth: 800, quality: 60, grayscale: false}
-	html, _, _, err := processURL(srv.URL, opts, 5*time.Second, "test-agent", "", 5)
+	html, _, _, err := processURL(srv.URL, cliConfig{opts: opts, timeout: 5*time.Second, userAgent: "test-agent", concurrency: 5}, "")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -315,7 +315,7 @@ func TestIntegration_TextOnlyArticle(t *testing.T) {
 	defer srv.Close()
 
 	opts := optimizeOpts{maxWidth: 800, quality: 60}
-	html, title, _, err := processURL(srv.URL, opts, 5*time.Second, "test-agent", "", 5)
+	html, title, _, err := processURL(srv.URL, cliConfig{opts: opts, timeout: 5*time.Second, userAgent: "test-agent", concurrency: 5}, "")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -359,7 +359,7 @@ func TestIntegration_ManyImages(t *testing.T) {
 
 	start := time.Now()
 	opts := optimizeOpts{maxWidth: 800, quality: 60, grayscale: true}
-	html, _, _, err := processURL(srv.URL, opts, 10*time.Second, "test-agent", "", 5)
+	html, _, _, err := processURL(srv.URL, cliConfig{opts: opts, timeout: 10*time.Second, userAgent: "test-agent", concurrency: 5}, "")
 	elapsed := time.Since(start)
 
 	if err != nil {
@@ -570,7 +570,7 @@ article is long enough to be properly extracted by the algorithm.</p>
 	defer func() { fetchImageClient = saved }()
 
 	opts := optimizeOpts{maxWidth: 800, quality: 60}
-	html, _, _, err := processURL(srv.URL, opts, 5*time.Second, "test-agent", "", 5)
+	html, _, _, err := processURL(srv.URL, cliConfig{opts: opts, timeout: 5*time.Second, userAgent: "test-agent", concurrency: 5}, "")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -611,7 +611,7 @@ func TestIntegration_LargeArticle(t *testing.T) {
 
 	start := time.Now()
 	opts := optimizeOpts{maxWidth: 800, quality: 60}
-	html, _, _, err := processURL(srv.URL, opts, 10*time.Second, "test-agent", "", 5)
+	html, _, _, err := processURL(srv.URL, cliConfig{opts: opts, timeout: 10*time.Second, userAgent: "test-agent", concurrency: 5}, "")
 	elapsed := time.Since(start)
 
 	if err != nil {
@@ -737,7 +737,7 @@ to satisfy readability requirements and heuristics for extraction.</p>
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_, _, _, err := processURL(srv.URL, opts, 5*time.Second, "bench-agent", "", 5)
+		_, _, _, err := processURL(srv.URL, cliConfig{opts: opts, timeout: 5*time.Second, userAgent: "bench-agent", concurrency: 5}, "")
 		if err != nil {
 			b.Fatal(err)
 		}
@@ -779,7 +779,7 @@ func BenchmarkBuildEpub(b *testing.B) {
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		outPath := filepath.Join(dir, fmt.Sprintf("bench_%d.epub", i))
-		if err := buildEpub(articles, "Bench Book", outPath, "collage"); err != nil {
+		if err := buildEpub(articles, "Bench Book", outPath, epubOpts{coverStyle: "collage"}); err != nil {
 			b.Fatal(err)
 		}
 	}
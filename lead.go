@@ -0,0 +1,91 @@
+// Lead paragraph styling (-lead-paragraph).
+// Books often set an article's opening paragraph apart with a drop cap or a
+// small-caps first line. This marks that paragraph with class "lead" for
+// the rule in leadParagraphCSS.
+package main
+
+import (
+	"bytes"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// leadParagraphCSS maps each -lead-paragraph style to its stylesheet rule.
+var leadParagraphCSS = map[string]string{
+	"drop-cap":   "p.lead::first-letter { float: left; font-size: 3.2em; line-height: 0.85; font-weight: bold; margin: 0.05em 0.08em 0 0; }",
+	"small-caps": "p.lead::first-line { font-variant: small-caps; letter-spacing: 0.05em; }",
+}
+
+// markLeadParagraph adds the "lead" class to the article's opening
+// paragraph: the first block after the title and byline, looking inside
+// wrapper <div>s and <section>s. Nothing is marked when the article opens
+// with anything else (an image, a heading, a list) or has no paragraphs.
+func markLeadParagraph(body string) string {
+	if !strings.Contains(body, "<p") {
+		return body
+	}
+	ctx := &html.Node{Type: html.ElementNode, DataAtom: atom.Body, Data: "body"}
+	nodes, err := html.ParseFragment(strings.NewReader(body), ctx)
+	if err != nil {
+		return body
+	}
+	for _, n := range nodes {
+		ctx.AppendChild(n)
+	}
+	p, _ := leadParagraph(ctx)
+	if p == nil {
+		return body
+	}
+	setAttr(p, "class", strings.TrimSpace(getAttr(p, "class")+" lead"))
+
+	var buf bytes.Buffer
+	for c := ctx.FirstChild; c != nil; c = c.NextSibling {
+		html.Render(&buf, c)
+	}
+	return buf.String()
+}
+
+// leadParagraph looks for the opening paragraph among n's children. done
+// reports whether the search is over: the paragraph was found, or some
+// other content comes first. Empty wrappers and paragraphs are skipped.
+func leadParagraph(n *html.Node) (p *html.Node, done bool) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		switch {
+		case c.Type == html.CommentNode:
+		case c.Type == html.TextNode:
+			if strings.TrimSpace(c.Data) != "" {
+				return nil, true
+			}
+		case c.Type != html.ElementNode:
+		case c.DataAtom == atom.H1, c.DataAtom == atom.P && hasClass(c, "byline"):
+		case c.DataAtom == atom.Div, c.DataAtom == atom.Section, c.DataAtom == atom.Article, c.DataAtom == atom.Main:
+			if p, done := leadParagraph(c); done {
+				return p, true
+			}
+		case c.DataAtom == atom.P:
+			if strings.TrimSpace(textContent(c)) != "" {
+				return c, true
+			}
+			for k := c.FirstChild; k != nil; k = k.NextSibling {
+				if k.Type == html.ElementNode { // an image or other non-text content
+					return nil, true
+				}
+			}
+		default:
+			return nil, true
+		}
+	}
+	return nil, false
+}
+
+// hasClass reports whether n's class attribute lists name.
+func hasClass(n *html.Node, name string) bool {
+	for _, c := range strings.Fields(getAttr(n, "class")) {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
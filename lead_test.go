@@ -0,0 +1,52 @@
+package main
+
+import (
+	"archive/zip"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMarkLeadParagraph(t *testing.T) {
+	for _, tc := range []struct{ in, want string }{
+		{
+			`<h1>Title</h1><p class="byline">By Ann</p><div id="readability-page-1" class="page"><div><p></p><p class="intro">It was a dark night.</p><p>Second.</p></div></div>`,
+			`<h1>Title</h1><p class="byline">By Ann</p><div id="readability-page-1" class="page"><div><p></p><p class="intro lead">It was a dark night.</p><p>Second.</p></div></div>`,
+		},
+		{`<h1>T</h1><div></div><section><p>Opening.</p></section>`, `<h1>T</h1><div></div><section><p class="lead">Opening.</p></section>`},
+		// Articles opening with something else, or without paragraphs, are
+		// left alone.
+		{`<h1>T</h1><figure><img src="a.jpg"/></figure><p>After the photo.</p>`, `<h1>T</h1><figure><img src="a.jpg"/></figure><p>After the photo.</p>`},
+		{`<h1>T</h1><div><p><img src="a.jpg"/></p><p>Text.</p></div>`, `<h1>T</h1><div><p><img src="a.jpg"/></p><p>Text.</p></div>`},
+		{`<h1>T</h1><div>Loose text<p>Para.</p></div>`, `<h1>T</h1><div>Loose text<p>Para.</p></div>`},
+		{`<h1>T</h1><ul><li>Item</li></ul>`, `<h1>T</h1><ul><li>Item</li></ul>`},
+	} {
+		if got := markLeadParagraph(tc.in); got != tc.want {
+			t.Errorf("markLeadParagraph(%s)\n got %s\nwant %s", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestBuildEpub_LeadParagraph(t *testing.T) {
+	articles := []epubArticle{{
+		HTML:  `<html><body><h1>Lead</h1><p>Once upon a time.</p><p>Later.</p></body></html>`,
+		Title: "Lead",
+	}}
+	outPath := filepath.Join(t.TempDir(), "lead.epub")
+	opts := epubOpts{coverStyle: "none", leadParagraph: "drop-cap", minimal: true}
+	if err := buildEpub(articles, "Lead", outPath, opts); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.OpenReader(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+
+	if article := findZipFile(zr, "EPUB/xhtml/article001.xhtml"); !strings.Contains(article, `<p class="lead">Once upon a time.</p>`) {
+		t.Errorf("opening paragraph should keep its lead class under -minimal, got %q", article)
+	}
+	if css := findZipFile(zr, "EPUB/css/styles.css"); !strings.Contains(css, "p.lead::first-letter") {
+		t.Error("stylesheet should include the drop-cap rule")
+	}
+}
@@ -0,0 +1,165 @@
+// Bare URL linking (-link-preview, -link-titles).
+// Turns plain pasted URLs in article text into real links, optionally
+// titled with the linked page's <title>.
+package main
+
+import (
+	"bytes"
+	gohtml "html"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// Matches an http(s) URL in (escaped) text. Trailing punctuation is trimmed
+// separately by trimURLPunct.
+var bareURLRe = regexp.MustCompile(`https?://[^\s<>"']+`)
+
+// linkTitleCache maps URL to *linkTitle, shared across articles so each link
+// is fetched at most once per run.
+var linkTitleCache sync.Map
+
+// linkTitle is a fetched page title ("" when unavailable).
+type linkTitle struct {
+	once  sync.Once
+	title string
+}
+
+// Elements whose text is never linked.
+var noLinkifyElements = map[string]bool{
+	"a": true, "pre": true, "code": true, "script": true, "style": true,
+	"textarea": true, "title": true,
+}
+
+// trimURLPunct drops sentence punctuation and quotes that trail a URL in
+// prose, and a closing parenthesis with no opening partner inside the URL.
+func trimURLPunct(u string) string {
+	for len(u) > 0 {
+		last := u[len(u)-1]
+		switch {
+		case strings.HasSuffix(u, "&quot;") || strings.HasSuffix(u, "&#39;"):
+			u = u[:strings.LastIndexByte(u, '&')]
+		case strings.IndexByte(".,;:!?*", last) >= 0:
+			u = u[:len(u)-1]
+		case last == ')' && strings.Count(u, "(") < strings.Count(u, ")"):
+			u = u[:len(u)-1]
+		default:
+			return u
+		}
+	}
+	return u
+}
+
+// linkifyBareURLs wraps bare http(s) URLs in text (outside existing links,
+// code, and scripts) in <a> elements. With cfg.linkTitles, each URL's page
+// <title> becomes the link text, fetched up to cfg.concurrency at a time;
+// otherwise the URL itself is the text, which markdown renders as <url>.
+func linkifyBareURLs(content string, cfg cliConfig) string {
+	type token struct {
+		raw  string
+		keep bool // not linkable text: a tag, or text inside noLinkifyElements
+	}
+	var tokens []token
+	var urls []string
+	skip := 0
+	z := html.NewTokenizer(strings.NewReader(content))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		raw := string(z.Raw())
+		switch tt {
+		case html.StartTagToken, html.EndTagToken:
+			name, _ := z.TagName()
+			if noLinkifyElements[string(name)] {
+				if tt == html.StartTagToken {
+					skip++
+				} else if skip > 0 {
+					skip--
+				}
+			}
+		case html.TextToken:
+			if skip == 0 {
+				for _, m := range bareURLRe.FindAllString(raw, -1) {
+					urls = append(urls, gohtml.UnescapeString(trimURLPunct(m)))
+				}
+			}
+		}
+		tokens = append(tokens, token{raw: raw, keep: tt != html.TextToken || skip > 0})
+	}
+	if len(urls) == 0 {
+		return content
+	}
+
+	titles := map[string]string{}
+	if cfg.linkTitles {
+		titles = fetchLinkTitles(urls, cfg)
+	}
+
+	var buf bytes.Buffer
+	for _, tok := range tokens {
+		if tok.keep {
+			buf.WriteString(tok.raw)
+			continue
+		}
+		last := 0
+		for _, loc := range bareURLRe.FindAllStringIndex(tok.raw, -1) {
+			u := trimURLPunct(tok.raw[loc[0]:loc[1]])
+			text := u
+			if t := titles[gohtml.UnescapeString(u)]; t != "" {
+				text = gohtml.EscapeString(t)
+			}
+			buf.WriteString(tok.raw[last:loc[0]])
+			buf.WriteString(`<a href="` + u + `">` + text + `</a>`)
+			last = loc[0] + len(u)
+		}
+		buf.WriteString(tok.raw[last:])
+	}
+	return buf.String()
+}
+
+// fetchLinkTitles returns the page title of each URL, fetching at most
+// cfg.concurrency at once and reusing titles cached by earlier articles.
+func fetchLinkTitles(urls []string, cfg cliConfig) map[string]string {
+	sem := make(chan struct{}, max(cfg.concurrency, 1))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	titles := map[string]string{}
+	for _, u := range slices.Compact(slices.Sorted(slices.Values(urls))) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, _ := linkTitleCache.LoadOrStore(u, &linkTitle{})
+			lt := v.(*linkTitle)
+			lt.once.Do(func() {
+				sem <- struct{}{}
+				defer func() { <-sem }()
+				lt.title = fetchLinkTitle(u, cfg.timeout, cfg.userAgent)
+			})
+			mu.Lock()
+			titles[u] = lt.title
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return titles
+}
+
+// fetchLinkTitle returns the <title> of the page at u, or "" on any failure.
+func fetchLinkTitle(u string, timeout time.Duration, userAgent string) string {
+	body, _, err := fetchHTML(u, timeout, userAgent)
+	if err != nil {
+		vprintf("Link title for %s unavailable: %v\n", u, err)
+		return ""
+	}
+	m := titleTagRe.FindSubmatch(body)
+	if m == nil {
+		return ""
+	}
+	return strings.Join(strings.Fields(gohtml.UnescapeString(string(m[1]))), " ")
+}
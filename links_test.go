@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTrimURLPunct(t *testing.T) {
+	tests := map[string]string{
+		"https://example.com/a.":                  "https://example.com/a",
+		"https://example.com/a),":                 "https://example.com/a",
+		"https://en.wikipedia.org/wiki/Go_(game)": "https://en.wikipedia.org/wiki/Go_(game)",
+		"https://example.com/?q=1&amp;r=2":        "https://example.com/?q=1&amp;r=2",
+		"https://example.com/x&quot;":             "https://example.com/x",
+	}
+	for in, want := range tests {
+		if got := trimURLPunct(in); got != want {
+			t.Errorf("trimURLPunct(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestLinkifyBareURLs(t *testing.T) {
+	in := `<p>See https://example.com/a?x=1&amp;y=2. Or (https://example.org/b).</p>` +
+		`<p><a href="https://example.com/c">https://example.com/c</a></p>` +
+		`<pre>curl https://example.com/d</pre><p><code>https://example.com/e</code></p>`
+	got := linkifyBareURLs(in, cliConfig{})
+
+	for _, want := range []string{
+		`See <a href="https://example.com/a?x=1&amp;y=2">https://example.com/a?x=1&amp;y=2</a>. Or`,
+		`(<a href="https://example.org/b">https://example.org/b</a>).`,
+		`<a href="https://example.com/c">https://example.com/c</a></p>`,
+		`<pre>curl https://example.com/d</pre>`,
+		`<code>https://example.com/e</code>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in:\n%s", want, got)
+		}
+	}
+	if strings.Count(got, "<a ") != 3 {
+		t.Errorf("expected 3 links (2 new, 1 existing), got:\n%s", got)
+	}
+
+	plain := `<p>No links here.</p>`
+	if got := linkifyBareURLs(plain, cliConfig{}); got != plain {
+		t.Errorf("content without URLs should be unchanged, got %q", got)
+	}
+}
+
+func TestLinkifyBareURLs_Titles(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("<html><head><title>\n  Fish &amp; Chips\n</title></head><body></body></html>"))
+	}))
+	defer srv.Close()
+
+	cfg := cliConfig{linkPreview: true, linkTitles: true, concurrency: 2, timeout: 5 * time.Second}
+	in := "<p>" + srv.URL + "/page and again " + srv.URL + "/page, plus " + srv.URL + "/missing</p>"
+	got := linkifyBareURLs(in, cfg)
+
+	titled := `<a href="` + srv.URL + `/page">Fish &amp; Chips</a>`
+	if strings.Count(got, titled) != 2 {
+		t.Errorf("expected both links titled, got:\n%s", got)
+	}
+	if !strings.Contains(got, `<a href="`+srv.URL+`/missing">`+srv.URL+`/missing</a>`) {
+		t.Errorf("failed title fetch should fall back to the URL, got:\n%s", got)
+	}
+
+	// A second article linking the same page reuses the cached title.
+	linkifyBareURLs("<p>"+srv.URL+"/page</p>", cfg)
+	if n := hits.Load(); n != 2 {
+		t.Errorf("expected 2 fetches (one per distinct URL), got %d", n)
+	}
+}
+
+func TestConvertArticleToMarkdown_Autolink(t *testing.T) {
+	html := linkifyBareURLs(`<p>Docs: https://example.com/a_b?q=1 and <a href="https://example.com/x">the spec</a>.</p>`, cliConfig{})
+	md, err := convertArticleToMarkdown(html, markdownOpts{autolinks: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(md, "Docs: <https://example.com/a_b?q=1> and") {
+		t.Errorf("expected autolink, got %q", md)
+	}
+	if !strings.Contains(md, "[the spec](https://example.com/x)") {
+		t.Errorf("titled links should stay inline links, got %q", md)
+	}
+
+	// Without -link-preview, links are converted as they always were.
+	md, err = convertArticleToMarkdown(`<p><a href="https://example.com/a">https://example.com/a</a></p>`, markdownOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md != "[https://example.com/a](https://example.com/a)" {
+		t.Errorf("expected an inline link without -link-preview, got %q", md)
+	}
+}
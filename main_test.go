@@ -1,14 +1,21 @@
 package main
 
 import (
+	"archive/zip"
+	"bytes"
 	"encoding/base64"
+	"errors"
 	"image/color"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -257,7 +264,7 @@ The article needs substantial text to be recognized properly.</p>
 	defer srv.Close()
 
 	opts := optimizeOpts{maxWidth: 800, quality: 60}
-	html, title, src, err := processURL(srv.URL, opts, 5*time.Second, "test-agent", "", 5)
+	html, title, src, err := processURL(srv.URL, cliConfig{opts: opts, timeout: 5 * time.Second, userAgent: "test-agent", concurrency: 5}, "")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -291,7 +298,7 @@ this is the main article content region of the page.</p>
 	defer srv.Close()
 
 	opts := optimizeOpts{maxWidth: 800, quality: 60}
-	_, title, _, err := processURL(srv.URL, opts, 5*time.Second, "test-agent", "Custom Title", 5)
+	_, title, _, err := processURL(srv.URL, cliConfig{opts: opts, timeout: 5 * time.Second, userAgent: "test-agent", concurrency: 5}, "Custom Title")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -302,12 +309,78 @@ this is the main article content region of the page.</p>
 
 func TestProcessURL_FetchError(t *testing.T) {
 	opts := optimizeOpts{maxWidth: 800, quality: 60}
-	_, _, _, err := processURL("http://localhost:1/nonexistent", opts, 1*time.Second, "test-agent", "", 5)
+	_, _, _, err := processURL("http://localhost:1/nonexistent", cliConfig{opts: opts, timeout: 1 * time.Second, userAgent: "test-agent", concurrency: 5}, "")
 	if err == nil {
 		t.Error("expected error for unreachable URL")
 	}
 }
 
+func TestProcessURL_RetryOnEmpty(t *testing.T) {
+	saved := retryEmptyDelay
+	defer func() { retryEmptyDelay = saved }()
+	retryEmptyDelay = time.Millisecond
+
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if hits.Add(1) == 1 {
+			w.Write([]byte(`<html><head><title>Loading</title></head><body><p>Redirecting...</p></body></html>`))
+			return
+		}
+		w.Write([]byte(makeArticleHTML("Real Article", "The real content arrives on the second request.")))
+	}))
+	defer srv.Close()
+
+	cfg := cliConfig{opts: optimizeOpts{maxWidth: 800, quality: 60}, timeout: 5 * time.Second, userAgent: "test-agent", retryOnEmpty: true}
+	html, _, _, err := processURL(srv.URL, cfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hits.Load() != 2 {
+		t.Errorf("expected exactly one retry, got %d requests", hits.Load())
+	}
+	if !strings.Contains(html, "second request") {
+		t.Error("expected content from the retried fetch")
+	}
+}
+
+func TestProcessURL_NoRetryByDefault(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(`<html><body><p>Redirecting...</p></body></html>`))
+	}))
+	defer srv.Close()
+
+	cfg := cliConfig{opts: optimizeOpts{maxWidth: 800, quality: 60}, timeout: 5 * time.Second, userAgent: "test-agent"}
+	processURL(srv.URL, cfg, "")
+	if hits.Load() != 1 {
+		t.Errorf("expected no retry without -retry-on-empty, got %d requests", hits.Load())
+	}
+}
+
+func TestProcessURL_RetryOnEmptySkipsFetchErrors(t *testing.T) {
+	saved := retryEmptyDelay
+	defer func() { retryEmptyDelay = saved }()
+	retryEmptyDelay = time.Millisecond
+
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.WriteHeader(500)
+	}))
+	defer srv.Close()
+
+	cfg := cliConfig{timeout: 5 * time.Second, userAgent: "test-agent", retryOnEmpty: true}
+	if _, _, _, err := processURL(srv.URL, cfg, ""); err == nil {
+		t.Fatal("expected error for HTTP 500")
+	}
+	if hits.Load() != 1 {
+		t.Errorf("network/HTTP errors should not trigger the empty retry, got %d requests", hits.Load())
+	}
+}
+
 func TestRun_SingleURLMode(t *testing.T) {
 	pageHTML := `<!DOCTYPE html>
 <html><head><title>Run Test</title></head><body>
@@ -509,6 +582,184 @@ func TestRun_EpubMode_MultipleArticles(t *testing.T) {
 	}
 }
 
+func TestRun_EpubMode_Separate(t *testing.T) {
+	articlesByPath := map[string]string{
+		"/1": makeArticleHTML("Article One", "First separate article."),
+		"/2": makeArticleHTML("Article Two", "Second separate article."),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(articlesByPath[r.URL.Path]))
+	}))
+	defer srv.Close()
+
+	outDir := filepath.Join(t.TempDir(), "books")
+	cfg := cliConfig{
+		opts:       optimizeOpts{maxWidth: 800, quality: 60},
+		output:     outDir,
+		format:     "epub",
+		coverStyle: "none",
+		separate:   true,
+		timeout:    5 * time.Second,
+		userAgent:  "test-agent",
+		args:       []string{srv.URL + "/1", srv.URL + "/2"},
+	}
+	if err := run(cfg); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"article-one.epub", "article-two.epub"} {
+		if _, err := os.Stat(filepath.Join(outDir, name)); err != nil {
+			t.Errorf("expected %s: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "index.html")); err == nil {
+		t.Error("index.html should only be written with -output-index")
+	}
+
+	cfg.outputIndex = true
+	if err := run(cfg); err != nil {
+		t.Fatal(err)
+	}
+	index, err := os.ReadFile(filepath.Join(outDir, "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<a href="article-one.epub">Article One</a>`,
+		`<a href="article-two.epub">Article Two</a>`,
+		srv.URL + "/2",
+	} {
+		if !strings.Contains(string(index), want) {
+			t.Errorf("index.html missing %q:\n%s", want, index)
+		}
+	}
+}
+
+func TestRun_EpubMode_SeparateEmbedBudget(t *testing.T) {
+	png := makePNG(300, 200, color.RGBA{30, 90, 160, 255})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, ".png") {
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(png)
+			return
+		}
+		w.Write([]byte(makeArticleHTML("Story "+r.URL.Path[1:], `Text <img src="/pic.png" alt="pic">`)))
+	}))
+	defer srv.Close()
+
+	// images returns the image sizes in each epub under dir.
+	images := func(dir string) map[string][]int64 {
+		books, _ := filepath.Glob(filepath.Join(dir, "*.epub"))
+		got := map[string][]int64{}
+		for _, book := range books {
+			zr, err := zip.OpenReader(book)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got[filepath.Base(book)] = nil
+			for _, f := range zr.File {
+				if strings.HasPrefix(f.Name, "EPUB/images/ch") {
+					got[filepath.Base(book)] = append(got[filepath.Base(book)], int64(f.UncompressedSize64))
+				}
+			}
+			zr.Close()
+		}
+		return got
+	}
+	build := func(separate bool, budget int64) map[string][]int64 {
+		dir := t.TempDir()
+		cfg := cliConfig{
+			opts:          optimizeOpts{maxWidth: 800, quality: 60},
+			output:        filepath.Join(dir, "book.epub"),
+			format:        "epub",
+			coverStyle:    "none",
+			maxEmbedBytes: budget,
+			timeout:       5 * time.Second,
+			args:          []string{srv.URL + "/a", srv.URL + "/b"},
+		}
+		if separate {
+			cfg.separate, cfg.output = true, dir
+		}
+		if err := run(cfg); err != nil {
+			t.Fatal(err)
+		}
+		return images(dir)
+	}
+
+	size := build(false, 0)["book.epub"][0]
+	budget := size * 3 / 2 // room for one image, not two
+	if got := build(false, budget)["book.epub"]; len(got) != 1 {
+		t.Errorf("combined book should keep one image within the budget, got %v", got)
+	}
+	got := build(true, budget)
+	if len(got) != 2 {
+		t.Fatalf("expected two separate books, got %v", got)
+	}
+	for book, imgs := range got {
+		if len(imgs) != 1 {
+			t.Errorf("%s: each separate book should get the whole budget, got images %v", book, imgs)
+		}
+	}
+}
+
+func TestRun_EpubMode_SeparateRequiresDirectory(t *testing.T) {
+	outFile := filepath.Join(t.TempDir(), "book.epub")
+	if err := os.WriteFile(outFile, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := cliConfig{
+		output:   outFile,
+		format:   "epub",
+		separate: true,
+		args:     []string{"https://example.com"},
+	}
+	err := run(cfg)
+	if err == nil || !strings.Contains(err.Error(), "directory") {
+		t.Errorf("expected directory error, got %v", err)
+	}
+}
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"Hello, World!", "hello-world"},
+		{"  Go 1.24 Release Notes  ", "go-1-24-release-notes"},
+		{"Café Crème", "café-crème"},
+		{"!!!", "article"},
+		{"", "article"},
+		{"東京の天気 — Ελληνικά", "東京の天気-ελληνικά"},
+		{"🎉 🎉", "article"},
+		{"Ünïcödé: ½ Cup", "ünïcödé-cup"},
+	}
+	for _, tt := range tests {
+		if got := slugify(tt.in, false); got != tt.want {
+			t.Errorf("slugify(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSlugify_ASCII(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"Café Crème", "cafe-creme"},
+		{"Ünïcödé: ½ Cup", "unicode-cup"},
+		{"Straße in Łódź", "strasse-in-lodz"},
+		{"Ærøskøbing Œuvre", "aeroskobing-oeuvre"},
+		{"東京の天気 — Tokyo", "tokyo"},
+		{"Ελληνικά", "article"},
+	}
+	for _, tt := range tests {
+		if got := slugify(tt.in, true); got != tt.want {
+			t.Errorf("slugify(%q, true) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+	if got := foldASCII("Crème brûlée, 東京"); got != "Creme brulee, 東京" {
+		t.Errorf("foldASCII should leave other scripts alone, got %q", got)
+	}
+}
+
 func TestRun_EpubMode_NoOutput(t *testing.T) {
 	cfg := cliConfig{
 		opts:   optimizeOpts{maxWidth: 800, quality: 60},
@@ -953,13 +1204,38 @@ func TestCollectAllURLs_NoStdin(t *testing.T) {
 	}
 }
 
+func TestCollectAllURLs_Dedupe(t *testing.T) {
+	cfg := cliConfig{
+		args: []string{
+			"https://example.com/a?id=1",
+			"https://Example.COM/a?id=1&utm_source=feed#comments",
+			"https://example.com/b",
+			"https://example.com/a?id=2",
+			"https://example.com/b?fbclid=xyz",
+		},
+	}
+	urls, _, err := collectAllURLs(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"https://example.com/a?id=1", "https://example.com/b", "https://example.com/a?id=2"}
+	if !slices.Equal(urls, want) {
+		t.Errorf("got %v, want %v", urls, want)
+	}
+
+	cfg.noDedupe = true
+	if urls, _, _ := collectAllURLs(cfg); len(urls) != 5 {
+		t.Errorf("-no-dedupe should keep all 5 URLs, got %v", urls)
+	}
+}
+
 // TestArticlesToHTML verifies HTML concatenation.
 func TestArticlesToHTML(t *testing.T) {
 	articles := []epubArticle{
 		{HTML: `<html><body><h1>First</h1><p>First article.</p></body></html>`, Title: "First"},
 		{HTML: `<html><body><h1>Second</h1><p>Second article.</p></body></html>`, Title: "Second"},
 	}
-	html, err := articlesToHTML(articles)
+	html, err := articlesToHTML(articles, htmlOpts{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -977,9 +1253,24 @@ func TestArticlesToHTML(t *testing.T) {
 	}
 }
 
+func TestArticlesToHTML_Fragment(t *testing.T) {
+	articles := []epubArticle{
+		{HTML: `<html><body><h1>First</h1><p>First article.</p></body></html>`, Title: "First"},
+		{HTML: `<html><body><h1>Second</h1><p>Second article.</p></body></html>`, Title: "Second"},
+	}
+	html, err := articlesToHTML(articles, htmlOpts{fragment: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "<h1>First</h1><p>First article.</p>\n<hr>\n<h1>Second</h1><p>Second article.</p>\n"
+	if html != want {
+		t.Errorf("got %q, want %q", html, want)
+	}
+}
+
 // TestArticlesToHTML_Empty verifies error for empty input.
 func TestArticlesToHTML_Empty(t *testing.T) {
-	_, err := articlesToHTML(nil)
+	_, err := articlesToHTML(nil, htmlOpts{})
 	if err == nil {
 		t.Error("expected error for empty articles")
 	}
@@ -990,7 +1281,7 @@ func TestArticlesToHTML_Single(t *testing.T) {
 	articles := []epubArticle{
 		{HTML: `<html><body><h1>Solo</h1><p>Single article.</p></body></html>`, Title: "Solo"},
 	}
-	html, err := articlesToHTML(articles)
+	html, err := articlesToHTML(articles, htmlOpts{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1002,6 +1293,46 @@ func TestArticlesToHTML_Single(t *testing.T) {
 	}
 }
 
+// TestArticlesToHTML_PageBreaks verifies page-break separators and the
+// optional title page.
+func TestArticlesToHTML_PageBreaks(t *testing.T) {
+	articles := []epubArticle{
+		{HTML: `<html><body><h1>First</h1><p>First article.</p></body></html>`, Title: "First"},
+		{HTML: `<html><body><h1>Second</h1><p>Second article.</p></body></html>`, Title: "Second"},
+	}
+	html, err := articlesToHTML(articles, htmlOpts{pageBreaks: true, titlePage: true, title: "Weekend Reads"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(html, "page-break-before: always") != 2 {
+		t.Errorf("expected page breaks after title page and between articles, got:\n%s", html)
+	}
+	if strings.Contains(html, "<hr>") {
+		t.Error("page breaks should replace <hr> separators")
+	}
+	if !strings.Contains(html, `<section class="title-page">`) || !strings.Contains(html, "<h1>Weekend Reads</h1>") {
+		t.Error("expected title page with the book title")
+	}
+	if strings.Index(html, "title-page") > strings.Index(html, "First article.") {
+		t.Error("title page should come before the articles")
+	}
+}
+
+// TestArticlesToHTML_PageBreaksSingle verifies a single article gets no
+// page breaks or title page.
+func TestArticlesToHTML_PageBreaksSingle(t *testing.T) {
+	articles := []epubArticle{
+		{HTML: `<html><body><h1>Solo</h1><p>Single article.</p></body></html>`, Title: "Solo"},
+	}
+	html, err := articlesToHTML(articles, htmlOpts{pageBreaks: true, titlePage: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(html, "page-break") || strings.Contains(html, "title-page") {
+		t.Error("single article output should not contain page breaks or a title page")
+	}
+}
+
 // TestRun_FormatHTMLSingleURL verifies -format html with single URL.
 func TestRun_FormatHTMLSingleURL(t *testing.T) {
 	pageHTML := makeArticleHTML("Single HTML Test", "Content for single HTML.")
@@ -1098,3 +1429,459 @@ func TestMain(m *testing.M) {
 	os.Setenv("DECKLE_TEST_ALLOW_LOCAL", "1")
 	os.Exit(m.Run())
 }
+
+func TestSplitList(t *testing.T) {
+	got := splitList(" pullquote, caption ,,note ")
+	want := []string{"pullquote", "caption", "note"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("splitList = %q, want %q", got, want)
+	}
+	if splitList("") != nil {
+		t.Error("empty input should yield nil")
+	}
+}
+
+func TestSortArticles(t *testing.T) {
+	day := func(d int) *time.Time {
+		tm := time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC)
+		return &tm
+	}
+	input := []epubArticle{
+		{Title: "banana", PublishedTime: day(3), URL: "https://b.example.com/2"},
+		{Title: "Cherry", URL: "http://A.example.com/z"},
+		{Title: "apple", PublishedTime: day(1), URL: "https://b.example.com/10"},
+		{Title: "date", URL: "https://a.example.com/z?utm_source=x"},
+		{Title: "Apple", PublishedTime: day(3), URL: "https://a.example.com/m"},
+	}
+	titles := func(as []epubArticle) string {
+		var out []string
+		for _, a := range as {
+			out = append(out, a.Title)
+		}
+		return strings.Join(out, ",")
+	}
+
+	tests := []struct {
+		order string
+		want  string
+	}{
+		{"none", "banana,Cherry,apple,date,Apple"},
+		{"", "banana,Cherry,apple,date,Apple"},
+		// Undated articles go last; equal dates and undated keep input order.
+		{"date", "apple,banana,Apple,Cherry,date"},
+		// Case-insensitive; "apple" and "Apple" tie and keep input order.
+		{"title", "apple,Apple,banana,Cherry,date"},
+		// Scheme, host case, and tracking parameters are ignored, so
+		// "Cherry" and "date" tie and keep input order.
+		{"url", "Apple,Cherry,date,apple,banana"},
+		{"reverse", "Apple,date,apple,Cherry,banana"},
+	}
+	for _, tt := range tests {
+		articles := slices.Clone(input)
+		sortArticles(articles, tt.order)
+		if got := titles(articles); got != tt.want {
+			t.Errorf("sortArticles(%q) = %s, want %s", tt.order, got, tt.want)
+		}
+	}
+}
+
+func TestRun_MarkdownSortReverse(t *testing.T) {
+	srv := serveArticles(map[string]string{
+		"/a": makeArticleHTML("First Sorted Article", "<p>Alpha body text.</p>"),
+		"/b": makeArticleHTML("Second Sorted Article", "<p>Beta body text.</p>"),
+	}, nil)
+	defer srv.Close()
+
+	outFile := filepath.Join(t.TempDir(), "sorted.md")
+	cfg := cliConfig{
+		opts:      optimizeOpts{maxWidth: 800, quality: 60},
+		output:    outFile,
+		format:    "markdown",
+		timeout:   5 * time.Second,
+		userAgent: "test-agent",
+		sortOrder: "reverse",
+		args:      []string{srv.URL + "/a", srv.URL + "/b"},
+	}
+	if err := run(cfg); err != nil {
+		t.Fatalf("run() error: %v", err)
+	}
+	data, err := os.ReadFile(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	md := string(data)
+	first, second := strings.Index(md, "First Sorted"), strings.Index(md, "Second Sorted")
+	if first < 0 || second < 0 || second > first {
+		t.Errorf("expected reversed article order, got:\n%s", md)
+	}
+}
+
+func TestRun_UnknownSort(t *testing.T) {
+	err := run(cliConfig{sortOrder: "random", args: []string{"http://example.com"}})
+	if err == nil || !strings.Contains(err.Error(), "unknown sort") {
+		t.Errorf("expected unknown sort error, got %v", err)
+	}
+}
+
+func TestRun_OutputIndexRequiresSeparate(t *testing.T) {
+	for _, cfg := range []cliConfig{
+		{format: "epub", output: filepath.Join(t.TempDir(), "book.epub")},
+		{format: "html"},
+	} {
+		cfg.outputIndex = true
+		cfg.args = []string{"http://example.com"}
+		if err := run(cfg); err == nil || !strings.Contains(err.Error(), "-output-index requires") {
+			t.Errorf("%s: expected -output-index to require -combine=false, got %v", cfg.format, err)
+		}
+	}
+}
+
+func TestRun_UnknownListStyle(t *testing.T) {
+	err := run(cliConfig{listStyle: "table", args: []string{"http://example.com"}})
+	if err == nil || !strings.Contains(err.Error(), "unknown list style") {
+		t.Errorf("expected unknown list style error, got %v", err)
+	}
+}
+
+func TestWriteRunSummary(t *testing.T) {
+	var buf bytes.Buffer
+	writeRunSummary(&buf, 3, nil)
+	if buf.String() != "3/3 converted\n" {
+		t.Errorf("unexpected success summary %q", buf.String())
+	}
+
+	buf.Reset()
+	writeRunSummary(&buf, 3, []urlFailure{
+		{url: "https://a.example/x", err: errors.New("HTTP 404 for https://a.example/x")},
+		{url: "https://b.example/y", err: errors.New("fetch failed: timeout")},
+	})
+	want := "1/3 converted, 2 failed:\n" +
+		"  https://a.example/x: HTTP 404 for https://a.example/x\n" +
+		"  https://b.example/y: fetch failed: timeout\n"
+	if buf.String() != want {
+		t.Errorf("got summary:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestRun_ErrorLog(t *testing.T) {
+	srv := serveArticles(map[string]string{
+		"/ok": makeArticleHTML("Working Article", "<p>Body text.</p>"),
+	}, nil)
+	defer srv.Close()
+
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "errors.tsv")
+	cfg := cliConfig{
+		opts:      optimizeOpts{maxWidth: 800, quality: 60},
+		output:    filepath.Join(dir, "out.md"),
+		format:    "markdown",
+		timeout:   5 * time.Second,
+		userAgent: "test-agent",
+		errorLog:  logFile,
+		args:      []string{srv.URL + "/ok", "http://127.0.0.1:1/gone"},
+	}
+	if err := run(cfg); err != nil {
+		t.Fatalf("run() error: %v", err)
+	}
+	data, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := string(data)
+	if !strings.HasPrefix(line, "http://127.0.0.1:1/gone\tfetch failed:") || strings.Count(line, "\n") != 1 {
+		t.Errorf("expected one tab-separated failure line, got %q", line)
+	}
+}
+
+func TestResolveConcurrency(t *testing.T) {
+	tests := []struct {
+		in             string
+		fetch, workers int
+	}{
+		{"5", 5, 0},
+		{"1", 1, 0},
+		{"-3", 1, 0},
+		{"auto", autoFetchConcurrency, runtime.NumCPU()},
+		{"0", autoFetchConcurrency, runtime.NumCPU()},
+	}
+	for _, tt := range tests {
+		fetch, workers, err := resolveConcurrency(tt.in)
+		if err != nil || fetch != tt.fetch || workers != tt.workers {
+			t.Errorf("resolveConcurrency(%q) = %d, %d, %v; want %d, %d", tt.in, fetch, workers, err, tt.fetch, tt.workers)
+		}
+	}
+	if _, _, err := resolveConcurrency("lots"); err == nil {
+		t.Error("expected error for non-numeric concurrency")
+	}
+}
+
+func TestRun_InvalidConcurrency(t *testing.T) {
+	err := run(cliConfig{concSpec: "lots", args: []string{"http://example.com"}})
+	if err == nil || !strings.Contains(err.Error(), "-concurrency") {
+		t.Errorf("expected an invalid -concurrency error, got %v", err)
+	}
+}
+
+func TestRun_BadCropRatio(t *testing.T) {
+	err := run(cliConfig{format: "html", opts: optimizeOpts{cropRatio: 0.5}, args: []string{"http://example.com"}})
+	if err == nil || !strings.Contains(err.Error(), "-crop-banners") {
+		t.Errorf("expected -crop-banners error, got %v", err)
+	}
+}
+
+func TestRun_RateLimit(t *testing.T) {
+	var mu sync.Mutex
+	var hits []time.Time
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits = append(hits, time.Now())
+		mu.Unlock()
+		if strings.HasPrefix(r.URL.Path, "/img/") {
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(makePNG(10, 10, color.White))
+			return
+		}
+		w.Write([]byte(makeArticleHTML("Paced "+r.URL.Path, `<img src="/img`+r.URL.Path+`.png">`)))
+	}))
+	defer srv.Close()
+
+	cfg := cliConfig{
+		opts:        optimizeOpts{maxWidth: 800, quality: 60},
+		output:      filepath.Join(t.TempDir(), "out.html"),
+		format:      "html",
+		concurrency: 4,
+		rateLimit:   20,
+		timeout:     5 * time.Second,
+		args:        []string{srv.URL + "/a", srv.URL + "/b", srv.URL + "/c"},
+	}
+	if err := run(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if fetchLimiter != nil {
+		t.Error("fetchLimiter should be reset after run")
+	}
+	if len(hits) != 6 {
+		t.Fatalf("expected 3 pages and 3 images fetched, got %d requests", len(hits))
+	}
+	slices.SortFunc(hits, func(a, b time.Time) int { return a.Compare(b) })
+	for i := 1; i < len(hits); i++ {
+		// 20/s is one request per 50ms; allow for timer jitter.
+		if gap := hits[i].Sub(hits[i-1]); gap < 40*time.Millisecond {
+			t.Errorf("requests %d and %d only %v apart", i-1, i, gap)
+		}
+	}
+
+	if err := run(cliConfig{format: "html", rateLimit: -1, args: []string{"http://example.com"}}); err == nil || !strings.Contains(err.Error(), "-rate-limit") {
+		t.Errorf("expected -rate-limit error, got %v", err)
+	}
+}
+
+func TestRun_FetchDelay(t *testing.T) {
+	var mu sync.Mutex
+	var hits []time.Time
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits = append(hits, time.Now())
+		mu.Unlock()
+		w.Write([]byte(makeArticleHTML("Delayed "+r.URL.Path, "Body.")))
+	}))
+	defer srv.Close()
+
+	cfg := cliConfig{
+		output:      filepath.Join(t.TempDir(), "out.md"),
+		concurrency: 1,
+		fetchDelay:  60 * time.Millisecond,
+		timeout:     5 * time.Second,
+		args:        []string{srv.URL + "/a", srv.URL + "/b", srv.URL + "/c"},
+	}
+	start := time.Now()
+	if err := run(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 3 {
+		t.Fatalf("expected 3 fetches, got %d", len(hits))
+	}
+	if first := hits[0].Sub(start); first >= 60*time.Millisecond {
+		t.Errorf("first fetch should not be delayed, started after %v", first)
+	}
+	for i := 1; i < len(hits); i++ {
+		if gap := hits[i].Sub(hits[i-1]); gap < 60*time.Millisecond {
+			t.Errorf("fetches %d and %d only %v apart", i-1, i, gap)
+		}
+	}
+
+	cfg.fetchDelay = -time.Second
+	if err := run(cfg); err == nil || !strings.Contains(err.Error(), "-fetch-delay") {
+		t.Errorf("expected -fetch-delay error, got %v", err)
+	}
+}
+
+func TestRun_ExtractOnly(t *testing.T) {
+	var imageHits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/img/") {
+			imageHits.Add(1)
+			w.Write(makePNG(10, 10, color.White))
+			return
+		}
+		w.Write([]byte(makeArticleHTML("Raw "+r.URL.Path, `<h2>Part</h2> See https://example.com/x <img src="/img/a.png">`)))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out.epub")
+	cfg := cliConfig{
+		format:      "epub",
+		extractOnly: true,
+		linkPreview: true,
+		output:      out,
+		timeout:     5 * time.Second,
+		args:        []string{srv.URL + "/one"},
+	}
+	if err := run(cfg); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := os.ReadFile(out)
+	if !strings.Contains(string(got), "<h2>Part</h2>") || !strings.Contains(string(got), `src="`+srv.URL+`/img/a.png"`) {
+		t.Errorf("expected untouched headings and image URLs, got:\n%s", got)
+	}
+	if strings.Contains(string(got), "<h1>") || strings.Contains(string(got), `href="https://example.com/x"`) {
+		t.Errorf("no title, byline, or links should be added, got:\n%s", got)
+	}
+	if imageHits.Load() != 0 {
+		t.Error("images should not be fetched")
+	}
+
+	cfg.args = []string{srv.URL + "/one", srv.URL + "/two"}
+	if err := run(cfg); err != nil {
+		t.Fatal(err)
+	}
+	got, _ = os.ReadFile(out)
+	if !strings.Contains(string(got), "<!-- "+srv.URL+"/two -->") || strings.Count(string(got), "<h2>Part</h2>") != 2 {
+		t.Errorf("expected both articles labeled by URL, got:\n%s", got)
+	}
+}
+
+func TestRun_ExcerptOnly(t *testing.T) {
+	var imageHits atomic.Int32
+	body := `<p>First sentence here. Second sentence here. Third sentence here. Fourth sentence is omitted.</p>
+<p>More text that should not appear in the excerpt at all.</p>
+<img src="/img/photo.png">`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/img/") {
+			imageHits.Add(1)
+			w.Write(makePNG(10, 10, color.White))
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(makeArticleHTML("Triage Article", body)))
+	}))
+	defer srv.Close()
+
+	outFile := filepath.Join(t.TempDir(), "excerpts.html")
+	cfg := cliConfig{
+		opts:        optimizeOpts{maxWidth: 800, quality: 60},
+		output:      outFile,
+		format:      "html",
+		timeout:     5 * time.Second,
+		userAgent:   "test-agent",
+		excerptOnly: true,
+		args:        []string{srv.URL + "/a", srv.URL + "/b"},
+	}
+	if err := run(cfg); err != nil {
+		t.Fatalf("run() error: %v", err)
+	}
+	data, err := os.ReadFile(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(data)
+	if !strings.Contains(out, "<h1>Triage Article</h1>") {
+		t.Error("expected article title heading")
+	}
+	if !strings.Contains(out, "Third sentence here.") || strings.Contains(out, "Fourth sentence") || strings.Contains(out, "More text") {
+		t.Errorf("expected a three-sentence excerpt, got:\n%s", out)
+	}
+	if strings.Contains(out, "<img") || imageHits.Load() != 0 {
+		t.Error("excerpt-only mode should not fetch or include images")
+	}
+}
+
+func TestSelectTitle(t *testing.T) {
+	meta := articleMeta{Title: "Readability", PageTitle: "Page", OGTitle: "OG", H1Title: "Heading"}
+	tests := []struct{ from, want string }{
+		{"", "Readability"},
+		{"auto", "Readability"},
+		{"h1", "Heading"},
+		{"meta", "Page"},
+		{"og", "OG"},
+	}
+	for _, tt := range tests {
+		if got := selectTitle(meta, tt.from); got != tt.want {
+			t.Errorf("selectTitle(%q) = %q, want %q", tt.from, got, tt.want)
+		}
+	}
+	if got := selectTitle(articleMeta{Title: "Fallback"}, "og"); got != "Fallback" {
+		t.Errorf("missing source should fall back, got %q", got)
+	}
+}
+
+func TestProcessURL_TitleFromH1(t *testing.T) {
+	page := `<html><head><title>SEO Title Stuffed With Keywords</title></head><body><article>
+<h1>The Actual Headline</h1>
+<p>This article has enough content for readability to identify it as the main
+article. More text is needed to ensure the algorithm works correctly.</p>
+<p>Second paragraph with additional content to boost the text density and keep
+the readability heuristics happy with this test document.</p>
+</article></body></html>`
+	srv := serveArticles(map[string]string{"/a": page}, nil)
+	defer srv.Close()
+
+	cfg := cliConfig{opts: optimizeOpts{maxWidth: 800, quality: 60}, timeout: 5 * time.Second, userAgent: "test-agent", titleFrom: "h1"}
+	_, title, _, err := processURL(srv.URL+"/a", cfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if title != "The Actual Headline" {
+		t.Errorf("title = %q, want the <h1> text", title)
+	}
+}
+
+func TestRun_UnknownTitleFrom(t *testing.T) {
+	err := run(cliConfig{titleFrom: "body", args: []string{"http://example.com"}})
+	if err == nil || !strings.Contains(err.Error(), "unknown title source") {
+		t.Errorf("expected unknown title source error, got %v", err)
+	}
+}
+
+func TestRun_KeepHTMLComments(t *testing.T) {
+	body := `Marked <!-- schema:begin -->content for downstream tooling.<!-- schema:end -->`
+	srv := serveArticles(map[string]string{"/a": makeArticleHTML("Comments Kept", body)}, nil)
+	defer srv.Close()
+
+	for _, format := range []string{"html", "markdown"} {
+		for _, keep := range []bool{false, true} {
+			outFile := filepath.Join(t.TempDir(), "out")
+			cfg := cliConfig{
+				opts:         optimizeOpts{maxWidth: 800, quality: 60},
+				output:       outFile,
+				format:       format,
+				timeout:      5 * time.Second,
+				userAgent:    "test-agent",
+				keepComments: keep,
+				args:         []string{srv.URL + "/a"},
+			}
+			if err := run(cfg); err != nil {
+				t.Fatalf("run() error: %v", err)
+			}
+			data, _ := os.ReadFile(outFile)
+			got := strings.Contains(string(data), "<!-- schema:begin -->")
+			if want := keep && format == "html"; got != want {
+				t.Errorf("format=%s keep=%v: comment present = %v, want %v", format, keep, got, want)
+			}
+			if strings.Contains(string(data), "data-deckle-comment") {
+				t.Errorf("format=%s keep=%v: marker spans leaked into output", format, keep)
+			}
+		}
+	}
+}
@@ -2,7 +2,12 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io/fs"
+	"os"
+	"regexp"
 	"strings"
 	"sync"
 
@@ -13,62 +18,239 @@ import (
 	"golang.org/x/net/html"
 )
 
+// Matches an absolute URL that is valid as a CommonMark autolink.
+var autolinkRe = regexp.MustCompile(`^https?://[^\s<>]+$`)
+
+// Matches a list item line that can start a list inside a paragraph: a
+// bullet, or an ordered item numbered 1.
+var listMarkerRe = regexp.MustCompile(`^\s+(?:[-*+]|0*1[.)])(?:\s|$)`)
+
+// markdownOpts are the flags that change how articles convert to markdown.
+type markdownOpts struct {
+	autolinks bool // links whose text is their own URL become <url> (-link-preview)
+	cites     bool // <cite> titles become italic (-retain-blockquote-citation)
+
+	// listStyle is the -list-style for definition lists: "bold" or ""
+	// (term in bold, each definition on its own line), "colon" (Pandoc and
+	// PHP Markdown Extra "Term / : definition" syntax), or "list" (a
+	// bullet per term).
+	listStyle string
+}
+
 var (
-	mdConverter     *converter.Converter
-	mdConverterOnce sync.Once
+	mdConverters   = map[markdownOpts]*converter.Converter{}
+	mdConvertersMu sync.Mutex
 )
 
-// getMarkdownConverter returns a shared converter that replaces base64 data URI
-// images with alt-text placeholders instead of embedding the raw data URI.
-func getMarkdownConverter() *converter.Converter {
-	mdConverterOnce.Do(func() {
-		mdConverter = converter.NewConverter(
-			converter.WithPlugins(
-				base.NewBasePlugin(),
-				commonmark.NewCommonmarkPlugin(),
-			),
+// getMarkdownConverter returns a shared converter for opts that replaces
+// base64 data URI images with alt-text placeholders instead of embedding
+// the raw data URI.
+func getMarkdownConverter(opts markdownOpts) *converter.Converter {
+	mdConvertersMu.Lock()
+	defer mdConvertersMu.Unlock()
+	if mdConverter := mdConverters[opts]; mdConverter != nil {
+		return mdConverter
+	}
+	mdConverter := converter.NewConverter(
+		converter.WithPlugins(
+			base.NewBasePlugin(),
+			commonmark.NewCommonmarkPlugin(),
+		),
+	)
+	// Override img rendering: strip data URIs, keep plain URLs as-is.
+	// PriorityEarly (100) runs before the commonmark plugin (PriorityStandard 500).
+	mdConverter.Register.RendererFor("img", converter.TagTypeInline,
+		func(ctx converter.Context, w converter.Writer, n *html.Node) converter.RenderStatus {
+			src := dom.GetAttributeOr(n, "src", "")
+			if !strings.HasPrefix(src, "data:") {
+				// Regular URL – let the default commonmark handler take over.
+				return converter.RenderTryNext
+			}
+			// Data URI: emit alt text as a placeholder, or nothing.
+			alt := dom.GetAttributeOr(n, "alt", "")
+			alt = strings.TrimSpace(alt)
+			if alt != "" {
+				w.WriteString("[Image: " + alt + "]")
+			}
+			return converter.RenderSuccess
+		},
+		converter.PriorityEarly,
+	)
+	if opts.cites {
+		// Titles of cited works are italic, as browsers show them.
+		mdConverter.Register.RendererFor("cite", converter.TagTypeInline,
+			func(ctx converter.Context, w converter.Writer, n *html.Node) converter.RenderStatus {
+				var buf bytes.Buffer
+				ctx.RenderChildNodes(ctx, &buf, n)
+				if text := strings.TrimSpace(buf.String()); text != "" {
+					w.WriteString("*" + text + "*")
+				}
+				return converter.RenderSuccess
+			},
+			converter.PriorityEarly,
 		)
-		// Override img rendering: strip data URIs, keep plain URLs as-is.
-		// PriorityEarly (100) runs before the commonmark plugin (PriorityStandard 500).
-		mdConverter.Register.RendererFor("img", converter.TagTypeInline,
+	}
+	if opts.autolinks {
+		// Links whose text is their own URL become CommonMark
+		// autolinks rather than [url](url).
+		mdConverter.Register.RendererFor("a", converter.TagTypeInline,
 			func(ctx converter.Context, w converter.Writer, n *html.Node) converter.RenderStatus {
-				src := dom.GetAttributeOr(n, "src", "")
-				if !strings.HasPrefix(src, "data:") {
-					// Regular URL – let the default commonmark handler take over.
+				href := dom.GetAttributeOr(n, "href", "")
+				if !autolinkRe.MatchString(href) || dom.CollectText(n) != href {
 					return converter.RenderTryNext
 				}
-				// Data URI: emit alt text as a placeholder, or nothing.
-				alt := dom.GetAttributeOr(n, "alt", "")
-				alt = strings.TrimSpace(alt)
-				if alt != "" {
-					w.WriteString("[Image: " + alt + "]")
-				}
+				w.WriteString("<" + href + ">")
 				return converter.RenderSuccess
 			},
 			converter.PriorityEarly,
 		)
-	})
+	}
+	mdConverter.Register.RendererFor("dl", converter.TagTypeBlock,
+		func(ctx converter.Context, w converter.Writer, n *html.Node) converter.RenderStatus {
+			w.WriteString("\n\n" + renderDefinitionList(ctx, n, opts.listStyle) + "\n\n")
+			return converter.RenderSuccess
+		},
+		converter.PriorityEarly,
+	)
+	mdConverters[opts] = mdConverter
 	return mdConverter
 }
 
+// definition is one group of a definition list: its terms and definitions,
+// rendered as markdown.
+type definition struct {
+	terms, defs []string
+}
+
+// renderDefinitionList writes the <dt>/<dd> groups of dl in style.
+func renderDefinitionList(ctx converter.Context, dl *html.Node, style string) string {
+	var groups []definition
+	var collect func(n *html.Node)
+	collect = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode {
+				continue
+			}
+			if c.Data == "div" { // <dl><div><dt>..</dt><dd>..</dd></div></dl>
+				collect(c)
+				continue
+			}
+			if c.Data != "dt" && c.Data != "dd" {
+				continue
+			}
+			var buf bytes.Buffer
+			ctx.RenderChildNodes(ctx, &buf, c)
+			text := strings.TrimSpace(buf.String())
+			if text == "" {
+				continue
+			}
+			if c.Data == "dt" {
+				if len(groups) == 0 || len(groups[len(groups)-1].defs) > 0 {
+					groups = append(groups, definition{})
+				}
+				groups[len(groups)-1].terms = append(groups[len(groups)-1].terms, text)
+			} else {
+				if len(groups) == 0 {
+					groups = append(groups, definition{})
+				}
+				groups[len(groups)-1].defs = append(groups[len(groups)-1].defs, text)
+			}
+		}
+	}
+	collect(dl)
+
+	var out []string
+	for _, g := range groups {
+		var b strings.Builder
+		switch style {
+		case "colon":
+			for _, t := range g.terms {
+				b.WriteString(strings.ReplaceAll(t, "\n", " ") + "\n")
+			}
+			for i, d := range g.defs {
+				if i > 0 {
+					b.WriteString("\n")
+				}
+				b.WriteString(":   " + indentLines(d, "    "))
+			}
+		case "list":
+			var terms []string
+			for _, t := range g.terms {
+				terms = append(terms, "**"+strings.ReplaceAll(t, "\n", " ")+"**")
+			}
+			b.WriteString("- " + strings.Join(terms, ", "))
+			switch {
+			case len(g.defs) == 1 && !strings.Contains(g.defs[0], "\n\n"):
+				b.WriteString(": " + indentLines(g.defs[0], "  "))
+			default:
+				for _, d := range g.defs {
+					b.WriteString("\n  - " + indentLines(d, "    "))
+				}
+			}
+		default:
+			var lines []string
+			for _, t := range g.terms {
+				lines = append(lines, "**"+strings.ReplaceAll(t, "\n", " ")+"**")
+			}
+			lines = append(lines, g.defs...)
+			b.WriteString(strings.Join(lines, "  \n"))
+		}
+		out = append(out, strings.TrimRight(b.String(), "\n"))
+	}
+	if style == "list" {
+		return strings.Join(out, "\n")
+	}
+	return strings.Join(out, "\n\n")
+}
+
+// indentLines indents every line of s after the first with indent.
+func indentLines(s, indent string) string {
+	return strings.ReplaceAll(s, "\n", "\n"+indent)
+}
+
+// tightenNestedLists removes the blank lines the converter leaves between a
+// list item and the list nested in it, which would make every enclosing
+// list loose (its items wrapped in <p>), and empties whitespace-only lines.
+// Only lists that may interrupt a paragraph are pulled up: an ordered list
+// starting at another number would read as a continuation of the item.
+func tightenNestedLists(md string) string {
+	lines := strings.Split(md, "\n")
+	out := lines[:0]
+	fence := false
+	for i, line := range lines {
+		if t := strings.TrimSpace(line); strings.HasPrefix(t, "```") || strings.HasPrefix(t, "~~~") {
+			fence = !fence
+		}
+		if !fence && line != "" && strings.TrimSpace(line) == "" {
+			if len(out) > 0 && strings.TrimSpace(out[len(out)-1]) != "" &&
+				i+1 < len(lines) && listMarkerRe.MatchString(lines[i+1]) {
+				continue
+			}
+			line = ""
+		}
+		out = append(out, line)
+	}
+	return strings.Join(out, "\n")
+}
+
 // convertArticleToMarkdown converts a processed article HTML string (as
 // returned by processURL or renderFullHTML) to CommonMark Markdown.
 // Base64 data URI images are replaced by alt-text placeholders.
-func convertArticleToMarkdown(htmlStr string) (string, error) {
+func convertArticleToMarkdown(htmlStr string, opts markdownOpts) (string, error) {
 	body := extractBodyContent(htmlStr)
-	md, err := getMarkdownConverter().ConvertString(body)
+	md, err := getMarkdownConverter(opts).ConvertString(body)
 	if err != nil {
 		return "", fmt.Errorf("markdown conversion: %w", err)
 	}
-	return strings.TrimSpace(md), nil
+	return strings.TrimSpace(tightenNestedLists(md)), nil
 }
 
 // articlesToMarkdown converts a slice of processed articles to a single
 // Markdown document. Articles are separated by a horizontal rule.
-func articlesToMarkdown(articles []epubArticle) (string, error) {
+func articlesToMarkdown(articles []epubArticle, opts markdownOpts) (string, error) {
 	var parts []string
 	for _, a := range articles {
-		md, err := convertArticleToMarkdown(a.HTML)
+		md, err := convertArticleToMarkdown(a.HTML, opts)
 		if err != nil {
 			fmt.Fprintf(logOut, "Warning: markdown conversion failed for %q: %v\n", a.Title, err)
 			continue
@@ -81,3 +263,37 @@ func articlesToMarkdown(articles []epubArticle) (string, error) {
 	return strings.Join(parts, "\n\n---\n\n"), nil
 }
 
+// appendMarkdown adds md to the end of the markdown file at path (-append),
+// after a "---" rule, creating the file if it doesn't exist. The rule gets
+// a blank line before it whatever the file ends with, since a "---" right
+// under a line of text would turn that line into a heading.
+func appendMarkdown(path, md string) error {
+	existing, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) || err == nil && strings.TrimSpace(string(existing)) == "" {
+		return writeOutput(path, md+"\n")
+	}
+	if err != nil {
+		return fmt.Errorf("reading %s to append: %w", path, err)
+	}
+	sep := "\n---\n\n"
+	switch {
+	case strings.HasSuffix(string(existing), "\n\n"):
+		sep = sep[1:]
+	case !strings.HasSuffix(string(existing), "\n"):
+		sep = "\n" + sep
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return fmt.Errorf("writing output: %w", err)
+	}
+	if _, err := f.WriteString(sep + md + "\n"); err != nil {
+		f.Close()
+		return fmt.Errorf("writing output: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("writing output: %w", err)
+	}
+	return nil
+}
+
@@ -16,7 +16,7 @@ import (
 
 func TestConvertArticleToMarkdown_Basic(t *testing.T) {
 	html := `<html><body><h1>Hello World</h1><p>A simple paragraph.</p></body></html>`
-	md, err := convertArticleToMarkdown(html)
+	md, err := convertArticleToMarkdown(html, markdownOpts{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -30,7 +30,7 @@ func TestConvertArticleToMarkdown_Basic(t *testing.T) {
 
 func TestConvertArticleToMarkdown_Headings(t *testing.T) {
 	html := `<html><body><h1>Title</h1><h2>Section</h2><h3>Sub</h3><p>text</p></body></html>`
-	md, err := convertArticleToMarkdown(html)
+	md, err := convertArticleToMarkdown(html, markdownOpts{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -47,7 +47,7 @@ func TestConvertArticleToMarkdown_Headings(t *testing.T) {
 
 func TestConvertArticleToMarkdown_Links(t *testing.T) {
 	html := `<html><body><p>See <a href="https://example.com">example</a> for details.</p></body></html>`
-	md, err := convertArticleToMarkdown(html)
+	md, err := convertArticleToMarkdown(html, markdownOpts{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -58,7 +58,7 @@ func TestConvertArticleToMarkdown_Links(t *testing.T) {
 
 func TestConvertArticleToMarkdown_RegularImageURLs(t *testing.T) {
 	html := `<html><body><img src="https://example.com/photo.jpg" alt="A photo"></body></html>`
-	md, err := convertArticleToMarkdown(html)
+	md, err := convertArticleToMarkdown(html, markdownOpts{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -72,7 +72,7 @@ func TestConvertArticleToMarkdown_DataURIImages(t *testing.T) {
 	uri := "data:image/png;base64," + base64.StdEncoding.EncodeToString(imgData)
 	html := `<html><body><img src="` + uri + `" alt="a diagram"><p>text</p></body></html>`
 
-	md, err := convertArticleToMarkdown(html)
+	md, err := convertArticleToMarkdown(html, markdownOpts{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -91,7 +91,7 @@ func TestConvertArticleToMarkdown_DataURINoAlt(t *testing.T) {
 	uri := "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(imgData)
 	html := `<html><body><p>before</p><img src="` + uri + `"><p>after</p></body></html>`
 
-	md, err := convertArticleToMarkdown(html)
+	md, err := convertArticleToMarkdown(html, markdownOpts{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -110,7 +110,7 @@ func TestConvertArticleToMarkdown_CodeBlock(t *testing.T) {
 	html := `<html><body><pre><code>func hello() {
     fmt.Println("hi")
 }</code></pre></body></html>`
-	md, err := convertArticleToMarkdown(html)
+	md, err := convertArticleToMarkdown(html, markdownOpts{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -124,7 +124,7 @@ func TestConvertArticleToMarkdown_CodeBlock(t *testing.T) {
 
 func TestConvertArticleToMarkdown_Blockquote(t *testing.T) {
 	html := `<html><body><blockquote><p>A famous quote.</p></blockquote></body></html>`
-	md, err := convertArticleToMarkdown(html)
+	md, err := convertArticleToMarkdown(html, markdownOpts{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -133,12 +133,52 @@ func TestConvertArticleToMarkdown_Blockquote(t *testing.T) {
 	}
 }
 
+func TestConvertArticleToMarkdown_NestedLists(t *testing.T) {
+	html := `<ul><li>One<ul><li>One A</li><li>One B<ol><li>Deep</li></ol></li></ul></li><li>Two</li></ul>` +
+		`<ol><li>Three<ol start="3"><li>Third</li></ol></li></ol>` +
+		"<pre><code>x\n  \n  - y</code></pre>"
+	md, err := convertArticleToMarkdown(html, markdownOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "- One\n  - One A\n  - One B\n    1. Deep\n- Two"
+	if !strings.Contains(md, want) {
+		t.Errorf("expected a tight two-level list %q, got:\n%s", want, md)
+	}
+	// A list numbered from 3 can't interrupt the item's text, so it keeps
+	// its blank line; code keeps its whitespace.
+	if !strings.Contains(md, "1. Three\n\n   3. Third") {
+		t.Errorf("expected the blank line before an ordered list not starting at 1, got:\n%s", md)
+	}
+	if !strings.Contains(md, "x\n  \n  - y") {
+		t.Errorf("code block whitespace should be untouched, got:\n%s", md)
+	}
+}
+
+func TestConvertArticleToMarkdown_DefinitionLists(t *testing.T) {
+	html := `<dl><dt>Term</dt><dd>First meaning.</dd><dd>Second <em>meaning</em>.</dd>` +
+		`<div><dt>Alias</dt><dt>Other</dt><dd>Shared.</dd></div></dl><p>After.</p>`
+	for style, want := range map[string]string{
+		"bold":  "**Term**  \nFirst meaning.  \nSecond *meaning*.\n\n**Alias**  \n**Other**  \nShared.\n\nAfter.",
+		"colon": "Term\n:   First meaning.\n:   Second *meaning*.\n\nAlias\nOther\n:   Shared.\n\nAfter.",
+		"list":  "- **Term**\n  - First meaning.\n  - Second *meaning*.\n- **Alias**, **Other**: Shared.\n\nAfter.",
+	} {
+		md, err := convertArticleToMarkdown(html, markdownOpts{listStyle: style})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if md != want {
+			t.Errorf("%s style:\n got %q\nwant %q", style, md, want)
+		}
+	}
+}
+
 func TestConvertArticleToMarkdown_StripsStyleTags(t *testing.T) {
 	// renderFullHTML wraps content in a full HTML doc with inline <style>.
 	// convertArticleToMarkdown should use extractBodyContent to avoid
 	// rendering CSS rules as text.
 	html := `<html><head><style>body { color: red; }</style></head><body><h1>Title</h1><p>Content.</p></body></html>`
-	md, err := convertArticleToMarkdown(html)
+	md, err := convertArticleToMarkdown(html, markdownOpts{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -157,7 +197,7 @@ func TestArticlesToMarkdown_Separator(t *testing.T) {
 		{HTML: `<html><body><h1>First</h1><p>Article one.</p></body></html>`, Title: "First"},
 		{HTML: `<html><body><h1>Second</h1><p>Article two.</p></body></html>`, Title: "Second"},
 	}
-	md, err := articlesToMarkdown(articles)
+	md, err := articlesToMarkdown(articles, markdownOpts{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -172,8 +212,34 @@ func TestArticlesToMarkdown_Separator(t *testing.T) {
 	}
 }
 
+func TestAppendMarkdown(t *testing.T) {
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		name, existing, want string
+	}{
+		{"missing", "", "# New\n"},
+		{"blank", " \n", "# New\n"},
+		{"no trailing newline", "Old text", "Old text\n\n---\n\n# New\n"},
+		{"one newline", "Old text\n", "Old text\n\n---\n\n# New\n"},
+		{"blank line", "Old text\n\n", "Old text\n\n---\n\n# New\n"},
+	} {
+		path := filepath.Join(dir, strings.ReplaceAll(tc.name, " ", "-")+".md")
+		if tc.existing != "" {
+			if err := os.WriteFile(path, []byte(tc.existing), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := appendMarkdown(path, "# New"); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := os.ReadFile(path); string(got) != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
 func TestArticlesToMarkdown_Empty(t *testing.T) {
-	_, err := articlesToMarkdown(nil)
+	_, err := articlesToMarkdown(nil, markdownOpts{})
 	if err == nil {
 		t.Error("expected error for empty articles slice")
 	}
@@ -0,0 +1,94 @@
+// Machine-readable sidecar describing a built epub (-metadata-json).
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	gohtml "html"
+	"os"
+	"strings"
+	"time"
+)
+
+// bookMetadata is the -metadata-json document.
+type bookMetadata struct {
+	Title    string            `json:"title"`
+	EPUB     *fileMetadata     `json:"epub,omitempty"` // combined output
+	Articles []articleMetadata `json:"articles"`
+}
+
+// articleMetadata describes one article included in the output.
+type articleMetadata struct {
+	Title  string        `json:"title"`
+	URL    string        `json:"url"`
+	Byline string        `json:"byline,omitempty"`
+	Site   string        `json:"site,omitempty"`
+	Date   *time.Time    `json:"date,omitempty"`
+	Words  int           `json:"words"`
+	EPUB   *fileMetadata `json:"epub,omitempty"` // -combine=false: this article's own epub
+}
+
+// fileMetadata is an output file's path and size in bytes.
+type fileMetadata struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// statFile returns the path and current size of an output file.
+func statFile(path string) (*fileMetadata, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return &fileMetadata{Path: path, Size: info.Size()}, nil
+}
+
+// articleWordCount counts the words in an article's visible body text.
+func articleWordCount(htmlDoc string) int {
+	text := gohtml.UnescapeString(stripTagsRe.ReplaceAllString(extractBodyContent(htmlDoc), " "))
+	return len(strings.Fields(text))
+}
+
+// writeMetadataJSON writes the metadata for the built epub to filename.
+// combined is the single output epub; with -combine=false it is empty and
+// separate holds each article's epub path instead.
+func writeMetadataJSON(filename, title string, articles []epubArticle, combined string, separate []string) error {
+	meta := bookMetadata{Title: title, Articles: make([]articleMetadata, len(articles))}
+	for i, a := range articles {
+		html, err := a.loadHTML()
+		if err != nil {
+			return fmt.Errorf("writing metadata: %w", err)
+		}
+		meta.Articles[i] = articleMetadata{
+			Title:  a.Title,
+			URL:    a.URL,
+			Byline: a.Byline,
+			Site:   a.SiteName,
+			Date:   a.PublishedTime,
+			Words:  articleWordCount(html),
+		}
+		if i < len(separate) {
+			f, err := statFile(separate[i])
+			if err != nil {
+				return fmt.Errorf("writing metadata: %w", err)
+			}
+			meta.Articles[i].EPUB = f
+		}
+	}
+	if combined != "" {
+		f, err := statFile(combined)
+		if err != nil {
+			return fmt.Errorf("writing metadata: %w", err)
+		}
+		meta.EPUB = f
+	}
+
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filename, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("writing metadata: %w", err)
+	}
+	return nil
+}
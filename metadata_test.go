@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestArticleWordCount(t *testing.T) {
+	doc := `<html><head><title>Not counted</title></head><body><h1>Two words</h1><p>Fish&amp;chips, <em>three</em>   more.</p></body></html>`
+	if n := articleWordCount(doc); n != 5 {
+		t.Errorf("expected 5 words, got %d", n)
+	}
+}
+
+func TestRun_MetadataJSON(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := strings.Replace(makeArticleHTML("Story "+r.URL.Path[1:], "Body text."),
+			"<head>", `<head><meta name="author" content="Ann Writer"><meta property="og:site_name" content="The Site">`, 1)
+		w.Write([]byte(page))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	metaPath := filepath.Join(dir, "book.json")
+	cfg := cliConfig{
+		format:        "epub",
+		output:        filepath.Join(dir, "book.epub"),
+		coverStyle:    "none",
+		titleOverride: "Reading",
+		timeout:       5 * time.Second,
+		metadataJSON:  metaPath,
+		args:          []string{srv.URL + "/a", "http://127.0.0.1:1/gone", srv.URL + "/b"},
+	}
+	if err := run(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	var meta bookMetadata
+	data, err := os.ReadFile(metaPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		t.Fatal(err)
+	}
+	info, _ := os.Stat(cfg.output)
+	if meta.Title != "Reading" || meta.EPUB == nil || meta.EPUB.Path != cfg.output || meta.EPUB.Size != info.Size() {
+		t.Errorf("unexpected book metadata: %+v", meta)
+	}
+	if len(meta.Articles) != 2 {
+		t.Fatalf("expected the 2 converted articles, got %d", len(meta.Articles))
+	}
+	a := meta.Articles[1]
+	if a.Title != "Story b" || a.URL != srv.URL+"/b" || a.Byline != "Ann Writer" || a.Site != "The Site" || a.Words < 30 || a.EPUB != nil {
+		t.Errorf("unexpected article metadata: %+v", a)
+	}
+
+	// One epub per article: each article lists its own file.
+	cfg.separate = true
+	cfg.output = filepath.Join(dir, "split")
+	if err := run(cfg); err != nil {
+		t.Fatal(err)
+	}
+	data, _ = os.ReadFile(metaPath)
+	meta = bookMetadata{}
+	json.Unmarshal(data, &meta)
+	if meta.EPUB != nil || len(meta.Articles) != 2 || meta.Articles[0].EPUB == nil ||
+		meta.Articles[0].EPUB.Path != filepath.Join(dir, "split", "story-a.epub") {
+		t.Errorf("unexpected separate-mode metadata:\n%s", data)
+	}
+
+	err = run(cliConfig{format: "html", metadataJSON: metaPath, args: []string{srv.URL + "/a"}})
+	if err == nil || !strings.Contains(err.Error(), "-metadata-json") {
+		t.Errorf("expected -metadata-json format error, got %v", err)
+	}
+}
@@ -0,0 +1,130 @@
+// Raw page cache (-cache-dir, -fetch-only).
+// With -cache-dir every page is stored as it was fetched, and later runs
+// with the same directory read it from there instead of the network: a
+// -fetch-only run can download a reading list on a good connection, and a
+// later run builds the output offline. Images are not cached.
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// pageCache is the cache for the current run, or nil when -cache-dir is
+// unset. All its methods are no-ops on nil.
+var pageCache *diskPageCache
+
+// diskPageCache keeps fetched pages in a directory, one file per URL: the
+// final URL after redirects on the first line, then the body.
+type diskPageCache struct {
+	dir     string
+	refresh bool // ignore cached pages but store fresh ones (-fetch-only)
+}
+
+// path returns the cache file for rawURL.
+func (c *diskPageCache) path(rawURL string) string {
+	sum := sha256.Sum256([]byte(rawURL))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:16])+".html")
+}
+
+// get returns the cached body of rawURL and the URL it was finally served
+// from, if the page is in the cache.
+func (c *diskPageCache) get(rawURL string) ([]byte, *url.URL, bool) {
+	if c == nil || c.refresh {
+		return nil, nil, false
+	}
+	data, err := os.ReadFile(c.path(rawURL))
+	if err != nil {
+		return nil, nil, false
+	}
+	line, body, ok := bytes.Cut(data, []byte("\n"))
+	if !ok {
+		return nil, nil, false
+	}
+	final, err := url.Parse(string(line))
+	if err != nil || final.Host == "" {
+		return nil, nil, false
+	}
+	return body, final, true
+}
+
+// put stores body, fetched from rawURL and served from final. It writes to
+// a temporary file and renames it into place, so a reader never sees a
+// partial page. Errors are logged, not returned: the page was fetched.
+func (c *diskPageCache) put(rawURL string, final *url.URL, body []byte) {
+	if c == nil {
+		return
+	}
+	f, err := os.CreateTemp(c.dir, ".page-*")
+	if err == nil {
+		_, err = f.Write(append([]byte(final.String()+"\n"), body...))
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = os.Rename(f.Name(), c.path(rawURL))
+		}
+		if err != nil {
+			os.Remove(f.Name())
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(logOut, "Warning: could not cache %s: %v\n", rawURL, err)
+	}
+}
+
+// drop removes rawURL's page from the cache, so the next fetch goes to the
+// network and a bad page isn't served again on later runs.
+func (c *diskPageCache) drop(rawURL string) {
+	if c == nil {
+		return
+	}
+	if err := os.Remove(c.path(rawURL)); err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(logOut, "Warning: could not drop cached %s: %v\n", rawURL, err)
+	}
+}
+
+// runFetchOnly fetches every URL into the page cache without extracting
+// anything or writing output (-fetch-only), cfg.concurrency at a time.
+func runFetchOnly(cfg cliConfig, urls []string) error {
+	errs := make([]error, len(urls))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, max(cfg.concurrency, 1))
+	for i, rawURL := range urls {
+		wg.Add(1)
+		go func(i int, rawURL string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			_, _, errs[i] = fetchHTML(rawURL, cfg.timeout, cfg.userAgent)
+			progress.articleDone()
+		}(i, rawURL)
+	}
+	wg.Wait()
+
+	var failures []urlFailure
+	for i, err := range errs {
+		if err != nil {
+			failures = append(failures, urlFailure{url: urls[i], err: err})
+		}
+	}
+	if cfg.errorLog != "" {
+		if err := writeErrorLog(cfg.errorLog, failures); err != nil {
+			return fmt.Errorf("writing error log: %w", err)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "%d/%d fetched into %s\n", len(urls)-len(failures), len(urls), cfg.cacheDir)
+	for _, f := range failures {
+		fmt.Fprintf(os.Stderr, "  %s: %v\n", f.url, f.err)
+	}
+	if len(failures) == len(urls) {
+		return fmt.Errorf("no pages fetched")
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"archive/zip"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDiskPageCache(t *testing.T) {
+	c := &diskPageCache{dir: t.TempDir()}
+	if _, _, ok := c.get("https://example.com/a"); ok {
+		t.Fatal("empty cache should miss")
+	}
+	final, _ := url.Parse("https://example.com/final")
+	c.put("https://example.com/a", final, []byte("<p>line one\nline two</p>"))
+	body, got, ok := c.get("https://example.com/a")
+	if !ok || string(body) != "<p>line one\nline two</p>" || got.String() != final.String() {
+		t.Fatalf("get = %q, %v, %v", body, got, ok)
+	}
+	if _, _, ok := (&diskPageCache{dir: c.dir, refresh: true}).get("https://example.com/a"); ok {
+		t.Error("a refreshing cache should not read cached pages")
+	}
+	if entries, _ := os.ReadDir(c.dir); len(entries) != 1 {
+		t.Errorf("expected only the cached page in the directory, got %d entries", len(entries))
+	}
+
+	var nilCache *diskPageCache
+	nilCache.put("https://example.com/a", final, nil)
+	if _, _, ok := nilCache.get("https://example.com/a"); ok {
+		t.Error("nil cache should miss")
+	}
+}
+
+func TestRun_FetchOnlyThenOffline(t *testing.T) {
+	hits := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(makeArticleHTML("Cached Article", "Fetched once, read later.")))
+	}))
+	urls := []string{srv.URL + "/1", srv.URL + "/2"}
+	cacheDir := filepath.Join(t.TempDir(), "cache")
+	out := filepath.Join(t.TempDir(), "book.epub")
+
+	cfg := cliConfig{
+		opts:        optimizeOpts{maxWidth: 800, quality: 60},
+		format:      "epub",
+		timeout:     5 * time.Second,
+		userAgent:   "test-agent",
+		concurrency: 2,
+		cacheDir:    cacheDir,
+		args:        urls,
+	}
+	if err := run(cliConfig{format: "epub", fetchOnly: true, args: urls}); err == nil || !strings.Contains(err.Error(), "-cache-dir") {
+		t.Errorf("-fetch-only without -cache-dir should fail, got %v", err)
+	}
+	fetchCfg := cfg
+	fetchCfg.fetchOnly = true
+	if err := run(fetchCfg); err != nil {
+		t.Fatal(err)
+	}
+	if hits != 2 {
+		t.Fatalf("expected both pages fetched, got %d requests", hits)
+	}
+	if _, err := os.Stat(out); err == nil {
+		t.Error("-fetch-only should not write output")
+	}
+
+	srv.Close() // offline from here on
+	cfg.output = out
+	if err := run(cfg); err != nil {
+		t.Fatalf("building from the cache: %v", err)
+	}
+	zr, err := zip.OpenReader(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	if !strings.Contains(findZipFile(zr, "EPUB/xhtml/article001.xhtml"), "Fetched once, read later.") {
+		t.Error("expected the cached article in the book")
+	}
+}
+
+func TestProcessURL_RetryOnEmptyBypassesCache(t *testing.T) {
+	saved := retryEmptyDelay
+	defer func() { retryEmptyDelay = saved }()
+	retryEmptyDelay = time.Millisecond
+
+	shell := `<html><head><title>Loading</title></head><body><p>Redirecting...</p></body></html>`
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if r.URL.Path == "/shell" {
+			w.Write([]byte(shell))
+			return
+		}
+		w.Write([]byte(makeArticleHTML("Real Article", "The real content comes from the network.")))
+	}))
+	defer srv.Close()
+
+	pageCache = &diskPageCache{dir: t.TempDir()}
+	defer func() { pageCache = nil }()
+	final, _ := url.Parse(srv.URL + "/a")
+	pageCache.put(srv.URL+"/a", final, []byte(shell)) // cached by an earlier run
+
+	cfg := cliConfig{opts: optimizeOpts{maxWidth: 800, quality: 60}, timeout: 5 * time.Second, userAgent: "test-agent", retryOnEmpty: true}
+	html, _, _, err := processURL(srv.URL+"/a", cfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hits.Load() != 1 || !strings.Contains(html, "from the network") {
+		t.Errorf("expected the retry to fetch the page, got %d requests:\n%s", hits.Load(), html)
+	}
+	if body, _, ok := pageCache.get(srv.URL + "/a"); !ok || !strings.Contains(string(body), "from the network") {
+		t.Error("expected the fetched page to replace the cached shell")
+	}
+
+	processURL(srv.URL+"/shell", cfg, "")
+	if hits.Load() != 3 {
+		t.Errorf("expected the shell fetched twice, got %d requests in all", hits.Load())
+	}
+	if _, _, ok := pageCache.get(srv.URL + "/shell"); ok {
+		t.Error("a page that stays nearly empty should not be cached")
+	}
+}
+
+func TestRun_WARCWithCacheDir(t *testing.T) {
+	cfg := cliConfig{format: "html", warcPath: filepath.Join(t.TempDir(), "out.warc"), cacheDir: t.TempDir(), args: []string{"https://example.com/a"}}
+	if err := run(cfg); err == nil || !strings.Contains(err.Error(), "-fetch-only") {
+		t.Errorf("-warc with -cache-dir should require -fetch-only, got %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+// Paywall detection (-skip-paywalled).
+// Paywalled pages tend to extract to a teaser and a "subscribe" pitch that
+// looks like a short article; these heuristics flag them so they can be
+// warned about or skipped.
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// maxTeaserWords is the most words an extraction can have and still count
+// as a teaser for the markup and subscribe-prompt signals.
+const maxTeaserWords = 200
+
+var (
+	// schema.org markup publishers use to tell search engines an article is
+	// gated: "isAccessibleForFree": false (or "False", or the string "false").
+	notFreeRe = regexp.MustCompile(`(?i)"isAccessibleForFree"\s*:\s*"?false"?`)
+	// class or id values of common paywall and registration-wall widgets
+	paywallClassRe = regexp.MustCompile(`(?i)\b(?:class|id)\s*=\s*["'][^"']*\b(?:paywall|pay-wall|regwall|reg-wall|piano-offer|tp-modal|meteredContent|metered-content|subscriber-only|subscribers-only|premium-content|article-locked|locked-content)\b`)
+	// calls to action that end a teaser
+	subscribePromptRe = regexp.MustCompile(`(?i)\b(?:subscribe (?:now|today|to (?:continue|read|keep))|already a (?:subscriber|member)|sign in to (?:continue|read)|log in to (?:continue|read)|(?:subscribers|members)[ -]only|to continue reading|become a (?:subscriber|member))\b`)
+)
+
+// paywallSignals are the heuristics checked by paywallReasons, each named
+// for the warning it produces. A signal gets the raw page and the
+// extracted article HTML and reports whether it sees a paywall. To add a
+// heuristic, append a signal here; any one signal flags the page.
+var paywallSignals = []struct {
+	name string
+	test func(page []byte, content string) bool
+}{
+	{"isAccessibleForFree is false", func(page []byte, _ string) bool {
+		return notFreeRe.Match(page)
+	}},
+	{"paywall markup around short text", func(page []byte, content string) bool {
+		// Widgets like these are often on every page of a site, left
+		// hidden for readers who get in, so the markup alone isn't enough.
+		return articleWordCount(content) <= maxTeaserWords && paywallClassRe.Match(page)
+	}},
+	{"short text with a subscribe prompt", func(_ []byte, content string) bool {
+		return articleWordCount(content) <= maxTeaserWords && subscribePromptRe.MatchString(stripTagsRe.ReplaceAllString(content, " "))
+	}},
+}
+
+// paywallReasons returns the names of the paywallSignals the page and its
+// extracted content trip, or nil if it looks freely readable.
+func paywallReasons(page []byte, content string) []string {
+	var reasons []string
+	for _, s := range paywallSignals {
+		if s.test(page, content) {
+			reasons = append(reasons, s.name)
+		}
+	}
+	return reasons
+}
+
+// checkPaywall warns about a page that looks paywalled, or with skip
+// (-skip-paywalled) returns an error so it is treated as a failed URL.
+func checkPaywall(pageURL string, page []byte, content string, skip bool) error {
+	reasons := paywallReasons(page, content)
+	if len(reasons) == 0 {
+		return nil
+	}
+	if skip {
+		return fmt.Errorf("page looks paywalled (%s); skipped by -skip-paywalled", strings.Join(reasons, ", "))
+	}
+	fmt.Fprintf(logOut, "Warning: %s looks paywalled (%s); the article may be only a teaser\n", pageURL, strings.Join(reasons, ", "))
+	return nil
+}
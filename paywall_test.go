@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPaywallReasons(t *testing.T) {
+	long := "<p>" + strings.Repeat("Plenty of article text here. ", 60) + "</p>"
+	for _, tc := range []struct {
+		name, page, content string
+		want                []string
+	}{
+		{"free", `<html><script type="application/ld+json">{"isAccessibleForFree": true}</script></html>`, long, nil},
+		{"json-ld", `<script type="application/ld+json">{"@type":"NewsArticle","isAccessibleForFree":"False"}</script>`, long,
+			[]string{"isAccessibleForFree is false"}},
+		{"no markup", `<div class="article-body payment-form"></div>`, long, nil},
+		{"markup", `<div id="regwall"></div>`, "<p>The first lines of the story.</p>", []string{"paywall markup around short text"}},
+		{"markup on a full article", `<div class="paywall hidden"></div>`, long, nil},
+		{"teaser", ``, "<p>The first lines of the story.</p><p>Subscribe now to keep reading.</p>",
+			[]string{"short text with a subscribe prompt"}},
+		{"long with prompt", ``, long + "<p>Already a subscriber? Sign in.</p>", nil},
+	} {
+		if got := paywallReasons([]byte(tc.page), tc.content); strings.Join(got, "|") != strings.Join(tc.want, "|") {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestFetchAndExtract_SkipPaywalled(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Replace(makeArticleHTML("Locked", "Teaser text."),
+			"<head>", `<head><script type="application/ld+json">{"isAccessibleForFree": false}</script>`, 1)))
+	}))
+	defer srv.Close()
+
+	var buf bytes.Buffer
+	savedLog := logOut
+	logOut = &buf
+	defer func() { logOut = savedLog }()
+	if _, _, err := fetchAndExtract(srv.URL, cliConfig{timeout: 5 * time.Second}); err != nil {
+		t.Errorf("without -skip-paywalled the page should be kept, got %v", err)
+	}
+	if !strings.Contains(buf.String(), "looks paywalled (isAccessibleForFree is false)") {
+		t.Errorf("expected a paywall warning, got %q", buf.String())
+	}
+	_, _, err := fetchAndExtract(srv.URL, cliConfig{timeout: 5 * time.Second, skipPaywall: true})
+	if err == nil || !strings.Contains(err.Error(), "-skip-paywalled") {
+		t.Errorf("expected a paywall error, got %v", err)
+	}
+}
+
+func TestFetchAndExtract_PaywalledAMP(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/story", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Replace(makeArticleHTML("Story", "Loading."),
+			"<head>", `<head><link rel="amphtml" href="/story/amp">`, 1)))
+	})
+	mux.HandleFunc("/story/amp", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Replace(makeArticleHTML("Story", "The first lines of the story, and then a wall."),
+			"</body>", `<div class="amp-paywall regwall">Log in</div></body>`, 1)))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	cfg := cliConfig{preferAMP: true, skipPaywall: true, timeout: 5 * time.Second}
+	_, _, err := fetchAndExtract(srv.URL+"/story", cfg)
+	if err == nil || !strings.Contains(err.Error(), "paywall markup") {
+		t.Errorf("expected the AMP page's paywall to be caught, got %v", err)
+	}
+}
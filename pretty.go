@@ -0,0 +1,145 @@
+// Pretty-printed HTML output (-prettify).
+// Re-serializes the finished document with one block element per line,
+// indented by nesting, so output can be diffed and edited by hand.
+package main
+
+import (
+	"bytes"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// prettyBlocks are the elements prettifyHTML puts on lines of their own.
+// Everything else is inline and stays on its parent's line.
+var prettyBlocks = map[string]bool{
+	"html": true, "head": true, "body": true, "title": true, "meta": true,
+	"link": true, "style": true, "script": true, "noscript": true,
+	"main": true, "article": true, "section": true, "header": true,
+	"footer": true, "nav": true, "aside": true, "div": true, "p": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"hr": true, "pre": true, "blockquote": true, "figure": true,
+	"figcaption": true, "ul": true, "ol": true, "li": true, "dl": true,
+	"dt": true, "dd": true, "table": true, "caption": true, "colgroup": true,
+	"col": true, "thead": true, "tbody": true, "tfoot": true, "tr": true,
+	"th": true, "td": true, "details": true, "summary": true, "math": true,
+	"svg": true,
+}
+
+// prettyVerbatim are block elements whose content is whitespace-sensitive
+// or not HTML, so they are written exactly as parsed.
+var prettyVerbatim = map[string]bool{
+	"pre": true, "textarea": true, "script": true, "style": true,
+	"math": true, "svg": true,
+}
+
+// prettifyHTML re-serializes an HTML document (or, with fragment, a run of
+// body content) with each block element on its own line, indented two
+// spaces per level. Inline content keeps its spacing apart from leading
+// and trailing whitespace, which the new line breaks replace.
+func prettifyHTML(doc string, fragment bool) (string, error) {
+	var nodes []*html.Node
+	if fragment {
+		body := &html.Node{Type: html.ElementNode, DataAtom: atom.Body, Data: "body"}
+		var err error
+		if nodes, err = html.ParseFragment(strings.NewReader(doc), body); err != nil {
+			return "", err
+		}
+	} else {
+		root, err := html.Parse(strings.NewReader(doc))
+		if err != nil {
+			return "", err
+		}
+		for c := root.FirstChild; c != nil; c = c.NextSibling {
+			nodes = append(nodes, c)
+		}
+	}
+	var p prettyPrinter
+	p.children(nodes, 0)
+	return p.buf.String(), nil
+}
+
+// prettyPrinter accumulates prettifyHTML's output.
+type prettyPrinter struct {
+	buf    bytes.Buffer
+	inline []*html.Node // pending inline siblings, written as one line
+}
+
+// children writes a sibling list at depth, grouping inline runs into lines.
+func (p *prettyPrinter) children(nodes []*html.Node, depth int) {
+	for _, n := range nodes {
+		if isPrettyBlock(n) {
+			p.flush(depth)
+			p.node(n, depth)
+		} else {
+			p.inline = append(p.inline, n)
+		}
+	}
+	p.flush(depth)
+}
+
+// flush writes the pending inline nodes as one line, unless they are only
+// whitespace.
+func (p *prettyPrinter) flush(depth int) {
+	var line bytes.Buffer
+	for _, n := range p.inline {
+		html.Render(&line, n)
+	}
+	p.inline = p.inline[:0]
+	if text := strings.TrimSpace(line.String()); text != "" {
+		p.line(depth, text)
+	}
+}
+
+// node writes a block-level node: on one line when it has no block
+// children (or is verbatim), else as open tag, children, and close tag.
+func (p *prettyPrinter) node(n *html.Node, depth int) {
+	if n.Type == html.DoctypeNode || prettyVerbatim[n.Data] || !hasPrettyBlockChild(n) {
+		var b bytes.Buffer
+		html.Render(&b, n)
+		p.line(depth, strings.TrimSpace(b.String()))
+		return
+	}
+
+	// Render the element without children to get its open and close tags.
+	shell := &html.Node{Type: n.Type, DataAtom: n.DataAtom, Data: n.Data, Namespace: n.Namespace, Attr: n.Attr}
+	var b bytes.Buffer
+	html.Render(&b, shell)
+	open, end, _ := strings.Cut(b.String(), "</")
+	p.line(depth, open)
+	var kids []*html.Node
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		kids = append(kids, c)
+	}
+	p.children(kids, depth+1)
+	p.line(depth, "</"+end)
+}
+
+// line writes s on its own line, indented for depth.
+func (p *prettyPrinter) line(depth int, s string) {
+	p.buf.WriteString(strings.Repeat("  ", depth))
+	p.buf.WriteString(s)
+	p.buf.WriteByte('\n')
+}
+
+// isPrettyBlock reports whether n starts its own line.
+func isPrettyBlock(n *html.Node) bool {
+	switch n.Type {
+	case html.DoctypeNode:
+		return true
+	case html.ElementNode:
+		return prettyBlocks[n.Data]
+	}
+	return false
+}
+
+// hasPrettyBlockChild reports whether any of n's children is a block.
+func hasPrettyBlockChild(n *html.Node) bool {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if isPrettyBlock(c) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPrettifyHTML(t *testing.T) {
+	doc := `<!DOCTYPE html><html><head><title>T</title></head><body><article><h1>Title</h1>` +
+		`<p>Some <b>bold</b> and <a href="/x?a=1&amp;b=2">a link</a>.</p>` +
+		"<pre>line 1\n  line 2</pre><ul><li>One</li><li>Two <em>too</em></li></ul>" +
+		`text after<div>inner</div></article></body></html>`
+	got, err := prettifyHTML(doc, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<!DOCTYPE html>
+<html>
+  <head>
+    <title>T</title>
+  </head>
+  <body>
+    <article>
+      <h1>Title</h1>
+      <p>Some <b>bold</b> and <a href="/x?a=1&amp;b=2">a link</a>.</p>
+      <pre>line 1
+  line 2</pre>
+      <ul>
+        <li>One</li>
+        <li>Two <em>too</em></li>
+      </ul>
+      text after
+      <div>inner</div>
+    </article>
+  </body>
+</html>
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	frag, err := prettifyHTML("<p>One</p>\n\n<blockquote><p>Two</p></blockquote>", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "<p>One</p>\n<blockquote>\n  <p>Two</p>\n</blockquote>\n"; frag != want {
+		t.Errorf("fragment: got %q, want %q", frag, want)
+	}
+	if strings.Contains(frag, "<html>") {
+		t.Error("fragments should not gain a document wrapper")
+	}
+}
@@ -1,13 +1,17 @@
 // Verbose output for deckle.
-// Default: no output except errors. With -v, simple summary lines on stderr.
+// Default: no output except errors and the run's image total. With -v, simple summary lines on stderr.
+// With -progress bar, a redrawn progress bar with percentage and ETA.
 package main
 
 import (
 	"fmt"
 	"io"
 	"net/url"
+	"os"
 	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
 )
 
 // verboseOut is the writer for verbose summary lines. Set to os.Stderr
@@ -18,6 +22,66 @@ var verboseOut io.Writer = io.Discard
 // incremented inside processArticleImages and read after all fetches complete.
 var totalImages atomic.Int64
 
+// imageTotals aggregates image optimization stats across all articles of a
+// run. processArticleImages adds each article's stats; the run functions
+// reset it and report the total.
+var imageTotals imageStatTotals
+
+type imageStatTotals struct {
+	count          atomic.Int64
+	kept           atomic.Int64
+	originalTotal  atomic.Int64
+	optimizedTotal atomic.Int64
+}
+
+func (t *imageStatTotals) add(st stats) {
+	t.count.Add(int64(st.count))
+	t.kept.Add(int64(st.kept))
+	t.originalTotal.Add(st.originalTotal)
+	t.optimizedTotal.Add(st.optimizedTotal)
+}
+
+func (t *imageStatTotals) reset() {
+	t.count.Store(0)
+	t.kept.Store(0)
+	t.originalTotal.Store(0)
+	t.optimizedTotal.Store(0)
+}
+
+// summary returns a line like "Total: optimized 12 images, 4.0MB → 1.0MB
+// (75% smaller)", noting any small images kept as-is, or "" if no images
+// were optimized or kept.
+func (t *imageStatTotals) summary() string {
+	n, orig, opt := t.count.Load(), t.originalTotal.Load(), t.optimizedTotal.Load()
+	kept := ""
+	if k := t.kept.Load(); k > 0 {
+		kept = fmt.Sprintf("; kept %d small %s as-is", k, plural(k, "image"))
+	}
+	if n == 0 {
+		if kept == "" {
+			return ""
+		}
+		return "Total: optimized 0 images" + kept
+	}
+	change := ""
+	if orig > 0 {
+		if pct := 100 * (orig - opt) / orig; pct >= 0 {
+			change = fmt.Sprintf(" (%d%% smaller)", pct)
+		} else {
+			change = fmt.Sprintf(" (%d%% larger)", -pct)
+		}
+	}
+	return fmt.Sprintf("Total: optimized %d %s, %s → %s%s%s", n, plural(n, "image"), humanSize(orig), humanSize(opt), change, kept)
+}
+
+// plural returns noun, with an "s" unless n is 1.
+func plural(n int64, noun string) string {
+	if n == 1 {
+		return noun
+	}
+	return noun + "s"
+}
+
 // vprintf writes a formatted line to verboseOut when -v is active.
 func vprintf(format string, args ...any) {
 	fmt.Fprintf(verboseOut, format, args...)
@@ -37,3 +101,107 @@ func shortURL(rawURL string) string {
 	}
 	return display
 }
+
+// progress is the -progress bar tracker for the current run, or nil when
+// the bar is disabled. All its methods are no-ops on nil.
+var progress *progressTracker
+
+// progressBarWidth is the number of cells in the drawn bar.
+const progressBarWidth = 20
+
+// progressTracker draws a one-line bar with percentage and ETA for article
+// downloads, plus a running image count. On a terminal the line is redrawn
+// in place; otherwise only the final line is written. Safe for concurrent use.
+type progressTracker struct {
+	mu           sync.Mutex
+	w            io.Writer
+	tty          bool
+	now          func() time.Time
+	start        time.Time
+	articles     int
+	articlesDone int
+	images       int
+	imagesDone   int
+	lastLen      int
+}
+
+func newProgressTracker(w io.Writer, tty bool, articles int) *progressTracker {
+	return &progressTracker{w: w, tty: tty, now: time.Now, start: time.Now(), articles: articles}
+}
+
+// isTerminal reports whether f is a character device (an interactive terminal).
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func (p *progressTracker) articleDone() {
+	p.update(func() { p.articlesDone++ })
+}
+
+func (p *progressTracker) addImages(n int) {
+	p.update(func() { p.images += n })
+}
+
+func (p *progressTracker) imageDone() {
+	p.update(func() { p.imagesDone++ })
+}
+
+func (p *progressTracker) update(fn func()) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fn()
+	if p.tty {
+		p.draw()
+	}
+}
+
+// draw rewrites the current line. Callers must hold p.mu.
+func (p *progressTracker) draw() {
+	line := p.render()
+	pad := ""
+	if n := p.lastLen - len(line); n > 0 {
+		pad = strings.Repeat(" ", n)
+	}
+	fmt.Fprintf(p.w, "\r%s%s", line, pad)
+	p.lastLen = len(line)
+}
+
+// finish writes the final state of the bar and ends the line.
+func (p *progressTracker) finish() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.tty {
+		p.draw()
+		fmt.Fprintln(p.w)
+	} else {
+		fmt.Fprintln(p.w, p.render())
+	}
+}
+
+// render formats the progress line. Callers must hold p.mu.
+func (p *progressTracker) render() string {
+	pct := 0
+	if p.articles > 0 {
+		pct = p.articlesDone * 100 / p.articles
+	}
+	filled := pct * progressBarWidth / 100
+	line := fmt.Sprintf("Articles [%s%s] %d/%d %3d%%",
+		strings.Repeat("#", filled), strings.Repeat("-", progressBarWidth-filled),
+		p.articlesDone, p.articles, pct)
+	if p.images > 0 {
+		line += fmt.Sprintf(" | Images %d/%d", p.imagesDone, p.images)
+	}
+	if p.articlesDone > 0 && p.articlesDone < p.articles {
+		elapsed := p.now().Sub(p.start)
+		eta := elapsed / time.Duration(p.articlesDone) * time.Duration(p.articles-p.articlesDone)
+		line += " | ETA " + eta.Round(time.Second).String()
+	}
+	return line
+}
@@ -8,8 +8,11 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -205,6 +208,15 @@ readability to extract it as the main article. More filler text here.</p>
 		args:      []string{srv.URL},
 	}
 
+	// The run-wide total goes to stderr, with or without -v.
+	stderr, err := os.CreateTemp(t.TempDir(), "stderr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	savedStderr := os.Stderr
+	os.Stderr = stderr
+	defer func() { os.Stderr = savedStderr }()
+
 	output := withVerboseCapture(func() {
 		if err := run(cfg); err != nil {
 			t.Fatal(err)
@@ -222,6 +234,39 @@ readability to extract it as the main article. More filler text here.</p>
 	if strings.Contains(output, "Optimized") {
 		t.Errorf("verbose output should not contain per-image 'Optimized' detail, got:\n%s", output)
 	}
+	// Only the run-wide total
+	total, _ := os.ReadFile(stderr.Name())
+	if !regexp.MustCompile(`Total: optimized 1 image, [\d.]+KB → [\d.]+KB \(\d+% smaller\)`).Match(total) {
+		t.Errorf("expected run-wide optimization total on stderr, got:\n%s", total)
+	}
+}
+
+func TestImageStatTotals(t *testing.T) {
+	var totals imageStatTotals
+	if got := totals.summary(); got != "" {
+		t.Errorf("expected no summary without images, got %q", got)
+	}
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			totals.add(stats{count: 2, originalTotal: 1000, optimizedTotal: 250})
+		}()
+	}
+	wg.Wait()
+	if got, want := totals.summary(), "Total: optimized 8 images, 3.9KB → 1000.0B (75% smaller)"; got != want {
+		t.Errorf("summary = %q, want %q", got, want)
+	}
+	totals.reset()
+	totals.add(stats{count: 1, originalTotal: 100, optimizedTotal: 150})
+	if got, want := totals.summary(), "Total: optimized 1 image, 100.0B → 150.0B (50% larger)"; got != want {
+		t.Errorf("summary = %q, want %q", got, want)
+	}
+	totals.add(stats{kept: 3})
+	if got, want := totals.summary(), "Total: optimized 1 image, 100.0B → 150.0B (50% larger); kept 3 small images as-is"; got != want {
+		t.Errorf("summary = %q, want %q", got, want)
+	}
 }
 
 func TestVerbose_ExternalImages(t *testing.T) {
@@ -406,3 +451,75 @@ it as the main article. More text here for the algorithm.</p>
 		t.Errorf("expected no output in default (silent) mode, got: %q", buf.String())
 	}
 }
+
+func TestProgressTracker_Render(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	p := newProgressTracker(io.Discard, false, 4)
+	p.start = start
+	p.now = func() time.Time { return start.Add(10 * time.Second) }
+
+	if got, want := p.render(), "Articles [--------------------] 0/4   0%"; got != want {
+		t.Errorf("render() = %q, want %q", got, want)
+	}
+
+	p.articleDone()
+	p.addImages(3)
+	p.imageDone()
+	want := "Articles [#####---------------] 1/4  25% | Images 1/3 | ETA 30s"
+	if got := p.render(); got != want {
+		t.Errorf("render() = %q, want %q", got, want)
+	}
+
+	for i := 0; i < 3; i++ {
+		p.articleDone()
+	}
+	if got := p.render(); !strings.HasPrefix(got, "Articles [####################] 4/4 100%") || strings.Contains(got, "ETA") {
+		t.Errorf("completed bar = %q", got)
+	}
+}
+
+func TestProgressTracker_TTYRedraws(t *testing.T) {
+	var buf bytes.Buffer
+	p := newProgressTracker(&buf, true, 2)
+	p.articleDone()
+	p.articleDone()
+	p.finish()
+	out := buf.String()
+	if strings.Count(out, "\r") != 3 || !strings.HasSuffix(out, "100%\n") {
+		t.Errorf("expected in-place redraws ending in a newline, got %q", out)
+	}
+}
+
+func TestProgressTracker_NonTTYWritesFinalLineOnly(t *testing.T) {
+	var buf bytes.Buffer
+	p := newProgressTracker(&buf, false, 2)
+	p.articleDone()
+	p.articleDone()
+	p.finish()
+	if got := buf.String(); strings.Contains(got, "\r") || strings.Count(got, "\n") != 1 {
+		t.Errorf("non-TTY output should be a single plain line, got %q", got)
+	}
+}
+
+func TestProgressTracker_ConcurrentAndNilSafe(t *testing.T) {
+	var nilTracker *progressTracker
+	nilTracker.articleDone()
+	nilTracker.addImages(1)
+	nilTracker.finish()
+
+	p := newProgressTracker(io.Discard, true, 50)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.addImages(2)
+			p.imageDone()
+			p.articleDone()
+		}()
+	}
+	wg.Wait()
+	if p.articlesDone != 50 || p.images != 100 || p.imagesDone != 50 {
+		t.Errorf("lost updates: articles=%d images=%d imagesDone=%d", p.articlesDone, p.images, p.imagesDone)
+	}
+}
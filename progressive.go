@@ -0,0 +1,60 @@
+// Progressive JPEG encoding (-progressive).
+// The standard library only writes baseline JPEGs, so progressive ones are
+// written with jpegli, a libjpeg-compatible encoder run as WebAssembly (no
+// cgo). It is set up like image/jpeg, with 4:2:0 chroma and the JPEG
+// spec's quantization tables, so -quality means the same for both; the
+// optimized Huffman tables of each scan usually make the file smaller.
+package main
+
+import (
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"io"
+
+	"github.com/gen2brain/jpegli"
+)
+
+// encodeJPEG writes img as a JPEG at quality, progressive or baseline.
+func encodeJPEG(w io.Writer, img image.Image, quality int, progressive bool) error {
+	if progressive {
+		return encodeProgressiveJPEG(w, img, quality)
+	}
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+}
+
+// encodeProgressiveJPEG writes img as a progressive JPEG at quality (1-100,
+// on the same scale as image/jpeg). Gray images are written with one
+// component; everything else as YCbCr.
+func encodeProgressiveJPEG(w io.Writer, img image.Image, quality int) error {
+	return jpegli.Encode(w, packedImage(img), &jpegli.EncodingOptions{
+		Quality:              min(max(quality, 1), 100),
+		ChromaSubsampling:    image.YCbCrSubsampleRatio420,
+		ProgressiveLevel:     2,
+		OptimizeCoding:       true,
+		AdaptiveQuantization: true,
+		StandardQuantTables:  true,
+	})
+}
+
+// packedImage returns img as a *image.Gray or *image.RGBA whose pixels
+// start at the origin with no row padding, the layout jpegli reads.
+func packedImage(img image.Image) image.Image {
+	b := img.Bounds()
+	switch m := img.(type) {
+	case *image.Gray:
+		if b.Min == (image.Point{}) && m.Stride == b.Dx() {
+			return m
+		}
+		dst := image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(dst, dst.Bounds(), m, b.Min, draw.Src)
+		return dst
+	case *image.RGBA:
+		if b.Min == (image.Point{}) && m.Stride == 4*b.Dx() {
+			return m
+		}
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+	return dst
+}
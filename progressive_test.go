@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/gen2brain/jpegli"
+)
+
+// gradientImage makes a w×h image with smooth color gradients and a few
+// hard edges, so every coefficient band has something to code.
+func gradientImage(w, h int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			c := color.NRGBA{uint8(x * 255 / w), uint8(y * 255 / h), uint8((x + y) % 256), 0xff}
+			if (x/10+y/10)%2 == 0 {
+				c.B = 0xff - c.B
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+// psnr returns the luma peak signal-to-noise ratio of b against a, in dB.
+func psnr(a, b image.Image) float64 {
+	ga, gb := toGrayscale(a), toGrayscale(b)
+	var sum float64
+	for i := range ga.Pix {
+		d := float64(ga.Pix[i]) - float64(gb.Pix[i])
+		sum += d * d
+	}
+	if sum == 0 {
+		return math.Inf(1)
+	}
+	return 10 * math.Log10(255*255/(sum/float64(len(ga.Pix))))
+}
+
+func TestEncodeProgressiveJPEG(t *testing.T) {
+	for _, size := range []image.Point{{1, 1}, {37, 23}, {200, 120}, {64, 64}} {
+		src := gradientImage(size.X, size.Y)
+		for _, img := range []image.Image{src, toGrayscale(src)} {
+			var buf bytes.Buffer
+			if err := encodeProgressiveJPEG(&buf, img, 85); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Contains(buf.Bytes(), []byte{0xff, 0xc2}) {
+				t.Errorf("%v: expected a progressive (SOF2) frame", size)
+			}
+			dec, err := jpeg.Decode(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatalf("%v %T: decoding progressive output: %v", size, img, err)
+			}
+			if dec.Bounds().Size() != size {
+				t.Errorf("%v: decoded size %v", size, dec.Bounds().Size())
+			}
+			if _, gray := img.(*image.Gray); gray != (dec.ColorModel() == color.GrayModel) {
+				t.Errorf("%v %T: decoded color model %v", size, img, dec.ColorModel())
+			}
+			if p := psnr(img, dec); p < 30 {
+				t.Errorf("%v %T: PSNR %.1f dB, want at least 30", size, img, p)
+			}
+		}
+	}
+}
+
+func TestEncodeProgressiveJPEG_Decoders(t *testing.T) {
+	// image/jpeg and libjpeg-style decoding (jpegli's decoder) must agree on
+	// the progressive output, including for a sub-image with a row stride.
+	src := gradientImage(300, 200)
+	for _, img := range []image.Image{src, src.SubImage(image.Rect(17, 9, 250, 181)), toGrayscale(src)} {
+		var buf bytes.Buffer
+		if err := encodeProgressiveJPEG(&buf, img, 75); err != nil {
+			t.Fatal(err)
+		}
+		goDec, err := jpeg.Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("image/jpeg: %v", err)
+		}
+		libDec, err := jpegli.Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("jpegli: %v", err)
+		}
+		if goDec.Bounds().Size() != img.Bounds().Size() || libDec.Bounds().Size() != img.Bounds().Size() {
+			t.Errorf("%v: decoded sizes %v and %v", img.Bounds(), goDec.Bounds(), libDec.Bounds())
+			continue
+		}
+		if p := psnr(goDec, libDec); p < 40 {
+			t.Errorf("%v: decoders differ, PSNR %.1f dB between them", img.Bounds(), p)
+		}
+		if p := psnr(img, goDec); p < 30 {
+			t.Errorf("%v: PSNR %.1f dB against the source, want at least 30", img.Bounds(), p)
+		}
+	}
+}
+
+func TestEncodeProgressiveJPEG_ComparableToBaseline(t *testing.T) {
+	src := gradientImage(400, 300)
+	for _, q := range []int{20, 60, 90} {
+		var base, prog bytes.Buffer
+		jpeg.Encode(&base, src, &jpeg.Options{Quality: q})
+		if err := encodeProgressiveJPEG(&prog, src, q); err != nil {
+			t.Fatal(err)
+		}
+		dec, err := jpeg.Decode(bytes.NewReader(prog.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		baseDec, _ := jpeg.Decode(bytes.NewReader(base.Bytes()))
+		if p, bp := psnr(src, dec), psnr(src, baseDec); p < bp-1 {
+			t.Errorf("quality %d: progressive PSNR %.1f dB well below baseline %.1f dB", q, p, bp)
+		}
+		if prog.Len() > base.Len()*11/10 {
+			t.Errorf("quality %d: progressive %d bytes, baseline %d", q, prog.Len(), base.Len())
+		}
+	}
+}
+
+func TestEncodeImage_Progressive(t *testing.T) {
+	opts := optimizeOpts{maxWidth: 800, quality: 80, progressive: true}
+	uri, n := encodeImage(gradientImage(120, 80), opts)
+	if n == 0 || !strings.HasPrefix(uri, "data:image/jpeg;base64,") {
+		t.Fatalf("expected a JPEG data URI, got %.40q (%d bytes)", uri, n)
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(uri, "data:image/jpeg;base64,"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte{0xff, 0xc2}) {
+		t.Error("-progressive should produce a progressive JPEG")
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(data)); err != nil {
+		t.Errorf("output should decode: %v", err)
+	}
+}
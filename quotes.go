@@ -0,0 +1,190 @@
+// Blockquote citations (-retain-blockquote-citation).
+// Readability drops a quote's <footer> attribution, and a trailing
+// "— Name" line stays inside the quote, so markdown renders it as part of
+// the quoted text. These move the attribution to a source line after the
+// quote, linked to the quote's cite URL when it has one.
+package main
+
+import (
+	"bytes"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var (
+	// Matches a whole (non-nested) <blockquote> element.
+	blockquoteRe = regexp.MustCompile(`(?is)<blockquote\b[^>]*>.*?</blockquote>`)
+	// Matches <footer> open and close tags.
+	footerTagRe = regexp.MustCompile(`(?i)<(/?)footer\b`)
+)
+
+// quoteFooterAttr marks a blockquote's <footer>, which protectQuoteFooters
+// turns into a <div> so readability keeps it.
+const quoteFooterAttr = "data-deckle-quote-footer"
+
+// attributionDashes are the characters that open an attribution line. A
+// hyphen is left out: a paragraph starting with one is more often a list
+// item or a negative number than an attribution.
+const attributionDashes = "—–―"
+
+// protectQuoteFooters renames <footer> elements inside blockquotes to
+// marked <div>s before extraction; readability removes footers as page
+// furniture. retainQuoteCitations picks the markers up afterwards.
+func protectQuoteFooters(page []byte) []byte {
+	return blockquoteRe.ReplaceAllFunc(page, func(q []byte) []byte {
+		return footerTagRe.ReplaceAllFunc(q, func(tag []byte) []byte {
+			if tag[1] == '/' {
+				return []byte("</div")
+			}
+			return []byte("<div " + quoteFooterAttr + `="1"`)
+		})
+	})
+}
+
+// retainQuoteCitations moves each blockquote's attribution (a <footer>, or a
+// last paragraph starting with a dash) to a <p class="quote-source"> after
+// the quote. When the quote has an http(s) cite URL, the attribution links
+// to it; a quote with a cite URL but no attribution gets a source line
+// naming the URL's host.
+func retainQuoteCitations(content string) string {
+	if !strings.Contains(content, "<blockquote") {
+		return content
+	}
+	body := &html.Node{Type: html.ElementNode, DataAtom: atom.Body, Data: "body"}
+	nodes, err := html.ParseFragment(strings.NewReader(content), body)
+	if err != nil {
+		return content
+	}
+	for _, n := range nodes {
+		body.AppendChild(n)
+	}
+
+	var quotes []*html.Node
+	var find func(n *html.Node)
+	find = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.ElementNode && c.DataAtom == atom.Blockquote {
+				quotes = append(quotes, c)
+			}
+			find(c)
+		}
+	}
+	find(body)
+	for _, q := range quotes {
+		citeQuote(q)
+	}
+
+	var buf bytes.Buffer
+	for c := body.FirstChild; c != nil; c = c.NextSibling {
+		html.Render(&buf, c)
+	}
+	return buf.String()
+}
+
+// citeQuote gives blockquote q its source line, if it has an attribution
+// or a cite URL.
+func citeQuote(q *html.Node) {
+	var cite *url.URL
+	if u, err := url.Parse(strings.TrimSpace(getAttr(q, "cite"))); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		cite = u
+	}
+	attribution := quoteAttribution(q)
+	if attribution == nil && cite == nil {
+		return
+	}
+
+	source := &html.Node{Type: html.ElementNode, DataAtom: atom.P, Data: "p",
+		Attr: []html.Attribute{{Key: "class", Val: "quote-source"}}}
+	source.AppendChild(&html.Node{Type: html.TextNode, Data: "— "})
+	parent := source
+	if cite != nil && (attribution == nil || !containsLink(attribution)) {
+		parent = &html.Node{Type: html.ElementNode, DataAtom: atom.A, Data: "a",
+			Attr: []html.Attribute{{Key: "href", Val: cite.String()}}}
+		source.AppendChild(parent)
+	}
+	if attribution == nil {
+		parent.AppendChild(&html.Node{Type: html.TextNode, Data: strings.TrimPrefix(cite.Hostname(), "www.")})
+	} else {
+		q.RemoveChild(attribution)
+		trimAttributionDash(attribution)
+		moveInline(attribution, parent)
+	}
+	q.Parent.InsertBefore(source, q.NextSibling)
+}
+
+// quoteAttribution returns q's attribution element: a <footer> (or one
+// protectQuoteFooters marked), or a last paragraph that starts with a dash.
+func quoteAttribution(q *html.Node) *html.Node {
+	var last *html.Node
+	elements := 0
+	for c := q.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode {
+			continue
+		}
+		if c.DataAtom == atom.Footer || getAttr(c, quoteFooterAttr) != "" {
+			return c
+		}
+		last = c
+		elements++
+	}
+	if elements > 1 && last.DataAtom == atom.P &&
+		strings.ContainsRune(attributionDashes, firstRune(strings.TrimSpace(textContent(last)))) {
+		return last
+	}
+	return nil
+}
+
+// trimAttributionDash removes the dash (and following space) that opens
+// attribution n, since the source line supplies its own. It reports
+// whether it reached n's first text.
+func trimAttributionDash(n *html.Node) bool {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.TextNode && strings.TrimSpace(c.Data) != "" {
+			text := strings.TrimLeft(c.Data, " \t\r\n")
+			c.Data = strings.TrimLeft(strings.TrimLeft(text, attributionDashes), " \t\r\n\u00a0")
+			return true
+		}
+		if c.Type == html.ElementNode && trimAttributionDash(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// moveInline moves the content of from into to, unwrapping block elements
+// (a footer may hold paragraphs) so the source line stays one paragraph.
+func moveInline(from, to *html.Node) {
+	for c := from.FirstChild; c != nil; c = from.FirstChild {
+		from.RemoveChild(c)
+		if c.Type == html.ElementNode && (c.DataAtom == atom.P || c.DataAtom == atom.Div) {
+			if l := to.LastChild; l != nil && (l.Type != html.TextNode || !strings.HasSuffix(l.Data, " ")) {
+				to.AppendChild(&html.Node{Type: html.TextNode, Data: " "})
+			}
+			moveInline(c, to)
+			continue
+		}
+		to.AppendChild(c)
+	}
+}
+
+// containsLink reports whether n has an <a> descendant.
+func containsLink(n *html.Node) bool {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && (c.DataAtom == atom.A || containsLink(c)) {
+			return true
+		}
+	}
+	return false
+}
+
+// firstRune returns the first rune of s, or 0 if s is empty.
+func firstRune(s string) rune {
+	for _, r := range s {
+		return r
+	}
+	return 0
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRetainQuoteCitations(t *testing.T) {
+	for _, tc := range []struct{ in, want string }{
+		{
+			`<blockquote cite="https://src.example/speech"><p>We choose to go to the moon.</p><footer>— <cite>John F. Kennedy</cite></footer></blockquote>`,
+			`<blockquote cite="https://src.example/speech"><p>We choose to go to the moon.</p></blockquote><p class="quote-source">— <a href="https://src.example/speech"><cite>John F. Kennedy</cite></a></p>`,
+		},
+		{
+			`<blockquote><p>Second quote.</p><p>– Ada Lovelace</p></blockquote>`,
+			`<blockquote><p>Second quote.</p></blockquote><p class="quote-source">— Ada Lovelace</p>`,
+		},
+		{
+			`<blockquote cite="https://www.example.org/post"><p>Just a quote.</p></blockquote>`,
+			`<blockquote cite="https://www.example.org/post"><p>Just a quote.</p></blockquote><p class="quote-source">— <a href="https://www.example.org/post">example.org</a></p>`,
+		},
+		{
+			`<blockquote cite="https://a.example/"><p>Q</p><footer><p>Jane Doe,</p><p><a href="https://b.example/">Letters</a></p></footer></blockquote>`,
+			`<blockquote cite="https://a.example/"><p>Q</p></blockquote><p class="quote-source">— Jane Doe, <a href="https://b.example/">Letters</a></p>`,
+		},
+		// A one-paragraph quote that opens with a dash is all quote, and a
+		// quote with neither attribution nor cite URL is left alone.
+		{`<blockquote><p>— said nobody</p></blockquote>`, `<blockquote><p>— said nobody</p></blockquote>`},
+		{`<blockquote><p>Overnight lows:</p><p>-5 degrees</p></blockquote>`, `<blockquote><p>Overnight lows:</p><p>-5 degrees</p></blockquote>`},
+		{`<blockquote cite="notes.txt"><p>Q</p></blockquote>`, `<blockquote cite="notes.txt"><p>Q</p></blockquote>`},
+	} {
+		if got := retainQuoteCitations(tc.in); got != tc.want {
+			t.Errorf("retainQuoteCitations(%s)\n got %s\nwant %s", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestExtractPage_QuoteFooterSurvives(t *testing.T) {
+	page := `<html><body><article><h1>Quotes</h1>
+<p>This article has enough content for readability to identify it as the main
+article. More text is needed to ensure the algorithm works correctly.</p>
+<blockquote cite="https://src.example/speech"><p>We choose to go to the moon.</p><footer>— <cite>John F. Kennedy</cite></footer></blockquote>
+<p>Second paragraph with additional content to boost the text density.</p>
+</article></body></html>`
+	u, _ := url.Parse("https://example.com/a")
+
+	content, _, err := extractPage([]byte(page), u, cliConfig{quoteCites: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	md, err := convertArticleToMarkdown(retainQuoteCitations(content), markdownOpts{cites: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "> We choose to go to the moon.\n\n— [*John F. Kennedy*](https://src.example/speech)"
+	if !strings.Contains(md, want) {
+		t.Errorf("expected %q in markdown, got:\n%s", want, md)
+	}
+}
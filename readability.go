@@ -3,10 +3,14 @@ package main
 import (
 	"bytes"
 	"fmt"
+	"html"
 	"net/url"
+	"regexp"
+	"strings"
 	"time"
 
 	readability "codeberg.org/readeck/go-readability"
+	xhtml "golang.org/x/net/html"
 )
 
 // articleMeta holds metadata extracted alongside the article content.
@@ -15,6 +19,37 @@ type articleMeta struct {
 	Byline        string     // Author attribution (e.g. "Steve Yegge")
 	SiteName      string     // Publication name (e.g. "Medium")
 	PublishedTime *time.Time // Publication date, if available
+	Excerpt       string     // og:description or first paragraph, per readability
+	PageTitle     string     // raw <title> text
+	OGTitle       string     // og:title meta content
+	H1Title       string     // text of the page's first <h1>
+	Image         string     // og:image (or twitter:image) URL, made absolute
+	URL           string     // the page's final URL, after any redirects
+}
+
+var (
+	ogTitleTagRe  = regexp.MustCompile(`(?i)<meta\b[^>]*\bproperty\s*=\s*["']og:title["'][^>]*>`)
+	contentAttrRe = regexp.MustCompile(`(?i)\bcontent\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+)
+
+// titleCandidates finds the <title>, og:title, and first <h1> of a page,
+// for -title-from.
+func titleCandidates(page []byte) (pageTitle, ogTitle, h1 string) {
+	clean := func(s string) string {
+		return strings.Join(strings.Fields(html.UnescapeString(htmlTagRe.ReplaceAllString(s, ""))), " ")
+	}
+	if m := titleTagRe.FindSubmatch(page); m != nil {
+		pageTitle = clean(string(m[1]))
+	}
+	if tag := ogTitleTagRe.Find(page); tag != nil {
+		if m := contentAttrRe.FindSubmatch(tag); m != nil {
+			ogTitle = clean(string(m[1]) + string(m[2]))
+		}
+	}
+	if m := firstH1Re.FindSubmatch(page); m != nil {
+		h1 = clean(string(m[1]))
+	}
+	return pageTitle, ogTitle, h1
 }
 
 // extractArticle runs go-readability on the HTML and returns the article
@@ -34,6 +69,222 @@ func extractArticle(htmlBytes []byte, pageURL *url.URL) (content string, meta ar
 		Byline:        article.Byline,
 		SiteName:      article.SiteName,
 		PublishedTime: article.PublishedTime,
+		Excerpt:       article.Excerpt,
+		URL:           pageURL.String(),
 	}
+	if article.Image != "" {
+		if u, err := pageURL.Parse(strings.TrimSpace(article.Image)); err == nil {
+			meta.Image = u.String()
+		}
+	}
+	meta.PageTitle, meta.OGTitle, meta.H1Title = titleCandidates(htmlBytes)
 	return article.Content, meta, nil
 }
+
+// Attributes holding URLs that rawArticle resolves against the page.
+var urlAttrs = map[string]bool{"href": true, "src": true, "poster": true}
+
+// resolveAttrURL makes an attribute's URL absolute against the page, as
+// readability does. Fragment-only references ("#fn1") point within the
+// article and are left as they are, as are URLs that don't parse.
+func resolveAttrURL(val string, pageURL *url.URL) string {
+	val = strings.TrimSpace(val)
+	if strings.HasPrefix(val, "#") {
+		return val
+	}
+	if u, err := pageURL.Parse(val); err == nil {
+		return u.String()
+	}
+	return val
+}
+
+// rawArticle returns the page's <body> content as the article, without
+// running readability (-skip-extraction), for pages that are already just
+// the article. Scripts and styles are dropped (and comments, unless
+// keepComments), and relative links and image URLs are made absolute as
+// readability would. The title is the page's <title>, else its first <h1>.
+func rawArticle(htmlBytes []byte, pageURL *url.URL, keepComments bool) (content string, meta articleMeta, err error) {
+	doc, err := xhtml.Parse(bytes.NewReader(htmlBytes))
+	if err != nil {
+		return "", articleMeta{}, fmt.Errorf("parsing page: %w", err)
+	}
+	body := findElement(doc, "body")
+	if body == nil {
+		return "", articleMeta{}, fmt.Errorf("no <body> in %s", pageURL)
+	}
+
+	var clean func(n *xhtml.Node)
+	clean = func(n *xhtml.Node) {
+		for c := n.FirstChild; c != nil; {
+			next := c.NextSibling
+			switch {
+			case c.Type == xhtml.CommentNode && !keepComments,
+				c.Type == xhtml.ElementNode && (c.Data == "script" || c.Data == "style"):
+				n.RemoveChild(c)
+			case c.Type == xhtml.ElementNode:
+				for i, a := range c.Attr {
+					switch {
+					case urlAttrs[a.Key]:
+						c.Attr[i].Val = resolveAttrURL(a.Val, pageURL)
+					case a.Key == "srcset":
+						c.Attr[i].Val = resolveSrcset(a.Val, pageURL)
+					}
+				}
+				clean(c)
+			}
+			c = next
+		}
+	}
+	clean(body)
+
+	var buf bytes.Buffer
+	for c := body.FirstChild; c != nil; c = c.NextSibling {
+		if err := xhtml.Render(&buf, c); err != nil {
+			return "", articleMeta{}, fmt.Errorf("rendering page: %w", err)
+		}
+	}
+	content = strings.TrimSpace(buf.String())
+	if content == "" {
+		return "", articleMeta{}, fmt.Errorf("page body of %s is empty", pageURL)
+	}
+
+	meta.URL = pageURL.String()
+	meta.PageTitle, meta.OGTitle, meta.H1Title = titleCandidates(htmlBytes)
+	meta.Title = meta.PageTitle
+	if meta.Title == "" {
+		meta.Title = meta.H1Title
+	}
+	return content, meta, nil
+}
+
+// findElement returns the first element named tag in n's subtree, or nil.
+func findElement(n *xhtml.Node, tag string) *xhtml.Node {
+	if n.Type == xhtml.ElementNode && n.Data == tag {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findElement(c, tag); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// resolveSrcset makes each URL in a srcset absolute, keeping descriptors.
+func resolveSrcset(srcset string, base *url.URL) string {
+	candidates := strings.Split(srcset, ",")
+	for i, c := range candidates {
+		fields := strings.Fields(c)
+		if len(fields) == 0 {
+			continue
+		}
+		if u, err := base.Parse(fields[0]); err == nil {
+			fields[0] = u.String()
+		}
+		candidates[i] = strings.Join(fields, " ")
+	}
+	return strings.Join(candidates, ", ")
+}
+
+var (
+	htmlCommentRe    = regexp.MustCompile(`(?s)<!--(.*?)-->`)
+	commentStandInRe = regexp.MustCompile(`<span data-deckle-comment="([^"]*)"></span>`)
+)
+
+// protectComments replaces HTML comments in the <body> with empty marker
+// spans, which readability keeps (it drops comment nodes). restoreComments
+// turns the markers back into comments after extraction.
+func protectComments(page []byte) []byte {
+	start := 0
+	if loc := bodyTagRe.FindIndex(page); loc != nil {
+		start = loc[1]
+	}
+	body := htmlCommentRe.ReplaceAllFunc(page[start:], func(m []byte) []byte {
+		text := htmlCommentRe.FindSubmatch(m)[1]
+		return []byte(`<span data-deckle-comment="` + html.EscapeString(string(text)) + `"></span>`)
+	})
+	return append(page[:start:start], body...)
+}
+
+// restoreComments reverses protectComments on extracted content.
+func restoreComments(content string) string {
+	return commentStandInRe.ReplaceAllStringFunc(content, func(m string) string {
+		return "<!--" + html.UnescapeString(commentStandInRe.FindStringSubmatch(m)[1]) + "-->"
+	})
+}
+
+var (
+	codeTagRe      = regexp.MustCompile(`(?i)<(?:pre|code)\b[^>]*>`)
+	classAttrRe    = regexp.MustCompile(`(?i)\sclass\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+	codeLangRe     = regexp.MustCompile(`(?i)^(?:language|lang)-([\w+#.-]+)$`)
+	codeLangAttrRe = regexp.MustCompile(`<(pre|code)\b([^>]*?) data-deckle-lang="([^"]*)"([^>]*)>`)
+)
+
+// protectCodeLanguages copies the language-xxx (or lang-xxx) class of <pre>
+// and <code> tags into a marker attribute, since readability strips
+// classes. restoreCodeLanguages turns the markers back into classes after
+// extraction.
+func protectCodeLanguages(page []byte) []byte {
+	return codeTagRe.ReplaceAllFunc(page, func(tag []byte) []byte {
+		lang := codeLanguage(tag)
+		if lang == "" {
+			return tag
+		}
+		name := bytes.IndexAny(tag, " \t\r\n/>")
+		return []byte(string(tag[:name]) + ` data-deckle-lang="` + lang + `"` + string(tag[name:]))
+	})
+}
+
+// codeLanguage returns the language named by an open tag's language-xxx or
+// lang-xxx class, or "" if it has none.
+func codeLanguage(tag []byte) string {
+	m := classAttrRe.FindSubmatch(tag)
+	if m == nil {
+		return ""
+	}
+	for _, c := range strings.Fields(string(m[1]) + string(m[2]) + string(m[3])) {
+		if lm := codeLangRe.FindStringSubmatch(c); lm != nil {
+			return strings.ToLower(lm[1])
+		}
+	}
+	return ""
+}
+
+// restoreCodeLanguages reverses protectCodeLanguages on extracted content,
+// giving each marked tag a language-xxx class.
+func restoreCodeLanguages(content string) string {
+	return codeLangAttrRe.ReplaceAllString(content, `<$1$2 class="language-$3"$4>`)
+}
+
+// maxExcerptSentences caps the length of -excerpt-only summaries.
+const maxExcerptSentences = 3
+
+var (
+	paragraphRe   = regexp.MustCompile(`(?is)<p\b[^>]*>(.*?)</p>`)
+	sentenceEndRe = regexp.MustCompile(`[.!?]["'”’)]*\s+`)
+)
+
+// articleExcerpt returns a short plain-text summary of an article: the
+// metadata description when present, otherwise the opening paragraphs of
+// the content, trimmed to maxExcerptSentences sentences.
+func articleExcerpt(content, description string) string {
+	text := strings.Join(strings.Fields(description), " ")
+	if text == "" {
+		var paras []string
+		for _, m := range paragraphRe.FindAllStringSubmatch(content, -1) {
+			p := strings.Join(strings.Fields(html.UnescapeString(stripTagsRe.ReplaceAllString(m[1], ""))), " ")
+			if p != "" {
+				paras = append(paras, p)
+			}
+			if len(sentenceEndRe.FindAllStringIndex(strings.Join(paras, " ")+" ", -1)) >= maxExcerptSentences {
+				break
+			}
+		}
+		text = strings.Join(paras, " ")
+	}
+	ends := sentenceEndRe.FindAllStringIndex(text+" ", -1)
+	if len(ends) > maxExcerptSentences {
+		text = text[:ends[maxExcerptSentences-1][1]]
+	}
+	return strings.TrimSpace(text)
+}
@@ -96,6 +96,7 @@ func TestExtractArticle_WithMetadata(t *testing.T) {
 		<title>Metadata Test</title>
 		<meta name="author" content="John Doe">
 		<meta property="og:site_name" content="Test Site">
+		<meta property="og:image" content="/img/lead.jpg">
 	</head><body>
 		<article>
 			<h1>Metadata Test</h1>
@@ -116,4 +117,160 @@ func TestExtractArticle_WithMetadata(t *testing.T) {
 	if !strings.Contains(meta.Title, "Metadata Test") {
 		t.Errorf("title = %q, expected to contain 'Metadata Test'", meta.Title)
 	}
+	if meta.Image != "https://example.com/img/lead.jpg" {
+		t.Errorf("og:image should be made absolute, got %q", meta.Image)
+	}
+}
+
+func TestArticleExcerpt(t *testing.T) {
+	tests := []struct {
+		name, content, description, want string
+	}{
+		{
+			name:        "description wins",
+			content:     "<p>Body text.</p>",
+			description: "  A summary\n from og:description. ",
+			want:        "A summary from og:description.",
+		},
+		{
+			name:    "first paragraphs",
+			content: `<p>One &amp; <b>two</b>.</p><p>Three? Four! Five.</p><p>Six.</p>`,
+			want:    "One & two. Three? Four!",
+		},
+		{
+			name:        "long description trimmed",
+			description: "A. B. C. D.",
+			want:        "A. B. C.",
+		},
+		{
+			name:    "no paragraphs",
+			content: "<div>Just a div</div>",
+			want:    "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := articleExcerpt(tt.content, tt.description); got != tt.want {
+				t.Errorf("articleExcerpt() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTitleCandidates(t *testing.T) {
+	page := []byte(`<html><head><title>Story &amp; More | Daily Site</title>
+<meta content="The OG Story" property="og:title">
+</head><body><h1 class="headline">The <em>Real</em>
+ Headline</h1></body></html>`)
+	pageTitle, ogTitle, h1 := titleCandidates(page)
+	if pageTitle != "Story & More | Daily Site" {
+		t.Errorf("pageTitle = %q", pageTitle)
+	}
+	if ogTitle != "The OG Story" {
+		t.Errorf("ogTitle = %q", ogTitle)
+	}
+	if h1 != "The Real Headline" {
+		t.Errorf("h1 = %q", h1)
+	}
+
+	if a, b, c := titleCandidates([]byte(`<p>nothing</p>`)); a+b+c != "" {
+		t.Errorf("expected no candidates, got %q %q %q", a, b, c)
+	}
+}
+
+func TestProtectRestoreComments(t *testing.T) {
+	page := []byte(`<html><head><!-- head note --></head><body><p>A<!-- inline & "quoted" --></p><!--
+multi
+line--></body></html>`)
+	protected := protectComments(page)
+	if strings.Contains(string(protected[bytes.Index(protected, []byte("<body>")):]), "<!--") {
+		t.Errorf("body comments should be replaced, got %q", protected)
+	}
+	if !bytes.Contains(protected, []byte("<head><!-- head note --></head>")) {
+		t.Error("head comments should be left alone")
+	}
+	restored := restoreComments(string(protected))
+	if restored != string(page) {
+		t.Errorf("round trip mismatch:\n got %q\nwant %q", restored, page)
+	}
+}
+
+func TestExtractArticle_ProtectedCommentsSurvive(t *testing.T) {
+	page := `<html><body><article><h1>Marked</h1><!-- data:start -->
+<p>This article has enough content for readability to identify it as the main
+article. More text is needed to ensure the algorithm works correctly.</p>
+<p>Second paragraph with additional content to boost the text density.</p>
+<!-- data:end --></article></body></html>`
+	u, _ := url.Parse("https://example.com/a")
+
+	content, _, err := extractArticle(protectComments([]byte(page)), u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content = restoreComments(content)
+	if !strings.Contains(content, "<!-- data:start -->") || !strings.Contains(content, "<!-- data:end -->") {
+		t.Errorf("comments should survive extraction, got %q", content)
+	}
+}
+
+func TestExtractPage_CodeLanguageSurvives(t *testing.T) {
+	page := `<html><body><article><h1>Snippets</h1>
+<p>This article has enough content for readability to identify it as the main
+article. More text is needed to ensure the algorithm works correctly.</p>
+<pre class="highlight"><code class="hljs language-Go">x := 1</code></pre>
+<p>Second paragraph with additional content to boost the text density.</p>
+<pre class='lang-python'>print(1)</pre>
+<pre><code>plain</code></pre>
+</article></body></html>`
+	u, _ := url.Parse("https://example.com/a")
+
+	content, _, err := extractPage([]byte(page), u, cliConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(content, "data-deckle-lang") {
+		t.Errorf("markers should be removed, got %q", content)
+	}
+	md, err := convertArticleToMarkdown(content, markdownOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"```go\nx := 1\n```", "```python\nprint(1)\n```", "```\nplain\n```"} {
+		if !strings.Contains(md, want) {
+			t.Errorf("expected %q in markdown, got:\n%s", want, md)
+		}
+	}
+}
+
+func TestRawArticle(t *testing.T) {
+	page := `<html><head><title>Gist: notes.md</title><style>p{}</style></head><body>
+<p>Short <a href="../other">note</a>.<sup><a href="#fn1">1</a></sup></p><!-- build 42 -->
+<script>track()</script><img src="/img/a.png" srcset="a-1x.png 1x, /b-2x.png 2x" alt="a">
+</body></html>`
+	u, _ := url.Parse("https://gist.example.com/user/abc")
+	content, meta, err := rawArticle([]byte(page), u, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<p>Short <a href="https://gist.example.com/other">note</a>.<sup><a href="#fn1">1</a></sup></p>
+<img src="https://gist.example.com/img/a.png" srcset="https://gist.example.com/user/a-1x.png 1x, https://gist.example.com/b-2x.png 2x" alt="a"/>`
+	if content != want {
+		t.Errorf("content:\n got %q\nwant %q", content, want)
+	}
+	if meta.Title != "Gist: notes.md" {
+		t.Errorf("title = %q, want the <title>", meta.Title)
+	}
+
+	content, _, _ = rawArticle([]byte(page), u, true)
+	if !strings.Contains(content, "<!-- build 42 -->") {
+		t.Errorf("keepComments should keep comments, got %q", content)
+	}
+
+	_, meta, err = rawArticle([]byte(`<body><h1>Only <em>heading</em></h1><p>x</p></body>`), u, false)
+	if err != nil || meta.Title != "Only heading" {
+		t.Errorf("title should fall back to the <h1>, got %q (%v)", meta.Title, err)
+	}
+	if _, _, err := rawArticle([]byte(`<html><body> <script>x()</script> </body></html>`), u, false); err == nil {
+		t.Error("expected an error for an empty body")
+	}
 }
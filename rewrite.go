@@ -0,0 +1,43 @@
+// URL rewriting (-url-rewrite).
+// Regexp substitutions applied to every input URL before fetching, for
+// site quirks like mobile hosts or reader-mode query parameters.
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// urlRewrite replaces matches of re in a URL with repl, which may refer to
+// capture groups as $1 or ${name}.
+type urlRewrite struct {
+	re   *regexp.Regexp
+	repl string
+}
+
+// parseURLRewrites parses -url-rewrite rules of the form
+// "pattern=>replacement", e.g. `^https://m\.=>https://www.`.
+func parseURLRewrites(rules []string) ([]urlRewrite, error) {
+	var rewrites []urlRewrite
+	for _, rule := range rules {
+		pattern, repl, ok := strings.Cut(rule, "=>")
+		if !ok {
+			return nil, fmt.Errorf("invalid -url-rewrite %q (must be pattern=>replacement)", rule)
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -url-rewrite pattern %q: %w", pattern, err)
+		}
+		rewrites = append(rewrites, urlRewrite{re: re, repl: repl})
+	}
+	return rewrites, nil
+}
+
+// rewriteURL applies each rewrite to u in turn.
+func rewriteURL(u string, rewrites []urlRewrite) string {
+	for _, r := range rewrites {
+		u = r.re.ReplaceAllString(u, r.repl)
+	}
+	return u
+}
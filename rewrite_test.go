@@ -0,0 +1,56 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseURLRewrites(t *testing.T) {
+	for _, bad := range []string{"no arrow", "([=>x"} {
+		if _, err := parseURLRewrites([]string{bad}); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+	rewrites, err := parseURLRewrites([]string{`^https://m\.=>https://www.`, `^(https://www\.example\.com/[^?]*)$=>$1?output=reader`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for in, want := range map[string]string{
+		"https://m.example.com/story":   "https://www.example.com/story?output=reader",
+		"https://www.example.com/x?a=1": "https://www.example.com/x?a=1",
+		"https://other.org/m.html":      "https://other.org/m.html",
+	} {
+		if got := rewriteURL(in, rewrites); got != want {
+			t.Errorf("rewriteURL(%s) = %s, want %s", in, got, want)
+		}
+	}
+}
+
+func TestCollectAllURLs_Rewrites(t *testing.T) {
+	rewrites, err := parseURLRewrites([]string{`^https://m\.=>https://`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := cliConfig{
+		args:     []string{"https://m.example.com/a", "https://example.com/a", "https://example.com/b"},
+		rewrites: rewrites,
+	}
+	urls, _, err := collectAllURLs(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Rewriting happens before dedupe, so the mobile URL merges with its twin.
+	if want := []string{"https://example.com/a", "https://example.com/b"}; !slices.Equal(urls, want) {
+		t.Errorf("got %v, want %v", urls, want)
+	}
+}
+
+func TestRun_InvalidURLRewrite(t *testing.T) {
+	for _, rule := range []string{`^https://m\.`, `(=>x`} {
+		err := run(cliConfig{urlRewrites: []string{rule}, args: []string{"https://m.example.com/a"}})
+		if err == nil || !strings.Contains(err.Error(), "invalid -url-rewrite") {
+			t.Errorf("%s: expected an invalid -url-rewrite error, got %v", rule, err)
+		}
+	}
+}
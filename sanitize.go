@@ -7,6 +7,9 @@ import (
 	"bytes"
 	"fmt"
 	"math"
+	"net/url"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"unicode"
@@ -64,6 +67,16 @@ func sanitizeID(val string) string {
 	return b.String()
 }
 
+// fragmentID returns the sanitized id that an in-document link's fragment
+// (the part after "#") points to, URL-unescaped so "#caf%C3%A9" finds
+// id="café".
+func fragmentID(frag string) string {
+	if u, err := url.PathUnescape(frag); err == nil {
+		frag = u
+	}
+	return sanitizeID(frag)
+}
+
 // isPhrasingElement returns true if the tag is a phrasing content element
 // that cannot contain block-level elements in EPUB XHTML.
 func isPhrasingElement(tag string) bool {
@@ -97,7 +110,7 @@ func isBlockElement(tag string) bool {
 		"ul", "ol", "li", "dl", "dt", "dd",
 		"blockquote", "section", "article", "aside",
 		"header", "footer", "main", "figure", "figcaption", "nav",
-		"table", "pre", "hr", "address":
+		"details", "summary", "table", "pre", "hr", "address":
 		return true
 	}
 	return false
@@ -122,7 +135,7 @@ func isAllowedAttr(a html.Attribute) bool {
 		"href", "src", "alt", "width", "height",
 		"colspan", "rowspan", "scope", "headers",
 		"cite", "datetime", "value", "type",
-		"rel", "media", "start", "reversed":
+		"rel", "media", "start", "reversed", "open":
 		return true
 	}
 	// epub:type is allowed and encouraged for semantic inflection
@@ -132,11 +145,40 @@ func isAllowedAttr(a html.Attribute) bool {
 	return false
 }
 
+// mathMLElements are the MathML presentation and semantics elements allowed
+// in EPUB 3 content documents.
+var mathMLElements = map[string]bool{
+	"math": true, "mi": true, "mn": true, "mo": true, "ms": true, "mtext": true,
+	"mspace": true, "mrow": true, "mfrac": true, "msqrt": true, "mroot": true,
+	"mstyle": true, "merror": true, "mpadded": true, "mphantom": true,
+	"mfenced": true, "menclose": true, "msub": true, "msup": true,
+	"msubsup": true, "munder": true, "mover": true, "munderover": true,
+	"mmultiscripts": true, "mprescripts": true, "none": true, "mtable": true,
+	"mtr": true, "mtd": true, "mlabeledtr": true, "semantics": true,
+	"annotation": true, "annotation-xml": true,
+}
+
+// isAllowedMathAttr returns true for presentation attributes on MathML elements.
+func isAllowedMathAttr(key string) bool {
+	switch key {
+	case "display", "displaystyle", "mathvariant", "mathsize", "mathcolor",
+		"mathbackground", "scriptlevel", "stretchy", "fence", "separator",
+		"separators", "open", "close", "form", "lspace", "rspace", "largeop",
+		"movablelimits", "accent", "accentunder", "linethickness", "notation",
+		"columnalign", "rowalign", "columnspan", "encoding", "alttext":
+		return true
+	}
+	return false
+}
+
 // isAllowedElement returns true if the tag is allowed in EPUB 3 XHTML.
 func isAllowedElement(n *html.Node) bool {
 	if n.Type != html.ElementNode {
 		return true
 	}
+	if n.Namespace == "math" {
+		return mathMLElements[n.Data]
+	}
 	switch n.Data {
 	case "div", "p", "h1", "h2", "h3", "h4", "h5", "h6", "ul", "ol", "li", "dl", "dt", "dd",
 		"address", "hr", "pre", "blockquote", "cite", "em", "strong", "small", "s", "dfn",
@@ -144,7 +186,7 @@ func isAllowedElement(n *html.Node) bool {
 		"mark", "ruby", "rt", "rp", "bdi", "bdo", "span", "br", "wbr", "ins", "del", "img",
 		"table", "caption", "colgroup", "col", "tbody", "thead", "tfoot", "tr", "td", "th",
 		"section", "article", "aside", "header", "footer", "main", "figure", "figcaption", "nav",
-		"a":
+		"details", "summary", "a":
 		return true
 	}
 	return false
@@ -157,15 +199,127 @@ var voidElements = map[atom.Atom]bool{
 	atom.Link: true, atom.Meta: true, atom.Source: true, atom.Wbr: true,
 }
 
+// sanitizeOpts holds optional sanitization behaviour. The zero value keeps
+// the default rules.
+type sanitizeOpts struct {
+	keepClasses    map[string]bool // if non-nil, only these class names survive
+	stackTableCols int             // stack tables wider than this many columns (0 disables)
+	popupFootnotes bool            // turn #fnN footnotes into EPUB 3 popup notes
+	expandDetails  bool            // unwrap <details>, rendering <summary> as a bold paragraph
+	keepData       map[string]bool // data-* attributes that survive (all others are stripped)
+	minimal        bool            // also strip style, title, and ids no fragment link targets
+	dropSections   map[string]bool // titleKeys of headings whose sections are removed
+	iframeLinks    bool            // replace <iframe> embeds with links instead of dropping them
+}
+
 // xhtmlSanitizer holds state for a single HTML→XHTML sanitization pass.
 type xhtmlSanitizer struct {
 	ids     map[string]bool // all IDs present in the document
+	refs    map[string]bool // fragments targeted by href="#..." links (minimal mode)
 	usedIDs map[string]bool // IDs already emitted (for deduplication)
+	opts    sanitizeOpts
+}
+
+// filterClasses keeps only the allowlisted class names from a class
+// attribute value, preserving their order. Code language classes
+// (language-xxx, lang-xxx) always survive for reader syntax highlighting,
+// and stacked-table for the stylesheet's stacked-table rules.
+func filterClasses(val string, keep map[string]bool) string {
+	var kept []string
+	for _, c := range strings.Fields(val) {
+		if keep[c] || codeLangRe.MatchString(c) || c == "stacked-table" {
+			kept = append(kept, c)
+		}
+	}
+	return strings.Join(kept, " ")
+}
+
+// dataAttrSet builds a keep set from -keep-data names, adding the "data-"
+// prefix where it was left off.
+func dataAttrSet(names []string) map[string]bool {
+	if len(names) == 0 {
+		return nil
+	}
+	set := map[string]bool{}
+	for _, name := range names {
+		name = strings.ToLower(name)
+		if !strings.HasPrefix(name, "data-") {
+			name = "data-" + name
+		}
+		set[name] = true
+	}
+	return set
+}
+
+// stripDataAttributes removes data-* attributes not in keep from every tag,
+// leaving the rest of the markup byte-for-byte intact. Used for HTML output,
+// which is not otherwise sanitized.
+func stripDataAttributes(htmlBytes []byte, keep map[string]bool) []byte {
+	var buf bytes.Buffer
+	z := html.NewTokenizer(bytes.NewReader(htmlBytes))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		raw := z.Raw()
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken || !bytes.Contains(raw, []byte("data-")) {
+			buf.Write(raw)
+			continue
+		}
+		raw = bytes.Clone(raw) // Token unescapes attribute values in place
+		tok := z.Token()
+		var attrs []html.Attribute
+		for _, a := range tok.Attr {
+			if !strings.HasPrefix(a.Key, "data-") || keep[a.Key] {
+				attrs = append(attrs, a)
+			}
+		}
+		if len(attrs) == len(tok.Attr) {
+			buf.Write(raw)
+			continue
+		}
+		tok.Attr = attrs
+		buf.WriteString(tok.String())
+	}
+	return buf.Bytes()
+}
+
+// Embed players whose src maps to the provider's own page for the media.
+var (
+	youtubeEmbedRe = regexp.MustCompile(`^(?:www\.)?youtube(?:-nocookie)?\.com/embed/([\w-]+)`)
+	vimeoEmbedRe   = regexp.MustCompile(`^player\.vimeo\.com/video/(\d+)`)
+)
+
+// embedLink returns the URL an <iframe src> embed should link to, with the
+// host to name in its text: the watch page for YouTube and Vimeo players,
+// else the embed URL itself. It returns "" for a missing or non-http src.
+func embedLink(src string) (href, host string) {
+	src = strings.TrimSpace(src)
+	if strings.HasPrefix(src, "//") {
+		src = "https:" + src
+	}
+	u, err := url.Parse(src)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", ""
+	}
+	hostPath := strings.ToLower(u.Host) + u.Path
+	switch {
+	case youtubeEmbedRe.MatchString(hostPath):
+		href = "https://www.youtube.com/watch?v=" + youtubeEmbedRe.FindStringSubmatch(hostPath)[1]
+		if t := u.Query().Get("start"); t != "" {
+			href += "&t=" + url.QueryEscape(t) + "s"
+		}
+		return href, "youtube.com"
+	case vimeoEmbedRe.MatchString(hostPath):
+		return "https://vimeo.com/" + vimeoEmbedRe.FindStringSubmatch(hostPath)[1], "vimeo.com"
+	}
+	return u.String(), strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
 }
 
 // transformElement handles element-level transformations that may replace or
 // remove the node entirely: media→link conversion, source/picture removal,
-// element whitelist, and image validation.
+// iframe→link conversion, element whitelist, and image validation.
 // Returns nil to remove, a different node to replace, or n to continue processing.
 func (s *xhtmlSanitizer) transformElement(n *html.Node) *html.Node {
 	// Convert media tags to links
@@ -208,6 +362,27 @@ func (s *xhtmlSanitizer) transformElement(n *html.Node) *html.Node {
 		return nil
 	}
 
+	// Convert embeds (videos, tweets, code playgrounds) to links
+	if n.Data == "iframe" && s.opts.iframeLinks {
+		src := ""
+		for _, a := range n.Attr {
+			if a.Key == "src" || (a.Key == "data-src" && src == "") {
+				src = a.Val
+			}
+		}
+		href, host := embedLink(src)
+		if href == "" {
+			return nil
+		}
+		link := &html.Node{
+			Type: html.ElementNode,
+			Data: "a",
+			Attr: []html.Attribute{{Key: "href", Val: href}},
+		}
+		link.AppendChild(&html.Node{Type: html.TextNode, Data: "[Embedded: " + host + "]"})
+		return link
+	}
+
 	// Remove <source> elements
 	if n.Data == "source" {
 		return nil
@@ -224,6 +399,16 @@ func (s *xhtmlSanitizer) transformElement(n *html.Node) *html.Node {
 		return nil
 	}
 
+	// Expand collapsibles for readers without <details> support
+	if n.Data == "details" && s.opts.expandDetails {
+		return s.clean(expandDetails(n))
+	}
+
+	// Stack wide tables into per-row definition lists
+	if n.Data == "table" && s.opts.stackTableCols > 0 && tableColumnCount(n) > s.opts.stackTableCols {
+		return s.clean(stackTable(n))
+	}
+
 	// Apply element whitelist
 	if !isAllowedElement(n) {
 		if n.Data != "html" && n.Data != "head" && n.Data != "body" {
@@ -260,13 +445,26 @@ func (s *xhtmlSanitizer) transformElement(n *html.Node) *html.Node {
 func (s *xhtmlSanitizer) filterAttributes(n *html.Node) {
 	var filtered []html.Attribute
 	for _, a := range n.Attr {
-		if !isAllowedAttr(a) {
+		if !isAllowedAttr(a) && !(n.Namespace == "math" && isAllowedMathAttr(a.Key)) && !s.opts.keepData[a.Key] {
+			continue
+		}
+		if s.opts.minimal && (a.Key == "style" || a.Key == "title") {
 			continue
 		}
 		// Fix broken fragment links
 		if a.Key == "href" && strings.HasPrefix(a.Val, "#") {
-			frag := a.Val[1:]
-			if frag != "" && !s.ids[frag] {
+			if frag := a.Val[1:]; frag != "" {
+				id := fragmentID(frag)
+				if !s.ids[id] {
+					continue
+				}
+				a.Val = "#" + id
+			}
+		}
+		// Reduce class lists to the allowlist, if one is configured
+		if a.Key == "class" && s.opts.keepClasses != nil {
+			a.Val = filterClasses(a.Val, s.opts.keepClasses)
+			if a.Val == "" {
 				continue
 			}
 		}
@@ -276,6 +474,9 @@ func (s *xhtmlSanitizer) filterAttributes(n *html.Node) {
 			if cleaned == "" {
 				continue
 			}
+			if s.refs != nil && !s.refs[cleaned] {
+				continue
+			}
 			if s.usedIDs[cleaned] {
 				for i := 2; ; i++ {
 					candidate := fmt.Sprintf("%s-%d", cleaned, i)
@@ -433,6 +634,209 @@ func (s *xhtmlSanitizer) fixFigcaption(n *html.Node) {
 	}
 }
 
+// tableRows returns the <tr> elements of a table in document order,
+// looking through thead/tbody/tfoot but not into nested tables.
+func tableRows(table *html.Node) []*html.Node {
+	var rows []*html.Node
+	for c := table.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode {
+			continue
+		}
+		switch c.Data {
+		case "tr":
+			rows = append(rows, c)
+		case "thead", "tbody", "tfoot":
+			for r := c.FirstChild; r != nil; r = r.NextSibling {
+				if r.Type == html.ElementNode && r.Data == "tr" {
+					rows = append(rows, r)
+				}
+			}
+		}
+	}
+	return rows
+}
+
+// rowCells returns the td/th children of a table row.
+func rowCells(row *html.Node) []*html.Node {
+	var cells []*html.Node
+	for c := row.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && (c.Data == "td" || c.Data == "th") {
+			cells = append(cells, c)
+		}
+	}
+	return cells
+}
+
+// tableColumnCount returns the widest row's column count, honouring colspan.
+func tableColumnCount(table *html.Node) int {
+	max := 0
+	for _, row := range tableRows(table) {
+		n := 0
+		for _, cell := range rowCells(row) {
+			n += cellSpan(cell, "colspan")
+		}
+		if n > max {
+			max = n
+		}
+	}
+	return max
+}
+
+// maxCellSpan caps colspan and rowspan, as browsers do for colspan.
+const maxCellSpan = 1000
+
+// cellSpan returns a cell's colspan or rowspan (key), at least 1.
+func cellSpan(cell *html.Node, key string) int {
+	for _, a := range cell.Attr {
+		if a.Key == key {
+			if v, err := strconv.Atoi(strings.TrimSpace(a.Val)); err == nil && v > 1 {
+				return min(v, maxCellSpan)
+			}
+		}
+	}
+	return 1
+}
+
+// gridCell is a table cell placed on its table's grid: the column it
+// starts in and how many columns it spans.
+type gridCell struct {
+	cell      *html.Node
+	col, span int
+}
+
+// tableGrid places the cells of rows on a grid, honouring colspan and
+// rowspan. Each row lists its cells in column order, including the cells
+// of rows above that span down into it.
+func tableGrid(rows []*html.Node) [][]gridCell {
+	grid := make([][]gridCell, len(rows))
+	taken := make([]map[int]bool, len(rows))
+	for i := range taken {
+		taken[i] = map[int]bool{}
+	}
+	for i, row := range rows {
+		col := 0
+		for _, cell := range rowCells(row) {
+			for taken[i][col] {
+				col++
+			}
+			span := cellSpan(cell, "colspan")
+			for r := i; r < min(i+cellSpan(cell, "rowspan"), len(rows)); r++ {
+				grid[r] = append(grid[r], gridCell{cell: cell, col: col, span: span})
+				for c := col; c < col+span; c++ {
+					taken[r][c] = true
+				}
+			}
+			col += span
+		}
+	}
+	for _, cells := range grid {
+		slices.SortStableFunc(cells, func(a, b gridCell) int { return a.col - b.col })
+	}
+	return grid
+}
+
+// isHeaderRow reports whether every cell of row is a <th>.
+func isHeaderRow(row *html.Node) bool {
+	cells := rowCells(row)
+	for _, cell := range cells {
+		if cell.Data != "th" {
+			return false
+		}
+	}
+	return len(cells) > 0
+}
+
+// cloneNode returns a deep copy of n, detached from any tree.
+func cloneNode(n *html.Node) *html.Node {
+	c := &html.Node{Type: n.Type, DataAtom: n.DataAtom, Data: n.Data, Namespace: n.Namespace,
+		Attr: append([]html.Attribute(nil), n.Attr...)}
+	for ch := n.FirstChild; ch != nil; ch = ch.NextSibling {
+		c.AppendChild(cloneNode(ch))
+	}
+	return c
+}
+
+// stackTable converts a table into a <div class="stacked-table"> holding one
+// <dl> per data row, pairing each cell with its column header so the data
+// reflows on narrow screens. Leading rows of <th> cells supply the labels,
+// with a header spanning several columns labelling each of them and
+// stacked headers joined ("Sales / 2024"); without any, columns are
+// labelled "Column N". A cell spanning several rows is repeated in each.
+func stackTable(table *html.Node) *html.Node {
+	div := &html.Node{Type: html.ElementNode, Data: "div", DataAtom: atom.Div,
+		Attr: []html.Attribute{{Key: "class", Val: "stacked-table"}}}
+
+	for c := table.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && c.Data == "caption" {
+			p := &html.Node{Type: html.ElementNode, Data: "p", DataAtom: atom.P}
+			p.AppendChild(&html.Node{Type: html.TextNode, Data: strings.TrimSpace(textContent(c))})
+			div.AppendChild(p)
+			break
+		}
+	}
+
+	rows := tableRows(table)
+	grid := tableGrid(rows)
+	head := 0
+	for head < len(rows) && isHeaderRow(rows[head]) {
+		head++
+	}
+	headers := map[int][]string{}
+	for _, cells := range grid[:head] {
+		for _, g := range cells {
+			text := strings.TrimSpace(textContent(g.cell))
+			for c := g.col; c < g.col+g.span; c++ {
+				if h := headers[c]; text != "" && (len(h) == 0 || h[len(h)-1] != text) {
+					headers[c] = append(h, text)
+				}
+			}
+		}
+	}
+
+	dds := map[*html.Node]*html.Node{} // the <dd> each cell was first moved into
+	for _, cells := range grid[head:] {
+		dl := &html.Node{Type: html.ElementNode, Data: "dl", DataAtom: atom.Dl}
+		for _, g := range cells {
+			label := fmt.Sprintf("Column %d", g.col+1)
+			if h := headers[g.col]; len(h) > 0 {
+				label = strings.Join(h, " / ")
+			}
+			dt := &html.Node{Type: html.ElementNode, Data: "dt", DataAtom: atom.Dt}
+			dt.AppendChild(&html.Node{Type: html.TextNode, Data: label})
+			dd := &html.Node{Type: html.ElementNode, Data: "dd", DataAtom: atom.Dd}
+			if first := dds[g.cell]; first != nil {
+				for cc := first.FirstChild; cc != nil; cc = cc.NextSibling {
+					dd.AppendChild(cloneNode(cc))
+				}
+			} else {
+				for cc := g.cell.FirstChild; cc != nil; {
+					next := cc.NextSibling
+					g.cell.RemoveChild(cc)
+					dd.AppendChild(cc)
+					cc = next
+				}
+				dds[g.cell] = dd
+			}
+			dl.AppendChild(dt)
+			dl.AppendChild(dd)
+		}
+		div.AppendChild(dl)
+	}
+	return div
+}
+
+// textContent returns the concatenated text of a node and its descendants.
+func textContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		b.WriteString(textContent(c))
+	}
+	return b.String()
+}
+
 // clean recursively processes a node and its children, applying all
 // sanitization rules. Returns nil to remove the node, a different node
 // to replace it, or n to keep it.
@@ -471,6 +875,11 @@ func (s *xhtmlSanitizer) clean(n *html.Node) *html.Node {
 // Strips non-standard attributes, ensures self-closing void elements,
 // removes broken fragment links, and eliminates disallowed tags/nesting.
 func sanitizeForXHTML(htmlStr string) string {
+	return sanitizeForXHTMLOpts(htmlStr, sanitizeOpts{})
+}
+
+// sanitizeForXHTMLOpts is sanitizeForXHTML with optional behaviour.
+func sanitizeForXHTMLOpts(htmlStr string, opts sanitizeOpts) string {
 	// Strip invalid XML characters (control chars like U+0012)
 	htmlStr = stripInvalidXMLChars(htmlStr)
 
@@ -479,9 +888,20 @@ func sanitizeForXHTML(htmlStr string) string {
 		return htmlStr // fallback: return as-is
 	}
 
+	if len(opts.dropSections) > 0 {
+		dropSections(doc, opts.dropSections)
+	}
+	if opts.popupFootnotes {
+		convertFootnotes(doc)
+	}
+
 	s := &xhtmlSanitizer{
 		ids:     collectIDs(doc),
 		usedIDs: map[string]bool{},
+		opts:    opts,
+	}
+	if opts.minimal {
+		s.refs = collectFragmentRefs(doc)
 	}
 	s.clean(doc)
 
@@ -502,6 +922,200 @@ func sanitizeForXHTML(htmlStr string) string {
 	return result
 }
 
+// dropSections removes each heading whose text matches one of headings
+// (compared by titleKey, so the whole heading must match, ignoring case and
+// punctuation), along with its following siblings up to the next heading of
+// the same or a higher level (-drop-section).
+func dropSections(doc *html.Node, headings map[string]bool) {
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; {
+			level := headingLevel(c)
+			if level == 0 || !headings[titleKey(textContent(c))] {
+				walk(c)
+				c = c.NextSibling
+				continue
+			}
+			next := c.NextSibling
+			n.RemoveChild(c)
+			for next != nil {
+				if l := headingLevel(next); l > 0 && l <= level {
+					break
+				}
+				after := next.NextSibling
+				n.RemoveChild(next)
+				next = after
+			}
+			c = next
+		}
+	}
+	walk(doc)
+}
+
+// headingLevel returns 1-6 for an <h1>-<h6> element, or 0.
+func headingLevel(n *html.Node) int {
+	if n.Type != html.ElementNode || len(n.Data) != 2 || n.Data[0] != 'h' || n.Data[1] < '1' || n.Data[1] > '6' {
+		return 0
+	}
+	return int(n.Data[1] - '0')
+}
+
+// expandDetails turns a <details> element into a <div> holding its content
+// in the open state, with the <summary> as a bold paragraph.
+func expandDetails(details *html.Node) *html.Node {
+	div := &html.Node{Type: html.ElementNode, Data: "div", DataAtom: atom.Div}
+	for c := details.FirstChild; c != nil; c = details.FirstChild {
+		details.RemoveChild(c)
+		if c.Type == html.ElementNode && c.Data == "summary" {
+			p := &html.Node{Type: html.ElementNode, Data: "p", DataAtom: atom.P}
+			strong := &html.Node{Type: html.ElementNode, Data: "strong", DataAtom: atom.Strong}
+			for gc := c.FirstChild; gc != nil; gc = c.FirstChild {
+				c.RemoveChild(gc)
+				strong.AppendChild(gc)
+			}
+			p.AppendChild(strong)
+			div.AppendChild(p)
+			continue
+		}
+		div.AppendChild(c)
+	}
+	return div
+}
+
+// footnoteIDRe matches the ids footnote links point at, e.g. fn1, fn:2,
+// footnote-3, note_4.
+var footnoteIDRe = regexp.MustCompile(`(?i)^(?:fn|footnote|note)[-_:]?\d+$`)
+
+// convertFootnotes marks links to footnotes as EPUB 3 noterefs and moves
+// each footnote's content into an <aside epub:type="footnote"> at the end
+// of the body, so readers that support it show the note in a popup.
+// Footnote lists left empty by the move are removed.
+func convertFootnotes(doc *html.Node) {
+	byID := map[string]*html.Node{}
+	var body *html.Node
+	var refs []*html.Node
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			if n.DataAtom == atom.Body {
+				body = n
+			}
+			if id := getAttr(n, "id"); id != "" {
+				byID[id] = n
+			}
+			if n.DataAtom == atom.A {
+				refs = append(refs, n)
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	if body == nil {
+		return
+	}
+
+	var notes []*html.Node
+	seen := map[*html.Node]bool{}
+	for _, a := range refs {
+		id, ok := strings.CutPrefix(getAttr(a, "href"), "#")
+		if !ok || !footnoteIDRe.MatchString(id) {
+			continue
+		}
+		target := byID[id]
+		if target == nil || isAncestor(target, a) {
+			continue
+		}
+		setAttr(a, "epub:type", "noteref")
+		if !seen[target] {
+			seen[target] = true
+			notes = append(notes, target)
+		}
+	}
+
+	for _, target := range notes {
+		aside := &html.Node{
+			Type:     html.ElementNode,
+			Data:     "aside",
+			DataAtom: atom.Aside,
+			Attr: []html.Attribute{
+				{Key: "id", Val: getAttr(target, "id")},
+				{Key: "epub:type", Val: "footnote"},
+			},
+		}
+		parent := target.Parent
+		parent.RemoveChild(target)
+		if target.DataAtom == atom.Li {
+			for c := target.FirstChild; c != nil; c = target.FirstChild {
+				target.RemoveChild(c)
+				aside.AppendChild(c)
+			}
+		} else {
+			removeAttr(target, "id")
+			aside.AppendChild(target)
+		}
+		removeEmptyContainers(parent, body)
+		body.AppendChild(aside)
+	}
+}
+
+// removeEmptyContainers removes n and then its ancestors (below stop) for
+// as long as they hold nothing but whitespace and <hr> separators.
+func removeEmptyContainers(n, stop *html.Node) {
+	for n != nil && n != stop && n.Parent != nil && isEmptyContainer(n) {
+		parent := n.Parent
+		parent.RemoveChild(n)
+		n = parent
+	}
+}
+
+func isEmptyContainer(n *html.Node) bool {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		switch {
+		case c.Type == html.TextNode && strings.TrimSpace(c.Data) == "":
+		case c.Type == html.CommentNode:
+		case c.Type == html.ElementNode && c.DataAtom == atom.Hr:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func getAttr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func setAttr(n *html.Node, key, val string) {
+	removeAttr(n, key)
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: val})
+}
+
+func removeAttr(n *html.Node, key string) {
+	attrs := n.Attr[:0]
+	for _, a := range n.Attr {
+		if a.Key != key {
+			attrs = append(attrs, a)
+		}
+	}
+	n.Attr = attrs
+}
+
+func isAncestor(anc, n *html.Node) bool {
+	for p := n.Parent; p != nil; p = p.Parent {
+		if p == anc {
+			return true
+		}
+	}
+	return false
+}
+
 // collectIDs collects all sanitized ID values from the document tree.
 func collectIDs(doc *html.Node) map[string]bool {
 	ids := map[string]bool{}
@@ -525,6 +1139,27 @@ func collectIDs(doc *html.Node) map[string]bool {
 	return ids
 }
 
+// collectFragmentRefs returns the sanitized ids that in-document links
+// (href="#...") point to.
+func collectFragmentRefs(doc *html.Node) map[string]bool {
+	refs := map[string]bool{}
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			for _, a := range n.Attr {
+				if a.Key == "href" && strings.HasPrefix(a.Val, "#") && len(a.Val) > 1 {
+					refs[fragmentID(a.Val[1:])] = true
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	return refs
+}
+
 // renderXHTML renders an html.Node tree as XHTML (self-closing void elements).
 func renderXHTML(buf *bytes.Buffer, n *html.Node) {
 	switch n.Type {
@@ -533,6 +1168,9 @@ func renderXHTML(buf *bytes.Buffer, n *html.Node) {
 	case html.ElementNode:
 		buf.WriteByte('<')
 		buf.WriteString(n.Data)
+		if n.Namespace == "math" && n.Data == "math" {
+			buf.WriteString(` xmlns="http://www.w3.org/1998/Math/MathML"`)
+		}
 		for _, a := range n.Attr {
 			buf.WriteByte(' ')
 			buf.WriteString(a.Key)
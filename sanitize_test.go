@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/xml"
 	"strings"
 	"testing"
 
@@ -554,3 +555,269 @@ func TestSanitizeForXHTML_StripInlineSVG(t *testing.T) {
 		t.Error("surrounding content should be preserved")
 	}
 }
+
+func TestSanitizeForXHTMLOpts_KeepClasses(t *testing.T) {
+	input := `<p class="pullquote big-red">Quote</p><p class="tracking">Body</p>`
+	opts := sanitizeOpts{keepClasses: map[string]bool{"pullquote": true}}
+	result := sanitizeForXHTMLOpts(input, opts)
+	if !strings.Contains(result, `<p class="pullquote">Quote</p>`) {
+		t.Errorf("allowlisted class should survive alone, got %q", result)
+	}
+	if strings.Contains(result, "big-red") || strings.Contains(result, "tracking") {
+		t.Errorf("non-allowlisted classes should be stripped, got %q", result)
+	}
+	if !strings.Contains(result, "<p>Body</p>") {
+		t.Errorf("empty class attribute should be dropped, got %q", result)
+	}
+	result = sanitizeForXHTMLOpts(`<pre><code class="hljs language-go">x</code></pre>`, opts)
+	if !strings.Contains(result, `<code class="language-go">`) {
+		t.Errorf("code language class should always survive, got %q", result)
+	}
+}
+
+func TestSanitizeForXHTMLOpts_KeepData(t *testing.T) {
+	input := `<p>Text<sup data-footnote-id="f1" data-tracking="x">1</sup></p>`
+	if result := sanitizeForXHTML(input); strings.Contains(result, "data-") {
+		t.Errorf("data-* attributes should be stripped by default, got %q", result)
+	}
+	result := sanitizeForXHTMLOpts(input, sanitizeOpts{keepData: dataAttrSet([]string{"data-footnote-id"})})
+	if !strings.Contains(result, `<sup data-footnote-id="f1">1</sup>`) {
+		t.Errorf("allowlisted data attribute should survive alone, got %q", result)
+	}
+}
+
+func TestSanitizeForXHTMLOpts_Minimal(t *testing.T) {
+	input := `<p class="byline x-meta" id="top" style="color:red" title="Hover">By <a href="#fn1" class="ref" title="Note">1</a></p>` +
+		`<h2 id="section-2" class="hed" lang="fr">Deux</h2>` +
+		`<p id="fn1" class="note">Footnote.</p>`
+	got := sanitizeForXHTMLOpts(input, epubOpts{minimal: true}.sanitizeOpts())
+	want := `<p class="byline">By <a href="#fn1">1</a></p>` +
+		`<h2 lang="fr">Deux</h2>` +
+		`<p id="fn1">Footnote.</p>`
+	if got != want {
+		t.Errorf("minimal sanitize:\n got %s\nwant %s", got, want)
+	}
+
+	// Escaped and non-ASCII fragments still find their ids.
+	escaped := `<p><a href="#caf%C3%A9">1</a> <a href="#see%20also">2</a></p><p id="café">A</p><p id="see also">B</p><p id="unused">C</p>`
+	wantEscaped := `<p><a href="#café">1</a> <a href="#see-also">2</a></p><p id="café">A</p><p id="see-also">B</p><p>C</p>`
+	if got := sanitizeForXHTMLOpts(escaped, epubOpts{minimal: true}.sanitizeOpts()); got != wantEscaped {
+		t.Errorf("minimal sanitize of escaped fragments:\n got %s\nwant %s", got, wantEscaped)
+	}
+
+	kept := sanitizeForXHTMLOpts(input, epubOpts{minimal: true, keepClasses: []string{"note"}}.sanitizeOpts())
+	if !strings.Contains(kept, `<p id="fn1" class="note">`) {
+		t.Errorf("-keep-classes should still apply with -minimal, got %s", kept)
+	}
+	if full := sanitizeForXHTML(input); !strings.Contains(full, `id="section-2" class="hed"`) || !strings.Contains(full, `style="color:red"`) {
+		t.Errorf("default sanitize should keep the broad allowlist, got %s", full)
+	}
+}
+
+func TestSanitizeForXHTMLOpts_DropSections(t *testing.T) {
+	input := `<h2>Story</h2><p>Body.</p>` +
+		`<h3>Comments on style</h3><p>Kept: not an exact match.</p>` +
+		`<h2>Comments:</h2><p>First!</p><h3>Older</h3><ol><li>Reply</li></ol>` +
+		`<h2>Notes</h2><p>Kept.</p>` +
+		`<div><h4>Related</h4><ul><li>Other story</li></ul></div><p>Kept too.</p>`
+	got := sanitizeForXHTMLOpts(input, epubOpts{dropSections: []string{"comments", "Related", "  "}}.sanitizeOpts())
+	want := `<h2>Story</h2><p>Body.</p>` +
+		`<h3>Comments on style</h3><p>Kept: not an exact match.</p>` +
+		`<h2>Notes</h2><p>Kept.</p>` +
+		`<div></div><p>Kept too.</p>`
+	if got != want {
+		t.Errorf("drop sections:\n got %s\nwant %s", got, want)
+	}
+	if full := sanitizeForXHTML(input); !strings.Contains(full, "First!") {
+		t.Errorf("sections should be kept by default, got %s", full)
+	}
+}
+
+func TestSanitizeForXHTMLOpts_IframeLinks(t *testing.T) {
+	input := `<p>Watch:</p><iframe src="https://www.youtube-nocookie.com/embed/dQw4w9WgXcQ?start=42&amp;rel=0"></iframe>` +
+		`<iframe src="//player.vimeo.com/video/123456?h=abc"></iframe>` +
+		`<iframe data-src="https://codepen.io/a/embed/xyz?a=1&amp;b=2"></iframe>` +
+		`<iframe src="about:blank"></iframe>`
+	got := sanitizeForXHTMLOpts(input, epubOpts{iframeLinks: true}.sanitizeOpts())
+	want := `<p>Watch:</p><a href="https://www.youtube.com/watch?v=dQw4w9WgXcQ&amp;t=42s">[Embedded: youtube.com]</a>` +
+		`<a href="https://vimeo.com/123456">[Embedded: vimeo.com]</a>` +
+		`<a href="https://codepen.io/a/embed/xyz?a=1&amp;b=2">[Embedded: codepen.io]</a>`
+	if got != want {
+		t.Errorf("iframe links:\n got %s\nwant %s", got, want)
+	}
+	if plain := sanitizeForXHTML(input); strings.Contains(plain, "Embedded") {
+		t.Errorf("iframes should be dropped by default, got %s", plain)
+	}
+}
+
+func TestDataAttrSet(t *testing.T) {
+	got := dataAttrSet([]string{"data-footnote-id", "Lang"})
+	if !got["data-footnote-id"] || !got["data-lang"] || len(got) != 2 {
+		t.Errorf("unexpected set %v", got)
+	}
+	if dataAttrSet(nil) != nil {
+		t.Error("empty list should give a nil set")
+	}
+}
+
+func TestStripDataAttributes(t *testing.T) {
+	input := `<p data-x="1">Prose mentioning data-x="2" stays.</p>` +
+		`<img src="a.png" data-lang="en" alt="A &amp; B"><br data-y/>` +
+		`<span class="c" data-footnote-id="f1">n</span>`
+	got := string(stripDataAttributes([]byte(input), dataAttrSet([]string{"data-footnote-id", "data-lang"})))
+	want := `<p>Prose mentioning data-x="2" stays.</p>` +
+		`<img src="a.png" data-lang="en" alt="A &amp; B"><br/>` +
+		`<span class="c" data-footnote-id="f1">n</span>`
+	if got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestSanitizeForXHTML_KeepsAllClassesByDefault(t *testing.T) {
+	result := sanitizeForXHTML(`<p class="pullquote big-red">Quote</p>`)
+	if !strings.Contains(result, `class="pullquote big-red"`) {
+		t.Errorf("without an allowlist all classes should be kept, got %q", result)
+	}
+}
+
+func TestSanitizeForXHTMLOpts_StackWideTable(t *testing.T) {
+	input := `<table><caption>Results</caption>` +
+		`<thead><tr><th>Name</th><th>A</th><th>B</th><th>C</th></tr></thead>` +
+		`<tbody><tr><td>Row one</td><td><em>1</em></td><td>2</td><td>3</td></tr></tbody></table>`
+	result := sanitizeForXHTMLOpts(input, sanitizeOpts{stackTableCols: 3})
+	if strings.Contains(result, "<table") {
+		t.Fatalf("wide table should be stacked, got %q", result)
+	}
+	for _, want := range []string{
+		`<div class="stacked-table">`,
+		`<p>Results</p>`,
+		`<dt>Name</dt><dd>Row one</dd>`,
+		`<dt>A</dt><dd><em>1</em></dd>`,
+	} {
+		if !strings.Contains(result, want) {
+			t.Errorf("expected %q in %q", want, result)
+		}
+	}
+	if err := xml.Unmarshal([]byte("<root>"+result+"</root>"), new(interface{})); err != nil {
+		t.Errorf("stacked table is not well-formed XML: %v", err)
+	}
+}
+
+func TestSanitizeForXHTMLOpts_NarrowTableUnchanged(t *testing.T) {
+	input := `<table><tr><th>A</th><th>B</th></tr><tr><td>1</td><td>2</td></tr></table>`
+	result := sanitizeForXHTMLOpts(input, sanitizeOpts{stackTableCols: 3})
+	if !strings.Contains(result, "<table>") || strings.Contains(result, "<dl>") {
+		t.Errorf("narrow table should stay a table, got %q", result)
+	}
+}
+
+func TestSanitizeForXHTMLOpts_StackTableWithoutHeaders(t *testing.T) {
+	input := `<table><tr><td>1</td><td colspan="3">2</td></tr></table>`
+	result := sanitizeForXHTMLOpts(input, sanitizeOpts{stackTableCols: 2})
+	if !strings.Contains(result, "<dt>Column 1</dt><dd>1</dd><dt>Column 2</dt><dd>2</dd>") {
+		t.Errorf("expected generic column labels, got %q", result)
+	}
+}
+
+func TestSanitizeForXHTMLOpts_StackTableSpans(t *testing.T) {
+	input := `<table class="results">` +
+		`<tr><th rowspan="2">Region</th><th colspan="2">Sales</th><th rowspan="2">Notes</th></tr>` +
+		`<tr><th>2023</th><th>2024</th></tr>` +
+		`<tr><td rowspan="2">North</td><td>1</td><td>2</td><td>Up</td></tr>` +
+		`<tr><td colspan="2">n/a</td><td>New</td></tr></table>`
+	result := sanitizeForXHTMLOpts(input, sanitizeOpts{stackTableCols: 3, keepClasses: map[string]bool{}})
+	for _, want := range []string{
+		`<div class="stacked-table">`,
+		`<dl><dt>Region</dt><dd>North</dd><dt>Sales / 2023</dt><dd>1</dd><dt>Sales / 2024</dt><dd>2</dd><dt>Notes</dt><dd>Up</dd></dl>`,
+		`<dl><dt>Region</dt><dd>North</dd><dt>Sales / 2023</dt><dd>n/a</dd><dt>Notes</dt><dd>New</dd></dl>`,
+	} {
+		if !strings.Contains(result, want) {
+			t.Errorf("expected %q in %q", want, result)
+		}
+	}
+}
+
+func TestSanitizeForXHTML_MathML(t *testing.T) {
+	input := `<p>Euler: <math display="block"><mrow><msup><mi>e</mi><mrow><mi>i</mi><mi>&pi;</mi></mrow></msup><mo stretchy="false">+</mo><mn>1</mn></mrow></math></p>`
+	result := sanitizeForXHTML(input)
+	for _, want := range []string{
+		`<math xmlns="http://www.w3.org/1998/Math/MathML" display="block">`,
+		`<msup><mi>e</mi>`,
+		`<mo stretchy="false">+</mo>`,
+	} {
+		if !strings.Contains(result, want) {
+			t.Errorf("expected %q in %q", want, result)
+		}
+	}
+	if err := xml.Unmarshal([]byte("<div>"+result+"</div>"), new(interface{})); err != nil {
+		t.Errorf("MathML output is not well-formed XML: %v", err)
+	}
+}
+
+func TestSanitizeForXHTMLOpts_PopupFootnotes(t *testing.T) {
+	input := `<p>Claim.<sup id="fnref1"><a href="#fn1">1</a></sup> Other.<sup><a href="#fn2">2</a></sup></p>
+<div class="footnotes"><hr><ol>
+<li id="fn1"><p>First note. <a href="#fnref1">↩</a></p></li>
+<li id="fn2"><p>Second note.</p></li>
+</ol></div>`
+	result := sanitizeForXHTMLOpts(input, sanitizeOpts{popupFootnotes: true})
+
+	if !strings.Contains(result, `<a href="#fn1" epub:type="noteref">1</a>`) {
+		t.Errorf("footnote link should be a noteref, got %q", result)
+	}
+	if !strings.Contains(result, `<aside id="fn1" epub:type="footnote"><p>First note. <a href="#fnref1">↩</a></p></aside>`) {
+		t.Errorf("footnote should move into a popup aside, got %q", result)
+	}
+	if !strings.Contains(result, `<aside id="fn2" epub:type="footnote">`) {
+		t.Error("second footnote should be converted too")
+	}
+	if strings.Contains(result, "<ol>") || strings.Contains(result, "footnotes") || strings.Contains(result, "<hr") {
+		t.Errorf("emptied footnote list should be removed, got %q", result)
+	}
+	if err := xml.Unmarshal([]byte("<div xmlns:epub=\"http://www.idpf.org/2007/ops\">"+result+"</div>"), new(interface{})); err != nil {
+		t.Errorf("output is not well-formed XML: %v", err)
+	}
+}
+
+func TestSanitizeForXHTMLOpts_PopupFootnotesNonListTarget(t *testing.T) {
+	input := `<p>Text<a href="#note-1">*</a></p><p id="note-1">* The note.</p><p>After.</p>`
+	result := sanitizeForXHTMLOpts(input, sanitizeOpts{popupFootnotes: true})
+	if !strings.Contains(result, `<aside id="note-1" epub:type="footnote"><p>* The note.</p></aside>`) {
+		t.Errorf("paragraph footnote should be wrapped in an aside, got %q", result)
+	}
+	if !strings.Contains(result, "<p>After.</p>") {
+		t.Error("surrounding content should be kept")
+	}
+}
+
+func TestSanitizeForXHTML_FootnotesUntouchedByDefault(t *testing.T) {
+	input := `<p>Claim<a href="#fn1">1</a></p><ol><li id="fn1">Note.</li></ol><p><a href="#section2">not a note</a></p>`
+	result := sanitizeForXHTML(input)
+	if strings.Contains(result, "epub:type") || !strings.Contains(result, `<li id="fn1">Note.</li>`) {
+		t.Errorf("footnotes should be left as-is without the option, got %q", result)
+	}
+
+	// Links that aren't footnote-shaped, or point nowhere, are ignored.
+	result = sanitizeForXHTMLOpts(`<p><a href="#fn9">9</a><a href="#intro">x</a></p><h2 id="intro">Intro</h2>`, sanitizeOpts{popupFootnotes: true})
+	if strings.Contains(result, "epub:type") || strings.Contains(result, "<aside") {
+		t.Errorf("unexpected footnote conversion: %q", result)
+	}
+}
+
+func TestSanitizeForXHTML_DetailsKept(t *testing.T) {
+	input := `<details open><summary>Read more</summary><p>Hidden text.</p></details>`
+	result := sanitizeForXHTML(input)
+	want := `<details open=""><summary>Read more</summary><p>Hidden text.</p></details>`
+	if result != want {
+		t.Errorf("got %q, want %q", result, want)
+	}
+}
+
+func TestSanitizeForXHTMLOpts_DetailsExpanded(t *testing.T) {
+	input := `<details><summary>Read <em>more</em></summary><p>Hidden text.</p><details><summary>Nested</summary>Deep.</details></details>`
+	result := sanitizeForXHTMLOpts(input, sanitizeOpts{expandDetails: true})
+	want := `<div><p><strong>Read <em>more</em></strong></p><p>Hidden text.</p><div><p><strong>Nested</strong></p>Deep.</div></div>`
+	if result != want {
+		t.Errorf("got %q, want %q", result, want)
+	}
+}
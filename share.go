@@ -0,0 +1,180 @@
+// Share widget removal (epub; -keep-share turns it off).
+// Readability often keeps the "Share on Twitter / Facebook / Email" rows
+// sites put above and below articles. A container is dropped only when
+// every link in it is a share link, they name at least two platforms, and
+// it has almost no other text, so ordinary links to those sites survive.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// maxShareWidgetWords is how many words of text besides the share links
+// ("Share this article:") a share widget may have.
+const maxShareWidgetWords = 6
+
+// shareWords are the words that text around share links may use. A
+// sentence that merely mentions two platforms has others.
+var shareWords = map[string]bool{
+	"share": true, "this": true, "article": true, "story": true, "post": true,
+	"page": true, "on": true, "to": true, "via": true, "with": true, "it": true,
+	"the": true, "follow": true, "us": true, "or": true, "and": true,
+	"sharing": true, "options": true, "links": true, "link": true, "copy": true,
+}
+
+var (
+	// Matches the verb phrases share links wrap around a platform name.
+	sharePrefixRe = regexp.MustCompile(`^(?:share|post|send|tweet)(?: (?:this|it|article|story|post|page))?(?: (?:on|to|via|with|by))?\s*`)
+
+	// Matches share endpoints, for icon links with no usable text.
+	shareHrefRe = regexp.MustCompile(`(?i)^(?:mailto:\?|https?://(?:www\.)?(?:twitter\.com/(?:intent|share)|x\.com/intent|facebook\.com/shar|linkedin\.com/(?:share|cws/share)|reddit\.com/submit|pinterest\.com/pin/create|api\.whatsapp\.com/send|wa\.me/\?|t\.me/share|bsky\.app/intent|news\.ycombinator\.com/submitlink|getpocket\.com/(?:save|edit)|(?:www\.)?tumblr\.com/(?:share|widgets/share)))`)
+)
+
+// sharePlatforms maps share link text to the platform it names.
+var sharePlatforms = map[string]string{
+	"twitter": "twitter", "x": "twitter", "x (twitter)": "twitter", "tweet": "twitter",
+	"facebook": "facebook", "linkedin": "linkedin", "reddit": "reddit",
+	"email": "email", "e-mail": "email", "mail": "email", "print": "print",
+	"whatsapp": "whatsapp", "pinterest": "pinterest", "mastodon": "mastodon",
+	"bluesky": "bluesky", "threads": "threads", "telegram": "telegram",
+	"tumblr": "tumblr", "pocket": "pocket", "flipboard": "flipboard",
+	"hacker news": "hacker news", "copy link": "copy link",
+}
+
+// shareContainers are the elements that can hold a share widget.
+var shareContainers = map[atom.Atom]bool{
+	atom.Div: true, atom.Ul: true, atom.Ol: true, atom.P: true, atom.Section: true,
+	atom.Aside: true, atom.Nav: true, atom.Footer: true, atom.Header: true,
+}
+
+// stripShareWidgets removes share button rows from content, logging how
+// many were dropped.
+func stripShareWidgets(content string) string {
+	if !strings.Contains(content, "<a") && !strings.Contains(content, "<button") {
+		return content
+	}
+	body := &html.Node{Type: html.ElementNode, DataAtom: atom.Body, Data: "body"}
+	nodes, err := html.ParseFragment(strings.NewReader(content), body)
+	if err != nil {
+		return content
+	}
+	for _, n := range nodes {
+		body.AppendChild(n)
+	}
+
+	var widgets []*html.Node
+	var find func(n *html.Node)
+	find = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.ElementNode && shareContainers[c.DataAtom] && isShareWidget(c) {
+				widgets = append(widgets, c)
+				continue
+			}
+			find(c)
+		}
+	}
+	find(body)
+	if len(widgets) == 0 {
+		return content
+	}
+	for _, w := range widgets {
+		w.Parent.RemoveChild(w)
+	}
+	fmt.Fprintf(logOut, "Removed %d share widgets\n", len(widgets))
+
+	var buf bytes.Buffer
+	for c := body.FirstChild; c != nil; c = c.NextSibling {
+		html.Render(&buf, c)
+	}
+	return buf.String()
+}
+
+// isShareWidget reports whether n holds share links for at least two
+// platforms, no other links or media, and at most maxShareWidgetWords other
+// words, all of them shareWords.
+func isShareWidget(n *html.Node) bool {
+	platforms := map[string]bool{}
+	words := 0
+	other := false
+	countWords := func(text string) {
+		for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}) {
+			words++
+			other = other || !shareWords[w]
+		}
+	}
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil && !other; c = c.NextSibling {
+			switch {
+			case c.Type == html.TextNode:
+				countWords(c.Data)
+			case c.Type != html.ElementNode:
+			case c.DataAtom == atom.A || c.DataAtom == atom.Button:
+				if p := sharePlatform(c); p != "" {
+					platforms[p] = true
+				} else if c.DataAtom == atom.A {
+					other = true
+				} else {
+					countWords(textContent(c))
+				}
+			case c.DataAtom == atom.Img || c.DataAtom == atom.Video || c.DataAtom == atom.Table:
+				other = true
+			default:
+				walk(c)
+			}
+		}
+	}
+	walk(n)
+	return !other && len(platforms) >= 2 && words <= maxShareWidgetWords
+}
+
+// sharePlatform returns the platform a share link or button names in its
+// text, aria-label, or title, or the host of a share endpoint it points
+// to; "" if it isn't a share link. A named platform only counts for a
+// button, a share endpoint, or a script-driven link (no href, "#", or
+// javascript:), so a list of a site's own Twitter and Facebook pages stays.
+func sharePlatform(n *html.Node) string {
+	href := strings.TrimSpace(getAttr(n, "href"))
+	if n.DataAtom != atom.Button && !shareHrefRe.MatchString(href) && !isScriptHref(href) {
+		return ""
+	}
+	for _, label := range []string{textContent(n), getAttr(n, "aria-label"), getAttr(n, "title")} {
+		label = strings.Join(strings.Fields(strings.ToLower(label)), " ")
+		if label == "" {
+			continue
+		}
+		if p, ok := sharePlatforms[label]; ok {
+			return p
+		}
+		if p, ok := sharePlatforms[sharePrefixRe.ReplaceAllString(label, "")]; ok {
+			return p
+		}
+		break // a label that names no platform
+	}
+	if !shareHrefRe.MatchString(href) {
+		return ""
+	}
+	if strings.HasPrefix(strings.ToLower(href), "mailto:") {
+		return "email"
+	}
+	if u, err := url.Parse(href); err == nil {
+		return strings.TrimPrefix(strings.ToLower(u.Host), "www.")
+	}
+	return ""
+}
+
+// isScriptHref reports whether href leads nowhere on its own, as on links
+// that scripts turn into buttons.
+func isScriptHref(href string) bool {
+	return href == "" || href == "#" || strings.HasPrefix(strings.ToLower(href), "javascript:")
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStripShareWidgets(t *testing.T) {
+	for _, tc := range []struct{ in, want string }{
+		{
+			`<p>Intro.</p><div class="share"><span>Share this article:</span><ul><li><a href="https://twitter.com/intent/tweet?url=x">Twitter</a></li><li><a href="https://www.facebook.com/sharer/sharer.php?u=x">Facebook</a></li><li><a href="mailto:?subject=x">Email</a></li></ul></div><p>Body.</p>`,
+			`<p>Intro.</p><p>Body.</p>`,
+		},
+		{`<p><a href="#">Share on X</a> | <a href="#">Share on LinkedIn</a> | <button>Print</button></p><p>Body.</p>`, `<p>Body.</p>`},
+		{`<div><a href="https://www.reddit.com/submit?url=x" aria-label="Reddit"><svg></svg></a><a href="https://www.linkedin.com/shareArticle?url=x"><svg></svg></a></div><p>Body.</p>`, `<p>Body.</p>`},
+		// Prose that mentions platforms, single share links, and rows with
+		// other links stay.
+		{`<p>I posted it on <a href="https://twitter.com/me">Twitter</a> and <a href="https://facebook.com/me">Facebook</a>.</p>`, `<p>I posted it on <a href="https://twitter.com/me">Twitter</a> and <a href="https://facebook.com/me">Facebook</a>.</p>`},
+		{`<p><a href="mailto:?subject=x">Email</a> this story</p>`, `<p><a href="mailto:?subject=x">Email</a> this story</p>`},
+		{`<ul><li><a href="/a">Previous story</a></li><li><a href="#">Twitter</a></li><li><a href="#">Facebook</a></li></ul>`, `<ul><li><a href="/a">Previous story</a></li><li><a href="#">Twitter</a></li><li><a href="#">Facebook</a></li></ul>`},
+		// Links to a site's own profiles name platforms but share nothing.
+		{`<ul><li><a href="https://twitter.com/nasa">Twitter</a></li><li><a href="https://www.facebook.com/nasa">Facebook</a></li><li><a href="mailto:press@example.com">Email</a></li></ul>`, `<ul><li><a href="https://twitter.com/nasa">Twitter</a></li><li><a href="https://www.facebook.com/nasa">Facebook</a></li><li><a href="mailto:press@example.com">Email</a></li></ul>`},
+		{`<p><a href="javascript:void(0)">Tweet</a> <a>Email</a></p><p>Body.</p>`, `<p>Body.</p>`},
+	} {
+		if got := stripShareWidgets(tc.in); got != tc.want {
+			t.Errorf("stripShareWidgets(%s)\n got %s\nwant %s", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestProcessURL_ShareWidgets(t *testing.T) {
+	page := makeArticleHTML("Shared", `Real content.</p><p><a href="https://twitter.com/intent/tweet">Twitter</a> <a href="https://www.facebook.com/sharer.php">Facebook</a>`)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(page))
+	}))
+	defer srv.Close()
+	for _, tc := range []struct {
+		cfg  cliConfig
+		kept bool
+	}{
+		{cliConfig{format: "epub"}, false},
+		{cliConfig{format: "epub", keepShare: true}, true},
+		{cliConfig{format: "html"}, true},
+	} {
+		tc.cfg.timeout = 5 * time.Second
+		out, _, _, err := processURL(srv.URL, tc.cfg, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Contains(out, "Facebook"); got != tc.kept {
+			t.Errorf("%s (keepShare %v): share links kept = %v, want %v", tc.cfg.format, tc.cfg.keepShare, got, tc.kept)
+		}
+	}
+}
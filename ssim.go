@@ -0,0 +1,96 @@
+// Perceptual JPEG quality (-target-ssim).
+// Instead of one fixed quality for every image, searches for the lowest
+// JPEG quality whose result stays structurally similar to the source.
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+)
+
+// Quality range searched for -target-ssim.
+const (
+	minSSIMQuality = 10
+	maxSSIMQuality = 95
+)
+
+// ssimBlock is the side of the square windows SSIM is averaged over.
+const ssimBlock = 8
+
+// encodeJPEGForSSIM encodes img at the lowest quality in
+// [minSSIMQuality, maxSSIMQuality] whose decoded result has an SSIM of at
+// least target against img, falling back to maxSSIMQuality when none does.
+// SSIM grows with quality, so a binary search takes about seven encodes.
+func encodeJPEGForSSIM(img image.Image, target float64) (*bytes.Buffer, int, error) {
+	ref := toGrayscale(img)
+	var best *bytes.Buffer
+	bestQ := 0
+	lo, hi := minSSIMQuality, maxSSIMQuality
+	for lo <= hi {
+		q := (lo + hi) / 2
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: q}); err != nil {
+			return nil, 0, err
+		}
+		dec, err := jpeg.Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			return nil, 0, err
+		}
+		if ssim(ref, toGrayscale(dec)) >= target {
+			best, bestQ = &buf, q
+			hi = q - 1
+		} else {
+			lo = q + 1
+		}
+	}
+	if best == nil {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: maxSSIMQuality}); err != nil {
+			return nil, 0, err
+		}
+		best, bestQ = &buf, maxSSIMQuality
+	}
+	return best, bestQ, nil
+}
+
+// ssim returns the mean structural similarity of two same-sized grayscale
+// images over non-overlapping ssimBlock windows: 1 for identical images,
+// lower as structure, contrast, or brightness diverge.
+func ssim(a, b *image.Gray) float64 {
+	const (
+		c1 = (0.01 * 255) * (0.01 * 255)
+		c2 = (0.03 * 255) * (0.03 * 255)
+	)
+	ab, bb := a.Bounds(), b.Bounds()
+	w, h := min(ab.Dx(), bb.Dx()), min(ab.Dy(), bb.Dy())
+	if w == 0 || h == 0 {
+		return 1
+	}
+	var total float64
+	blocks := 0
+	for y0 := 0; y0 < h; y0 += ssimBlock {
+		for x0 := 0; x0 < w; x0 += ssimBlock {
+			x1, y1 := min(x0+ssimBlock, w), min(y0+ssimBlock, h)
+			n := float64((x1 - x0) * (y1 - y0))
+			var sa, sb, saa, sbb, sab float64
+			for y := y0; y < y1; y++ {
+				ra, rb := a.Pix[y*a.Stride:], b.Pix[y*b.Stride:]
+				for x := x0; x < x1; x++ {
+					va, vb := float64(ra[x]), float64(rb[x])
+					sa += va
+					sb += vb
+					saa += va * va
+					sbb += vb * vb
+					sab += va * vb
+				}
+			}
+			ma, mb := sa/n, sb/n
+			vara, varb := saa/n-ma*ma, sbb/n-mb*mb
+			cov := sab/n - ma*mb
+			total += ((2*ma*mb + c1) * (2*cov + c2)) / ((ma*ma + mb*mb + c1) * (vara + varb + c2))
+			blocks++
+		}
+	}
+	return total / float64(blocks)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math/rand"
+	"testing"
+)
+
+func TestSSIM(t *testing.T) {
+	a := image.NewGray(image.Rect(0, 0, 20, 13))
+	for i := range a.Pix {
+		a.Pix[i] = uint8(i * 7)
+	}
+	if got := ssim(a, a); got < 0.9999 {
+		t.Errorf("identical images: ssim = %f, want 1", got)
+	}
+	b := image.NewGray(a.Rect)
+	copy(b.Pix, a.Pix)
+	for i := range b.Pix {
+		if i%3 == 0 {
+			b.Pix[i] = 255 - b.Pix[i]
+		}
+	}
+	if got := ssim(a, b); got > 0.8 {
+		t.Errorf("heavily altered image: ssim = %f, want well below 1", got)
+	}
+}
+
+func TestEncodeJPEGForSSIM(t *testing.T) {
+	smooth := image.NewNRGBA(image.Rect(0, 0, 120, 80))
+	noisy := image.NewNRGBA(smooth.Rect)
+	rng := rand.New(rand.NewSource(1))
+	for y := 0; y < 80; y++ {
+		for x := 0; x < 120; x++ {
+			smooth.Set(x, y, color.NRGBA{uint8(x * 2), uint8(y * 3), 128, 255})
+			v := uint8(rng.Intn(256))
+			noisy.Set(x, y, color.NRGBA{v, v, uint8(x), 255})
+		}
+	}
+
+	const target = 0.95
+	quality := map[string]int{}
+	for name, img := range map[string]image.Image{"smooth": smooth, "noisy": noisy} {
+		buf, q, err := encodeJPEGForSSIM(img, target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dec, err := jpeg.Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := ssim(toGrayscale(img), toGrayscale(dec)); s < target && q != maxSSIMQuality {
+			t.Errorf("%s: quality %d gives ssim %f, below target %g", name, q, s, target)
+		}
+		quality[name] = q
+	}
+	if quality["smooth"] >= quality["noisy"] {
+		t.Errorf("smooth image should need a lower quality than noise, got %v", quality)
+	}
+}
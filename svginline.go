@@ -0,0 +1,228 @@
+// Inline SVG sanitizing (-svg-inline).
+// An SVG kept as an image file can't run scripts or load anything, but
+// inlined into the page it can. So the markup is rebuilt from an allowlist
+// of drawing elements and attributes, every reference outside the SVG is
+// dropped, and its ids are prefixed so several SVGs on one page (and the
+// page's own ids) can't collide.
+package main
+
+import (
+	"encoding/xml"
+	"html"
+	"io"
+	"regexp"
+	"strings"
+)
+
+const (
+	svgNS   = "http://www.w3.org/2000/svg"
+	xlinkNS = "http://www.w3.org/1999/xlink"
+	xmlNS   = "http://www.w3.org/XML/1998/namespace"
+)
+
+// svgElements are the SVG elements kept when inlining: shapes, text,
+// grouping and reuse, paint servers, clipping and masking, and filters.
+// Anything else (script, style, foreignObject, image, animation, metadata)
+// is dropped with its content.
+var svgElements = map[string]bool{
+	"svg": true, "g": true, "defs": true, "symbol": true, "use": true, "title": true, "desc": true,
+	"path": true, "rect": true, "circle": true, "ellipse": true, "line": true, "polyline": true, "polygon": true,
+	"text": true, "tspan": true, "textPath": true,
+	"linearGradient": true, "radialGradient": true, "stop": true, "pattern": true, "marker": true,
+	"clipPath": true, "mask": true,
+	"filter": true, "feBlend": true, "feColorMatrix": true, "feComponentTransfer": true, "feComposite": true,
+	"feFlood": true, "feGaussianBlur": true, "feMerge": true, "feMergeNode": true, "feMorphology": true,
+	"feOffset": true, "feFuncR": true, "feFuncG": true, "feFuncB": true, "feFuncA": true,
+}
+
+// svgUnwrapped are elements dropped while their content is kept: links
+// would lead out of the book.
+var svgUnwrapped = map[string]bool{"a": true, "switch": true}
+
+// svgAttrs are the unprefixed attributes kept: geometry, presentation,
+// text layout, paint server and filter parameters, and accessibility.
+var svgAttrs = map[string]bool{
+	"id": true, "width": true, "height": true, "viewBox": true, "preserveAspectRatio": true, "version": true,
+	"x": true, "y": true, "x1": true, "y1": true, "x2": true, "y2": true, "cx": true, "cy": true,
+	"r": true, "rx": true, "ry": true, "fx": true, "fy": true, "d": true, "points": true, "pathLength": true,
+	"transform": true, "href": true, "style": true, "role": true, "aria-label": true, "aria-hidden": true,
+	"fill": true, "fill-opacity": true, "fill-rule": true, "stroke": true, "stroke-width": true,
+	"stroke-opacity": true, "stroke-linecap": true, "stroke-linejoin": true, "stroke-dasharray": true,
+	"stroke-dashoffset": true, "stroke-miterlimit": true, "opacity": true, "color": true,
+	"visibility": true, "display": true, "overflow": true, "vector-effect": true,
+	"clip-path": true, "clip-rule": true, "mask": true, "filter": true,
+	"marker-start": true, "marker-mid": true, "marker-end": true,
+	"font-family": true, "font-size": true, "font-weight": true, "font-style": true, "font-variant": true,
+	"font-stretch": true, "text-anchor": true, "dominant-baseline": true, "alignment-baseline": true,
+	"baseline-shift": true, "letter-spacing": true, "word-spacing": true, "text-decoration": true,
+	"writing-mode": true, "dx": true, "dy": true, "rotate": true, "textLength": true, "lengthAdjust": true,
+	"startOffset": true, "offset": true, "stop-color": true, "stop-opacity": true,
+	"gradientUnits": true, "gradientTransform": true, "spreadMethod": true,
+	"patternUnits": true, "patternContentUnits": true, "patternTransform": true,
+	"clipPathUnits": true, "maskUnits": true, "maskContentUnits": true,
+	"markerWidth": true, "markerHeight": true, "markerUnits": true, "refX": true, "refY": true, "orient": true,
+	"filterUnits": true, "primitiveUnits": true, "color-interpolation-filters": true,
+	"in": true, "in2": true, "result": true, "stdDeviation": true, "mode": true, "operator": true,
+	"k1": true, "k2": true, "k3": true, "k4": true, "values": true, "type": true, "tableValues": true,
+	"slope": true, "intercept": true, "amplitude": true, "exponent": true, "radius": true,
+	"flood-color": true, "flood-opacity": true,
+}
+
+var (
+	// Matches a url(...) reference in an attribute or style value; group 1
+	// is the reference
+	svgURLRe = regexp.MustCompile(`url\(\s*['"]?([^'")]*)['"]?\s*\)`)
+	// Matches the start of a fragment url(#...) reference, to prefix the id
+	svgFragmentURLRe = regexp.MustCompile(`url\(\s*(['"]?)#`)
+	// Matches values no kept attribute should carry
+	svgUnsafeValueRe = regexp.MustCompile(`(?i)javascript:|expression\s*\(|@import`)
+)
+
+// sanitizeSVG rebuilds an SVG document for inlining, keeping only allowed
+// elements and attributes, dropping references that don't point within
+// the SVG, and prefixing its ids (and references to them) with prefix. It
+// reports false if the document isn't well-formed XML or isn't an SVG.
+func sanitizeSVG(doc []byte, prefix string) (string, bool) {
+	d := xml.NewDecoder(strings.NewReader(string(doc)))
+	var b strings.Builder
+	var open []string // kept elements not yet closed
+	var stack []bool  // for each open element, whether it was written
+	skip := 0         // depth within a dropped element
+	pending := false  // a start tag awaits ">" or "/>"
+	usesXlink := false
+	rootEnd := -1 // where the root's namespace declarations go
+
+	closePending := func() {
+		if pending {
+			b.WriteByte('>')
+			pending = false
+		}
+	}
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", false
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if skip > 0 {
+				skip++
+				continue
+			}
+			if rootEnd < 0 && (t.Name.Local != "svg" || (t.Name.Space != svgNS && t.Name.Space != "")) {
+				return "", false
+			}
+			inSVG := t.Name.Space == svgNS || t.Name.Space == ""
+			if !inSVG || !svgElements[t.Name.Local] {
+				if inSVG && svgUnwrapped[t.Name.Local] {
+					stack = append(stack, false)
+					continue
+				}
+				skip = 1
+				continue
+			}
+			closePending()
+			b.WriteString("<" + t.Name.Local)
+			for _, a := range t.Attr {
+				name, val, ok := svgAttr(a, prefix)
+				if !ok {
+					continue
+				}
+				usesXlink = usesXlink || strings.HasPrefix(name, "xlink:")
+				b.WriteString(" " + name + `="` + html.EscapeString(val) + `"`)
+			}
+			if rootEnd < 0 {
+				rootEnd = b.Len()
+			}
+			pending = true
+			open = append(open, t.Name.Local)
+			stack = append(stack, true)
+		case xml.EndElement:
+			if skip > 0 {
+				skip--
+				continue
+			}
+			written := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if !written {
+				continue
+			}
+			name := open[len(open)-1]
+			open = open[:len(open)-1]
+			if pending {
+				b.WriteString("/>")
+				pending = false
+			} else {
+				b.WriteString("</" + name + ">")
+			}
+			if len(open) == 0 {
+				return svgWithNamespaces(b.String(), rootEnd, usesXlink), true
+			}
+		case xml.CharData:
+			if skip > 0 || len(open) == 0 {
+				continue
+			}
+			closePending()
+			b.WriteString(html.EscapeString(string(t)))
+		}
+	}
+	return "", false // no root, or it was never closed
+}
+
+// svgWithNamespaces adds the SVG (and, if used, XLink) namespace
+// declarations to the root start tag, which ends at rootEnd in svg.
+func svgWithNamespaces(svg string, rootEnd int, xlink bool) string {
+	ns := ` xmlns="` + svgNS + `"`
+	if xlink {
+		ns += ` xmlns:xlink="` + xlinkNS + `"`
+	}
+	return svg[:rootEnd] + ns + svg[rootEnd:]
+}
+
+// svgAttr returns the name and value to write for an SVG attribute, or
+// false to drop it.
+func svgAttr(a xml.Attr, prefix string) (name, val string, ok bool) {
+	switch a.Name.Space {
+	case "":
+		if !svgAttrs[a.Name.Local] {
+			return "", "", false
+		}
+		name = a.Name.Local
+	case xlinkNS:
+		if a.Name.Local != "href" {
+			return "", "", false
+		}
+		name = "xlink:href"
+	case xmlNS:
+		if a.Name.Local != "space" && a.Name.Local != "lang" {
+			return "", "", false
+		}
+		name = "xml:" + a.Name.Local
+	default:
+		return "", "", false
+	}
+	val = a.Value
+	if svgUnsafeValueRe.MatchString(val) {
+		return "", "", false
+	}
+	for _, m := range svgURLRe.FindAllStringSubmatch(val, -1) {
+		if !strings.HasPrefix(m[1], "#") {
+			return "", "", false
+		}
+	}
+	switch {
+	case name == "id":
+		val = prefix + val
+	case name == "href" || name == "xlink:href":
+		if !strings.HasPrefix(val, "#") {
+			return "", "", false
+		}
+		val = "#" + prefix + val[1:]
+	default:
+		val = svgFragmentURLRe.ReplaceAllString(val, "url(${1}#"+prefix)
+	}
+	return name, val, true
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSanitizeSVG(t *testing.T) {
+	doc := `<?xml version="1.0"?>
+<!DOCTYPE svg PUBLIC "-//W3C//DTD SVG 1.1//EN" "http://www.w3.org/Graphics/SVG/1.1/DTD/svg11.dtd">
+<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" xmlns:inkscape="http://www.inkscape.org/namespaces/inkscape" viewBox="0 0 10 10" onload="evil()" inkscape:version="1.0">` +
+		`<style>rect { fill: url(https://tracker.example/x.svg) }</style>` +
+		`<script>evil()</script>` +
+		`<foreignObject><div xmlns="http://www.w3.org/1999/xhtml">HTML</div></foreignObject>` +
+		`<defs><linearGradient id="grad"><stop offset="0" stop-color="red"/></linearGradient></defs>` +
+		`<rect id="box" width="10" height="10" fill="url(#grad)" style="stroke: url('#grad')"/>` +
+		`<rect width="5" height="5" fill="url(https://tracker.example/paint)"/>` +
+		`<use xlink:href="#box"/><use href="https://example.com/sprite.svg#icon"/>` +
+		`<image href="https://tracker.example/pixel.png" width="1" height="1"/>` +
+		`<a href="javascript:evil()"><text x="1" y="8">A &amp; B</text></a>` +
+		`</svg>`
+
+	got, ok := sanitizeSVG([]byte(doc), "svg2-")
+	if !ok {
+		t.Fatal("expected a well-formed SVG to be inlined")
+	}
+	want := `<svg viewBox="0 0 10 10" xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink">` +
+		`<defs><linearGradient id="svg2-grad"><stop offset="0" stop-color="red"/></linearGradient></defs>` +
+		`<rect id="svg2-box" width="10" height="10" fill="url(#svg2-grad)" style="stroke: url(&#39;#svg2-grad&#39;)"/>` +
+		`<rect width="5" height="5"/>` +
+		`<use xlink:href="#svg2-box"/><use/>` +
+		`<text x="1" y="8">A &amp; B</text>` +
+		`</svg>`
+	if got != want {
+		t.Errorf("sanitizeSVG:\n got %s\nwant %s", got, want)
+	}
+	if again, ok := sanitizeSVG([]byte(got), ""); !ok || again != got {
+		t.Errorf("output should be well-formed and already clean, got %q", again)
+	}
+
+	for _, bad := range []string{
+		`<svg><rect></svg>`,
+		`<html><body>not an svg</body></html>`,
+		`<svg>&nbsp;</svg>`,
+		``,
+	} {
+		if _, ok := sanitizeSVG([]byte(bad), "svg1-"); ok {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestInlineSVGImages_UniqueIDs(t *testing.T) {
+	svg := `<svg xmlns="http://www.w3.org/2000/svg"><clipPath id="c"><rect width="4" height="4"/></clipPath><circle r="4" clip-path="url(#c)"/></svg>`
+	img := `<img src="` + dataURI("image/svg+xml", []byte(svg)) + `" alt="Dot"/>`
+	body := inlineSVGImages(`<p>` + img + `</p><p>` + img + `</p>`)
+
+	for _, want := range []string{`id="svg1-c"`, `url(#svg1-c)`, `id="svg2-c"`, `url(#svg2-c)`} {
+		if strings.Count(body, want) != 1 {
+			t.Errorf("expected %s once in:\n%s", want, body)
+		}
+	}
+	if strings.Count(body, `role="img" aria-label="Dot"`) != 2 {
+		t.Errorf("expected both SVGs labelled from their alt text:\n%s", body)
+	}
+}
@@ -0,0 +1,87 @@
+// Stage timings (-timings).
+// To show where a slow run spends its time, each article's fetch,
+// extraction, image fetching, and image optimization are timed, as is
+// building the epub. The totals are printed when the run ends, and with -v
+// a line per URL as each article finishes.
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// timingStages are the stages timed, in pipeline order.
+var timingStages = []string{"fetch", "extract", "image-fetch", "optimize", "epub-build"}
+
+// stageTimer accumulates wall time per stage. A nil *stageTimer records
+// nothing, so untimed runs pay only a nil check.
+type stageTimer struct {
+	mu       sync.Mutex
+	times    map[string]time.Duration
+	articles int // per-URL timers merged in
+}
+
+func newStageTimer() *stageTimer {
+	return &stageTimer{times: map[string]time.Duration{}}
+}
+
+// runTimings totals the stage times of the whole run (nil = -timings off).
+// Set by run().
+var runTimings *stageTimer
+
+// since adds the time elapsed from start to stage.
+func (t *stageTimer) since(stage string, start time.Time) {
+	if t == nil {
+		return
+	}
+	d := time.Since(start)
+	t.mu.Lock()
+	t.times[stage] += d
+	t.mu.Unlock()
+}
+
+// merge adds one article's stage times to t.
+func (t *stageTimer) merge(article *stageTimer) {
+	if t == nil || article == nil {
+		return
+	}
+	article.mu.Lock()
+	defer article.mu.Unlock()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for stage, d := range article.times {
+		t.times[stage] += d
+	}
+	t.articles++
+}
+
+// String lists the stages that took any time, e.g.
+// "fetch 1.20s, extract 0.08s".
+func (t *stageTimer) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var parts []string
+	for _, stage := range timingStages {
+		if d, ok := t.times[stage]; ok {
+			parts = append(parts, fmt.Sprintf("%s %.2fs", stage, d.Seconds()))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// reportTimings prints the run's stage totals to stderr. Articles are
+// processed concurrently, so their stages can add up to more than the
+// run's wall time.
+func reportTimings(t *stageTimer, start time.Time) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	articles := t.articles
+	t.mu.Unlock()
+	fmt.Fprintf(os.Stderr, "Timings (wall %.2fs; stages summed over %d %s): %s\n",
+		time.Since(start).Seconds(), articles, plural(int64(articles), "article"), t)
+}
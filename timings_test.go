@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStageTimer(t *testing.T) {
+	var off *stageTimer
+	off.since("fetch", time.Now()) // must not panic
+	off.merge(newStageTimer())
+
+	article := newStageTimer()
+	article.since("optimize", time.Now().Add(-2*time.Second))
+	article.since("fetch", time.Now().Add(-time.Second))
+	article.since("fetch", time.Now().Add(-time.Second))
+	total := newStageTimer()
+	total.merge(article)
+	total.merge(article)
+	if got := article.String(); !strings.HasPrefix(got, "fetch 2.0") || !strings.Contains(got, ", optimize 2.0") {
+		t.Errorf("stages should be listed in pipeline order, got %q", got)
+	}
+	if total.articles != 2 || total.times["fetch"] < 4*time.Second {
+		t.Errorf("merge: %d articles, fetch %v", total.articles, total.times["fetch"])
+	}
+}
+
+func TestProcessURL_Timings(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(makeArticleHTML("Timed", "Timed article text.")))
+	}))
+	defer srv.Close()
+	runTimings = newStageTimer()
+	defer func() { runTimings = nil }()
+
+	cfg := cliConfig{format: "html", timeout: 5 * time.Second}
+	if _, _, _, err := processURL(srv.URL, cfg, ""); err != nil {
+		t.Fatal(err)
+	}
+	got := runTimings.String()
+	for _, stage := range []string{"fetch ", "extract ", "image-fetch ", "optimize "} {
+		if !strings.Contains(got, stage) {
+			t.Errorf("expected a %q time, got %q", stage, got)
+		}
+	}
+	if runTimings.articles != 1 {
+		t.Errorf("expected 1 article merged, got %d", runTimings.articles)
+	}
+}
+
+func TestFetchAndExtract_AMPTimings(t *testing.T) {
+	const delay = 200 * time.Millisecond
+	mux := http.NewServeMux()
+	mux.HandleFunc("/a", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Replace(makeArticleHTML("Timed", "Short."), "<head>", `<head><link rel="amphtml" href="/a/amp">`, 1)))
+	})
+	mux.HandleFunc("/a/amp", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		w.Write([]byte(makeArticleHTML("Timed", strings.Repeat("The AMP page has the whole story. ", 20))))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	timer := newStageTimer()
+	cfg := cliConfig{preferAMP: true, timeout: 5 * time.Second, stageTimes: timer}
+	if _, _, err := fetchAndExtract(srv.URL+"/a", cfg); err != nil {
+		t.Fatal(err)
+	}
+	if timer.times["fetch"] < delay || timer.times["extract"] >= delay {
+		t.Errorf("the AMP fetch should count as fetch and its extraction as extract, got %s", timer)
+	}
+}
@@ -0,0 +1,233 @@
+// In-process epub checks (-validate, -verify-images).
+// A lightweight stand-in for epubcheck: every section must be well-formed
+// XML, load nothing remotely, use valid unique ids, and link only to
+// fragments that exist; and the written book's images must match its
+// manifest.
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// epubSection is one XHTML document of a book, as handed to AddSection.
+type epubSection struct {
+	name string // file name within the xhtml directory, e.g. "article001.xhtml"
+	body string
+}
+
+// fragmentRef is a link to id frag in section file, made from section from.
+type fragmentRef struct {
+	from, file, frag string
+}
+
+// validateSections checks sections and returns one description per problem
+// found, in section order.
+func validateSections(sections []epubSection) []string {
+	var problems []string
+	ids := map[string]map[string]bool{}
+	var refs []fragmentRef
+
+	for _, s := range sections {
+		seen := map[string]bool{}
+		d := xml.NewDecoder(strings.NewReader("<body>" + s.body + "</body>"))
+		for {
+			tok, err := d.Token()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: not well-formed XML: %v", s.name, err))
+				break
+			}
+			el, ok := tok.(xml.StartElement)
+			if !ok {
+				continue
+			}
+			for _, a := range el.Attr {
+				switch {
+				case a.Name.Local == "id" && a.Name.Space == "":
+					if !validXMLID(a.Value) {
+						problems = append(problems, fmt.Sprintf("%s: invalid id %q on <%s>", s.name, a.Value, el.Name.Local))
+					} else if seen[a.Value] {
+						problems = append(problems, fmt.Sprintf("%s: duplicate id %q", s.name, a.Value))
+					}
+					seen[a.Value] = true
+				case a.Name.Local == "href" && el.Name.Local == "a":
+					if ref, ok := fragmentTarget(s.name, a.Value); ok {
+						refs = append(refs, ref)
+					}
+				case a.Name.Local == "src" || a.Name.Local == "href" || a.Name.Local == "poster" || a.Name.Local == "data":
+					if isRemote(a.Value) {
+						problems = append(problems, fmt.Sprintf("%s: <%s> loads remote resource %s", s.name, el.Name.Local, a.Value))
+					}
+				case a.Name.Local == "srcset":
+					for _, c := range strings.Split(a.Value, ",") {
+						if f := strings.Fields(c); len(f) > 0 && isRemote(f[0]) {
+							problems = append(problems, fmt.Sprintf("%s: <%s> loads remote resource %s", s.name, el.Name.Local, f[0]))
+						}
+					}
+				}
+			}
+		}
+		ids[s.name] = seen
+	}
+
+	for _, r := range refs {
+		// Links into documents go-epub generates (cover, nav) aren't checked.
+		if target, ok := ids[r.file]; ok && !target[r.frag] {
+			problems = append(problems, fmt.Sprintf("%s: link to missing fragment %s#%s", r.from, r.file, r.frag))
+		}
+	}
+	return problems
+}
+
+// fragmentTarget resolves an <a href> in section from to the section and
+// id it points at, if it is an in-book link with a fragment.
+func fragmentTarget(from, href string) (fragmentRef, bool) {
+	u, err := url.Parse(href)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Fragment == "" {
+		return fragmentRef{}, false
+	}
+	file := from
+	if u.Path != "" {
+		file = u.Path[strings.LastIndex(u.Path, "/")+1:]
+	}
+	return fragmentRef{from: from, file: file, frag: u.Fragment}, true
+}
+
+// isRemote reports whether a resource URL points off the book.
+func isRemote(ref string) bool {
+	ref = strings.ToLower(strings.TrimSpace(ref))
+	return strings.HasPrefix(ref, "http://") || strings.HasPrefix(ref, "https://") || strings.HasPrefix(ref, "//")
+}
+
+// validXMLID reports whether id is a valid XML ID (an NCName).
+func validXMLID(id string) bool {
+	for i, r := range id {
+		switch {
+		case unicode.IsLetter(r) || r == '_':
+		case i > 0 && (unicode.IsDigit(r) || r == '-' || r == '.' || unicode.Is(unicode.Mn, r)):
+		default:
+			return false
+		}
+	}
+	return id != ""
+}
+
+// verifyEpubImages checks the images of the epub written at epubPath
+// (-verify-images): every image an XHTML document shows must be a file the
+// package manifest lists, and every image the manifest lists must exist and
+// be shown somewhere. It returns one description per problem.
+func verifyEpubImages(epubPath string) ([]string, error) {
+	zr, err := zip.OpenReader(epubPath)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	files := map[string]*zip.File{}
+	opfName := ""
+	for _, f := range zr.File {
+		files[f.Name] = f
+		if path.Ext(f.Name) == ".opf" {
+			opfName = f.Name
+		}
+	}
+	if opfName == "" {
+		return nil, fmt.Errorf("no package document")
+	}
+	var pkg struct {
+		Items []struct {
+			Href      string `xml:"href,attr"`
+			MediaType string `xml:"media-type,attr"`
+		} `xml:"manifest>item"`
+	}
+	if err := xml.Unmarshal(readZipEntry(files[opfName]), &pkg); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", opfName, err)
+	}
+
+	var problems, docs []string
+	images := map[string]bool{} // manifest image path -> shown
+	for _, item := range pkg.Items {
+		name := path.Join(path.Dir(opfName), item.Href)
+		switch {
+		case strings.HasPrefix(item.MediaType, "image/"):
+			images[name] = false
+			if files[name] == nil {
+				problems = append(problems, fmt.Sprintf("manifest lists %s, which is not in the book", name))
+			}
+		case item.MediaType == "application/xhtml+xml":
+			docs = append(docs, name)
+		}
+	}
+	for _, f := range zr.File {
+		if _, listed := images[f.Name]; !listed && strings.HasPrefix(mime.TypeByExtension(path.Ext(f.Name)), "image/") {
+			problems = append(problems, fmt.Sprintf("%s is in the book but not in the manifest", f.Name))
+		}
+	}
+
+	for _, doc := range docs {
+		d := xml.NewDecoder(bytes.NewReader(readZipEntry(files[doc])))
+		d.Strict = false
+		d.Entity = xml.HTMLEntity
+		for {
+			tok, err := d.Token()
+			if err != nil {
+				break
+			}
+			el, ok := tok.(xml.StartElement)
+			if !ok || (el.Name.Local != "img" && el.Name.Local != "image") {
+				continue
+			}
+			for _, a := range el.Attr {
+				if a.Name.Local != "src" && a.Name.Local != "href" {
+					continue
+				}
+				u, err := url.Parse(strings.TrimSpace(a.Value))
+				if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" {
+					continue // data: URIs and remote images are -validate's concern
+				}
+				name := path.Join(path.Dir(doc), u.Path)
+				if _, ok := images[name]; !ok {
+					problems = append(problems, fmt.Sprintf("%s: <%s> shows %s, which is not in the manifest", doc, el.Name.Local, name))
+					continue
+				}
+				images[name] = true
+			}
+		}
+	}
+	var unused []string
+	for name, shown := range images {
+		if !shown && files[name] != nil {
+			unused = append(unused, name)
+		}
+	}
+	slices.Sort(unused)
+	for _, name := range unused {
+		problems = append(problems, fmt.Sprintf("%s is never shown", name))
+	}
+	return problems, nil
+}
+
+// readZipEntry returns the contents of f, or nil if it can't be read.
+func readZipEntry(f *zip.File) []byte {
+	if f == nil {
+		return nil
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil
+	}
+	defer rc.Close()
+	data, _ := io.ReadAll(rc)
+	return data
+}
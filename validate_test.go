@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"image/color"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateSections(t *testing.T) {
+	good := []epubSection{
+		{"contents.xhtml", `<section epub:type="toc"><a href="article001.xhtml">One</a></section>`},
+		{"article001.xhtml", `<h1 id="top">One</h1><p>See <a href="#fn1">1</a> and <a href="article002.xhtml#s2">two</a>,` +
+			` <a href="https://example.com/">a site</a>, <a href="cover.xhtml#x">cover</a>.</p>` +
+			`<aside id="fn1">Note.</aside><img src="../images/a.jpg" alt=""/>`},
+		{"article002.xhtml", `<h2 id="s2">Two</h2>`},
+	}
+	if problems := validateSections(good); len(problems) != 0 {
+		t.Errorf("expected no problems, got %q", problems)
+	}
+
+	bad := []epubSection{
+		{"article001.xhtml", `<p id="1st">x</p><p id="a">y</p><p id="a">z</p><a href="#gone">g</a>` +
+			`<img src="https://example.com/a.jpg"/><img src="x.jpg" srcset="//cdn.example.com/b.jpg 2x"/>` +
+			`<a href="article002.xhtml#nope">n</a>`},
+		{"article002.xhtml", `<p>unclosed`},
+	}
+	problems := validateSections(bad)
+	for _, want := range []string{
+		`article001.xhtml: invalid id "1st" on <p>`,
+		`article001.xhtml: duplicate id "a"`,
+		`article001.xhtml: <img> loads remote resource https://example.com/a.jpg`,
+		`article001.xhtml: <img> loads remote resource //cdn.example.com/b.jpg`,
+		`article002.xhtml: not well-formed XML`,
+		`article001.xhtml: link to missing fragment article001.xhtml#gone`,
+		`article001.xhtml: link to missing fragment article002.xhtml#nope`,
+	} {
+		if !strings.Contains(strings.Join(problems, "\n"), want) {
+			t.Errorf("missing problem %q in:\n%s", want, strings.Join(problems, "\n"))
+		}
+	}
+	if len(problems) != 7 {
+		t.Errorf("expected 7 problems, got %d", len(problems))
+	}
+}
+
+func TestBuildEpub_Validate(t *testing.T) {
+	dir := t.TempDir()
+	ok := []epubArticle{{
+		HTML:  `<html><body><h1>Fine</h1><p>Text<a href="#fn1">1</a>.</p><p id="fn1">Note.</p></body></html>`,
+		Title: "Fine",
+	}}
+	if err := buildEpub(ok, "Fine", filepath.Join(dir, "ok.epub"), epubOpts{coverStyle: "none", validate: true}); err != nil {
+		t.Fatalf("valid book should build: %v", err)
+	}
+
+	// The sanitizer drops http(s) images that were never embedded, but
+	// not protocol-relative ones.
+	remote := []epubArticle{{
+		HTML:  `<html><body><h1>Remote</h1><p><img src="//example.com/pic.jpg" alt="pic"></p></body></html>`,
+		Title: "Remote",
+	}}
+	out := filepath.Join(dir, "remote.epub")
+	err := buildEpub(remote, "Remote", out, epubOpts{coverStyle: "none", validate: true})
+	if err == nil || !strings.Contains(err.Error(), "failed validation with 1 problem") {
+		t.Fatalf("expected a validation failure, got %v", err)
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Error("an invalid book should not be written")
+	}
+	if err := buildEpub(remote, "Remote", out, epubOpts{coverStyle: "none"}); err != nil {
+		t.Errorf("without -validate the book should still build: %v", err)
+	}
+}
+
+func TestVerifyEpubImages(t *testing.T) {
+	articles := []epubArticle{{
+		HTML:  `<html><body><h1>Pictures</h1><p><img src="` + dataURI("image/png", makePNG(20, 20, color.NRGBA{200, 0, 0, 255})) + `" alt="Red"/></p></body></html>`,
+		Title: "Pictures",
+	}}
+	out := filepath.Join(t.TempDir(), "images.epub")
+	if err := buildEpub(articles, "Pictures", out, epubOpts{coverStyle: "typographic", verifyImages: true}); err != nil {
+		t.Fatalf("a correctly built book should pass: %v", err)
+	}
+
+	// Point the article at an image that was never added, orphaning its own.
+	err := rewriteEpub(out, time.Time{}, func(name string, data []byte) []byte {
+		if path.Base(name) == "article001.xhtml" {
+			return bytes.ReplaceAll(data, []byte("ch001_img000.png"), []byte("ch001_img001.png"))
+		}
+		return data
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	problems, err := verifyEpubImages(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"EPUB/xhtml/article001.xhtml: <img> shows EPUB/images/ch001_img001.png, which is not in the manifest",
+		"EPUB/images/ch001_img000.png is never shown",
+	}
+	if strings.Join(problems, "\n") != strings.Join(want, "\n") {
+		t.Errorf("problems:\n%s\nwant:\n%s", strings.Join(problems, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,73 @@
+// Volumes (-volume-size, -volume-bytes).
+// A combined book of hundreds of articles is slow to open and page through
+// on an e-reader. These split it into numbered volumes of consecutive
+// articles, each a complete epub with its own cover and contents.
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// splitVolumes partitions articles, in order, into volumes of at most size
+// articles and at most maxBytes of article HTML (embedded images included),
+// where either limit is ignored when 0. An article larger than maxBytes on
+// its own gets a volume to itself.
+func splitVolumes(articles []epubArticle, size int, maxBytes int64) [][]epubArticle {
+	var volumes [][]epubArticle
+	var cur []epubArticle
+	var curBytes int64
+	for _, a := range articles {
+		n := articleBytes(a)
+		if len(cur) > 0 && ((size > 0 && len(cur) >= size) || (maxBytes > 0 && curBytes+n > maxBytes)) {
+			volumes = append(volumes, cur)
+			cur, curBytes = nil, 0
+		}
+		cur = append(cur, a)
+		curBytes += n
+	}
+	if len(cur) > 0 {
+		volumes = append(volumes, cur)
+	}
+	return volumes
+}
+
+// articleBytes returns the size of a's HTML, wherever it is kept.
+func articleBytes(a epubArticle) int64 {
+	if a.Spool != "" {
+		if info, err := os.Stat(a.Spool); err == nil {
+			return info.Size()
+		}
+	}
+	return int64(len(a.HTML))
+}
+
+// volumePath names volume n of the book at output: book.epub becomes
+// book-vol01.epub.
+func volumePath(output string, n int) string {
+	return fmt.Sprintf("%s-vol%02d.epub", strings.TrimSuffix(output, ".epub"), n)
+}
+
+// writeVolumes builds each volume as its own epub next to output, titled
+// "<title>, Volume N", and returns the path of the book holding each
+// article, in article order.
+func writeVolumes(volumes [][]epubArticle, title, output string, opts epubOpts) ([]string, error) {
+	var paths []string
+	for i, vol := range volumes {
+		path := volumePath(output, i+1)
+		volTitle := fmt.Sprintf("%s, Volume %d", title, i+1)
+		vopts := opts
+		if vopts.coverTitle != "" {
+			vopts.coverTitle = fmt.Sprintf("%s, Volume %d", vopts.coverTitle, i+1)
+		}
+		vprintf("Building epub at %s (%d articles)\n", path, len(vol))
+		if err := buildEpub(vol, volTitle, path, vopts); err != nil {
+			return nil, fmt.Errorf("building epub %s: %w", path, err)
+		}
+		for range vol {
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitVolumes(t *testing.T) {
+	var articles []epubArticle
+	for _, size := range []int{10, 10, 30, 5, 5} {
+		articles = append(articles, epubArticle{Title: fmt.Sprint(len(articles) + 1), HTML: strings.Repeat("x", size)})
+	}
+	titles := func(volumes [][]epubArticle) string {
+		var parts []string
+		for _, vol := range volumes {
+			var ts []string
+			for _, a := range vol {
+				ts = append(ts, a.Title)
+			}
+			parts = append(parts, strings.Join(ts, ","))
+		}
+		return strings.Join(parts, " | ")
+	}
+	for _, tc := range []struct {
+		size     int
+		maxBytes int64
+		want     string
+	}{
+		{0, 0, "1,2,3,4,5"},
+		{2, 0, "1,2 | 3,4 | 5"},
+		{5, 0, "1,2,3,4,5"},
+		// The 30-byte article is too big for a 25-byte volume and goes alone.
+		{0, 25, "1,2 | 3 | 4,5"},
+		{0, 40, "1,2 | 3,4,5"},
+		{2, 40, "1,2 | 3,4 | 5"},
+	} {
+		if got := titles(splitVolumes(articles, tc.size, tc.maxBytes)); got != tc.want {
+			t.Errorf("splitVolumes(size %d, bytes %d) = %s, want %s", tc.size, tc.maxBytes, got, tc.want)
+		}
+	}
+}
+
+func TestRun_EpubMode_Volumes(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(makeArticleHTML("Article "+strings.TrimPrefix(r.URL.Path, "/"), "Volume test article text.")))
+	}))
+	defer srv.Close()
+
+	out := filepath.Join(t.TempDir(), "book.epub")
+	cfg := cliConfig{
+		opts:          optimizeOpts{maxWidth: 800, quality: 60},
+		output:        out,
+		format:        "epub",
+		coverStyle:    "none",
+		titleOverride: "Reading",
+		volumeSize:    2,
+		timeout:       5 * time.Second,
+		userAgent:     "test-agent",
+		concurrency:   2,
+		args:          []string{srv.URL + "/1", srv.URL + "/2", srv.URL + "/3"},
+	}
+	if err := run(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(out); err == nil {
+		t.Error("the unsplit book should not be written")
+	}
+	for i, want := range [][]string{{"Article 1", "Article 2"}, {"Article 3"}} {
+		path := volumePath(out, i+1)
+		zr, err := zip.OpenReader(path)
+		if err != nil {
+			t.Fatalf("volume %d: %v", i+1, err)
+		}
+		opf := findZipFile(zr, "EPUB/package.opf")
+		if title := fmt.Sprintf("Reading, Volume %d", i+1); !strings.Contains(opf, title) {
+			t.Errorf("volume %d should be titled %q", i+1, title)
+		}
+		nav := findZipFile(zr, "EPUB/nav.xhtml")
+		for _, title := range want {
+			if !strings.Contains(nav, title) {
+				t.Errorf("volume %d contents missing %q", i+1, title)
+			}
+		}
+		if strings.Count(nav, "Article ") != len(want) {
+			t.Errorf("volume %d should list only %v:\n%s", i+1, want, nav)
+		}
+		zr.Close()
+	}
+
+	cfg.separate = true
+	if err := run(cfg); err == nil || !strings.Contains(err.Error(), "-volume-size") {
+		t.Errorf("-volume-size with -combine=false should fail, got %v", err)
+	}
+	cfg.separate = false
+	cfg.epubID = "urn:isbn:9780000000002"
+	if err := run(cfg); err == nil || !strings.Contains(err.Error(), "-epub-id") {
+		t.Errorf("-epub-id with volumes should fail, got %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+// WARC archiving of fetched pages and images (-warc).
+// Writes WARC/1.1 request/response record pairs alongside normal output.
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// warcOut is the archive for the current run, or nil when -warc is unset.
+// All its methods are no-ops on nil.
+var warcOut *warcWriter
+
+// warcWriter appends WARC records to a file. Safe for concurrent use.
+type warcWriter struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// newWARCWriter creates path and writes the opening warcinfo record.
+func newWARCWriter(path string) (*warcWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("creating WARC file: %w", err)
+	}
+	w := &warcWriter{f: f}
+	info := "software: deckle\r\nformat: WARC File Format 1.1\r\n"
+	if err := w.writeRecord("warcinfo", "", "application/warc-fields", warcRecordID(), nil, []byte(info)); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// recordExchange archives a request and its response. body is the response
+// body as read (possibly truncated by the size limit); Content-Length is
+// rewritten to match it. Errors are logged, not returned, so archiving
+// never fails a fetch.
+func (w *warcWriter) recordExchange(req *http.Request, resp *http.Response, body []byte) {
+	if w == nil {
+		return
+	}
+	var reqBlock bytes.Buffer
+	fmt.Fprintf(&reqBlock, "%s %s HTTP/1.1\r\nHost: %s\r\n", req.Method, req.URL.RequestURI(), req.URL.Host)
+	req.Header.Write(&reqBlock)
+	reqBlock.WriteString("\r\n")
+
+	var respBlock bytes.Buffer
+	fmt.Fprintf(&respBlock, "HTTP/%d.%d %s\r\n", resp.ProtoMajor, resp.ProtoMinor, resp.Status)
+	h := resp.Header.Clone()
+	h.Del("Transfer-Encoding")
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	h.Write(&respBlock)
+	respBlock.WriteString("\r\n")
+	respBlock.Write(body)
+
+	uri := req.URL.String()
+	respID := warcRecordID()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	err := w.writeRecord("response", uri, "application/http;msgtype=response", respID, nil, respBlock.Bytes())
+	if err == nil {
+		err = w.writeRecord("request", uri, "application/http;msgtype=request", warcRecordID(),
+			[]string{"WARC-Concurrent-To: " + respID}, reqBlock.Bytes())
+	}
+	if err != nil {
+		fmt.Fprintf(logOut, "Warning: could not write WARC record for %s: %v\n", uri, err)
+	}
+}
+
+// writeRecord writes one WARC record. Callers other than the constructor
+// must hold w.mu.
+func (w *warcWriter) writeRecord(typ, uri, contentType, id string, extra []string, block []byte) error {
+	var hdr bytes.Buffer
+	hdr.WriteString("WARC/1.1\r\n")
+	fmt.Fprintf(&hdr, "WARC-Type: %s\r\n", typ)
+	fmt.Fprintf(&hdr, "WARC-Record-ID: %s\r\n", id)
+	fmt.Fprintf(&hdr, "WARC-Date: %s\r\n", time.Now().UTC().Format(time.RFC3339))
+	if uri != "" {
+		fmt.Fprintf(&hdr, "WARC-Target-URI: %s\r\n", uri)
+	}
+	for _, line := range extra {
+		hdr.WriteString(line + "\r\n")
+	}
+	fmt.Fprintf(&hdr, "Content-Type: %s\r\n", contentType)
+	fmt.Fprintf(&hdr, "Content-Length: %d\r\n\r\n", len(block))
+
+	for _, b := range [][]byte{hdr.Bytes(), block, []byte("\r\n\r\n")} {
+		if _, err := w.f.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close flushes and closes the archive.
+func (w *warcWriter) Close() error {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.f.Close()
+}
+
+// warcRecordID returns a random (version 4) UUID URN.
+func warcRecordID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("<urn:uuid:%x-%x-%x-%x-%x>", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"image/color"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type warcRecord struct {
+	headers map[string]string
+	block   []byte
+}
+
+// readWARC parses a WARC file, failing the test on malformed records.
+func readWARC(t *testing.T, path string) []warcRecord {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := bufio.NewReader(bytes.NewReader(data))
+	var records []warcRecord
+	for {
+		line, err := r.ReadString('\n')
+		if err == io.EOF {
+			return records
+		}
+		if line != "WARC/1.1\r\n" {
+			t.Fatalf("expected WARC/1.1 version line, got %q", line)
+		}
+		rec := warcRecord{headers: map[string]string{}}
+		for {
+			line, err = r.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if line == "\r\n" {
+				break
+			}
+			k, v, _ := strings.Cut(strings.TrimRight(line, "\r\n"), ": ")
+			rec.headers[k] = v
+		}
+		n, err := strconv.Atoi(rec.headers["Content-Length"])
+		if err != nil {
+			t.Fatalf("bad Content-Length: %v", err)
+		}
+		rec.block = make([]byte, n)
+		if _, err := io.ReadFull(r, rec.block); err != nil {
+			t.Fatal(err)
+		}
+		if trailer, _ := r.Peek(4); string(trailer) != "\r\n\r\n" {
+			t.Fatalf("missing record trailer, got %q", trailer)
+		}
+		r.Discard(4)
+		records = append(records, rec)
+	}
+}
+
+func TestRun_WARC(t *testing.T) {
+	png := makePNG(20, 20, color.RGBA{0, 128, 0, 255})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/img/pic.png" {
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(png)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(makeArticleHTML("Archived", `Text <img src="/img/pic.png" alt="pic">`)))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	warcPath := filepath.Join(dir, "out.warc")
+	cfg := cliConfig{
+		opts:      optimizeOpts{maxWidth: 800, quality: 60},
+		output:    filepath.Join(dir, "out.html"),
+		format:    "html",
+		timeout:   5 * time.Second,
+		userAgent: "test-agent",
+		warcPath:  warcPath,
+		args:      []string{srv.URL + "/a"},
+	}
+	if err := run(cfg); err != nil {
+		t.Fatalf("run() error: %v", err)
+	}
+	if warcOut != nil {
+		t.Error("warcOut should be reset after run")
+	}
+
+	records := readWARC(t, warcPath)
+	if len(records) != 5 {
+		t.Fatalf("expected warcinfo + 2 request/response pairs, got %d records", len(records))
+	}
+	if records[0].headers["WARC-Type"] != "warcinfo" {
+		t.Errorf("first record should be warcinfo, got %q", records[0].headers["WARC-Type"])
+	}
+
+	byURI := map[string]map[string]warcRecord{}
+	for _, rec := range records[1:] {
+		uri := rec.headers["WARC-Target-URI"]
+		if byURI[uri] == nil {
+			byURI[uri] = map[string]warcRecord{}
+		}
+		byURI[uri][rec.headers["WARC-Type"]] = rec
+	}
+
+	page := byURI[srv.URL+"/a"]
+	if !bytes.HasPrefix(page["response"].block, []byte("HTTP/1.1 200 OK\r\n")) ||
+		!bytes.Contains(page["response"].block, []byte("<h1>Archived</h1>")) {
+		t.Errorf("page response record missing status or body:\n%s", page["response"].block)
+	}
+	if !bytes.HasPrefix(page["request"].block, []byte("GET /a HTTP/1.1\r\n")) ||
+		!bytes.Contains(page["request"].block, []byte("User-Agent: test-agent")) {
+		t.Errorf("page request record malformed:\n%s", page["request"].block)
+	}
+	if page["request"].headers["WARC-Concurrent-To"] != page["response"].headers["WARC-Record-ID"] {
+		t.Error("request should point at its response via WARC-Concurrent-To")
+	}
+
+	img := byURI[srv.URL+"/img/pic.png"]["response"]
+	if !bytes.HasSuffix(img.block, png) {
+		t.Error("image response record should hold the original image bytes")
+	}
+	if !bytes.Contains(img.block, []byte("Content-Length: "+strconv.Itoa(len(png)))) {
+		t.Error("image response should carry the body's Content-Length")
+	}
+}
+
+func TestWARCWriter_Concurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "c.warc")
+	w, err := newWARCWriter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req, _ := http.NewRequest("GET", "http://example.com/"+strconv.Itoa(i), nil)
+			resp := &http.Response{Status: "200 OK", StatusCode: 200, ProtoMajor: 1, ProtoMinor: 1, Header: http.Header{}, Request: req}
+			w.recordExchange(req, resp, bytes.Repeat([]byte{'x'}, 1000+i))
+		}(i)
+	}
+	wg.Wait()
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(readWARC(t, path)); n != 41 {
+		t.Errorf("expected 41 intact records, got %d", n)
+	}
+
+	var nilWriter *warcWriter
+	nilWriter.recordExchange(nil, nil, nil)
+	if err := nilWriter.Close(); err != nil {
+		t.Error(err)
+	}
+}